@@ -0,0 +1,469 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/infobloxopen/cluster-operator/pkg/simulation"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// replaceCluster stores the manifest of -f, --force creates missing
+// clusters. Instance groups the manifest does not list are kept, a manifest
+// of instance groups only replaces them in the cluster of their label.
+func replaceCluster(s *store, o options, e env) error {
+	if o.filename == "" {
+		return fmt.Errorf("-f is required")
+	}
+	data, err := ioutil.ReadFile(o.filename)
+	if err != nil {
+		return err
+	}
+	m, err := kops.ParseManifest(string(data))
+	if err != nil {
+		return err
+	}
+	if m.Find("Cluster", "") == nil {
+		return replaceInstanceGroups(s, m, e)
+	}
+	doc, err := m.Cluster()
+	if err != nil {
+		return err
+	}
+	name := kops.Name(doc)
+	if name == "" {
+		return fmt.Errorf("cluster has no metadata.name")
+	}
+	if !s.exists(name) && !o.force {
+		return fmt.Errorf("cluster %q not found, use --force to create it", name)
+	}
+	if s.exists(name) {
+		stored, err := storedManifest(s, name)
+		if err != nil {
+			return err
+		}
+		m.KeepInstanceGroups(stored)
+	}
+	if err := writeManifest(s, name, m); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "Replaced cluster %s\n", name)
+	return nil
+}
+
+// replaceInstanceGroups stores the instance groups in the manifest of the
+// cluster named by their kops.k8s.io/cluster label
+func replaceInstanceGroups(s *store, m *kops.Manifest, e env) error {
+	igs := m.InstanceGroups()
+	if len(igs) == 0 {
+		return fmt.Errorf("manifest has no Cluster or InstanceGroup document")
+	}
+	for _, ig := range igs {
+		name, err := kops.InstanceGroupCluster(ig)
+		if err != nil {
+			return err
+		}
+		stored, err := storedManifest(s, name)
+		if err != nil {
+			return err
+		}
+		stored.SetInstanceGroup(ig)
+		if err := writeManifest(s, name, stored); err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "Replaced instancegroup %s\n", kops.Name(ig))
+	}
+	return nil
+}
+
+// getInstanceGroups lists the instance groups of the cluster, -o yaml
+// prints their documents. With a group only that one is listed, it fails
+// when the cluster has no such instance group.
+func getInstanceGroups(s *store, o options, group string, e env) error {
+	m, err := storedManifest(s, o.name)
+	if err != nil {
+		return err
+	}
+	igs := m.InstanceGroups()
+	if group != "" {
+		ig := m.Find("InstanceGroup", group)
+		if ig == nil {
+			return fmt.Errorf("InstanceGroup %q not found", group)
+		}
+		igs = []map[string]interface{}{ig}
+	}
+	if o.output == "yaml" {
+		out, err := (&kops.Manifest{Documents: igs}).String()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(e.stdout, out)
+		return nil
+	}
+	fmt.Fprintln(e.stdout, "NAME\tROLE")
+	for _, ig := range igs {
+		role, _, _ := unstructured.NestedString(ig, "spec", "role")
+		fmt.Fprintf(e.stdout, "%s\t%s\n", kops.Name(ig), role)
+	}
+	return nil
+}
+
+// deleteInstanceGroup removes the instance group and terminates its
+// instances, it asks for --yes like kops
+func deleteInstanceGroup(s *store, o options, group string, e env) error {
+	m, err := storedManifest(s, o.name)
+	if err != nil {
+		return err
+	}
+	if m.Find("InstanceGroup", group) == nil {
+		return fmt.Errorf("InstanceGroup %q not found", group)
+	}
+	if !o.yes {
+		fmt.Fprintf(e.stdout, "InstanceGroup %q found for deletion\nMust specify --yes to delete instancegroup\n", group)
+		return nil
+	}
+	m.RemoveInstanceGroup(group)
+	if err := writeManifest(s, o.name, m); err != nil {
+		return err
+	}
+	c, err := s.cluster(o.name)
+	if err != nil {
+		return err
+	}
+	var instances []instance
+	for _, i := range c.Instances {
+		if i.Group != group {
+			instances = append(instances, i)
+		}
+	}
+	c.Instances = instances
+	if err := s.writeCluster(o.name, c); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "InstanceGroup %q deleted\n", group)
+	return nil
+}
+
+func storedManifest(s *store, name string) (*kops.Manifest, error) {
+	manifest, _, err := s.manifest(name)
+	if err != nil {
+		return nil, err
+	}
+	return kops.ParseManifest(manifest)
+}
+
+func writeManifest(s *store, name string, m *kops.Manifest) error {
+	manifest, err := m.String()
+	if err != nil {
+		return err
+	}
+	return s.writeManifest(name, manifest)
+}
+
+// updateCluster launches and terminates instances so every group has its
+// minSize. Running instances keep the revision they were launched with
+// until they are rolled.
+func updateCluster(s *store, o options, e env) error {
+	manifest, revision, err := s.manifest(o.name)
+	if err != nil {
+		return err
+	}
+	groups, err := kops.ManifestGroups(manifest)
+	if err != nil {
+		return err
+	}
+	m, err := kops.ParseManifest(manifest)
+	if err != nil {
+		return err
+	}
+	revisions, err := m.GroupRevisions()
+	if err != nil {
+		return err
+	}
+	if !o.yes {
+		fmt.Fprintf(e.stdout, "Will apply revision %s to %d instance groups\nMust specify --yes to apply changes\n", revision, len(groups))
+		return nil
+	}
+
+	c, err := s.cluster(o.name)
+	if err != nil {
+		return err
+	}
+	c.Applied = revisions
+	sizes := map[string]int{}
+	for _, g := range groups {
+		sizes[g.Name] = g.Size
+	}
+	var instances []instance
+	running := map[string]int{}
+	for _, i := range c.Instances {
+		if running[i.Group] < sizes[i.Group] {
+			instances = append(instances, i)
+			running[i.Group]++
+		}
+	}
+	for _, g := range groups {
+		for ; running[g.Name] < g.Size; running[g.Name]++ {
+			instances = append(instances, c.launch(g.Name, g.Role, g.Zone))
+		}
+	}
+	c.Instances = instances
+	if err := s.writeCluster(o.name, c); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "Cluster changes have been applied to the cloud.\n")
+	return nil
+}
+
+// rollingUpdateCluster replaces the instances launched with an older
+// revision of their group, all of them with --force. --instance-group and
+// --instance-group-roles select the instances.
+func rollingUpdateCluster(s *store, o options, e env) error {
+	if _, _, err := s.manifest(o.name); err != nil {
+		return err
+	}
+	c, err := s.cluster(o.name)
+	if err != nil {
+		return err
+	}
+	roles := map[string]bool{}
+	for _, r := range strings.Split(o.roles, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			roles[strings.ToLower(r)] = true
+		}
+	}
+	groups := map[string]bool{}
+	for _, g := range o.groups {
+		groups[g] = true
+	}
+
+	replaced := 0
+	for n, i := range c.Instances {
+		if len(roles) > 0 && !roles[strings.ToLower(i.Role)] {
+			continue
+		}
+		if len(groups) > 0 && !groups[i.Group] {
+			continue
+		}
+		if !o.force && i.Revision == c.Applied[i.Group] {
+			continue
+		}
+		if o.yes {
+			c.Instances[n] = c.launch(i.Group, i.Role, i.Zone)
+		}
+		fmt.Fprintf(e.stdout, "%s\t%s\tNeedsUpdate\n", i.Group, i.Name)
+		replaced++
+	}
+	if !o.yes {
+		fmt.Fprintf(e.stdout, "Must specify --yes to rolling-update.\n")
+		return nil
+	}
+	if err := s.writeCluster(o.name, c); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "Rolling update completed, %d instances replaced.\n", replaced)
+	return nil
+}
+
+// validateCluster reports every instance as a ready node, it fails until
+// the cluster was updated and every instance group has its minSize
+func validateCluster(s *store, o options, e env) error {
+	manifest, _, err := s.manifest(o.name)
+	if err != nil {
+		return err
+	}
+	groups, err := kops.ManifestGroups(manifest)
+	if err != nil {
+		return err
+	}
+	c, err := s.cluster(o.name)
+	if err != nil {
+		return err
+	}
+
+	status := clusteroperatorv1alpha1.KopsStatus{}
+	ready := map[string]int{}
+	for _, i := range c.Instances {
+		ready[i.Group]++
+		status.Nodes = append(status.Nodes, clusteroperatorv1alpha1.KopsNode{
+			Name:     i.Name,
+			Zone:     i.Zone,
+			Role:     strings.ToLower(i.Role),
+			Hostname: i.Name,
+			Status:   "True",
+		})
+	}
+	for _, g := range groups {
+		if len(c.Instances) > 0 && ready[g.Name] < g.Size {
+			status.Failures = append(status.Failures, kops.NotEnoughNodesFailure(g.Name, ready[g.Name], g.Size))
+		}
+	}
+	if len(c.Instances) == 0 {
+		status.Failures = append(status.Failures, clusteroperatorv1alpha1.KopsFailure{
+			Type:    "dns",
+			Name:    "apiserver",
+			Message: "Validation Failed: the cluster has not been updated",
+		})
+	}
+
+	if o.output == "json" {
+		out, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(e.stdout, string(out))
+	} else {
+		for _, n := range status.Nodes {
+			fmt.Fprintf(e.stdout, "%s\t%s\t%s\t%s\n", n.Name, n.Role, n.Zone, n.Status)
+		}
+	}
+	if len(status.Failures) > 0 {
+		return fmt.Errorf("Validation Failed")
+	}
+	if o.output != "json" {
+		fmt.Fprintf(e.stdout, "Your cluster %s is ready\n", o.name)
+	}
+	return nil
+}
+
+// getCluster lists the clusters of the store, or checks --name exists
+func getCluster(s *store, o options, e env) error {
+	names := []string{o.name}
+	if o.name == "" {
+		var err error
+		if names, err = s.clusters(); err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("No clusters found")
+		}
+	}
+
+	var docs []map[string]interface{}
+	for _, name := range names {
+		manifest, _, err := s.manifest(name)
+		if err != nil {
+			return err
+		}
+		m, err := kops.ParseManifest(manifest)
+		if err != nil {
+			return err
+		}
+		doc, err := m.Cluster()
+		if err != nil {
+			return err
+		}
+		docs = append(docs, doc)
+	}
+
+	if o.output == "json" {
+		out, err := json.Marshal(docs)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(e.stdout, string(out))
+		return nil
+	}
+	fmt.Fprintln(e.stdout, "NAME\tCLOUD")
+	for _, doc := range docs {
+		cloud, _, _ := unstructured.NestedString(doc, "spec", "cloudProvider")
+		fmt.Fprintf(e.stdout, "%s\t%s\n", kops.Name(doc), cloud)
+	}
+	return nil
+}
+
+// deleteCluster lists the instances it would remove, --yes removes the
+// cluster from the store
+func deleteCluster(s *store, o options, e env) error {
+	if _, _, err := s.manifest(o.name); err != nil {
+		return err
+	}
+	c, err := s.cluster(o.name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(e.stdout, "TYPE\tNAME\tID")
+	for _, i := range c.Instances {
+		fmt.Fprintf(e.stdout, "instance\t%s.%s\t%s\n", i.Group, o.name, i.Name)
+	}
+	if !o.yes {
+		fmt.Fprintln(e.stdout, "\nMust specify --yes to delete cluster")
+		return nil
+	}
+	if err := s.delete(o.name); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "\nDeleted cluster: %q\n", o.name)
+	return nil
+}
+
+// exportKubecfg writes a kubeconfig for the API server kops would create
+func exportKubecfg(s *store, o options, e env) error {
+	if _, _, err := s.manifest(o.name); err != nil {
+		return err
+	}
+	path := o.kubeconfig
+	if path == "" {
+		path = e.getenv("KUBECONFIG")
+	}
+	if path == "" {
+		path = filepath.Join(e.getenv("HOME"), ".kube", "config")
+	}
+
+	fake := base64.StdEncoding.EncodeToString([]byte("fakekops " + o.name))
+	config := clusteroperatorv1alpha1.KubeConfig{
+		APIVersion:     "v1",
+		Kind:           "Config",
+		CurrentContext: o.name,
+		Clusters: []clusteroperatorv1alpha1.ClusterConfigs{{
+			Name: o.name,
+			ClusterConfigs: clusteroperatorv1alpha1.ClusterConfig{
+				CertificateAuthorityData: fake,
+				Server:                   simulation.APIServer(o.name),
+			},
+		}},
+		ContextConfigs: []clusteroperatorv1alpha1.ContextConfigs{{
+			Name:           o.name,
+			ContextConfigs: clusteroperatorv1alpha1.ContextConfig{Cluster: o.name, User: o.name},
+		}},
+		Users: []clusteroperatorv1alpha1.Users{{
+			Name: o.name,
+			Users: clusteroperatorv1alpha1.User{
+				ClientCertificateData: fake,
+				ClientKeyData:         fake,
+			},
+		}},
+	}
+	out, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, out, 0600); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "kops has set your kubectl context to %s\n", o.name)
+	return nil
+}
+
+// launch returns a new instance running the applied revision
+func (c *cluster) launch(group, role, zone string) instance {
+	c.Serial++
+	return instance{
+		Name:     simulation.InstanceName(c.Serial),
+		Group:    group,
+		Role:     role,
+		Zone:     zone,
+		Revision: c.Applied[group],
+	}
+}
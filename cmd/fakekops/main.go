@@ -0,0 +1,195 @@
+// fakekops implements the subset of the kops CLI the operator calls against
+// a state store on local disk, so KopsCmd and the controller can be run end
+// to end without a cloud account. Point kops.path, or a kops.versions entry,
+// at the binary.
+//
+// The environment controls the simulation:
+//
+//	FAKEKOPS_ROOT        directory s3:// and other remote state stores are kept in
+//	FAKEKOPS_VERSION     version reported by kops version, defaults to 1.17.1
+//	FAKEKOPS_LATENCY     duration every command but version takes, e.g. 2s
+//	FAKEKOPS_FAIL        commands that always fail, e.g. update,rolling-update
+//	FAKEKOPS_FAIL_RATE   probability between 0 and 1 any command fails
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const defaultVersion = "1.17.1"
+
+// options are the kops flags the operator passes
+type options struct {
+	state      string
+	name       string
+	filename   string
+	output     string
+	kubeconfig string
+	roles      string
+	groups     []string
+	force      bool
+	yes        bool
+}
+
+// env is the environment of a run, tests replace it
+type env struct {
+	getenv func(string) string
+	stdout io.Writer
+	stderr io.Writer
+	sleep  func(time.Duration)
+	random func() float64
+}
+
+func main() {
+	rand.Seed(time.Now().UnixNano())
+	os.Exit(run(os.Args[1:], env{
+		getenv: os.Getenv,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+		sleep:  time.Sleep,
+		random: rand.Float64,
+	}))
+}
+
+// run executes the kops command line and returns the exit code
+func run(args []string, e env) int {
+	fs := pflag.NewFlagSet("kops", pflag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	// Flags of kops the simulation does not need are accepted and ignored
+	fs.ParseErrorsWhitelist.UnknownFlags = true
+	o := options{}
+	fs.StringVar(&o.state, "state", e.getenv("KOPS_STATE_STORE"), "")
+	fs.StringVar(&o.name, "name", "", "")
+	fs.StringVarP(&o.filename, "filename", "f", "", "")
+	fs.StringVarP(&o.output, "output", "o", "", "")
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "")
+	fs.StringVar(&o.roles, "instance-group-roles", "", "")
+	fs.StringSliceVar(&o.groups, "instance-group", nil, "")
+	fs.BoolVar(&o.force, "force", false, "")
+	fs.BoolVar(&o.yes, "yes", false, "")
+	if err := fs.Parse(args); err != nil {
+		return fail(e, err)
+	}
+
+	positional := fs.Args()
+	if len(positional) == 0 {
+		return fail(e, fmt.Errorf("command required, e.g. replace, update, rolling-update, validate, get, delete, export or version"))
+	}
+	command := positional[0]
+	// get and delete name the instance group after the resource, the
+	// cluster is always passed with --name. get lists every group without
+	// a name.
+	groups := len(positional) > 1 && instanceGroupResource(positional[1])
+	group := ""
+	if len(positional) > 2 && groups {
+		group = positional[2]
+	} else if o.name == "" && len(positional) > 2 {
+		o.name = positional[2]
+	}
+
+	if command == "version" {
+		version := e.getenv("FAKEKOPS_VERSION")
+		if version == "" {
+			version = defaultVersion
+		}
+		fmt.Fprintf(e.stdout, "Version %s (git-fakekops)\n", version)
+		return 0
+	}
+
+	if err := inject(command, e); err != nil {
+		return fail(e, err)
+	}
+
+	root := e.getenv("FAKEKOPS_ROOT")
+	if root == "" {
+		root = filepath.Join(os.TempDir(), "fakekops")
+	}
+	s, err := openStore(o.state, root)
+	if err != nil {
+		return fail(e, err)
+	}
+
+	switch command {
+	case "replace":
+		err = replaceCluster(s, o, e)
+	case "update":
+		err = updateCluster(s, o, e)
+	case "rolling-update":
+		err = rollingUpdateCluster(s, o, e)
+	case "validate":
+		err = validateCluster(s, o, e)
+	case "get":
+		if groups {
+			err = getInstanceGroups(s, o, group, e)
+		} else {
+			err = getCluster(s, o, e)
+		}
+	case "delete":
+		if group != "" {
+			err = deleteInstanceGroup(s, o, group, e)
+		} else {
+			err = deleteCluster(s, o, e)
+		}
+	case "export":
+		err = exportKubecfg(s, o, e)
+	default:
+		err = fmt.Errorf("unknown command %q", command)
+	}
+	if err != nil {
+		return fail(e, err)
+	}
+	return 0
+}
+
+// instanceGroupResource reports if the resource argument of get or delete
+// names instance groups
+func instanceGroupResource(resource string) bool {
+	switch resource {
+	case "ig", "igs", "instancegroup", "instancegroups":
+		return true
+	}
+	return false
+}
+
+// inject simulates the latency of the cloud and fails the command when
+// FAKEKOPS_FAIL or FAKEKOPS_FAIL_RATE ask for it
+func inject(command string, e env) error {
+	if latency := e.getenv("FAKEKOPS_LATENCY"); latency != "" {
+		d, err := time.ParseDuration(latency)
+		if err != nil {
+			return fmt.Errorf("invalid FAKEKOPS_LATENCY: %v", err)
+		}
+		e.sleep(d)
+	}
+
+	for _, c := range strings.Split(e.getenv("FAKEKOPS_FAIL"), ",") {
+		if strings.TrimSpace(c) == command {
+			return fmt.Errorf("fakekops: injected failure of %s", command)
+		}
+	}
+	if rate := e.getenv("FAKEKOPS_FAIL_RATE"); rate != "" {
+		r, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			return fmt.Errorf("invalid FAKEKOPS_FAIL_RATE: %v", err)
+		}
+		if e.random() < r {
+			return fmt.Errorf("fakekops: injected random failure of %s", command)
+		}
+	}
+	return nil
+}
+
+func fail(e env, err error) int {
+	fmt.Fprintln(e.stderr, "Error:", err)
+	return 1
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/spf13/viper"
+)
+
+const testManifest = `apiVersion: kops.k8s.io/v1alpha2
+kind: Cluster
+metadata:
+  name: test.k8s.local
+spec:
+  kubernetesVersion: 1.16.7
+---
+apiVersion: kops.k8s.io/v1alpha2
+kind: InstanceGroup
+metadata:
+  name: master-us-east-2a
+spec:
+  role: Master
+  minSize: 1
+  subnets:
+  - us-east-2a
+---
+apiVersion: kops.k8s.io/v1alpha2
+kind: InstanceGroup
+metadata:
+  name: nodes
+spec:
+  role: Node
+  minSize: 2
+`
+
+// inProcess runs the command lines of KopsCmd with run instead of a binary
+type inProcess struct {
+	vars map[string]string
+	// sleeps records the injected latency
+	sleeps *[]time.Duration
+}
+
+func (p inProcess) RunCmd(cmd string) (*bytes.Buffer, error) {
+	var stdout, stderr bytes.Buffer
+	e := env{
+		getenv: func(k string) string { return p.vars[k] },
+		stdout: &stdout,
+		stderr: &stderr,
+		sleep:  func(d time.Duration) { *p.sleeps = append(*p.sleeps, d) },
+		random: func() float64 { return 0.5 },
+	}
+	if code := run(strings.Fields(cmd)[1:], e); code != 0 {
+		return &stderr, fmt.Errorf("exit status %d", code)
+	}
+	return &stdout, nil
+}
+
+func (p inProcess) RunStreamingCmd(cmd string) error {
+	_, err := p.RunCmd(cmd)
+	return err
+}
+
+func newTestKops(t *testing.T, dir string, vars map[string]string) (*kops.KopsCmd, *[]time.Duration) {
+	viper.Set("tmp.dir", dir)
+	viper.Set("kops.path", "fakekops")
+	viper.Set("kops.kube.dir", "/fakekops-test")
+	k, err := kops.NewKops("test.k8s.local", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sleeps := &[]time.Duration{}
+	k.SetExecutor(inProcess{vars: vars, sleeps: sleeps})
+	k.SetStateStore("file://"+filepath.Join(dir, "state"), "")
+	return k, sleeps
+}
+
+func TestKopsCmd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakekops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.RemoveAll("fakekops-test")
+	k, _ := newTestKops(t, dir, map[string]string{})
+	kc := clusteroperatorv1alpha1.KopsConfig{Name: "test.k8s.local"}
+
+	if err := k.ProbeStateStore(); err != nil {
+		t.Error("Expected empty state store to be reachable got", err)
+	}
+	if version, err := k.Version(); err != nil || version != defaultVersion {
+		t.Error("Expected version", defaultVersion, "got", version, err)
+	}
+	if _, err := k.ValidateCluster(kc); err == nil {
+		t.Error("Expected validation of a missing cluster to fail")
+	}
+
+	spec := clusteroperatorv1alpha1.ClusterSpec{Name: "test", Config: testManifest}
+	if err := k.ReplaceCluster(spec); err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	if exists, err := k.GetCluster(kc); !exists || err != nil {
+		t.Error("Expected the cluster to exist got", exists, err)
+	}
+	if _, err := k.ValidateCluster(kc); err == nil {
+		t.Error("Expected validation to fail before the cluster is updated")
+	}
+
+	if err := k.UpdateCluster(kc); err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	status, err := k.ValidateCluster(kc)
+	if err != nil || len(status.Nodes) != 3 {
+		t.Fatal("Expected 3 ready nodes got", status, err)
+	}
+	if status.Nodes[0].Role != "master" || status.Nodes[0].Zone != "us-east-2a" {
+		t.Error("Expected the master in us-east-2a got", status.Nodes[0])
+	}
+	config, err := k.GetKubeConfig(kc)
+	if err != nil || config.CurrentContext != "test.k8s.local" {
+		t.Error("Expected kubeconfig of the cluster got", config, err)
+	}
+
+	// Changing the manifest only replaces instances once they are rolled
+	spec.Config = strings.Replace(testManifest, "1.16.7", "1.17.4", 1)
+	if err := k.ReplaceCluster(spec); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.UpdateCluster(kc); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.RollingUpdateRole(kc, "Master"); err != nil {
+		t.Fatal(err)
+	}
+	rolled, _ := k.ValidateCluster(kc)
+	if rolled.Nodes[0].Name == status.Nodes[0].Name || rolled.Nodes[1].Name != status.Nodes[1].Name {
+		t.Error("Expected only the master to be replaced got", rolled.Nodes, "was", status.Nodes)
+	}
+	if err := k.ForceRollingUpdateCluster(kc); err != nil {
+		t.Fatal(err)
+	}
+	forced, _ := k.ValidateCluster(kc)
+	for i := range forced.Nodes {
+		if forced.Nodes[i].Name == rolled.Nodes[i].Name {
+			t.Error("Expected every instance to be replaced got", forced.Nodes[i].Name)
+		}
+	}
+
+	preview, err := k.DeleteClusterPreview(kc)
+	if err != nil || !strings.Contains(preview, forced.Nodes[0].Name) {
+		t.Error("Expected the preview to list the instances got", preview, err)
+	}
+	if err := k.DeleteCluster(kc); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := k.GetCluster(kc); exists || err != nil {
+		t.Error("Expected the cluster to be deleted got", exists, err)
+	}
+	if err := k.ProbeStateStore(); err != nil {
+		t.Error("Expected empty state store to be reachable got", err)
+	}
+}
+
+const testInstanceGroup = `apiVersion: kops.k8s.io/v1alpha2
+kind: InstanceGroup
+metadata:
+  name: pool
+  labels:
+    kops.k8s.io/cluster: test.k8s.local
+spec:
+  role: Node
+  minSize: 1
+`
+
+func TestKopsCmdInstanceGroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakekops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.RemoveAll("fakekops-test")
+	k, _ := newTestKops(t, dir, map[string]string{})
+	kc := clusteroperatorv1alpha1.KopsConfig{Name: "test.k8s.local"}
+
+	if err := k.ReplaceCluster(clusteroperatorv1alpha1.ClusterSpec{Name: "test", Config: testManifest}); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.ReplaceInstanceGroup(kc, testInstanceGroup); err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	if err := k.UpdateCluster(kc); err != nil {
+		t.Fatal(err)
+	}
+	status, err := k.ValidateCluster(kc)
+	if err != nil || len(status.Nodes) != 4 {
+		t.Fatal("Expected 4 ready nodes got", status, err)
+	}
+
+	// Only the instances of the changed group are replaced
+	spot := "role: Node\n  machineType: t3.large\n  maxPrice: \"0.05\""
+	if err := k.ReplaceInstanceGroup(kc, strings.Replace(testInstanceGroup, "role: Node", spot, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.UpdateCluster(kc); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.RollingUpdateInstanceGroup(kc, "pool"); err != nil {
+		t.Fatal(err)
+	}
+	rolled, _ := k.ValidateCluster(kc)
+	for i := range rolled.Nodes {
+		if replaced := rolled.Nodes[i].Name != status.Nodes[i].Name; replaced != (i == 3) {
+			t.Error("Expected only the instance of the group to be replaced got", rolled.Nodes[i].Name)
+		}
+	}
+
+	groups, err := k.GetInstanceGroups(kc)
+	if err != nil || len(groups) != 3 {
+		t.Fatal("Expected 3 instance groups got", groups, err)
+	}
+	if pool := groups[2]; pool.Name != "pool" || pool.Role != "Node" || pool.DesiredOnDemand != 0 || pool.DesiredSpot != 1 {
+		t.Error("Expected the pool to run 1 spot instance got", pool)
+	}
+	if nodes := groups[1]; nodes.Name != "nodes" || nodes.DesiredOnDemand != 2 || nodes.DesiredSpot != 0 {
+		t.Error("Expected the nodes to run 2 on-demand instances got", nodes)
+	}
+
+	if err := k.DeleteInstanceGroup(kc, "pool"); err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	if status, err := k.ValidateCluster(kc); err != nil || len(status.Nodes) != 3 {
+		t.Error("Expected the instance of the group to be terminated got", status, err)
+	}
+	if err := k.DeleteInstanceGroup(kc, "pool"); err != nil {
+		t.Error("Expected deleting a missing group to succeed got", err)
+	}
+	if exists, err := k.GetCluster(kc); !exists || err != nil {
+		t.Error("Expected the cluster to be kept got", exists, err)
+	}
+
+	// Failing to look the group up is not a missing group
+	k, _ = newTestKops(t, dir, map[string]string{"FAKEKOPS_FAIL": "get"})
+	if err := k.DeleteInstanceGroup(kc, "nodes"); err == nil {
+		t.Error("Expected the failed lookup to be returned")
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakekops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.RemoveAll("fakekops-test")
+	k, sleeps := newTestKops(t, dir, map[string]string{
+		"FAKEKOPS_LATENCY": "2s",
+		"FAKEKOPS_FAIL":    "update",
+	})
+	kc := clusteroperatorv1alpha1.KopsConfig{Name: "test.k8s.local"}
+
+	if err := k.ReplaceCluster(clusteroperatorv1alpha1.ClusterSpec{Name: "test", Config: testManifest}); err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	if err := k.UpdateCluster(kc); err == nil {
+		t.Error("Expected injected update failure")
+	}
+	if len(*sleeps) != 2 || (*sleeps)[0] != 2*time.Second {
+		t.Error("Expected 2s latency for each command got", *sleeps)
+	}
+
+	k, _ = newTestKops(t, dir, map[string]string{"FAKEKOPS_FAIL_RATE": "0.6"})
+	if exists, err := k.GetCluster(kc); err == nil {
+		t.Error("Expected random failure got", exists)
+	}
+	k, _ = newTestKops(t, dir, map[string]string{"FAKEKOPS_FAIL_RATE": "0.4"})
+	if exists, err := k.GetCluster(kc); !exists || err != nil {
+		t.Error("Expected the cluster to exist got", exists, err)
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	tests := []struct {
+		state string
+		dir   string
+	}{
+		{"file:///var/kops", "/var/kops"},
+		{"/var/kops", "/var/kops"},
+		{"s3://bucket/path", "/root/s3/bucket/path"},
+	}
+	for _, tt := range tests {
+		s, err := openStore(tt.state, "/root")
+		if err != nil || s.dir != tt.dir {
+			t.Error("Expected", tt.dir, "for", tt.state, "got", s, err)
+		}
+	}
+	if _, err := openStore("", "/root"); err == nil {
+		t.Error("Expected error without state store")
+	}
+}
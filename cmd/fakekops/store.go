@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// store is a kops state store kept in a local directory, every cluster is
+// a directory holding the replaced manifest and the simulated cloud state
+type store struct {
+	dir string
+}
+
+// cluster is the simulated cloud state of a cluster
+type cluster struct {
+	// Applied identifies the manifest of every instance group the last
+	// update applied, empty until the cluster was updated
+	Applied   map[string]string `json:"applied,omitempty"`
+	Instances []instance        `json:"instances,omitempty"`
+	// Serial numbers the instances so replaced ones get new names
+	Serial int `json:"serial,omitempty"`
+}
+
+// instance is a simulated machine of an instance group, Revision is the
+// manifest of the group it was launched with
+type instance struct {
+	Name     string `json:"name"`
+	Group    string `json:"group"`
+	Role     string `json:"role"`
+	Zone     string `json:"zone"`
+	Revision string `json:"revision"`
+}
+
+const (
+	manifestFile = "config.yaml"
+	clusterFile  = "cluster.json"
+)
+
+// openStore maps the --state URL to a directory. file:// URLs and paths are
+// used as is, other URLs such as s3://bucket/path are kept below root so
+// the operator configuration does not have to change.
+func openStore(state, root string) (*store, error) {
+	if state == "" {
+		return nil, fmt.Errorf("State Store: Required value: Please set the --state flag or export KOPS_STATE_STORE")
+	}
+	if strings.HasPrefix(state, "file://") {
+		return &store{dir: strings.TrimPrefix(state, "file://")}, nil
+	}
+	if i := strings.Index(state, "://"); i >= 0 {
+		return &store{dir: filepath.Join(root, state[:i], state[i+3:])}, nil
+	}
+	return &store{dir: state}, nil
+}
+
+func (s *store) path(name string, file ...string) string {
+	return filepath.Join(append([]string{s.dir, name}, file...)...)
+}
+
+// clusters returns the names of the clusters in the store
+func (s *store) clusters() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if _, err := os.Stat(s.path(e.Name(), manifestFile)); err == nil {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *store) exists(name string) bool {
+	_, err := os.Stat(s.path(name, manifestFile))
+	return err == nil
+}
+
+// manifest returns the last replaced manifest of the cluster and its revision
+func (s *store) manifest(name string) (string, string, error) {
+	data, err := ioutil.ReadFile(s.path(name, manifestFile))
+	if os.IsNotExist(err) {
+		return "", "", fmt.Errorf("cluster %q not found", name)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return string(data), fmt.Sprintf("%x", sha256.Sum256(data))[:12], nil
+}
+
+func (s *store) writeManifest(name, manifest string) error {
+	if err := os.MkdirAll(s.path(name), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path(name, manifestFile), []byte(manifest), 0644)
+}
+
+func (s *store) cluster(name string) (*cluster, error) {
+	c := &cluster{}
+	data, err := ioutil.ReadFile(s.path(name, clusterFile))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, json.Unmarshal(data, c)
+}
+
+func (s *store) writeCluster(name string, c *cluster) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path(name, clusterFile), data, 0644)
+}
+
+func (s *store) delete(name string) error {
+	return os.RemoveAll(s.path(name))
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"time"
+
 	"github.com/spf13/pflag"
 )
 
@@ -9,12 +11,32 @@ const (
 	defaultTmpDir = "/tmp"
 
 	//Kops
-	defaultKopsStateStore     = "s3://kops.state.seizadi.infoblox.com"
-	defaultKopsClusterDnsZone = "soheil.belamaric.com"
+	defaultKopsStateStore     = ""
+	defaultKopsStateStoreFile = false
+	defaultKopsClusterDnsZone = ""
 	defaultSSHKey             = "kops.pub"
-	defaultKopsContainer = "soheileizadi/kops:v1.0"
-	defaultKopsKubeDir = "kube"
-	defaultKopsPath = ".bin/kops"
+	defaultKopsContainer      = "soheileizadi/kops:v1.0"
+	defaultKopsKubeDir        = "kube"
+	defaultKopsPath           = ".bin/kops"
+	defaultKopsVersions       = ""
+	defaultKopsImages         = ""
+	defaultKopsExecutor       = "local"
+	defaultKopsWorkspaceDir   = ""
+	defaultKopsCloudProvider  = "aws"
+	defaultKopsJobKubectl     = "bitnami/kubectl:1.17"
+
+	//ClusterDefaults
+	defaultDefaultsNamespace      = ""
+	defaultDefaultsSourceURL      = ""
+	defaultDefaultsSourceTimeout  = 5 * time.Second
+	defaultDefaultsSourceCacheTTL = 5 * time.Minute
+
+	//Labels
+	defaultLabelsCloud = ""
+	defaultLabelsNode  = ""
+
+	//StateStore
+	defaultStateStoreProbeInterval = 5 * time.Minute
 
 	//Docker
 	defaultDockerBinPath = "/usr/local/bin/docker"
@@ -36,11 +58,19 @@ const (
 	defaultAwsSecretAccessKey = ""
 	defaultAwsRegion          = ""
 
-	// Development Flag
-	defaultClusterOperatorDevelopment bool = false
+	//Provider
+	defaultProvider                    = "kops"
+	defaultSimulatedConvergence        = 1 * time.Minute
+	defaultKindPath                    = "kind"
+	defaultKindNodeImage               = ""
+	defaultKindKubeConfigInternal bool = false
+	defaultCAPITemplate                = ""
 
 	//Reaper
 	defaultReaper bool = false
+
+	//Webhook
+	defaultWebhookProductionNamespaces = ""
 )
 
 var (
@@ -49,11 +79,31 @@ var (
 
 	// Kops
 	flagKopsStateStore     = pflag.String("kops.state.store", defaultKopsStateStore, "kops state store")
+	flagKopsStateStoreFile = pflag.Bool("kops.state.store.file", defaultKopsStateStoreFile, "accept file:// state stores, only fakekops reads them")
 	flagKopsClusterDnsZone = pflag.String("kops.cluster.dns.zone", defaultKopsClusterDnsZone, "kops cluster DNS zone")
 	flagSSHKey             = pflag.String("kops.ssh.key", defaultSSHKey, "kops ssh key")
-	flagKopsContainer = pflag.String("kops.container", defaultKopsContainer, "kops container")
-	flagKopsKubeDir  = pflag.String("kops.kube.dir", defaultKopsKubeDir, "kops kube directory")
-	flagKopsPath = pflag.String("kops.path", defaultKopsPath, "kops path")
+	flagKopsContainer      = pflag.String("kops.container", defaultKopsContainer, "kops container")
+	flagKopsKubeDir        = pflag.String("kops.kube.dir", defaultKopsKubeDir, "kops kube directory")
+	flagKopsPath           = pflag.String("kops.path", defaultKopsPath, "kops path")
+	flagKopsVersions       = pflag.String("kops.versions", defaultKopsVersions, "kops binaries by version, e.g. 1.16=.bin/kops-1.16,1.18=.bin/kops-1.18")
+	flagKopsImages         = pflag.String("kops.images", defaultKopsImages, "kops images of the container and job executors by version, e.g. 1.16=soheileizadi/kops:v1.16.0")
+	flagKopsExecutor       = pflag.String("kops.executor", defaultKopsExecutor, "kops executor, local, container or job")
+	flagKopsWorkspaceDir   = pflag.String("kops.workspace.dir", defaultKopsWorkspaceDir, "directory of the per cluster workspaces, defaults to tmp.dir/workspaces")
+	flagKopsCloudProvider  = pflag.String("kops.cloud.provider", defaultKopsCloudProvider, "cloud provider of new Clusters without one, aws, gce or openstack")
+	flagKopsJobKubectl     = pflag.String("kops.job.kubectl.image", defaultKopsJobKubectl, "kubectl image returning the kubeconfig exported by kops Jobs")
+
+	//ClusterDefaults
+	flagDefaultsNamespace      = pflag.String("defaults.namespace", defaultDefaultsNamespace, "namespace of the cluster wide ClusterDefaults")
+	flagDefaultsSourceURL      = pflag.String("defaults.source.url", defaultDefaultsSourceURL, "URL of the external defaults source, e.g. a CMDB")
+	flagDefaultsSourceTimeout  = pflag.Duration("defaults.source.timeout", defaultDefaultsSourceTimeout, "timeout of external defaults lookups")
+	flagDefaultsSourceCacheTTL = pflag.Duration("defaults.source.cache.ttl", defaultDefaultsSourceCacheTTL, "how long external defaults are cached")
+
+	//Labels
+	flagLabelsCloud = pflag.String("labels.cloud", defaultLabelsCloud, "Cluster labels and annotations copied to kops cloudLabels, e.g. team,cost-center=CostCenter")
+	flagLabelsNode  = pflag.String("labels.node", defaultLabelsNode, "Cluster labels and annotations copied to the nodeLabels of the instance groups, e.g. env")
+
+	//StateStore
+	flagStateStoreProbeInterval = pflag.Duration("statestore.probe.interval", defaultStateStoreProbeInterval, "interval between StateStore reachability probes")
 
 	//Docker
 	flagDockerBinPath = pflag.String("docker.bin.path", defaultDockerBinPath, "docker bin path")
@@ -72,9 +122,17 @@ var (
 	flagAwsSecretAccessKey = pflag.String("aws.secret.access.key", defaultAwsSecretAccessKey, "AWS secret access key")
 	flagAwsRegion          = pflag.String("aws.region", defaultAwsRegion, "AWS region")
 
-	// Developement Flag so eliminate Cloud interaction and speedup local developement
-	flagClusterOperatorDevelopment = pflag.Bool("development", defaultClusterOperatorDevelopment, "cluster operator development")
+	//Provider
+	flagProvider               = pflag.String("provider", defaultProvider, "provider creating the cloud resources of Clusters without spec.provider, kops, simulated, kind or capi")
+	flagSimulatedConvergence   = pflag.Duration("simulated.convergence", defaultSimulatedConvergence, "time instances of the simulated provider take to become ready")
+	flagKindPath               = pflag.String("kind.path", defaultKindPath, "kind path")
+	flagKindNodeImage          = pflag.String("kind.node.image", defaultKindNodeImage, "node image of kind clusters, e.g. kindest/node:v1.17.5")
+	flagKindKubeConfigInternal = pflag.Bool("kind.kubeconfig.internal", defaultKindKubeConfigInternal, "export kind kubeconfigs reaching the API server on the docker network")
+	flagCAPITemplate           = pflag.String("capi.template", defaultCAPITemplate, "infrastructure provider template of Cluster API clusters, CAPD when empty")
 
 	//Reaper
 	flagReaper = pflag.Bool("reaper", defaultReaper, "reaper value")
+
+	//Webhook
+	flagWebhookProductionNamespaces = pflag.String("webhook.production.namespaces", defaultWebhookProductionNamespaces, "label selector of the namespaces whose Clusters must be private and restrict access, empty disables the policy")
 )
@@ -7,18 +7,20 @@ import (
 	"fmt"
 	"os"
 	"runtime"
-	"strings"
 	"strconv"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/rest"
 
+	"github.com/infobloxopen/cluster-operator/kops"
 	"github.com/infobloxopen/cluster-operator/pkg/apis"
+	"github.com/infobloxopen/cluster-operator/pkg/clustervalidator"
 	"github.com/infobloxopen/cluster-operator/pkg/controller"
 	"github.com/infobloxopen/cluster-operator/pkg/controller/cluster"
+	"github.com/infobloxopen/cluster-operator/pkg/provisioner"
 	"github.com/infobloxopen/cluster-operator/version"
-	"github.com/infobloxopen/cluster-operator/pkg/clustervalidator"
 
 	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
 	kubemetrics "github.com/operator-framework/operator-sdk/pkg/kube-metrics"
@@ -29,6 +31,7 @@ import (
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -79,7 +82,8 @@ func init() {
 		}
 	}
 
-	if(viper.GetBool("development")) == true {
+	// GCE and OpenStack clusters take their credentials from Secrets
+	if viper.GetString("provider") == provisioner.KopsName && viper.GetString("kops.cloud.provider") == kops.CloudAWS {
 		// Check to see if the required configuration arguments are present
 		if len(viper.GetString("aws.access.key.id")) == 0 {
 			log.Error(errors.New("AWS_ACCESS_KEY_ID not configured"), "Missing Argument AWS_ACCESS_KEY_ID")
@@ -88,22 +92,42 @@ func init() {
 			log.Error(errors.New("AWS_SECRET_ACCESS_KEY not configured"), "Missing Argument AWS_SECRET_ACCESS_KEY")
 		}
 	}
-	if len(viper.GetString("kops.state.store")) == 0 {
-		log.Error(errors.New("KOPS_STATE_STORE not configured"), "Missing Argument KOPS_STATE_STORE")
+	// ClusterDefaults can provide these per namespace
+	if store := viper.GetString("kops.state.store"); len(store) == 0 {
+		log.Info("KOPS_STATE_STORE not configured, Clusters need a ClusterDefaults or StateStore providing it")
+	} else if _, err := kops.StateStoreScheme(store); err != nil {
+		log.Error(err, "Invalid KOPS_STATE_STORE")
 	}
 	if len(viper.GetString("kops.cluster.dns.zone")) == 0 {
-		log.Error(errors.New("KOPS_CLUSTER_DNS_ZONE not configured"), "Missing Argument KOPS_CLUSTER_DNS_ZONE")
+		log.Info("KOPS_CLUSTER_DNS_ZONE not configured, Clusters need a ClusterDefaults providing it or spec.dns: gossip")
 	}
 }
 
 func main() {
 	printVersion()
 
+	// Get a config to talk to the apiserver
+	cfg, err := config.GetConfig()
+	if err != nil {
+		log.Error(err, "")
+		os.Exit(1)
+	}
+
 	log.Info("Starting Validating Webhook Server...")
 
-	nsac := clustervalidator.ClusterAdmission{}
+	// The webhook reads StateStores and Namespaces to check Clusters may use their store
+	nsac, err := clustervalidator.NewClusterAdmission(cfg)
+	if err != nil {
+		log.Error(err, "Failed to create admission controller.")
+		os.Exit(1)
+	}
+	nsac.ProductionNamespaces, err = labels.Parse(viper.GetString("webhook.production.namespaces"))
+	if err != nil {
+		log.Error(err, "Failed to parse webhook.production.namespaces.")
+		os.Exit(1)
+	}
 	// TODO: hardcoded path and port number, can be pulled from env vars
-	s, err := clustervalidator.GetAdmissionValidationServer(&nsac, "/run/secrets/tls/tls.crt", "/run/secrets/tls/tls.key", "0.0.0.0:8443")
+	s, err := clustervalidator.GetAdmissionValidationServer(nsac, "/run/secrets/tls/tls.crt", "/run/secrets/tls/tls.key", "0.0.0.0:8443")
 	if err != nil {
 		log.Error(err, "Failed to create admission validation server.")
 		os.Exit(1)
@@ -111,7 +135,7 @@ func main() {
 
 	go func() {
 		s.ListenAndServeTLS("", "")
-    }()
+	}()
 
 	namespace, err := k8sutil.GetWatchNamespace()
 	if err != nil {
@@ -119,13 +143,6 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Get a config to talk to the apiserver
-	cfg, err := config.GetConfig()
-	if err != nil {
-		log.Error(err, "")
-		os.Exit(1)
-	}
-
 	ctx := context.TODO()
 	// Become the leader before proceeding
 	err = leader.Become(ctx, "cluster-operator-lock")
@@ -0,0 +1,158 @@
+package kops
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Cloud providers of kops the operator supports
+const (
+	CloudAWS       = "aws"
+	CloudGCE       = "gce"
+	CloudOpenStack = "openstack"
+)
+
+// Cloud holds what the operator knows about a kops cloud provider, the
+// defaults of its Clusters and the names of its machine types and zones
+type Cloud struct {
+	Name string
+	// MasterMachineType, NodeMachineType, BastionMachineType and Zones are
+	// the defaults of Clusters on the cloud
+	MasterMachineType  string
+	NodeMachineType    string
+	BastionMachineType string
+	Zones              []string
+	// StateStoreScheme is the URL scheme of the object store of the cloud
+	StateStoreScheme string
+
+	machineType *regexp.Regexp
+	zone        *regexp.Regexp
+}
+
+var clouds = map[string]Cloud{
+	CloudAWS: {
+		Name:               CloudAWS,
+		MasterMachineType:  "t3.medium",
+		NodeMachineType:    "t3.medium",
+		BastionMachineType: "t3.micro",
+		Zones:              []string{"us-east-2a"},
+		StateStoreScheme:   "s3",
+		// e.g. t3.medium, m5d.2xlarge
+		machineType: regexp.MustCompile(`^[a-z][a-z0-9-]*\.[a-z0-9]+$`),
+		// e.g. us-east-2a, us-gov-west-1b
+		zone: regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+[a-z]$`),
+	},
+	CloudGCE: {
+		Name:               CloudGCE,
+		MasterMachineType:  "n1-standard-1",
+		NodeMachineType:    "n1-standard-2",
+		BastionMachineType: "f1-micro",
+		Zones:              []string{"us-central1-a"},
+		StateStoreScheme:   "gs",
+		// e.g. n1-standard-2, e2-medium, custom-4-16384
+		machineType: regexp.MustCompile(`^[a-z][a-z0-9]*(-[a-z0-9]+)+$`),
+		// e.g. us-central1-a, europe-west4-b
+		zone: regexp.MustCompile(`^[a-z]+-[a-z]+[0-9]+-[a-z]$`),
+	},
+	CloudOpenStack: {
+		Name:               CloudOpenStack,
+		MasterMachineType:  "m1.medium",
+		NodeMachineType:    "m1.medium",
+		BastionMachineType: "m1.small",
+		Zones:              []string{"nova"},
+		StateStoreScheme:   "swift",
+		// Flavors and availability zones are named by the operator of the cloud
+		machineType: regexp.MustCompile(`^[^\s]+$`),
+		zone:        regexp.MustCompile(`^[^\s]+$`),
+	},
+}
+
+// CloudFor returns the cloud provider, kops Clusters run on AWS when it is
+// not set
+func CloudFor(name string) (Cloud, error) {
+	if name == "" {
+		name = CloudAWS
+	}
+	c, ok := clouds[name]
+	if !ok {
+		return Cloud{}, fmt.Errorf("unsupported cloud provider %q, expected %s, %s or %s", name, CloudAWS, CloudGCE, CloudOpenStack)
+	}
+	return c, nil
+}
+
+// ValidateMachineType checks the machine type is named like the ones of the cloud
+func (c Cloud) ValidateMachineType(machineType string) error {
+	if !c.machineType.MatchString(machineType) {
+		return fmt.Errorf("%q is not a %s machine type", machineType, c.Name)
+	}
+	return nil
+}
+
+// ValidateZone checks the zone is named like the ones of the cloud
+func (c Cloud) ValidateZone(zone string) error {
+	if !c.zone.MatchString(zone) {
+		return fmt.Errorf("%q is not a %s zone", zone, c.Name)
+	}
+	return nil
+}
+
+// ValidateManifest checks the machine types and zones of the instance
+// groups and the zones of the subnets of the manifest
+func (c Cloud) ValidateManifest(m *Manifest) error {
+	if doc, err := m.Cluster(); err == nil {
+		subnets, _, _ := unstructured.NestedSlice(doc, "spec", "subnets")
+		for _, s := range subnets {
+			subnet, _ := s.(map[string]interface{})
+			if zone, ok, _ := unstructured.NestedString(subnet, "zone"); ok {
+				if err := c.ValidateZone(zone); err != nil {
+					return fmt.Errorf("subnet %v: %s", subnet["name"], err)
+				}
+			}
+		}
+	}
+	for _, doc := range m.InstanceGroups() {
+		if machineType, ok, _ := unstructured.NestedString(doc, "spec", "machineType"); ok {
+			if err := c.ValidateMachineType(machineType); err != nil {
+				return fmt.Errorf("InstanceGroup %s: %s", Name(doc), err)
+			}
+		}
+		zones, _, _ := unstructured.NestedStringSlice(doc, "spec", "zones")
+		for _, zone := range zones {
+			if err := c.ValidateZone(zone); err != nil {
+				return fmt.Errorf("InstanceGroup %s: %s", Name(doc), err)
+			}
+		}
+	}
+	return nil
+}
+
+// stateStoreSchemes are the URL schemes of the state stores kops supports
+var stateStoreSchemes = []string{"s3", "gs", "swift"}
+
+// fileStateStoreScheme is only read by fakekops, the operator accepts it
+// with kops.state.store.file for development and tests
+const fileStateStoreScheme = "file"
+
+// StateStoreScheme returns the scheme of a state store URL, e.g. gs for
+// gs://bucket, or an error if kops does not support it
+func StateStoreScheme(url string) (string, error) {
+	i := strings.Index(url, "://")
+	if i <= 0 || i+3 == len(url) {
+		return "", fmt.Errorf("state store %q is not a URL like s3://bucket", url)
+	}
+	scheme := url[:i]
+	if scheme == fileStateStoreScheme && viper.GetBool("kops.state.store.file") {
+		return scheme, nil
+	}
+	for _, s := range stateStoreSchemes {
+		if s == scheme {
+			return scheme, nil
+		}
+	}
+	return "", fmt.Errorf("state store %q: unsupported scheme %s, expected one of %s",
+		url, scheme, strings.Join(stateStoreSchemes, ", "))
+}
@@ -0,0 +1,222 @@
+package kops
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/infobloxopen/cluster-operator/utils"
+	"sigs.k8s.io/yaml"
+)
+
+// credentialsProfile is the AWS profile kops runs with when a role is assumed
+const credentialsProfile = "cluster-operator"
+
+// Credentials are the cloud credentials kops runs with for one cluster,
+// either AWS, GCE or OpenStack ones
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// RoleARN is assumed with the access key pair, or the operator
+	// credentials when the pair is not set
+	RoleARN    string
+	ExternalID string
+
+	// GCEServiceAccount is the JSON key of a GCE service account
+	GCEServiceAccount string
+
+	// OpenStackCloudsYAML is a clouds.yaml file, OpenStackCloud names the
+	// cloud of the file kops uses and may be empty if it has only one
+	OpenStackCloudsYAML string
+	OpenStackCloud      string
+}
+
+// Cloud returns the cloud provider the credentials are for
+func (c Credentials) Cloud() string {
+	switch {
+	case c.GCEServiceAccount != "":
+		return CloudGCE
+	case c.OpenStackCloudsYAML != "" || c.OpenStackCloud != "":
+		return CloudOpenStack
+	}
+	return CloudAWS
+}
+
+// Validate checks the credentials are for one cloud. AWS credentials name
+// an access key pair, a role or both.
+func (c Credentials) Validate() error {
+	aws := c.AccessKeyID != "" || c.SecretAccessKey != "" || c.RoleARN != "" || c.ExternalID != ""
+	switch c.Cloud() {
+	case CloudGCE:
+		if aws || c.OpenStackCloudsYAML != "" || c.OpenStackCloud != "" {
+			return fmt.Errorf("GCE service account cannot be combined with other credentials")
+		}
+		var key struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal([]byte(c.GCEServiceAccount), &key); err != nil {
+			return fmt.Errorf("GCE service account is not a JSON key: %s", err)
+		}
+		if key.Type != "service_account" {
+			return fmt.Errorf("GCE service account key has type %q, expected service_account", key.Type)
+		}
+		return nil
+	case CloudOpenStack:
+		if aws {
+			return fmt.Errorf("OpenStack clouds.yaml cannot be combined with other credentials")
+		}
+		if c.OpenStackCloudsYAML == "" {
+			return fmt.Errorf("OpenStack cloud is set without a clouds.yaml")
+		}
+		var file struct {
+			Clouds map[string]interface{} `json:"clouds"`
+		}
+		if err := yaml.Unmarshal([]byte(c.OpenStackCloudsYAML), &file); err != nil {
+			return fmt.Errorf("OpenStack clouds.yaml: %s", err)
+		}
+		if c.OpenStackCloud == "" && len(file.Clouds) != 1 {
+			return fmt.Errorf("OpenStack clouds.yaml has %d clouds, the cloud must be named", len(file.Clouds))
+		}
+		if _, ok := file.Clouds[c.OpenStackCloud]; c.OpenStackCloud != "" && !ok {
+			return fmt.Errorf("OpenStack clouds.yaml has no cloud %s", c.OpenStackCloud)
+		}
+		return nil
+	}
+
+	if (c.AccessKeyID == "") != (c.SecretAccessKey == "") {
+		return fmt.Errorf("access key ID and secret access key must be set together")
+	}
+	if c.AccessKeyID == "" && c.RoleARN == "" {
+		return fmt.Errorf("neither an access key pair nor a role ARN is set")
+	}
+	if c.ExternalID != "" && c.RoleARN == "" {
+		return fmt.Errorf("external ID is only used to assume a role")
+	}
+	return nil
+}
+
+// SetCredentials makes the kops commands of the cluster run with the
+// credentials instead of the operator ones. Roles are assumed through an AWS
+// config file in the workspace, the AWS SDK in kops reads it. GCE and
+// OpenStack credentials are written to the workspace for their SDKs.
+func (k *KopsCmd) SetCredentials(clusterName string, c Credentials) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	// Roles without a key pair are assumed with the operator keys, nothing
+	// else of the operator credentials is passed on
+	operator := map[string]string{}
+	for _, name := range credentialVars {
+		operator[name] = k.env[name]
+		delete(k.env, name)
+	}
+
+	switch c.Cloud() {
+	case CloudGCE:
+		path, err := k.writeCredentialsFile("gce-credentials-"+clusterName+".json", []byte(c.GCEServiceAccount))
+		if err != nil {
+			return err
+		}
+		k.env["GOOGLE_APPLICATION_CREDENTIALS"] = path
+		return nil
+	case CloudOpenStack:
+		path, err := k.writeCredentialsFile("clouds-"+clusterName+".yaml", []byte(c.OpenStackCloudsYAML))
+		if err != nil {
+			return err
+		}
+		k.env["OS_CLIENT_CONFIG_FILE"] = path
+		if c.OpenStackCloud != "" {
+			k.env["OS_CLOUD"] = c.OpenStackCloud
+		}
+		return nil
+	}
+
+	if c.AccessKeyID != "" {
+		k.env["AWS_ACCESS_KEY_ID"] = c.AccessKeyID
+		k.env["AWS_SECRET_ACCESS_KEY"] = c.SecretAccessKey
+	} else {
+		for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"} {
+			if v := operator[name]; v != "" {
+				k.env[name] = v
+			}
+		}
+	}
+	if c.RoleARN == "" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "[profile %s]\n", credentialsProfile)
+	fmt.Fprintf(&buf, "role_arn = %s\n", c.RoleARN)
+	if c.ExternalID != "" {
+		fmt.Fprintf(&buf, "external_id = %s\n", c.ExternalID)
+	}
+	fmt.Fprintln(&buf, "credential_source = Environment")
+
+	path, err := k.writeCredentialsFile("aws-config-"+clusterName, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	k.env["AWS_CONFIG_FILE"] = path
+	k.env["AWS_PROFILE"] = credentialsProfile
+	k.env["AWS_SDK_LOAD_CONFIG"] = "1"
+	return nil
+}
+
+// writeWorkspaceFile writes the file to the workspace and returns its
+// absolute path
+func (k *KopsCmd) writeWorkspaceFile(name string, data []byte) (string, error) {
+	path, err := k.workspacePath(name)
+	if err != nil {
+		return "", err
+	}
+	return path, utils.CopyBufferContentsToFile(data, path)
+}
+
+// writeCredentialsFile writes the file readable by the operator only to
+// the workspace and returns its absolute path, RemoveCredentials removes it
+func (k *KopsCmd) writeCredentialsFile(name string, data []byte) (string, error) {
+	path, err := k.workspacePath(name)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	k.credentialFiles = append(k.credentialFiles, path)
+	// Files of older operators were created world readable
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// workspacePath creates the workspace and returns the absolute path of the
+// file in it
+func (k *KopsCmd) workspacePath(name string) (string, error) {
+	var mode os.FileMode = 509
+	if err := os.MkdirAll(k.workspace, mode); err != nil {
+		return "", err
+	}
+	return filepath.Abs(filepath.Join(k.workspace, name))
+}
+
+// RemoveCredentials removes the files SetCredentials wrote, the commands of
+// the cluster cannot run afterwards
+func (k *KopsCmd) RemoveCredentials() error {
+	for _, path := range k.credentialFiles {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	k.credentialFiles = nil
+	return nil
+}
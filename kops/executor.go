@@ -0,0 +1,209 @@
+package kops
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/infobloxopen/cluster-operator/utils"
+	"github.com/spf13/viper"
+)
+
+// Executors selectable with kops.executor
+const (
+	// LocalExecutorName runs the kops binary on the operator host
+	LocalExecutorName = "local"
+	// ContainerExecutorName runs kops in the kops.container image
+	ContainerExecutorName = "container"
+	// JobExecutorName runs kops in Jobs owned by the Cluster, the controller
+	// provides the executor with SetExecutor
+	JobExecutorName = "job"
+)
+
+// ErrPending is returned by executors running commands asynchronously while
+// the command has not finished, the step is retried once it completed
+var ErrPending = errors.New("kops command is still running")
+
+// Executor runs kops command lines for a cluster
+type Executor interface {
+	// RunStreamingCmd runs the command line streaming its output to the log
+	RunStreamingCmd(cmd string) error
+	// RunCmd runs the command line and returns its output
+	RunCmd(cmd string) (*bytes.Buffer, error)
+}
+
+// LocalExecutor runs kops command lines on the operator host, Env is added
+// to the environment of the operator
+type LocalExecutor struct {
+	Env map[string]string
+}
+
+func (e LocalExecutor) RunStreamingCmd(cmd string) error {
+	return utils.RunStreamingCmd(cmd, envList(e.Env)...)
+}
+
+func (e LocalExecutor) RunCmd(cmd string) (*bytes.Buffer, error) {
+	return utils.RunCmd(cmd, envList(e.Env)...)
+}
+
+// ExecutorConfig is what executors isolating the cluster run kops with
+type ExecutorConfig struct {
+	Image     string
+	Workspace string
+	Env       map[string]string
+}
+
+// unboundExecutor fails every command until the controller sets the executor
+type unboundExecutor struct {
+	name string
+}
+
+func (e unboundExecutor) RunStreamingCmd(cmd string) error {
+	_, err := e.RunCmd(cmd)
+	return err
+}
+
+func (e unboundExecutor) RunCmd(cmd string) (*bytes.Buffer, error) {
+	return nil, fmt.Errorf("kops.executor %s has no executor set", e.name)
+}
+
+// ContainerExecutor runs each kops command line in the kops image. Only the
+// workspace of the cluster is mounted and credentials are passed with an env
+// file, so a command cannot see the files of other clusters.
+type ContainerExecutor struct {
+	Image     string
+	Workspace string
+	Env       map[string]string
+}
+
+func (e *ContainerExecutor) RunStreamingCmd(cmd string) error {
+	args, err := e.dockerArgs(cmd)
+	if err != nil {
+		return err
+	}
+	return utils.RunStreamingDockerCmd(args)
+}
+
+func (e *ContainerExecutor) RunCmd(cmd string) (*bytes.Buffer, error) {
+	args, err := e.dockerArgs(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return utils.RunDockerCmd(args)
+}
+
+// dockerArgs writes the command line and the env file to the workspace and
+// returns the docker run arguments executing them. The workspace is mounted
+// at the same path so paths in the command line resolve in the container.
+func (e *ContainerExecutor) dockerArgs(cmd string) ([]string, error) {
+	var mode os.FileMode = 0700
+	if err := os.MkdirAll(e.Workspace, mode); err != nil {
+		return nil, err
+	}
+
+	script := filepath.Join(e.Workspace, "kops.sh")
+	if err := utils.CopyBufferContentsToFile([]byte(cmd+"\n"), script); err != nil {
+		return nil, err
+	}
+
+	envFile := filepath.Join(e.Workspace, "kops.env")
+	if err := writeEnvFile(envFile, e.Env); err != nil {
+		return nil, err
+	}
+
+	return []string{
+		"run", "--rm",
+		"--env-file=" + envFile,
+		"-v", e.Workspace + ":" + e.Workspace,
+		"-w", e.Workspace,
+		"--entrypoint", "/bin/sh",
+		e.Image,
+		script,
+	}, nil
+}
+
+// envList returns the variables as sorted KEY=value pairs
+func envList(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	list := make([]string, 0, len(env))
+	for _, k := range keys {
+		list = append(list, k+"="+env[k])
+	}
+	return list
+}
+
+// writeEnvFile writes the variables in docker env file format readable only by the operator
+func writeEnvFile(path string, env map[string]string) error {
+	var buf bytes.Buffer
+	for _, v := range envList(env) {
+		fmt.Fprintln(&buf, v)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Workspace returns the directory holding the files of one cluster for
+// executors that isolate clusters from each other
+func Workspace(clusterName string) (string, error) {
+	dir := viper.GetString("kops.workspace.dir")
+	if dir == "" {
+		dir = filepath.Join(viper.GetString("tmp.dir"), "workspaces")
+	}
+	return filepath.Abs(filepath.Join(dir, clusterName))
+}
+
+// credentialVars carry cloud credentials, the ones of the operator are
+// replaced by the credentials of a cluster
+var credentialVars = []string{
+	"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE",
+	"AWS_CONFIG_FILE", "AWS_SHARED_CREDENTIALS_FILE", "AWS_SDK_LOAD_CONFIG",
+	"AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE",
+	"GOOGLE_APPLICATION_CREDENTIALS",
+	"OS_CLIENT_CONFIG_FILE", "OS_CLOUD",
+}
+
+// localEnv returns the cloud settings of the operator environment kops runs
+// with on the operator host, the rest of the environment is not passed on
+func localEnv() map[string]string {
+	env := map[string]string{}
+	for _, name := range append([]string{"AWS_REGION", "AWS_DEFAULT_REGION"}, credentialVars...) {
+		if v, ok := os.LookupEnv(name); ok {
+			env[name] = v
+		}
+	}
+	return env
+}
+
+// kopsEnv returns the environment a kops process needs for the cluster
+func kopsEnv(kubeConfig string) map[string]string {
+	env := map[string]string{
+		"KOPS_STATE_STORE": viper.GetString("kops.state.store"),
+		"KUBECONFIG":       kubeConfig,
+	}
+	for name, key := range map[string]string{
+		"AWS_ACCESS_KEY_ID":     "aws.access.key.id",
+		"AWS_SECRET_ACCESS_KEY": "aws.secret.access.key",
+		"AWS_REGION":            "aws.region",
+	} {
+		if v := viper.GetString(key); v != "" {
+			env[name] = v
+		}
+	}
+	return env
+}
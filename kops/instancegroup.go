@@ -0,0 +1,412 @@
+package kops
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// Labels kops sets on the InstanceGroups and their nodes
+const (
+	ClusterLabel       = "kops.k8s.io/cluster"
+	InstanceGroupLabel = "kops.k8s.io/instancegroup"
+)
+
+// NodePoolInstanceGroup renders the InstanceGroup document of a NodePool of
+// the cluster. The machine type and the image default to the ones of the
+// Node groups of the manifest, the zones to its subnets.
+func (m *Manifest) NodePoolInstanceGroup(name string, pool clusteroperatorv1alpha1.NodePoolSpec) (map[string]interface{}, error) {
+	doc, err := m.Cluster()
+	if err != nil {
+		return nil, err
+	}
+	machineType, image := pool.MachineType, pool.Image
+	for _, ig := range m.InstanceGroups() {
+		if role, _, _ := unstructured.NestedString(ig, "spec", "role"); role != "Node" {
+			continue
+		}
+		if machineType == "" {
+			machineType, _, _ = unstructured.NestedString(ig, "spec", "machineType")
+		}
+		if image == "" {
+			image, _, _ = unstructured.NestedString(ig, "spec", "image")
+		}
+	}
+	if machineType == "" {
+		cloud, err := CloudFor(m.CloudProvider())
+		if err != nil {
+			return nil, err
+		}
+		machineType = cloud.NodeMachineType
+	}
+
+	zones := pool.Zones
+	if len(zones) == 0 {
+		zones = m.Subnets()
+	}
+	subnets := make([]interface{}, 0, len(zones))
+	for _, zone := range zones {
+		subnets = append(subnets, zone)
+	}
+	maxSize := pool.MaxSize
+	if maxSize < pool.MinSize {
+		maxSize = pool.MinSize
+	}
+	nodeLabels := map[string]interface{}{InstanceGroupLabel: name}
+	for k, v := range pool.Labels {
+		nodeLabels[k] = v
+	}
+
+	spec := map[string]interface{}{
+		"machineType": machineType,
+		"minSize":     int64(pool.MinSize),
+		"maxSize":     int64(maxSize),
+		"nodeLabels":  nodeLabels,
+		"role":        "Node",
+		"subnets":     subnets,
+	}
+	if image != "" {
+		spec["image"] = image
+	}
+	if len(pool.Taints) > 0 {
+		taints := make([]interface{}, 0, len(pool.Taints))
+		for _, taint := range pool.Taints {
+			taints = append(taints, taint)
+		}
+		spec["taints"] = taints
+	}
+	if pool.Spot != nil {
+		if err := m.checkSpotCloud(); err != nil {
+			return nil, err
+		}
+		setSpot(spec, *pool.Spot, machineType)
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "kops.k8s.io/v1alpha2",
+		"kind":       "InstanceGroup",
+		"metadata": map[string]interface{}{
+			"name":   name,
+			"labels": map[string]interface{}{ClusterLabel: Name(doc)},
+		},
+		"spec": spec,
+	}, nil
+}
+
+// SetWorkerSpot runs the Node InstanceGroups of the manifest on spot
+// instances, the Master and Bastion groups are left alone. Spot instances
+// are only supported on AWS.
+func (m *Manifest) SetWorkerSpot(spot clusteroperatorv1alpha1.SpotSpec) error {
+	if err := m.checkSpotCloud(); err != nil {
+		return err
+	}
+	for _, ig := range m.InstanceGroups() {
+		if role, _, _ := unstructured.NestedString(ig, "spec", "role"); role != "Node" {
+			continue
+		}
+		spec, ok := ig["spec"].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("InstanceGroup %q has no spec", Name(ig))
+		}
+		machineType, _, _ := unstructured.NestedString(spec, "machineType")
+		setSpot(spec, spot, machineType)
+	}
+	return nil
+}
+
+// checkSpotCloud rejects spot instances on other clouds than AWS
+func (m *Manifest) checkSpotCloud() error {
+	if cloud := m.CloudProvider(); cloud != "" && cloud != CloudAWS {
+		return fmt.Errorf("spot instances are not supported on %s", cloud)
+	}
+	return nil
+}
+
+// CheckMasterSpot rejects Master InstanceGroups that can run spot
+// instances, losing them takes the control plane down
+func (m *Manifest) CheckMasterSpot() error {
+	for _, ig := range m.InstanceGroups() {
+		if role, _, _ := unstructured.NestedString(ig, "spec", "role"); role == "Master" && IsSpot(ig) {
+			return fmt.Errorf("the master InstanceGroup %s runs on spot instances", Name(ig))
+		}
+	}
+	return nil
+}
+
+// setSpot configures the spec of an InstanceGroup of machineType for spot
+// instances. A maxPrice alone bids for spot instances of the machine type,
+// the other settings become the mixedInstancesPolicy of the group, which
+// runs only spot instances unless it has an on-demand share.
+func setSpot(spec map[string]interface{}, spot clusteroperatorv1alpha1.SpotSpec, machineType string) {
+	if spot.MaxPrice != "" {
+		spec["maxPrice"] = spot.MaxPrice
+	}
+	if spot.MaxPrice != "" && len(spot.MachineTypes) == 0 && spot.OnDemandBase == nil &&
+		spot.OnDemandAboveBase == nil && spot.AllocationStrategy == "" {
+		delete(spec, "mixedInstancesPolicy")
+		return
+	}
+
+	var instances []interface{}
+	seen := map[string]bool{}
+	for _, t := range append([]string{machineType}, spot.MachineTypes...) {
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		instances = append(instances, t)
+	}
+	policy := map[string]interface{}{
+		"instances":         instances,
+		"onDemandBase":      int64(0),
+		"onDemandAboveBase": int64(0),
+	}
+	if spot.OnDemandBase != nil {
+		policy["onDemandBase"] = int64(*spot.OnDemandBase)
+	}
+	if spot.OnDemandAboveBase != nil {
+		policy["onDemandAboveBase"] = int64(*spot.OnDemandAboveBase)
+	}
+	if spot.AllocationStrategy != "" {
+		policy["spotAllocationStrategy"] = string(spot.AllocationStrategy)
+	}
+	spec["mixedInstancesPolicy"] = policy
+}
+
+// IsSpot reports if the InstanceGroup can run spot instances, it has a
+// maxPrice or a mixedInstancesPolicy that is not all on-demand
+func IsSpot(ig map[string]interface{}) bool {
+	if price, _, _ := unstructured.NestedString(ig, "spec", "maxPrice"); price != "" {
+		return true
+	}
+	if _, ok, _ := unstructured.NestedMap(ig, "spec", "mixedInstancesPolicy"); !ok {
+		return false
+	}
+	// kops launches only on-demand instances above the base by default
+	aboveBase, ok := intField(ig, "spec", "mixedInstancesPolicy", "onDemandAboveBase")
+	return ok && aboveBase < 100
+}
+
+// DesiredInstanceMix splits the minSize of the InstanceGroup into the
+// on-demand and the spot instances its mixedInstancesPolicy asks for, the
+// way AWS rounds them. The running instances may differ while spot
+// capacity is short.
+func DesiredInstanceMix(ig map[string]interface{}) (onDemand, spot int) {
+	size, _ := intField(ig, "spec", "minSize")
+	if !IsSpot(ig) {
+		return size, 0
+	}
+	if _, ok, _ := unstructured.NestedMap(ig, "spec", "mixedInstancesPolicy"); !ok {
+		return 0, size
+	}
+	base, _ := intField(ig, "spec", "mixedInstancesPolicy", "onDemandBase")
+	aboveBase, _ := intField(ig, "spec", "mixedInstancesPolicy", "onDemandAboveBase")
+	if base > size {
+		base = size
+	}
+	onDemand = base + ((size-base)*aboveBase+99)/100
+	return onDemand, size - onDemand
+}
+
+// InstanceGroupStatuses reports the size and the desired on-demand/spot mix
+// of the InstanceGroups of a manifest, like the output of kops get ig -o yaml
+func InstanceGroupStatuses(manifest string) ([]clusteroperatorv1alpha1.InstanceGroupStatus, error) {
+	m, err := ParseManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []clusteroperatorv1alpha1.InstanceGroupStatus
+	for _, ig := range m.InstanceGroups() {
+		role, _, _ := unstructured.NestedString(ig, "spec", "role")
+		minSize, _ := intField(ig, "spec", "minSize")
+		maxSize, _ := intField(ig, "spec", "maxSize")
+		onDemand, spot := DesiredInstanceMix(ig)
+		statuses = append(statuses, clusteroperatorv1alpha1.InstanceGroupStatus{
+			Name:            Name(ig),
+			Role:            role,
+			MinSize:         int32(minSize),
+			MaxSize:         int32(maxSize),
+			DesiredOnDemand: int32(onDemand),
+			DesiredSpot:     int32(spot),
+		})
+	}
+	return statuses, nil
+}
+
+// Group is an InstanceGroup of a manifest with the number of instances
+// it runs and the zone of its first subnet
+type Group struct {
+	Name string
+	Role string
+	Zone string
+	Size int
+}
+
+// ManifestGroups returns the InstanceGroups of the manifest, groups without
+// minSize run one instance and groups without subnets run in zone local
+func ManifestGroups(manifest string) ([]Group, error) {
+	m, err := ParseManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	var groups []Group
+	for _, doc := range m.InstanceGroups() {
+		g := Group{Name: Name(doc), Zone: "local", Size: 1}
+		g.Role, _, _ = unstructured.NestedString(doc, "spec", "role")
+		if size, ok := intField(doc, "spec", "minSize"); ok {
+			g.Size = size
+		}
+		if subnets, ok, _ := unstructured.NestedStringSlice(doc, "spec", "subnets"); ok && len(subnets) > 0 {
+			g.Zone = subnets[0]
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// intField returns a number of the document, parsed manifests hold floats
+func intField(doc map[string]interface{}, fields ...string) (int, bool) {
+	v, ok, _ := unstructured.NestedFieldNoCopy(doc, fields...)
+	if !ok {
+		return 0, false
+	}
+	switch n := v.(type) {
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	}
+	return 0, false
+}
+
+// Subnets returns the names of the subnets of the kops Cluster document the
+// nodes can run in, utility subnets only hold load balancers and bastions
+func (m *Manifest) Subnets() []string {
+	doc, err := m.Cluster()
+	if err != nil {
+		return nil
+	}
+	subnets, _, _ := unstructured.NestedSlice(doc, "spec", "subnets")
+	var names []string
+	for _, s := range subnets {
+		subnet, ok := s.(map[string]interface{})
+		if !ok || subnet["type"] == "Utility" {
+			continue
+		}
+		if name, ok := subnet["name"].(string); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// SetInstanceGroup replaces the InstanceGroup document of the same name or
+// adds it, the way kops replace stores it
+func (m *Manifest) SetInstanceGroup(ig map[string]interface{}) {
+	for i, doc := range m.Documents {
+		if Kind(doc) == "InstanceGroup" && Name(doc) == Name(ig) {
+			m.Documents[i] = ig
+			return
+		}
+	}
+	m.Documents = append(m.Documents, ig)
+}
+
+// KeepInstanceGroups adds the InstanceGroups of the stored manifest the
+// manifest does not list, kops replace keeps them
+func (m *Manifest) KeepInstanceGroups(stored *Manifest) {
+	for _, ig := range stored.InstanceGroups() {
+		if m.Find("InstanceGroup", Name(ig)) == nil {
+			m.Documents = append(m.Documents, ig)
+		}
+	}
+}
+
+// InstanceGroupCluster returns the cluster an InstanceGroup document is
+// labeled for, kops replace stores it in the manifest of that cluster
+func InstanceGroupCluster(ig map[string]interface{}) (string, error) {
+	name, _, _ := unstructured.NestedString(ig, "metadata", "labels", ClusterLabel)
+	if name == "" {
+		return "", fmt.Errorf("InstanceGroup %q has no %s label", Name(ig), ClusterLabel)
+	}
+	return name, nil
+}
+
+// RemoveInstanceGroup removes the InstanceGroup document, it reports if the
+// manifest had one
+func (m *Manifest) RemoveInstanceGroup(name string) bool {
+	for i, doc := range m.Documents {
+		if Kind(doc) == "InstanceGroup" && Name(doc) == name {
+			m.Documents = append(m.Documents[:i], m.Documents[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// GroupRevisions identifies the manifest every InstanceGroup runs, it
+// changes with the Cluster document and the document of the group but not
+// with its size. The simulations roll only the groups whose revision
+// changed.
+func (m *Manifest) GroupRevisions() (map[string]string, error) {
+	doc, err := m.Cluster()
+	if err != nil {
+		return nil, err
+	}
+	cluster, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	revisions := map[string]string{}
+	for _, ig := range m.InstanceGroups() {
+		ig = runtime.DeepCopyJSON(ig)
+		unstructured.RemoveNestedField(ig, "spec", "minSize")
+		unstructured.RemoveNestedField(ig, "spec", "maxSize")
+		group, err := yaml.Marshal(ig)
+		if err != nil {
+			return nil, err
+		}
+		revisions[Name(ig)] = fmt.Sprintf("%x", sha256.Sum256(append(cluster, group...)))[:12]
+	}
+	return revisions, nil
+}
+
+// notEnoughNodes is the validation failure kops reports for an
+// InstanceGroup with fewer ready nodes than its minSize
+var notEnoughNodes = regexp.MustCompile(`did not have enough nodes (\d+) vs (\d+)`)
+
+// NotEnoughNodesFailure returns the failure kops validate reports for the
+// group with ready of its minSize nodes
+func NotEnoughNodesFailure(group string, ready, minSize int) clusteroperatorv1alpha1.KopsFailure {
+	return clusteroperatorv1alpha1.KopsFailure{
+		Type:    "InstanceGroup",
+		Name:    group,
+		Message: fmt.Sprintf("InstanceGroup %q did not have enough nodes %d vs %d", group, ready, minSize),
+	}
+}
+
+// ReadyNodes returns the number of ready nodes of the group from the result
+// of kops validate, the group has its minSize unless kops reports it short
+// of nodes. Nothing is ready when validation returned no result.
+func ReadyNodes(status clusteroperatorv1alpha1.KopsStatus, group string, minSize int) int {
+	for _, f := range status.Failures {
+		if f.Type != "InstanceGroup" || f.Name != group {
+			continue
+		}
+		if match := notEnoughNodes.FindStringSubmatch(f.Message); match != nil {
+			ready, _ := strconv.Atoi(match[1])
+			return ready
+		}
+		return 0
+	}
+	if len(status.Nodes) == 0 {
+		return 0
+	}
+	return minSize
+}
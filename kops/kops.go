@@ -5,7 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"strings"
+	"sync"
+
 	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
 	"github.com/infobloxopen/cluster-operator/utils"
 	"github.com/spf13/viper"
@@ -13,36 +16,238 @@ import (
 )
 
 type KopsCmd struct {
-	devMode         bool
 	publicKey       string
 	runStreamingCmd func(string) error
 	runCmd          func(string) (*bytes.Buffer, error)
-	path string
+	path            string
+	version         string
+	// workspace holds the kops manifests and kubeConfigDir the exported kubeconfigs
+	workspace     string
+	kubeConfigDir string
+	// image is what isolated executors run kops with, env is added to the
+	// environment of every kops command
+	image string
+	env   map[string]string
+	// stateStore is the kops state store of the cluster
+	stateStore string
+	// credentialFiles are the files SetCredentials wrote to the workspace
+	credentialFiles []string
 }
 
-func NewKops() (*KopsCmd, error) {
+// NewKops returns a runner for the cluster bound to the kops binary for the
+// version, or its image in isolated executors, the default kops.path binary
+// and kops.container image are used when no version is requested.
+// The executor running the commands is selected with kops.executor.
+func NewKops(clusterName, version string) (*KopsCmd, error) {
+	path := viper.GetString("kops.path")
+	e := viper.GetString("kops.executor")
+	if version != "" && (e == "" || e == LocalExecutorName) {
+		p, err := BinaryFor(version)
+		if err != nil {
+			return nil, err
+		}
+		path = p
+	}
+
 	k := KopsCmd{
-		publicKey:       viper.GetString("kops.ssh.key"),
-		devMode:         viper.GetBool("development"),
-		runStreamingCmd: utils.RunStreamingCmd,
-		runCmd:          utils.RunCmd,
-		path: viper.GetString("kops.path"),
+		publicKey:     viper.GetString("kops.ssh.key"),
+		path:          path,
+		version:       version,
+		workspace:     "." + viper.GetString("kops.kube.dir"),
+		kubeConfigDir: viper.GetString("tmp.dir"),
+		env:           localEnv(),
+		stateStore:    viper.GetString("kops.state.store"),
+	}
+
+	var executor Executor
+	switch e {
+	case "", LocalExecutorName:
+		// kops reaches the API server through the exported kubeconfig, the
+		// name of gossip clusters does not resolve outside of the cluster
+		k.env["KUBECONFIG"] = k.KubeConfigPath(clusterName)
+		executor = LocalExecutor{Env: k.env}
+	case ContainerExecutorName, JobExecutorName:
+		k.image = viper.GetString("kops.container")
+		if version != "" {
+			image, err := ImageFor(version)
+			if err != nil {
+				return nil, err
+			}
+			k.image = image
+		}
+		workspace, err := Workspace(clusterName)
+		if err != nil {
+			return nil, err
+		}
+		k.path = "kops"
+		k.workspace = workspace
+		k.kubeConfigDir = workspace
+		k.env = kopsEnv(k.KubeConfigPath(clusterName))
+		if e == ContainerExecutorName {
+			executor = &ContainerExecutor{
+				Image:     k.image,
+				Workspace: workspace,
+				Env:       k.env,
+			}
+		} else {
+			executor = unboundExecutor{name: e}
+		}
+	default:
+		return nil, fmt.Errorf("unknown kops.executor %q", e)
 	}
+	k.SetExecutor(executor)
 
 	return &k, nil
 }
 
+// SetStateStore makes the kops commands use the state store instead of
+// kops.state.store, region is the region of the bucket or swift container
+// if known
+func (k *KopsCmd) SetStateStore(url, region string) {
+	k.stateStore = url
+	k.env["KOPS_STATE_STORE"] = url
+	if region == "" {
+		return
+	}
+	switch {
+	case strings.HasPrefix(url, "swift://"):
+		k.env["OS_REGION_NAME"] = region
+	case strings.HasPrefix(url, "gs://"):
+		// Buckets are global, the region is part of the bucket
+	default:
+		k.env["AWS_REGION"] = region
+	}
+}
+
+// ProbeStateStore checks the state store can be listed, a store without
+// clusters is reachable
+func (k *KopsCmd) ProbeStateStore() error {
+	out, err := k.runCmd(k.path + " get cluster --state=" + k.stateStore)
+	if err == nil {
+		return nil
+	}
+	if out == nil || out.Len() == 0 {
+		return err
+	}
+	if strings.Contains(out.String(), "No clusters found") {
+		return nil
+	}
+	return fmt.Errorf("%s: %s", err, strings.TrimSpace(out.String()))
+}
+
+// SetExecutor replaces the executor running the kops commands
+func (k *KopsCmd) SetExecutor(e Executor) {
+	k.runStreamingCmd = e.RunStreamingCmd
+	k.runCmd = e.RunCmd
+}
+
+// ExecutorConfig returns the image, workspace and environment the commands
+// of the cluster run with in isolated executors
+func (k *KopsCmd) ExecutorConfig() ExecutorConfig {
+	return ExecutorConfig{
+		Image:     k.image,
+		Workspace: k.workspace,
+		Env:       k.env,
+	}
+}
+
+// KubeConfigPath returns where the kubeconfig of the cluster is exported
+func (k *KopsCmd) KubeConfigPath(clusterName string) string {
+	return k.kubeConfigDir + "/config-" + clusterName
+}
+
+// Versions returns the kops binaries the operator can run keyed by version,
+// configured in kops.versions as "1.16=.bin/kops-1.16,1.18=.bin/kops-1.18"
+func Versions() (map[string]string, error) {
+	return registry("kops.versions")
+}
+
+// Images returns the kops images isolated executors can run keyed by
+// version, configured in kops.images as "1.16=soheileizadi/kops:v1.16.0"
+func Images() (map[string]string, error) {
+	return registry("kops.images")
+}
+
+// registry parses the version=value entries of the setting
+func registry(setting string) (map[string]string, error) {
+	versions := map[string]string{}
+	for _, entry := range strings.Split(viper.GetString(setting), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid %s entry %q, expected version=value", setting, entry)
+		}
+		versions[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return versions, nil
+}
+
+// BinaryFor returns the path of the kops binary for the version. An exact
+// match is preferred, otherwise the latest binary of the same minor release
+// is used.
+func BinaryFor(version string) (string, error) {
+	versions, err := Versions()
+	if err != nil {
+		return "", err
+	}
+	return lookup("kops.versions", versions, version)
+}
+
+// ImageFor returns the kops image for the version, matched like BinaryFor
+func ImageFor(version string) (string, error) {
+	images, err := Images()
+	if err != nil {
+		return "", err
+	}
+	return lookup("kops.images", images, version)
+}
+
+func lookup(setting string, versions map[string]string, version string) (string, error) {
+	if value, ok := versions[version]; ok {
+		return value, nil
+	}
+
+	want, err := ParseVersion(version)
+	if err != nil {
+		return "", err
+	}
+	var best *Version
+	value := ""
+	for v, entry := range versions {
+		have, err := ParseVersion(v)
+		if err != nil || have.Major != want.Major || have.Minor != want.Minor {
+			continue
+		}
+		// Entries of equal versions, e.g. 1.18 and 1.18.0, are ordered by value
+		if best == nil || have.Compare(*best) > 0 || (have.Compare(*best) == 0 && entry < value) {
+			best, value = &have, entry
+		}
+	}
+	if best == nil {
+		return "", fmt.Errorf("kops %s is not configured in %s", version, setting)
+	}
+	return value, nil
+}
+
 func (k *KopsCmd) ReplaceCluster(cluster clusteroperatorv1alpha1.ClusterSpec) error {
 	tempConfigFile := cluster.Name + ".yaml"
-	err := utils.CopyBufferContentsToTempFile([]byte(cluster.Config), tempConfigFile)
+	var mode os.FileMode = 509
+	err := os.MkdirAll(k.workspace, mode)
+	if err != nil {
+		return err
+	}
+	err = utils.CopyBufferContentsToFile([]byte(cluster.Config), k.workspace+"/"+tempConfigFile)
 	if err != nil {
 		return err
 	}
 
 	kopsCmdStr := k.path +
 		" replace cluster" +
-		" -f ." + viper.GetString("kops.kube.dir") + "/" + tempConfigFile +
-		" --state=" + viper.GetString("kops.state.store") +
+		" -f " + k.workspace + "/" + tempConfigFile +
+		" --state=" + k.stateStore +
 		" --force"
 
 	err = k.runStreamingCmd(kopsCmdStr)
@@ -54,13 +259,9 @@ func (k *KopsCmd) ReplaceCluster(cluster clusteroperatorv1alpha1.ClusterSpec) er
 }
 
 func (k *KopsCmd) UpdateCluster(cluster clusteroperatorv1alpha1.KopsConfig) error {
-	if k.devMode { // Dry-run in Dev Mode and skip Update Cluster
-		return nil
-	}
-
 	kopsCmdStr := k.path +
 		" update cluster " +
-		" --state=" + viper.GetString("kops.state.store") +
+		" --state=" + k.stateStore +
 		" --name=" + cluster.Name +
 		// FIXME - Add in when we switch to kops config
 		// https://github.com/kubernetes/kops/blob/master/docs/iam_roles.md#use-existing-aws-instance-profiles
@@ -79,24 +280,25 @@ func (k *KopsCmd) UpdateCluster(cluster clusteroperatorv1alpha1.KopsConfig) erro
 func (k *KopsCmd) GetCluster(cluster clusteroperatorv1alpha1.KopsConfig) (bool, error) {
 	kopsCmdStr := k.path +
 		" get cluster " +
-		" --state=" + viper.GetString("kops.state.store") +
+		" --state=" + k.stateStore +
 		" --name=" + cluster.Name
-	exists := true
-	err := k.runStreamingCmd(kopsCmdStr)
+	out, err := k.runCmd(kopsCmdStr)
 	if err != nil {
-		if strings.Contains(err.Error(), "exit status 1") {
-			exists = false
+		if notFound(out) {
+			return false, nil
 		}
-		return exists, err
+		return false, err
 	}
-	return exists, nil
+	return true, nil
 }
 
-func (k *KopsCmd) RollingUpdateCluster(cluster clusteroperatorv1alpha1.KopsConfig) error {
+// notFound reports if kops failed because the cluster or instance group
+// does not exist, e.g. cluster not found "test.k8s.local"
+func notFound(out *bytes.Buffer) bool {
+	return out != nil && strings.Contains(out.String(), "not found")
+}
 
-	if k.devMode { // Dry-run in Dev Mode and skip Update Cluster
-		return nil
-	}
+func (k *KopsCmd) RollingUpdateCluster(cluster clusteroperatorv1alpha1.KopsConfig) error {
 
 	// Make sure we have config in tmp/config.yaml
 	_, err := k.GetKubeConfig(cluster)
@@ -106,7 +308,7 @@ func (k *KopsCmd) RollingUpdateCluster(cluster clusteroperatorv1alpha1.KopsConfi
 
 	kopsCmdStr := k.path +
 		" rolling-update cluster " +
-		" --state=" + viper.GetString("kops.state.store") +
+		" --state=" + k.stateStore +
 		" --name=" + cluster.Name +
 		" --fail-on-validate-error=false" +
 		// FIXME - Add in when we switch to kops config
@@ -123,11 +325,197 @@ func (k *KopsCmd) RollingUpdateCluster(cluster clusteroperatorv1alpha1.KopsConfi
 	return nil
 }
 
+// ForceRollingUpdateCluster replaces every instance of the cluster, also
+// the ones kops considers up to date
+func (k *KopsCmd) ForceRollingUpdateCluster(cluster clusteroperatorv1alpha1.KopsConfig) error {
+
+	// Make sure we have config in tmp/config.yaml
+	_, err := k.GetKubeConfig(cluster)
+	if err != nil {
+		return err
+	}
+
+	kopsCmdStr := k.path +
+		" rolling-update cluster " +
+		" --state=" + k.stateStore +
+		" --name=" + cluster.Name +
+		" --fail-on-validate-error=false" +
+		" --force" +
+		" --yes"
+
+	err = k.runStreamingCmd(kopsCmdStr)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RollingUpdateRole rolls only the instance groups with the role, Master or Node,
+// so masters can be validated before nodes are touched
+func (k *KopsCmd) RollingUpdateRole(cluster clusteroperatorv1alpha1.KopsConfig, role string) error {
+
+	// Make sure we have config in tmp/config.yaml
+	_, err := k.GetKubeConfig(cluster)
+	if err != nil {
+		return err
+	}
+
+	kopsCmdStr := k.path +
+		" rolling-update cluster " +
+		" --state=" + k.stateStore +
+		" --name=" + cluster.Name +
+		" --instance-group-roles=" + role +
+		" --yes"
+
+	err = k.runStreamingCmd(kopsCmdStr)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ReplaceInstanceGroup stores the InstanceGroup manifest in the state of the
+// cluster, it is applied by the next update
+func (k *KopsCmd) ReplaceInstanceGroup(cluster clusteroperatorv1alpha1.KopsConfig, manifest string) error {
+	m, err := ParseManifest(manifest)
+	if err != nil {
+		return err
+	}
+	ig := m.Find("InstanceGroup", "")
+	if ig == nil {
+		return fmt.Errorf("manifest has no InstanceGroup document")
+	}
+	path, err := k.writeWorkspaceFile(cluster.Name+"-"+Name(ig)+".yaml", []byte(manifest))
+	if err != nil {
+		return err
+	}
+
+	kopsCmdStr := k.path +
+		" replace" +
+		" -f " + path +
+		" --state=" + k.stateStore +
+		" --force"
+
+	return k.runStreamingCmd(kopsCmdStr)
+}
+
+// RollingUpdateInstanceGroup rolls only the instances of the group
+func (k *KopsCmd) RollingUpdateInstanceGroup(cluster clusteroperatorv1alpha1.KopsConfig, name string) error {
+	// Make sure we have config in tmp/config.yaml
+	if _, err := k.GetKubeConfig(cluster); err != nil {
+		return err
+	}
+
+	kopsCmdStr := k.path +
+		" rolling-update cluster " +
+		" --state=" + k.stateStore +
+		" --name=" + cluster.Name +
+		" --instance-group=" + name +
+		" --yes"
+
+	return k.runStreamingCmd(kopsCmdStr)
+}
+
+// DeleteInstanceGroup deletes the group and its instances, a group that
+// does not exist is already deleted
+func (k *KopsCmd) DeleteInstanceGroup(cluster clusteroperatorv1alpha1.KopsConfig, name string) error {
+	getCmdStr := k.path +
+		" get instancegroups " + name +
+		" --state=" + k.stateStore +
+		" --name=" + cluster.Name
+	if out, err := k.runCmd(getCmdStr); err != nil {
+		if notFound(out) {
+			return nil
+		}
+		return err
+	}
+
+	kopsCmdStr := k.path +
+		" delete instancegroup " + name +
+		" --state=" + k.stateStore +
+		" --name=" + cluster.Name +
+		" --yes"
+
+	return k.runStreamingCmd(kopsCmdStr)
+}
+
+// GetInstanceGroups returns the size and the on-demand/spot mix of the
+// instance groups kops stores for the cluster
+func (k *KopsCmd) GetInstanceGroups(cluster clusteroperatorv1alpha1.KopsConfig) ([]clusteroperatorv1alpha1.InstanceGroupStatus, error) {
+	kopsCmdStr := k.path +
+		" get instancegroups" +
+		" --state=" + k.stateStore +
+		" --name=" + cluster.Name +
+		" -o yaml"
+	out, err := k.runCmd(kopsCmdStr)
+	if err != nil {
+		return nil, err
+	}
+	if out == nil {
+		return nil, nil
+	}
+	return InstanceGroupStatuses(out.String())
+}
+
+// versions caches the output of kops version by binary or image, they only
+// change with the operator config
+var versions = struct {
+	sync.Mutex
+	m map[string]string
+}{m: map[string]string{}}
+
+// Version returns the version of the kops binary, or of the image in
+// isolated executors
+func (k *KopsCmd) Version() (string, error) {
+	key := k.image + ":" + k.path
+	versions.Lock()
+	v, ok := versions.m[key]
+	versions.Unlock()
+	if ok {
+		return v, nil
+	}
+
+	out, err := k.runCmd(k.path + " version")
+	if err != nil {
+		if out != nil && out.Len() > 0 {
+			return "", fmt.Errorf("%s: %s", err, strings.TrimSpace(out.String()))
+		}
+		return "", err
+	}
+	if out == nil {
+		return "", fmt.Errorf("kops version returned no output")
+	}
+	parsed, err := ParseVersion(out.String())
+	if err != nil {
+		return "", err
+	}
+
+	versions.Lock()
+	versions.m[key] = parsed.String()
+	versions.Unlock()
+	return parsed.String(), nil
+}
+
+// CheckCompatibility verifies the kops binary can manage the Kubernetes version
+// and returns the kops version
+func (k *KopsCmd) CheckCompatibility(kubernetesVersion string) (string, error) {
+	kopsVersion, err := k.Version()
+	if err != nil {
+		return "", err
+	}
+	if kubernetesVersion == "" {
+		return kopsVersion, nil
+	}
+	return kopsVersion, CheckKopsSupports(kopsVersion, kubernetesVersion)
+}
+
 func (k *KopsCmd) DeleteCluster(cluster clusteroperatorv1alpha1.KopsConfig) error {
 
 	kopsCmdStr := k.path +
 		" delete cluster --name=" + cluster.Name +
-		" --state=" + viper.GetString("kops.state.store") +
+		" --state=" + k.stateStore +
 		" --yes"
 
 	//out, err := utils.RunCmd(kopsCmd)
@@ -139,6 +527,25 @@ func (k *KopsCmd) DeleteCluster(cluster clusteroperatorv1alpha1.KopsConfig) erro
 	return nil
 }
 
+// DeleteClusterPreview runs kops delete without --yes, kops then only lists
+// the resources it would remove
+func (k *KopsCmd) DeleteClusterPreview(cluster clusteroperatorv1alpha1.KopsConfig) (string, error) {
+
+	kopsCmdStr := k.path +
+		" delete cluster --name=" + cluster.Name +
+		" --state=" + k.stateStore
+
+	out, err := k.runCmd(kopsCmdStr)
+	if err != nil {
+		return "", err
+	}
+	if out == nil {
+		return "", nil
+	}
+
+	return out.String(), nil
+}
+
 //func (k *KopsCmd) DeleteCluster(cluster clusteroperatorv1alpha1.KopsConfig) (string, error) {
 //
 //	//kopsCmd := "./.bin/docker"
@@ -163,21 +570,6 @@ func (k *KopsCmd) ValidateCluster(cluster clusteroperatorv1alpha1.KopsConfig) (c
 
 	status := clusteroperatorv1alpha1.KopsStatus{}
 
-	if k.devMode { // Dry-run in Dev Mode and skip Validate Cluster return Cluster Up Status
-		status = clusteroperatorv1alpha1.KopsStatus{
-			Nodes: []clusteroperatorv1alpha1.KopsNode{
-				{
-					Name:     "ip-172-17-17-143.compute.internal",
-					Zone:     "us-east-2a",
-					Role:     "Master",
-					Hostname: "ip-172-17-17-143.compute.internal",
-					Status:   "True",
-				},
-			},
-		}
-		return status, nil
-	}
-
 	// Make sure we have config in tmp/config.yaml
 	_, err := k.GetKubeConfig(cluster)
 	if err != nil {
@@ -186,7 +578,7 @@ func (k *KopsCmd) ValidateCluster(cluster clusteroperatorv1alpha1.KopsConfig) (c
 
 	kopsCmdStr := k.path +
 		" validate cluster" +
-		" --state=" + viper.GetString("kops.state.store") +
+		" --state=" + k.stateStore +
 		" --name=" + cluster.Name + " -o json"
 	out, err := k.runCmd(kopsCmdStr)
 	if err != nil {
@@ -197,31 +589,25 @@ func (k *KopsCmd) ValidateCluster(cluster clusteroperatorv1alpha1.KopsConfig) (c
 	if err != nil {
 		return status, err
 	}
-
-	fmt.Println("Kops Response: ", string(out.Bytes()))
 	return status, nil
 }
 
 func (k *KopsCmd) GetKubeConfig(cluster clusteroperatorv1alpha1.KopsConfig) (clusteroperatorv1alpha1.KubeConfig, error) {
 
-	if k.devMode { // Dry-run in Dev Mode and skip get kube.config
-		return clusteroperatorv1alpha1.KubeConfig{}, nil
-	}
-
 	config := clusteroperatorv1alpha1.KubeConfig{}
 
 	kopsCmdStr := k.path +
 		" export kubecfg" +
 		" --name=" + cluster.Name +
-		" --state=" + viper.GetString("kops.state.store") +
-		" --kubeconfig=" +  viper.GetString("tmp.dir") + "/config-" + cluster.Name
+		" --state=" + k.stateStore +
+		" --kubeconfig=" + k.KubeConfigPath(cluster.Name)
 
 	err := k.runStreamingCmd(kopsCmdStr)
 	if err != nil {
 		return clusteroperatorv1alpha1.KubeConfig{}, err
 	}
 
-	file, err := ioutil.ReadFile(viper.GetString("tmp.dir") + "/config-" + cluster.Name)
+	file, err := ioutil.ReadFile(k.KubeConfigPath(cluster.Name))
 	if err != nil {
 		return clusteroperatorv1alpha1.KubeConfig{}, err
 	}
@@ -237,7 +623,7 @@ func (k *KopsCmd) GetKubeConfig(cluster clusteroperatorv1alpha1.KopsConfig) (clu
 func (k *KopsCmd) ListClusters(stateStore string) ([]string, error) {
 	kopsCmdStr := k.path +
 		" get cluster " +
-		" --state=" + viper.GetString("kops.state.store") +
+		" --state=" + stateStore +
 		" -o json | jq -r '.[][\"metadata\"][\"name\"]'"
 
 	out, err := k.runCmd(kopsCmdStr)
@@ -245,5 +631,12 @@ func (k *KopsCmd) ListClusters(stateStore string) ([]string, error) {
 		return nil, err
 	}
 
-	return strings.Split(string(out.Bytes()), "\n"), nil
+	// jq prints nothing for an empty state store
+	var clusters []string
+	for _, name := range strings.Split(out.String(), "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			clusters = append(clusters, name)
+		}
+	}
+	return clusters, nil
 }
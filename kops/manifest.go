@@ -0,0 +1,192 @@
+package kops
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+var documentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// Manifest is a parsed multi document kops manifest. Documents are kept as
+// generic objects so fields we do not model are preserved on the way back out.
+type Manifest struct {
+	Documents []map[string]interface{}
+}
+
+// ParseManifest splits a multi document kops manifest and parses each document
+func ParseManifest(config string) (*Manifest, error) {
+	m := &Manifest{}
+	for i, d := range documentSeparator.Split(config, -1) {
+		if len(strings.TrimSpace(d)) == 0 {
+			continue
+		}
+		doc := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(d), &doc); err != nil {
+			return nil, fmt.Errorf("kops manifest document %d: %v", i, err)
+		}
+		if len(doc) == 0 {
+			continue
+		}
+		m.Documents = append(m.Documents, doc)
+	}
+	return m, nil
+}
+
+// String serializes the manifest back into a multi document YAML string
+func (m *Manifest) String() (string, error) {
+	docs := make([]string, 0, len(m.Documents))
+	for _, doc := range m.Documents {
+		out, err := yaml.Marshal(doc)
+		if err != nil {
+			return "", err
+		}
+		docs = append(docs, string(out))
+	}
+	return strings.Join(docs, "---\n"), nil
+}
+
+// Kind returns the kind of a manifest document
+func Kind(doc map[string]interface{}) string {
+	kind, _, _ := unstructured.NestedString(doc, "kind")
+	return kind
+}
+
+// Name returns the metadata.name of a manifest document
+func Name(doc map[string]interface{}) string {
+	name, _, _ := unstructured.NestedString(doc, "metadata", "name")
+	return name
+}
+
+// Find returns the first document of the kind with the name, an empty name
+// matches any document of the kind
+func (m *Manifest) Find(kind, name string) map[string]interface{} {
+	for _, doc := range m.Documents {
+		if Kind(doc) == kind && (name == "" || Name(doc) == name) {
+			return doc
+		}
+	}
+	return nil
+}
+
+// FindAll returns all the documents of the kind
+func (m *Manifest) FindAll(kind string) []map[string]interface{} {
+	var docs []map[string]interface{}
+	for _, doc := range m.Documents {
+		if Kind(doc) == kind {
+			docs = append(docs, doc)
+		}
+	}
+	return docs
+}
+
+// Cluster returns the kops Cluster document
+func (m *Manifest) Cluster() (map[string]interface{}, error) {
+	doc := m.Find("Cluster", "")
+	if doc == nil {
+		return nil, fmt.Errorf("kops manifest has no Cluster document")
+	}
+	return doc, nil
+}
+
+// InstanceGroups returns the kops InstanceGroup documents
+func (m *Manifest) InstanceGroups() []map[string]interface{} {
+	return m.FindAll("InstanceGroup")
+}
+
+// KubernetesVersion returns spec.kubernetesVersion of the kops Cluster document
+func (m *Manifest) KubernetesVersion() string {
+	doc, err := m.Cluster()
+	if err != nil {
+		return ""
+	}
+	version, _, _ := unstructured.NestedString(doc, "spec", "kubernetesVersion")
+	return version
+}
+
+// CloudProvider returns spec.cloudProvider of the kops Cluster document
+func (m *Manifest) CloudProvider() string {
+	doc, err := m.Cluster()
+	if err != nil {
+		return ""
+	}
+	provider, _, _ := unstructured.NestedString(doc, "spec", "cloudProvider")
+	return provider
+}
+
+// SetKubernetesVersion sets spec.kubernetesVersion of the kops Cluster document
+func (m *Manifest) SetKubernetesVersion(version string) error {
+	doc, err := m.Cluster()
+	if err != nil {
+		return err
+	}
+	return unstructured.SetNestedField(doc, version, "spec", "kubernetesVersion")
+}
+
+// SetCloudLabels adds the labels to spec.cloudLabels of the kops Cluster
+// document, kops tags the cloud resources with them
+func (m *Manifest) SetCloudLabels(labels map[string]string) error {
+	doc, err := m.Cluster()
+	if err != nil {
+		return err
+	}
+	return mergeStringMap(doc, labels, "spec", "cloudLabels")
+}
+
+// SetNodeLabels adds the labels to spec.nodeLabels of every InstanceGroup
+// document
+func (m *Manifest) SetNodeLabels(labels map[string]string) error {
+	for _, doc := range m.InstanceGroups() {
+		if err := mergeStringMap(doc, labels, "spec", "nodeLabels"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mergeStringMap(doc map[string]interface{}, values map[string]string, fields ...string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	merged, _, err := unstructured.NestedStringMap(doc, fields...)
+	if err != nil {
+		return err
+	}
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	for k, v := range values {
+		merged[k] = v
+	}
+	return unstructured.SetNestedStringMap(doc, merged, fields...)
+}
+
+// GossipSuffix ends the names of the clusters kops runs with gossip DNS
+const GossipSuffix = ".k8s.local"
+
+// IsGossip reports if kops runs the cluster with gossip DNS
+func IsGossip(clusterName string) bool {
+	return strings.HasSuffix(clusterName, GossipSuffix)
+}
+
+// SetGossipDNS makes the kops Cluster document fit a gossip cluster: no DNS
+// zone, private DNS topology and the API server behind a public load
+// balancer unless one is configured
+func (m *Manifest) SetGossipDNS() error {
+	doc, err := m.Cluster()
+	if err != nil {
+		return err
+	}
+	unstructured.RemoveNestedField(doc, "spec", "dnsZone")
+	unstructured.RemoveNestedField(doc, "spec", "api", "dns")
+	if err := unstructured.SetNestedField(doc, "Private", "spec", "topology", "dns", "type"); err != nil {
+		return err
+	}
+	if _, ok, _ := unstructured.NestedMap(doc, "spec", "api", "loadBalancer"); ok {
+		return nil
+	}
+	return unstructured.SetNestedField(doc, "Public", "spec", "api", "loadBalancer", "type")
+}
@@ -0,0 +1,209 @@
+package kops
+
+import (
+	"encoding/json"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"sigs.k8s.io/yaml"
+)
+
+// patchMergeKey identifies the objects of lists in strategic patches, kops
+// names the entries of its lists, e.g. subnets and etcdClusters
+const patchMergeKey = "name"
+
+// patchDirective removes the list entry it is set on when it is "delete"
+const patchDirective = "$patch"
+
+// ApplyPatch patches the documents the target selects, it fails when none is
+// selected so typos in targets do not go unnoticed
+func (m *Manifest) ApplyPatch(p clusteroperatorv1alpha1.ManifestPatch) error {
+	if p.Target.Kind == "" {
+		return fmt.Errorf("patch target has no kind")
+	}
+
+	data, err := yaml.YAMLToJSON([]byte(p.Patch))
+	if err != nil {
+		return fmt.Errorf("patch of %s: %v", targetName(p.Target), err)
+	}
+
+	matched := false
+	for i, doc := range m.Documents {
+		if Kind(doc) != p.Target.Kind || (p.Target.Name != "" && Name(doc) != p.Target.Name) {
+			continue
+		}
+		matched = true
+
+		patched, err := patchDocument(doc, p.Type, data)
+		if err != nil {
+			return fmt.Errorf("patch of %s: %v", targetName(p.Target), err)
+		}
+		m.Documents[i] = patched
+	}
+	if !matched {
+		return fmt.Errorf("patch target %s does not match any document", targetName(p.Target))
+	}
+	return nil
+}
+
+// ApplyPatches applies the patches in order
+func (m *Manifest) ApplyPatches(patches []clusteroperatorv1alpha1.ManifestPatch) error {
+	for _, p := range patches {
+		if err := m.ApplyPatch(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckPatch checks the patch is well formed when there is no manifest to
+// apply it to
+func CheckPatch(p clusteroperatorv1alpha1.ManifestPatch) error {
+	if p.Target.Kind == "" {
+		return fmt.Errorf("patch target has no kind")
+	}
+	data, err := yaml.YAMLToJSON([]byte(p.Patch))
+	if err != nil {
+		return fmt.Errorf("patch of %s: %v", targetName(p.Target), err)
+	}
+
+	switch p.Type {
+	case clusteroperatorv1alpha1.PatchTypeJSON:
+		_, err = jsonpatch.DecodePatch(data)
+	case clusteroperatorv1alpha1.PatchTypeStrategic, "":
+		err = json.Unmarshal(data, &map[string]interface{}{})
+	default:
+		err = fmt.Errorf("unknown patch type %q", p.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("patch of %s: %v", targetName(p.Target), err)
+	}
+	return nil
+}
+
+func patchDocument(doc map[string]interface{}, t clusteroperatorv1alpha1.PatchType, patch []byte) (map[string]interface{}, error) {
+	switch t {
+	case clusteroperatorv1alpha1.PatchTypeJSON:
+		p, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return nil, err
+		}
+		original, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		out, err := p.Apply(original)
+		if err != nil {
+			return nil, err
+		}
+		patched := map[string]interface{}{}
+		return patched, json.Unmarshal(out, &patched)
+	case clusteroperatorv1alpha1.PatchTypeStrategic, "":
+		p := map[string]interface{}{}
+		if err := json.Unmarshal(patch, &p); err != nil {
+			return nil, fmt.Errorf("strategic patch must be an object: %v", err)
+		}
+		return mergeObject(doc, p), nil
+	}
+	return nil, fmt.Errorf("unknown patch type %q", t)
+}
+
+// mergeObject merges the patch into the object, null values remove fields
+func mergeObject(obj, patch map[string]interface{}) map[string]interface{} {
+	for k, v := range patch {
+		if v == nil {
+			delete(obj, k)
+			continue
+		}
+		obj[k] = mergeValue(obj[k], v)
+	}
+	return obj
+}
+
+func mergeValue(value, patch interface{}) interface{} {
+	switch p := patch.(type) {
+	case map[string]interface{}:
+		if v, ok := value.(map[string]interface{}); ok {
+			return mergeObject(v, p)
+		}
+		return removeDirectives(p)
+	case []interface{}:
+		if v, ok := value.([]interface{}); ok && namedList(p) {
+			return mergeList(v, p)
+		}
+		return removeDirectives(p)
+	}
+	return patch
+}
+
+// mergeList merges the entries of the patch into the entries of the list with
+// the same name, entries that are not in the list are appended
+func mergeList(list, patch []interface{}) []interface{} {
+	for _, item := range patch {
+		p := item.(map[string]interface{})
+		found := -1
+		for i, entry := range list {
+			if e, ok := entry.(map[string]interface{}); ok && e[patchMergeKey] == p[patchMergeKey] {
+				found = i
+				break
+			}
+		}
+
+		if p[patchDirective] == "delete" {
+			if found >= 0 {
+				list = append(list[:found], list[found+1:]...)
+			}
+			continue
+		}
+		delete(p, patchDirective)
+		if found >= 0 {
+			list[found] = mergeObject(list[found].(map[string]interface{}), p)
+		} else {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// namedList reports if every entry of the list is an object with a name
+func namedList(list []interface{}) bool {
+	for _, item := range list {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if _, ok := obj[patchMergeKey]; !ok {
+			return false
+		}
+	}
+	return len(list) > 0
+}
+
+// removeDirectives drops patch directives from values that are added as is
+func removeDirectives(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		delete(v, patchDirective)
+		for k, e := range v {
+			v[k] = removeDirectives(e)
+		}
+	case []interface{}:
+		kept := v[:0]
+		for _, e := range v {
+			if obj, ok := e.(map[string]interface{}); ok && obj[patchDirective] == "delete" {
+				continue
+			}
+			kept = append(kept, removeDirectives(e))
+		}
+		return kept
+	}
+	return value
+}
+
+func targetName(t clusteroperatorv1alpha1.PatchTarget) string {
+	if t.Name == "" {
+		return t.Kind
+	}
+	return t.Kind + "/" + t.Name
+}
@@ -0,0 +1,61 @@
+package kops
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// SSHPublicKeyFingerprint returns the MD5 fingerprint of an authorized_keys
+// formatted public key, the format AWS reports for key pairs
+func SSHPublicKeyFingerprint(key string) (string, error) {
+	fields := strings.Fields(key)
+	if len(fields) < 2 || (!strings.HasPrefix(fields[0], "ssh-") && !strings.HasPrefix(fields[0], "ecdsa-")) {
+		return "", fmt.Errorf("not an SSH public key")
+	}
+	data, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", fmt.Errorf("not an SSH public key: %v", err)
+	}
+	sum := md5.Sum(data)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(hex, ":"), nil
+}
+
+// SetSSHPublicKey sets spec.publicKey of the SSHCredential documents, an
+// admin SSHCredential for the kops Cluster is added when there is none
+func (m *Manifest) SetSSHPublicKey(key string) error {
+	key = strings.TrimSpace(key)
+	docs := m.FindAll("SSHCredential")
+	if len(docs) == 0 {
+		cluster, err := m.Cluster()
+		if err != nil {
+			return err
+		}
+		apiVersion, _, _ := unstructured.NestedString(cluster, "apiVersion")
+		doc := map[string]interface{}{
+			"apiVersion": apiVersion,
+			"kind":       "SSHCredential",
+			"metadata": map[string]interface{}{
+				"name": "admin",
+				"labels": map[string]interface{}{
+					"kops.k8s.io/cluster": Name(cluster),
+				},
+			},
+		}
+		m.Documents = append(m.Documents, doc)
+		docs = append(docs, doc)
+	}
+	for _, doc := range docs {
+		if err := unstructured.SetNestedField(doc, key, "spec", "publicKey"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package kops
+
+import (
+	"fmt"
+	"net"
+
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Names of the documents and subnets SetTopology adds
+const (
+	BastionInstanceGroup = "bastions"
+	utilitySubnetPrefix  = "utility-"
+)
+
+// SetTopology applies the topology to the manifest. Private clusters get
+// private subnets routed through NAT gateways, a utility subnet per zone
+// for the load balancers and the bastion, the API server behind a load
+// balancer and the bastion InstanceGroup when it is asked for. kops assigns
+// the CIDRs of the utility subnets from the networkCIDR of the cluster. The
+// networking is left alone, CheckNetworking verifies it once the patches
+// are applied.
+func (m *Manifest) SetTopology(t clusteroperatorv1alpha1.TopologySpec) error {
+	doc, err := m.Cluster()
+	if err != nil {
+		return err
+	}
+
+	if len(t.SSHAccess) > 0 {
+		if err := unstructured.SetNestedStringSlice(doc, t.SSHAccess, "spec", "sshAccess"); err != nil {
+			return err
+		}
+	}
+	if len(t.KubernetesAPIAccess) > 0 {
+		if err := unstructured.SetNestedStringSlice(doc, t.KubernetesAPIAccess, "spec", "kubernetesApiAccess"); err != nil {
+			return err
+		}
+	}
+	if t.Type != clusteroperatorv1alpha1.TopologyPrivate {
+		return nil
+	}
+
+	for _, role := range []string{"masters", "nodes"} {
+		if err := unstructured.SetNestedField(doc, "private", "spec", "topology", role); err != nil {
+			return err
+		}
+	}
+	if _, ok, _ := unstructured.NestedString(doc, "spec", "topology", "dns", "type"); !ok {
+		if err := unstructured.SetNestedField(doc, "Public", "spec", "topology", "dns", "type"); err != nil {
+			return err
+		}
+	}
+	zones, err := setPrivateSubnets(doc, t.NATGateway)
+	if err != nil {
+		return err
+	}
+
+	unstructured.RemoveNestedField(doc, "spec", "api", "dns")
+	lb := string(t.APILoadBalancer)
+	if _, ok, _ := unstructured.NestedMap(doc, "spec", "api", "loadBalancer"); !ok && lb == "" {
+		lb = string(clusteroperatorv1alpha1.LoadBalancerPublic)
+	}
+	if lb != "" {
+		if err := unstructured.SetNestedField(doc, lb, "spec", "api", "loadBalancer", "type"); err != nil {
+			return err
+		}
+	}
+
+	if t.Bastion == nil {
+		return nil
+	}
+	name := Name(doc)
+	if !IsGossip(name) {
+		if err := unstructured.SetNestedField(doc, "bastion."+name, "spec", "topology", "bastion", "bastionPublicName"); err != nil {
+			return err
+		}
+	}
+	return m.setBastion(name, zones, t.Bastion.MachineType)
+}
+
+// setPrivateSubnets makes the public subnets of the Cluster document private
+// and adds the missing utility subnets, it returns the zones of the subnets
+func setPrivateSubnets(doc map[string]interface{}, natGateway string) ([]string, error) {
+	subnets, _, err := unstructured.NestedSlice(doc, "spec", "subnets")
+	if err != nil {
+		return nil, err
+	}
+
+	var zones []string
+	names := map[string]bool{}
+	for _, s := range subnets {
+		subnet, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		names[fmt.Sprint(subnet["name"])] = true
+		switch subnet["type"] {
+		case "Utility":
+			continue
+		case "Public", nil:
+			subnet["type"] = "Private"
+		}
+		if natGateway != "" {
+			subnet["egress"] = natGateway
+		}
+		if zone, ok := subnet["zone"].(string); ok {
+			zones = append(zones, zone)
+		}
+	}
+	for _, zone := range zones {
+		if name := utilitySubnetPrefix + zone; !names[name] {
+			subnets = append(subnets, map[string]interface{}{"name": name, "type": "Utility", "zone": zone})
+			names[name] = true
+		}
+	}
+	return zones, unstructured.SetNestedSlice(doc, subnets, "spec", "subnets")
+}
+
+// setBastion adds the bastion InstanceGroup in the utility subnets of the
+// zones, an existing one is kept. It runs the image of the other groups.
+func (m *Manifest) setBastion(clusterName string, zones []string, machineType string) error {
+	if m.Find("InstanceGroup", BastionInstanceGroup) != nil {
+		return nil
+	}
+	if machineType == "" {
+		cloud, err := CloudFor(m.CloudProvider())
+		if err != nil {
+			return err
+		}
+		machineType = cloud.BastionMachineType
+	}
+
+	var subnets []interface{}
+	seen := map[string]bool{}
+	for _, zone := range zones {
+		if !seen[zone] {
+			subnets = append(subnets, utilitySubnetPrefix+zone)
+			seen[zone] = true
+		}
+	}
+	spec := map[string]interface{}{
+		"machineType": machineType,
+		"maxSize":     int64(1),
+		"minSize":     int64(1),
+		"role":        "Bastion",
+		"subnets":     subnets,
+	}
+	for _, ig := range m.InstanceGroups() {
+		if image, ok, _ := unstructured.NestedString(ig, "spec", "image"); ok {
+			spec["image"] = image
+			break
+		}
+	}
+	m.Documents = append(m.Documents, map[string]interface{}{
+		"apiVersion": "kops.k8s.io/v1alpha2",
+		"kind":       "InstanceGroup",
+		"metadata": map[string]interface{}{
+			"name":   BastionInstanceGroup,
+			"labels": map[string]interface{}{"kops.k8s.io/cluster": clusterName},
+		},
+		"spec": spec,
+	})
+	return nil
+}
+
+// CheckNetworking verifies the networking of the manifest can route the pods
+// of its topology. kubenet routes pods through the route tables of public
+// subnets only, and kops cannot change the networking of a running cluster.
+func (m *Manifest) CheckNetworking() error {
+	doc, err := m.Cluster()
+	if err != nil {
+		return err
+	}
+	if _, ok, _ := unstructured.NestedMap(doc, "spec", "networking", "kubenet"); ok && m.Private() {
+		return fmt.Errorf("kubenet networking cannot route the pods of a private topology, use a CNI such as calico")
+	}
+	return nil
+}
+
+// Private reports if the masters and the nodes of the manifest run in
+// private subnets
+func (m *Manifest) Private() bool {
+	doc, err := m.Cluster()
+	if err != nil {
+		return false
+	}
+	masters, _, _ := unstructured.NestedString(doc, "spec", "topology", "masters")
+	nodes, _, _ := unstructured.NestedString(doc, "spec", "topology", "nodes")
+	return masters == "private" && nodes == "private"
+}
+
+// AccessCIDRs returns the sshAccess and kubernetesApiAccess CIDRs of the
+// manifest
+func (m *Manifest) AccessCIDRs() (ssh []string, api []string) {
+	doc, err := m.Cluster()
+	if err != nil {
+		return nil, nil
+	}
+	ssh, _, _ = unstructured.NestedStringSlice(doc, "spec", "sshAccess")
+	api, _, _ = unstructured.NestedStringSlice(doc, "spec", "kubernetesApiAccess")
+	return ssh, api
+}
+
+// OpenCIDR reports if the CIDR matches every address, e.g. 0.0.0.0/0
+func OpenCIDR(cidr string) (bool, error) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false, fmt.Errorf("%q is not a CIDR", cidr)
+	}
+	ones, _ := ipnet.Mask.Size()
+	return ones == 0, nil
+}
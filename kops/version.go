@@ -0,0 +1,101 @@
+package kops
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var versionPattern = regexp.MustCompile(`v?(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// Version is a parsed major.minor.patch version of kops or Kubernetes
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// ParseVersion parses versions such as 1.16.7, v1.16 or "Version 1.16.0 (git-4b0e62b82)"
+func ParseVersion(s string) (Version, error) {
+	match := versionPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return Version{}, fmt.Errorf("cannot parse version %q", s)
+	}
+	v := Version{}
+	v.Major, _ = strconv.Atoi(match[1])
+	v.Minor, _ = strconv.Atoi(match[2])
+	if match[3] != "" {
+		v.Patch, _ = strconv.Atoi(match[3])
+	}
+	return v, nil
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Compare returns -1, 0 or 1 if v is lower, equal or greater than o
+func (v Version) Compare(o Version) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// CheckKubernetesUpgrade verifies an upgrade between two Kubernetes versions
+// moves forward one minor version at a time and is supported by the kops
+// release, kops 1.N supports Kubernetes up to 1.N
+func CheckKubernetesUpgrade(from, to, kopsVersion string) error {
+	if err := CheckVersionSkew(from, to); err != nil {
+		return err
+	}
+	return CheckKopsSupports(kopsVersion, to)
+}
+
+// CheckVersionSkew verifies a Kubernetes version change is not a downgrade
+// and does not skip minor versions
+func CheckVersionSkew(from, to string) error {
+	current, err := ParseVersion(from)
+	if err != nil {
+		return err
+	}
+	target, err := ParseVersion(to)
+	if err != nil {
+		return err
+	}
+
+	if target.Compare(current) < 0 {
+		return fmt.Errorf("downgrade from %s to %s is not supported", current, target)
+	}
+	if target.Major != current.Major || target.Minor > current.Minor+1 {
+		return fmt.Errorf("upgrade from %s to %s skips minor versions, upgrade one minor version at a time", current, target)
+	}
+	return nil
+}
+
+// kopsSupportedMinors is how many Kubernetes minor releases up to its own a
+// kops release manages, kops 1.18 does not manage 1.16 clusters safely
+const kopsSupportedMinors = 2
+
+// CheckKopsSupports verifies the kops release can manage the Kubernetes
+// version, kops 1.N manages Kubernetes 1.N-1 and 1.N
+func CheckKopsSupports(kopsVersion, kubernetesVersion string) error {
+	k, err := ParseVersion(kopsVersion)
+	if err != nil {
+		return err
+	}
+	target, err := ParseVersion(kubernetesVersion)
+	if err != nil {
+		return err
+	}
+	if target.Major != k.Major || target.Minor > k.Minor || target.Minor <= k.Minor-kopsSupportedMinors {
+		return fmt.Errorf("kops %s does not support Kubernetes %s", k, target)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterConditionType is a valid value for ClusterCondition.Type
+type ClusterConditionType string
+
+// These are the valid conditions of a Cluster.
+const (
+	// ClusterConditionPaused is True when reconciliation of the Cluster is suspended
+	ClusterConditionPaused ClusterConditionType = "Paused"
+	// ClusterConditionUpgradeFailed is True when a Kubernetes version upgrade stopped on an error
+	ClusterConditionUpgradeFailed ClusterConditionType = "UpgradeFailed"
+	// ClusterConditionKopsCompatible is False when the selected kops release
+	// is not configured or cannot manage the Kubernetes version
+	ClusterConditionKopsCompatible ClusterConditionType = "KopsCompatible"
+	// ClusterConditionCredentialsValid is False when the Secret referenced by
+	// spec.credentialsRef is missing or does not hold usable credentials
+	ClusterConditionCredentialsValid ClusterConditionType = "CredentialsValid"
+	// ClusterConditionStateStoreValid is False when the StateStore referenced
+	// by spec.stateStoreRef is missing, unusable or not allowed for the namespace
+	ClusterConditionStateStoreValid ClusterConditionType = "StateStoreValid"
+	// ClusterConditionDefaultsResolved is False when the external defaults
+	// source could not be asked for the defaults of a new Cluster
+	ClusterConditionDefaultsResolved ClusterConditionType = "DefaultsResolved"
+	// ClusterConditionTemplateRendered is False when Config cannot be
+	// rendered from the ClusterTemplate referenced by spec.templateRef
+	ClusterConditionTemplateRendered ClusterConditionType = "TemplateRendered"
+	// ClusterConditionConfigResolved is False when a ConfigMap or Secret key
+	// of spec.configFrom is missing
+	ClusterConditionConfigResolved ClusterConditionType = "ConfigResolved"
+	// ClusterConditionSSHKeyValid is False when the Secret key referenced by
+	// spec.sshKeySecretRef is missing or does not hold an SSH public key
+	ClusterConditionSSHKeyValid ClusterConditionType = "SSHKeyValid"
+)
+
+// ClusterCondition describes the state of a Cluster at a certain point
+// +k8s:openapi-gen=true
+type ClusterCondition struct {
+	// Type of Cluster condition
+	Type ClusterConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown
+	Status corev1.ConditionStatus `json:"status"`
+	// Last time the condition transitioned from one status to another
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Unique, one-word, CamelCase reason for the condition's last transition
+	Reason string `json:"reason,omitempty"`
+	// Human-readable message indicating details about last transition
+	Message string `json:"message,omitempty"`
+}
+
+// GetCondition returns the condition with the provided type, nil if it is not set
+func (s *ClusterStatus) GetCondition(t ClusterConditionType) *ClusterCondition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == t {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition adds or updates the condition of the same type. The transition
+// time is only moved when the status changes. It returns true if anything changed.
+func (s *ClusterStatus) SetCondition(c ClusterCondition) bool {
+	existing := s.GetCondition(c.Type)
+	if existing == nil {
+		c.LastTransitionTime = metav1.Now()
+		s.Conditions = append(s.Conditions, c)
+		return true
+	}
+	if existing.Status == c.Status && existing.Reason == c.Reason && existing.Message == c.Message {
+		return false
+	}
+	if existing.Status != c.Status {
+		existing.LastTransitionTime = metav1.Now()
+	}
+	existing.Status = c.Status
+	existing.Reason = c.Reason
+	existing.Message = c.Message
+	return true
+}
+
+// IsConditionTrue reports if the condition with the provided type has status True
+func (s *ClusterStatus) IsConditionTrue(t ClusterConditionType) bool {
+	c := s.GetCondition(t)
+	return c != nil && c.Status == corev1.ConditionTrue
+}
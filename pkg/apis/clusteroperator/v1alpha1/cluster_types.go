@@ -1,20 +1,55 @@
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // KopsCluster defines the settings passed to Kops
 // +k8s:openapi-gen=true
 type KopsConfig struct {
-	Name        string   `json:"name,omitempty"`
-	MasterCount int      `json:"master_count,omitempty"`
-	MasterEc2   string   `json:"master_ec2,omitempty"`
-	WorkerCount int      `json:"worker_count,omitempty"`
-	WorkerEc2   string   `json:"worker_ec2,omitempty"`
-	StateStore  string   `json:"state_store,omitempty"`
-	Vpc         string   `json:"vpc,omitempty"`
-	Zones       []string `json:"zones,omitempty"`
+	Name        string `json:"name,omitempty"`
+	MasterCount int    `json:"master_count,omitempty"`
+	// MasterMachineType is the machine type of the masters on any cloud
+	// provider
+	MasterMachineType string `json:"master_machine_type,omitempty"`
+	// MasterEc2 is the deprecated alias of MasterMachineType
+	MasterEc2   string `json:"master_ec2,omitempty"`
+	WorkerCount int    `json:"worker_count,omitempty"`
+	// WorkerMachineType is the machine type of the workers on any cloud
+	// provider
+	WorkerMachineType string `json:"worker_machine_type,omitempty"`
+	// WorkerEc2 is the deprecated alias of WorkerMachineType
+	WorkerEc2  string   `json:"worker_ec2,omitempty"`
+	StateStore string   `json:"state_store,omitempty"`
+	Vpc        string   `json:"vpc,omitempty"`
+	Zones      []string `json:"zones,omitempty"`
+	// CloudProvider is the kops cloud provider of the cluster, aws, gce or
+	// openstack, clusters without one run on aws
+	CloudProvider string `json:"cloud_provider,omitempty"`
+	// Image is the machine image of the instance groups
+	Image string `json:"image,omitempty"`
+	// Labels of the cluster, see ClusterDefaultsSpec
+	Labels map[string]string `json:"labels,omitempty"`
+	// WorkerSpot runs the Node instance groups of the manifest on spot
+	// instances
+	WorkerSpot *SpotSpec `json:"worker_spot,omitempty"`
+}
+
+// MasterType returns MasterMachineType, or MasterEc2 when it is not set
+func (kc KopsConfig) MasterType() string {
+	if kc.MasterMachineType != "" {
+		return kc.MasterMachineType
+	}
+	return kc.MasterEc2
+}
+
+// WorkerType returns WorkerMachineType, or WorkerEc2 when it is not set
+func (kc KopsConfig) WorkerType() string {
+	if kc.WorkerMachineType != "" {
+		return kc.WorkerMachineType
+	}
+	return kc.WorkerEc2
 }
 
 // KopsFailure informs regarding reason cluster is not ready
@@ -111,10 +146,225 @@ type ClusterSpec struct {
 	Name string `json:"name,omitempty"`
 	// Kops Cluster Config
 	Config string `json:"config,omitempty"`
+	// ConfigFrom reads the kops manifest from ConfigMap and Secret keys in
+	// the namespace of the Cluster instead of Config, the keys are
+	// concatenated as multi-document YAML in order
+	ConfigFrom []ConfigSource `json:"configFrom,omitempty"`
+	// Patches are applied in order to the documents of the kops manifest
+	// before it is replaced
+	Patches []ManifestPatch `json:"patches,omitempty"`
 	// Kops Cluster Config
 	KopsConfig KopsConfig `json:"kops_config,omitempty"`
+	// Paused stops the operator from making changes to the cluster while the
+	// resource stays in place. The finalizer keeps blocking deletion unless
+	// the ForceDeleteAnnotation is set.
+	Paused bool `json:"paused,omitempty"`
+	// DeletionPolicy controls what happens to the cloud resources and kops
+	// state when the Cluster is deleted, defaults to Delete
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// DeletionProtection rejects deletion of the Cluster until it is disabled
+	DeletionProtection bool `json:"deletionProtection,omitempty"`
+	// KubernetesVersion is the desired Kubernetes version, it overrides
+	// kubernetesVersion in Config. Changes go through the Upgrading phase.
+	KubernetesVersion string `json:"kubernetesVersion,omitempty"`
+	// KopsVersion selects the kops release used to manage the cluster, it
+	// must be configured in the operator kops.versions registry
+	KopsVersion string `json:"kopsVersion,omitempty"`
+	// CredentialsRef names a Secret in the namespace of the Cluster holding
+	// the AWS credentials kops uses for this cluster instead of the operator
+	// ones, see the Credentials* keys
+	CredentialsRef *corev1.LocalObjectReference `json:"credentialsRef,omitempty"`
+	// StateStoreRef names the StateStore holding the kops state of the
+	// cluster, the operator kops.state.store is used when it is not set.
+	// Cannot be updated.
+	StateStoreRef string `json:"stateStoreRef,omitempty"`
+	// TemplateRef names a ClusterTemplate in the namespace of the Cluster
+	// the operator renders Config from
+	TemplateRef *corev1.LocalObjectReference `json:"templateRef,omitempty"`
+	// Values are passed to the template
+	Values map[string]string `json:"values,omitempty"`
+	// TemplateUpdatePolicy controls if Config is rendered again when the
+	// template changes, defaults to Auto
+	TemplateUpdatePolicy TemplateUpdatePolicy `json:"templateUpdatePolicy,omitempty"`
+	// SSHKeySecretRef selects the key of a Secret in the namespace of the
+	// Cluster holding the SSH public key of the cluster, it replaces the
+	// key of the SSHCredential documents of the kops manifest
+	SSHKeySecretRef *corev1.SecretKeySelector `json:"sshKeySecretRef,omitempty"`
+	// SSHKeyRotationPolicy controls how running instances are replaced when
+	// the SSH key changes, defaults to RollingUpdate
+	SSHKeyRotationPolicy SSHKeyRotationPolicy `json:"sshKeyRotationPolicy,omitempty"`
+	// Provider selects what creates the cloud resources of the cluster,
+	// kops, simulated, kind or capi. It is set to the provider of the
+	// operator when the Cluster is created and cannot be changed.
+	Provider string `json:"provider,omitempty"`
+	// DNS selects how the names of the cluster are resolved, defaults to
+	// Zone. Gossip clusters are named <name>.k8s.local and need no DNS
+	// zone. Cannot be updated.
+	DNS DNSMode `json:"dns,omitempty"`
+	// Topology configures the network of the cluster, the kops manifest
+	// keeps its own topology when it is not set
+	Topology *TopologySpec `json:"topology,omitempty"`
+}
+
+// TopologyType is a valid value for TopologySpec.Type
+type TopologyType string
+
+const (
+	// TopologyPublic keeps the subnets of the kops manifest
+	TopologyPublic TopologyType = "public"
+	// TopologyPrivate runs masters and nodes in private subnets, the load
+	// balancers and the bastion run in utility subnets of the same zones
+	TopologyPrivate TopologyType = "private"
+)
+
+// LoadBalancerType is a valid value for TopologySpec.APILoadBalancer
+type LoadBalancerType string
+
+const (
+	LoadBalancerPublic   LoadBalancerType = "Public"
+	LoadBalancerInternal LoadBalancerType = "Internal"
+)
+
+// TopologySpec configures the network topology of the cluster and who may
+// reach it, kops allows 0.0.0.0/0 when the access CIDRs are not set
+// +k8s:openapi-gen=true
+type TopologySpec struct {
+	// Type of the topology, public or private. It cannot be changed once
+	// the cluster exists.
+	Type TopologyType `json:"type"`
+	// APILoadBalancer is the type of the load balancer of the API server
+	// of private clusters, Public or Internal, defaults to Public
+	APILoadBalancer LoadBalancerType `json:"apiLoadBalancer,omitempty"`
+	// NATGateway is the ID of an existing NAT gateway the private subnets
+	// route through, kops creates one per zone when it is not set
+	NATGateway string `json:"natGateway,omitempty"`
+	// Bastion adds a bastion InstanceGroup to private clusters, SSH access
+	// to the instances goes through it
+	Bastion *BastionSpec `json:"bastion,omitempty"`
+	// SSHAccess and KubernetesAPIAccess replace the CIDRs allowed to reach
+	// SSH and the API server in the kops manifest
+	SSHAccess           []string `json:"sshAccess,omitempty"`
+	KubernetesAPIAccess []string `json:"kubernetesApiAccess,omitempty"`
+}
+
+// BastionSpec configures the bastion InstanceGroup
+// +k8s:openapi-gen=true
+type BastionSpec struct {
+	// MachineType of the bastion, defaults to a small machine type of the
+	// cloud of the cluster
+	MachineType string `json:"machineType,omitempty"`
+}
+
+// ConfigSource selects a key of a ConfigMap or a Secret holding kops
+// manifests, exactly one of the references must be set
+// +k8s:openapi-gen=true
+type ConfigSource struct {
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+	SecretKeyRef    *corev1.SecretKeySelector    `json:"secretKeyRef,omitempty"`
 }
 
+// PatchType is a valid value for ManifestPatch.Type
+type PatchType string
+
+const (
+	// PatchTypeStrategic merges the patch into the document, lists of
+	// objects are merged by name and null removes a field
+	PatchTypeStrategic PatchType = "strategic"
+	// PatchTypeJSON applies an RFC 6902 JSON patch to the document
+	PatchTypeJSON PatchType = "json"
+)
+
+// PatchTarget selects kops manifest documents
+// +k8s:openapi-gen=true
+type PatchTarget struct {
+	Kind string `json:"kind"`
+	// Name selects the document by metadata.name, every document of the
+	// kind is patched when it is not set
+	Name string `json:"name,omitempty"`
+}
+
+// ManifestPatch is a patch of kops manifest documents
+// +k8s:openapi-gen=true
+type ManifestPatch struct {
+	Target PatchTarget `json:"target"`
+	// Type of the patch, defaults to strategic
+	Type PatchType `json:"type,omitempty"`
+	// Patch in YAML or JSON
+	Patch string `json:"patch"`
+}
+
+// TemplateUpdatePolicy is a valid value for ClusterSpec.TemplateUpdatePolicy
+type TemplateUpdatePolicy string
+
+const (
+	// TemplateUpdateAuto renders Config again when the template changes
+	TemplateUpdateAuto TemplateUpdatePolicy = "Auto"
+	// TemplateUpdateManual keeps the rendered Config until the values of
+	// the Cluster change
+	TemplateUpdateManual TemplateUpdatePolicy = "Manual"
+)
+
+// SSHKeyRotationPolicy is a valid value for ClusterSpec.SSHKeyRotationPolicy
+type SSHKeyRotationPolicy string
+
+const (
+	// SSHKeyRotationRollingUpdate replaces every instance of the cluster
+	// once the new key is applied
+	SSHKeyRotationRollingUpdate SSHKeyRotationPolicy = "RollingUpdate"
+	// SSHKeyRotationNone only applies the new key to the kops state,
+	// instances get it when they are replaced for other reasons
+	SSHKeyRotationNone SSHKeyRotationPolicy = "None"
+)
+
+// Keys of the Secret referenced by CredentialsRef. AWS credentials set the
+// access key pair, the role ARN or both, the role is assumed with the pair
+// when both are. GCE credentials set the JSON key of a service account and
+// OpenStack ones a clouds.yaml with the name of the cloud if it has several.
+const (
+	CredentialsAccessKeyID         = "accessKeyID"
+	CredentialsSecretAccessKey     = "secretAccessKey"
+	CredentialsRoleARN             = "roleARN"
+	CredentialsExternalID          = "externalID"
+	CredentialsGCEServiceAccount   = "gceServiceAccount"
+	CredentialsOpenStackCloudsYAML = "openstackCloudsYAML"
+	CredentialsOpenStackCloud      = "openstackCloud"
+)
+
+// DNSMode describes how the names of the cluster are resolved
+type DNSMode string
+
+const (
+	// DNSZone names the cluster in the DNS zone of its defaults and kops
+	// publishes the API server records in the zone
+	DNSZone DNSMode = "zone"
+	// DNSGossip names the cluster <name>.k8s.local, the nodes find each
+	// other with gossip and the API server is reached through a load
+	// balancer
+	DNSGossip DNSMode = "gossip"
+)
+
+// DeletionPolicy describes how the cluster is handled when the Cluster resource is deleted
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete removes the cloud resources and the kops state
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyRetain only removes the finalizer, the cloud resources
+	// and the kops state are left in place
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+)
+
+const (
+	// PausedAnnotation has the same effect as Spec.Paused when set to "true",
+	// it can be used during incidents without editing the spec
+	PausedAnnotation = "cluster-operator.infobloxopen.github.com/paused"
+	// ForceDeleteAnnotation allows a paused Cluster to be deleted when set to "true"
+	ForceDeleteAnnotation = "cluster-operator.infobloxopen.github.com/force-delete"
+	// SimulateFailureAnnotation lists the operations the simulated provider
+	// fails for the Cluster, e.g. "update,validate"
+	SimulateFailureAnnotation = "cluster-operator.infobloxopen.github.com/simulate-failure"
+)
+
 // PodPhase is a label for the condition of a pod at the current time.
 type ClusterPhase string
 
@@ -132,8 +382,91 @@ const (
 	// ClusterDone means that Cluster has been provisioned
 	// and can be used
 	ClusterDone ClusterPhase = "Done"
+	// ClusterUpgrading means the Kubernetes version of the Cluster is being
+	// changed, masters are rolled before nodes
+	ClusterUpgrading ClusterPhase = "Upgrading"
+)
+
+// UpgradeStage is the step of a Kubernetes version upgrade
+type UpgradeStage string
+
+const (
+	// UpgradeStageApply updates the kops state with the new version
+	UpgradeStageApply UpgradeStage = "Apply"
+	// UpgradeStageMasters rolls and validates the master instance groups
+	UpgradeStageMasters UpgradeStage = "Masters"
+	// UpgradeStageNodes rolls and validates the node instance groups
+	UpgradeStageNodes UpgradeStage = "Nodes"
+)
+
+// KubernetesVersionStatus reports the Kubernetes version running on the cluster
+// +k8s:openapi-gen=true
+type KubernetesVersionStatus struct {
+	// Version is set once masters and nodes run the same version
+	Version string `json:"version,omitempty"`
+	Masters string `json:"masters,omitempty"`
+	Nodes   string `json:"nodes,omitempty"`
+}
+
+// UpgradeStatus tracks the progress of a Kubernetes version upgrade
+// +k8s:openapi-gen=true
+type UpgradeStatus struct {
+	From  string       `json:"from,omitempty"`
+	To    string       `json:"to,omitempty"`
+	Stage UpgradeStage `json:"stage,omitempty"`
+	// Rolled is set once the instance groups of Stage were rolled, the stage
+	// then only waits for the cluster to validate
+	Rolled bool `json:"rolled,omitempty"`
+}
+
+// KopsJobPhase is the state of the Job running a kops step
+type KopsJobPhase string
+
+const (
+	KopsJobRunning   KopsJobPhase = "Running"
+	KopsJobSucceeded KopsJobPhase = "Succeeded"
+	KopsJobFailed    KopsJobPhase = "Failed"
 )
 
+// KopsJobStatus reports the last kops step run as a Job
+// +k8s:openapi-gen=true
+type KopsJobStatus struct {
+	// Name of the Job
+	Name string `json:"name,omitempty"`
+	// Command is the kops command line the Job runs
+	Command string       `json:"command,omitempty"`
+	Phase   KopsJobPhase `json:"phase,omitempty"`
+	// ExitCode of the kops process once the Job finished
+	ExitCode       int32        `json:"exitCode,omitempty"`
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// LogTail holds the last lines of the kops output
+	LogTail string `json:"logTail,omitempty"`
+}
+
+// TemplateStatus reports the last rendering of the ClusterTemplate
+// +k8s:openapi-gen=true
+type TemplateStatus struct {
+	Name string `json:"name,omitempty"`
+	// Revision is the generation of the template
+	Revision int64 `json:"revision,omitempty"`
+	// ValuesHash identifies the values Config was rendered with
+	ValuesHash string `json:"valuesHash,omitempty"`
+	// Config is the rendered kops manifest, the Cluster is reconciled with
+	// it rather than Spec.Config
+	Config string `json:"config,omitempty"`
+}
+
+// SSHKeyStatus reports the SSH key applied to the cluster
+// +k8s:openapi-gen=true
+type SSHKeyStatus struct {
+	// Fingerprint is the MD5 fingerprint of the public key, as shown by
+	// the cloud provider
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// LastRotationTime is when a changed key was last applied
+	LastRotationTime *metav1.Time `json:"lastRotationTime,omitempty"`
+}
+
 // ClusterStatus defines the observed state of Cluster
 // +k8s:openapi-gen=true
 type ClusterStatus struct {
@@ -144,6 +477,49 @@ type ClusterStatus struct {
 	KopsStatus KopsStatus `json:"kops_status,omitempty"`
 	Validated  bool       `json:"validated,omitempty"`
 	KubeConfig KubeConfig `json:"kubeconfig,omitempty"`
+	// KubernetesVersion reports the version running on masters and nodes
+	KubernetesVersion KubernetesVersionStatus `json:"kubernetesVersion,omitempty"`
+	// KopsVersion is the version of kops that last managed the cluster
+	KopsVersion string `json:"kopsVersion,omitempty"`
+	// Upgrade is set while a Kubernetes version upgrade is in progress
+	Upgrade UpgradeStatus `json:"upgrade,omitempty"`
+	// DeletionPreview lists the resources kops reported it would remove
+	// before the cluster was deleted
+	DeletionPreview string `json:"deletionPreview,omitempty"`
+	// DefaultsSource lists the defaults, the ClusterDefaults as
+	// namespace/name, the kops config was completed from when the Cluster
+	// was created, highest precedence first, or operator when only the
+	// operator settings applied
+	DefaultsSource string `json:"defaultsSource,omitempty"`
+	// ConfigHash is the sha256 of the kops manifest resolved from ConfigFrom
+	ConfigHash string `json:"configHash,omitempty"`
+	// Template reports the ClusterTemplate revision the kops manifest was
+	// rendered from
+	Template TemplateStatus `json:"template,omitempty"`
+	// SSHKey reports the key from SSHKeySecretRef applied to the cluster
+	SSHKey SSHKeyStatus `json:"sshKey,omitempty"`
+	// KopsJob is the last kops step run when kops.executor is job
+	KopsJob KopsJobStatus `json:"kopsJob,omitempty"`
+	// InstanceGroups report the size and the desired on-demand/spot mix of
+	// the instance groups kops get ig returned after the last validation
+	InstanceGroups []InstanceGroupStatus `json:"instanceGroups,omitempty"`
+	// Conditions report the latest available observations of the cluster
+	Conditions []ClusterCondition `json:"conditions,omitempty"`
+}
+
+// InstanceGroupStatus reports a kops InstanceGroup of the cluster
+// +k8s:openapi-gen=true
+type InstanceGroupStatus struct {
+	Name    string `json:"name"`
+	Role    string `json:"role,omitempty"`
+	MinSize int32  `json:"minSize"`
+	MaxSize int32  `json:"maxSize"`
+	// DesiredOnDemand and DesiredSpot split MinSize by the
+	// mixedInstancesPolicy of the group, groups with a maxPrice only ask for
+	// spot instances. The instances running while spot capacity is short
+	// are not reported.
+	DesiredOnDemand int32 `json:"desiredOnDemand"`
+	DesiredSpot     int32 `json:"desiredSpot"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -160,6 +536,16 @@ type Cluster struct {
 	Status ClusterStatus `json:"status,omitempty"`
 }
 
+// IsPaused reports if reconciliation of the Cluster is suspended
+func (c *Cluster) IsPaused() bool {
+	return c.Spec.Paused || c.Annotations[PausedAnnotation] == "true"
+}
+
+// IsForceDeleted reports if deletion should proceed even though the Cluster is paused
+func (c *Cluster) IsForceDeleted() bool {
+	return c.Annotations[ForceDeleteAnnotation] == "true"
+}
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // ClusterList contains a list of Cluster
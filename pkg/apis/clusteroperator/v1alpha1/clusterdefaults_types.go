@@ -0,0 +1,62 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterDefaultsName is the name of the ClusterDefaults the operator looks
+// up in the namespace of a Cluster and in the operator defaults.namespace,
+// the namespace one wins field by field. The controller merges them into new
+// Clusters, there is no mutating webhook.
+const ClusterDefaultsName = "default"
+
+// ClusterDefaultsSpec defines the kops settings of Clusters that do not set them
+// +k8s:openapi-gen=true
+type ClusterDefaultsSpec struct {
+	// DNSZone is appended to the Cluster name to form the kops cluster name
+	DNSZone string `json:"dnsZone,omitempty"`
+	// StateStore is the URL of the kops state store, e.g. s3://kops-state-store,
+	// gs://kops-state-store or swift://kops-state-store
+	StateStore         string   `json:"stateStore,omitempty"`
+	MasterCount        int      `json:"masterCount,omitempty"`
+	MasterInstanceType string   `json:"masterInstanceType,omitempty"`
+	WorkerCount        int      `json:"workerCount,omitempty"`
+	WorkerInstanceType string   `json:"workerInstanceType,omitempty"`
+	Zones              []string `json:"zones,omitempty"`
+	VPC                string   `json:"vpc,omitempty"`
+	// CloudProvider is the kops cloud provider, aws, gce or openstack. The
+	// machine types and zones not set default to the ones of the cloud.
+	CloudProvider string `json:"cloudProvider,omitempty"`
+	// Image is the machine image of the instance groups
+	Image string `json:"image,omitempty"`
+	// Labels are merged into the labels of the Cluster kops config, the
+	// Cluster wins on conflicts
+	Labels map[string]string `json:"labels,omitempty"`
+	// WorkerSpot runs the workers on spot instances
+	WorkerSpot *SpotSpec `json:"workerSpot,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterDefaults is the Schema for the clusterdefaults API
+// +kubebuilder:resource:path=clusterdefaults,scope=Namespaced
+// +k8s:openapi-gen=true
+type ClusterDefaults struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ClusterDefaultsSpec `json:"spec,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterDefaultsList contains a list of ClusterDefaults
+type ClusterDefaultsList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterDefaults `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterDefaults{}, &ClusterDefaultsList{})
+}
@@ -0,0 +1,53 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TemplateValue declares a value Clusters pass to a ClusterTemplate
+// +k8s:openapi-gen=true
+type TemplateValue struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Default is used when the Cluster does not set the value
+	Default string `json:"default,omitempty"`
+	// Required values without a default must be set by the Cluster
+	Required bool `json:"required,omitempty"`
+}
+
+// ClusterTemplateSpec defines a kops manifest shared by Clusters
+// +k8s:openapi-gen=true
+type ClusterTemplateSpec struct {
+	// Template is a Go template of the kops manifest. It is executed with
+	// .Name, .ClusterName, .DNSZone, .StateStore, .SSHKey, .CloudProvider,
+	// .MasterMachineType, .NodeMachineType, .Zones and .Values.
+	Template string `json:"template"`
+	// Values declares the values of the template, Clusters cannot set
+	// others when it is not empty
+	Values []TemplateValue `json:"values,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterTemplate is the Schema for the clustertemplates API
+// +kubebuilder:resource:path=clustertemplates,scope=Namespaced
+// +k8s:openapi-gen=true
+type ClusterTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ClusterTemplateSpec `json:"spec,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterTemplateList contains a list of ClusterTemplate
+type ClusterTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterTemplate{}, &ClusterTemplateList{})
+}
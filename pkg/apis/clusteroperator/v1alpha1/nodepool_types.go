@@ -0,0 +1,111 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NodePoolSpec defines a group of worker nodes of a Cluster, the operator
+// applies it as a kops InstanceGroup named like the NodePool
+// +k8s:openapi-gen=true
+type NodePoolSpec struct {
+	// ClusterRef names the Cluster in the namespace of the NodePool
+	ClusterRef string `json:"clusterRef"`
+	// MachineType of the nodes, defaults to the machine type of the Node
+	// groups of the Cluster
+	MachineType string `json:"machineType,omitempty"`
+	// MinSize and MaxSize bound the number of nodes, MaxSize defaults to
+	// MinSize and grows with it. kubectl scale sets MinSize.
+	MinSize int32 `json:"minSize"`
+	MaxSize int32 `json:"maxSize,omitempty"`
+	// Zones name the subnets of the nodes, defaults to the subnets of the
+	// Cluster that are not utility subnets
+	Zones []string `json:"zones,omitempty"`
+	// Labels are the labels of the nodes
+	Labels map[string]string `json:"labels,omitempty"`
+	// Taints of the nodes in the kops format, e.g. dedicated=gpu:NoSchedule
+	Taints []string `json:"taints,omitempty"`
+	// Image is the machine image of the nodes, defaults to the image of the
+	// Node groups of the Cluster
+	Image string `json:"image,omitempty"`
+	// Spot runs the nodes as spot instances
+	Spot *SpotSpec `json:"spot,omitempty"`
+}
+
+// SpotAllocationStrategy decides which spot pools instances are launched from
+type SpotAllocationStrategy string
+
+// Spot allocation strategies of AWS
+const (
+	SpotLowestPrice       SpotAllocationStrategy = "lowest-price"
+	SpotCapacityOptimized SpotAllocationStrategy = "capacity-optimized"
+)
+
+// SpotSpec runs a worker group on spot instances, mixed with on-demand
+// instances when OnDemandBase or OnDemandAboveBase are set. Spot instances
+// are only supported on aws.
+// +k8s:openapi-gen=true
+type SpotSpec struct {
+	// MaxPrice is the highest hourly price paid for an instance, e.g.
+	// "0.05", the instances run at the current spot price when not set
+	MaxPrice string `json:"maxPrice,omitempty"`
+	// MachineTypes the group launches besides its own machine type, more
+	// types make spot capacity easier to get
+	MachineTypes []string `json:"machineTypes,omitempty"`
+	// OnDemandBase is the number of on-demand instances launched before
+	// any spot instance
+	OnDemandBase *int32 `json:"onDemandBase,omitempty"`
+	// OnDemandAboveBase is the percentage of on-demand instances above
+	// OnDemandBase, 0 when not set
+	OnDemandAboveBase *int32 `json:"onDemandAboveBase,omitempty"`
+	// AllocationStrategy is lowest-price or capacity-optimized, AWS
+	// defaults to lowest-price
+	AllocationStrategy SpotAllocationStrategy `json:"allocationStrategy,omitempty"`
+}
+
+// NodePoolStatus defines the observed state of NodePool
+// +k8s:openapi-gen=true
+type NodePoolStatus struct {
+	// Replicas is the MinSize applied to the InstanceGroup
+	Replicas int32 `json:"replicas"`
+	// ReadyReplicas is the number of ready nodes the last validation of
+	// the Cluster reported
+	ReadyReplicas int32 `json:"readyReplicas"`
+	// ObservedGeneration is the generation the InstanceGroup was last
+	// rolled for
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// DesiredOnDemandReplicas and DesiredSpotReplicas split Replicas by the
+	// mixedInstancesPolicy kops reports for the InstanceGroup, not by the
+	// lifecycle of the running instances
+	DesiredOnDemandReplicas int32 `json:"desiredOnDemandReplicas,omitempty"`
+	DesiredSpotReplicas     int32 `json:"desiredSpotReplicas,omitempty"`
+	// Message explains why the NodePool is not applied
+	Message string `json:"message,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NodePool is the Schema for the nodepools API
+// +kubebuilder:subresource:status
+// +kubebuilder:subresource:scale:specpath=.spec.minSize,statuspath=.status.replicas
+// +kubebuilder:resource:path=nodepools,scope=Namespaced
+// +k8s:openapi-gen=true
+type NodePool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NodePoolSpec   `json:"spec,omitempty"`
+	Status NodePoolStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NodePoolList contains a list of NodePool
+type NodePoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NodePool `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NodePool{}, &NodePoolList{})
+}
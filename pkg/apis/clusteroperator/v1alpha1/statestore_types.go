@@ -0,0 +1,74 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// StateStoreSpec defines a kops state store Clusters can reference
+// +k8s:openapi-gen=true
+type StateStoreSpec struct {
+	// URL of the store, e.g. s3://kops-state-store, gs://kops-state-store
+	// or swift://kops-state-store
+	URL string `json:"url"`
+	// Region of the bucket or swift container, kops looks it up when not set
+	Region string `json:"region,omitempty"`
+	// CredentialsRef names a Secret with the cloud credentials of the store,
+	// it has the keys of Cluster credentials. The operator credentials are
+	// used when it is not set.
+	CredentialsRef *corev1.SecretReference `json:"credentialsRef,omitempty"`
+	// AllowedNamespaces selects the namespaces whose Clusters may use the
+	// store, every namespace may when it is not set
+	AllowedNamespaces *metav1.LabelSelector `json:"allowedNamespaces,omitempty"`
+}
+
+// StateStoreStatus defines the observed state of StateStore
+// +k8s:openapi-gen=true
+type StateStoreStatus struct {
+	// Reachable is true when the last probe could list the store
+	Reachable bool `json:"reachable"`
+	// LastProbeTime is when the store was last probed
+	LastProbeTime *metav1.Time `json:"lastProbeTime,omitempty"`
+	// Message explains why the store is not reachable
+	Message string `json:"message,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// StateStore is the Schema for the statestores API
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=statestores,scope=Cluster
+// +k8s:openapi-gen=true
+type StateStore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   StateStoreSpec   `json:"spec,omitempty"`
+	Status StateStoreStatus `json:"status,omitempty"`
+}
+
+// AllowsNamespace reports if Clusters in the namespace may use the store
+func (s *StateStore) AllowsNamespace(namespace *corev1.Namespace) (bool, error) {
+	if s.Spec.AllowedNamespaces == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(s.Spec.AllowedNamespaces)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(namespace.Labels)), nil
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// StateStoreList contains a list of StateStore
+type StateStoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []StateStore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&StateStore{}, &StateStoreList{})
+}
@@ -1,14 +1,50 @@
 package clustervalidator
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
 
+	"github.com/infobloxopen/cluster-operator/kops"
+	"github.com/infobloxopen/cluster-operator/pkg/apis"
 	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/infobloxopen/cluster-operator/pkg/provisioner"
 	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type ClusterAdmission struct {
+	// Client reads the StateStores and Namespaces Clusters are checked against
+	Client client.Reader
+	// ProductionNamespaces selects the namespaces whose Clusters must be
+	// private and restrict access, the policy is off when it is nil
+	ProductionNamespaces labels.Selector
+}
+
+// NewClusterAdmission returns the admission controller with a client for the apiserver
+func NewClusterAdmission(cfg *rest.Config) (*ClusterAdmission, error) {
+	s := k8sruntime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		return nil, err
+	}
+	if err := apis.AddToScheme(s); err != nil {
+		return nil, err
+	}
+	c, err := client.New(cfg, client.Options{Scheme: s})
+	if err != nil {
+		return nil, err
+	}
+	return &ClusterAdmission{Client: c}, nil
 }
 
 func UnmarshalClusterObject(rawReview []byte) (clusteroperatorv1alpha1.Cluster, error) {
@@ -18,15 +54,45 @@ func UnmarshalClusterObject(rawReview []byte) (clusteroperatorv1alpha1.Cluster,
 	return cluster, err
 }
 
-func (*ClusterAdmission) HandleAdmission(review *v1beta1.AdmissionReview) error {
+func (ca *ClusterAdmission) HandleAdmission(review *v1beta1.AdmissionReview) error {
 	// Only operate on Cluster Kind
 	switch review.Request.Kind.Kind {
 	case "Cluster":
 		switch review.Request.Operation {
 		case "CREATE":
-			// Approves all CREATE requests currently
-			// Can be extended to run validation cases on CREATE
+			newCluster, unmarshalNewErr := UnmarshalClusterObject(review.Request.Object.Raw)
+			if unmarshalNewErr != nil {
+				return unmarshalNewErr
+			}
+
+			// Approves CREATE requests unless the config sources are
+			// ambiguous or the state store is not allowed
 			review.Response = &v1beta1.AdmissionResponse{Allowed: true}
+			ValidateConfigFrom(newCluster, review)
+			if review.Response.Allowed {
+				ValidateProviderName(newCluster, review)
+			}
+			if review.Response.Allowed {
+				ValidatePatches(newCluster, review)
+			}
+			if review.Response.Allowed {
+				ca.ValidateStateStore(newCluster, review)
+			}
+			if review.Response.Allowed {
+				ValidateCloud(newCluster, review)
+			}
+			if review.Response.Allowed {
+				ValidateGossip(newCluster, review)
+			}
+			if review.Response.Allowed {
+				ValidateTopology(newCluster, review)
+			}
+			if review.Response.Allowed {
+				ValidateSpot(newCluster, review)
+			}
+			if review.Response.Allowed {
+				ca.ValidateProductionPolicy(newCluster, review)
+			}
 			break
 		case "UPDATE":
 			// rewiew.Request.Object and review.Request.OldObject contain the newly applyed and current objects
@@ -41,10 +107,73 @@ func (*ClusterAdmission) HandleAdmission(review *v1beta1.AdmissionReview) error
 				return unmarshalNewErr
 			}
 
+			// Clusters being deleted only lose their finalizers, nothing may
+			// hold up the deletion
+			if newCluster.DeletionTimestamp != nil {
+				review.Response = &v1beta1.AdmissionResponse{Allowed: true}
+				break
+			}
+
 			// Reject UPDATE if Spec.Name is different between cluster objects
 			// Currently only case a Cluster object is rejected,
 			// can extend to check for additional cases
 			ValidateClusterName(oldCluster, newCluster, review)
+			if review.Response.Allowed {
+				ValidateKubernetesVersion(oldCluster, newCluster, review)
+			}
+			if review.Response.Allowed {
+				ValidateStateStoreRef(oldCluster, newCluster, review)
+			}
+			if review.Response.Allowed {
+				ValidateProvider(oldCluster, newCluster, review)
+			}
+			if review.Response.Allowed {
+				ValidateProviderName(newCluster, review)
+			}
+			if review.Response.Allowed {
+				ValidateDNS(oldCluster, newCluster, review)
+			}
+			if review.Response.Allowed {
+				ValidateTopologyType(oldCluster, newCluster, review)
+			}
+			if review.Response.Allowed {
+				ValidateConfigFrom(newCluster, review)
+			}
+			if review.Response.Allowed {
+				ValidatePatches(newCluster, review)
+			}
+			// A deleted store or changed policy must not block the updates of
+			// the operator, such as removing its finalizer
+			if review.Response.Allowed && newCluster.Spec.StateStoreRef != oldCluster.Spec.StateStoreRef {
+				ca.ValidateStateStore(newCluster, review)
+			}
+			if review.Response.Allowed {
+				ValidateCloud(newCluster, review)
+			}
+			if review.Response.Allowed {
+				ValidateGossip(newCluster, review)
+			}
+			if review.Response.Allowed {
+				ValidateTopology(newCluster, review)
+			}
+			if review.Response.Allowed {
+				ValidateSpot(newCluster, review)
+			}
+			// The policy only applies to what changes the topology or the
+			// access, the finalizers of the operator are always updated
+			if review.Response.Allowed && productionFieldsChanged(oldCluster, newCluster) {
+				ca.ValidateProductionPolicy(newCluster, review)
+			}
+
+			break
+		case "DELETE":
+			// The object being deleted is only available in OldObject
+			oldCluster, unmarshalOldErr := UnmarshalClusterObject(review.Request.OldObject.Raw)
+			if unmarshalOldErr != nil {
+				return unmarshalOldErr
+			}
+
+			ValidateClusterDeletion(oldCluster, review)
 
 			break
 		}
@@ -71,3 +200,536 @@ func ValidateClusterName(oldCluster clusteroperatorv1alpha1.Cluster, newCluster
 		}
 	}
 }
+
+// Validate Cluster Spec.KubernetesVersion field on UPDATE
+// Rejects downgrades and upgrades that skip minor versions
+func ValidateKubernetesVersion(oldCluster clusteroperatorv1alpha1.Cluster, newCluster clusteroperatorv1alpha1.Cluster, review *v1beta1.AdmissionReview) {
+	from := oldCluster.Spec.KubernetesVersion
+	to := newCluster.Spec.KubernetesVersion
+	if from == "" || to == "" || from == to {
+		return
+	}
+
+	if err := kops.CheckVersionSkew(from, to); err != nil {
+		review.Response = &v1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &v1.Status{
+				Message: "Update rejected, " + err.Error() + ".",
+			},
+		}
+	}
+}
+
+// Validate Cluster deletion on DELETE
+// Rejects deletion while Spec.DeletionProtection is enabled
+func ValidateClusterDeletion(cluster clusteroperatorv1alpha1.Cluster, review *v1beta1.AdmissionReview) {
+	if cluster.Spec.DeletionProtection {
+		review.Response = &v1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &v1.Status{
+				Message: "Delete rejected, Cluster Spec.DeletionProtection is enabled.",
+			},
+		}
+	} else {
+		review.Response = &v1beta1.AdmissionResponse{
+			Allowed: true,
+			Result: &v1.Status{
+				Message: "Delete approved.",
+			},
+		}
+	}
+}
+
+// Validate Cluster Spec.StateStoreRef field on UPDATE
+// The kops state of a cluster cannot move to another store
+func ValidateStateStoreRef(oldCluster clusteroperatorv1alpha1.Cluster, newCluster clusteroperatorv1alpha1.Cluster, review *v1beta1.AdmissionReview) {
+	if newCluster.Spec.StateStoreRef != oldCluster.Spec.StateStoreRef {
+		review.Response = &v1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &v1.Status{
+				Message: "Update rejected, Cluster Spec.StateStoreRef cannot be updated.",
+			},
+		}
+	}
+}
+
+// Validate Cluster Spec.Provider field on UPDATE
+// The operator sets it once, the cluster cannot move to another provider
+func ValidateProvider(oldCluster clusteroperatorv1alpha1.Cluster, newCluster clusteroperatorv1alpha1.Cluster, review *v1beta1.AdmissionReview) {
+	if oldCluster.Spec.Provider != "" && newCluster.Spec.Provider != oldCluster.Spec.Provider {
+		review.Response = &v1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &v1.Status{
+				Message: "Update rejected, Cluster Spec.Provider cannot be updated.",
+			},
+		}
+	}
+}
+
+// Validate Cluster Spec.Provider names a provider of the operator
+func ValidateProviderName(newCluster clusteroperatorv1alpha1.Cluster, review *v1beta1.AdmissionReview) {
+	if err := checkProviderName(newCluster.Spec.Provider); err != nil {
+		review.Response = &v1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &v1.Status{
+				Message: "Request rejected, " + err.Error() + ".",
+			},
+		}
+	}
+}
+
+func checkProviderName(name string) error {
+	if name == "" {
+		return nil
+	}
+	for _, p := range provisioner.Names {
+		if name == p {
+			return nil
+		}
+	}
+	return fmt.Errorf("Cluster Spec.Provider %q is not one of %s", name, strings.Join(provisioner.Names, ", "))
+}
+
+// Validate Cluster Spec.DNS field on UPDATE
+// The DNS mode decides the kops cluster name, it cannot be changed
+func ValidateDNS(oldCluster clusteroperatorv1alpha1.Cluster, newCluster clusteroperatorv1alpha1.Cluster, review *v1beta1.AdmissionReview) {
+	gossip := func(c clusteroperatorv1alpha1.Cluster) bool {
+		return c.Spec.DNS == clusteroperatorv1alpha1.DNSGossip
+	}
+	if gossip(oldCluster) != gossip(newCluster) {
+		review.Response = &v1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &v1.Status{
+				Message: "Update rejected, Cluster Spec.DNS cannot be updated.",
+			},
+		}
+	}
+}
+
+// Validate Cluster Spec.Topology.Type field on UPDATE
+// kops cannot move a running cluster to other subnets, the type cannot be
+// changed
+func ValidateTopologyType(oldCluster clusteroperatorv1alpha1.Cluster, newCluster clusteroperatorv1alpha1.Cluster, review *v1beta1.AdmissionReview) {
+	private := func(c clusteroperatorv1alpha1.Cluster) bool {
+		return c.Spec.Topology != nil && c.Spec.Topology.Type == clusteroperatorv1alpha1.TopologyPrivate
+	}
+	if private(oldCluster) != private(newCluster) {
+		review.Response = &v1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &v1.Status{
+				Message: "Update rejected, Cluster Spec.Topology.Type cannot be updated.",
+			},
+		}
+	}
+}
+
+// Validate gossip Clusters on CREATE and UPDATE
+// The kops cluster name of Spec.KopsConfig and Spec.Config must end with .k8s.local
+func ValidateGossip(cluster clusteroperatorv1alpha1.Cluster, review *v1beta1.AdmissionReview) {
+	if cluster.Spec.DNS != clusteroperatorv1alpha1.DNSGossip {
+		return
+	}
+	names := []string{cluster.Spec.KopsConfig.Name}
+	if cluster.Spec.Config != "" {
+		if m, err := kops.ParseManifest(cluster.Spec.Config); err == nil {
+			if doc, err := m.Cluster(); err == nil {
+				names = append(names, kops.Name(doc))
+			}
+		}
+	}
+	for _, name := range names {
+		if name != "" && !kops.IsGossip(name) {
+			review.Response = &v1beta1.AdmissionResponse{
+				Allowed: false,
+				Result: &v1.Status{
+					Message: fmt.Sprintf("Request rejected, gossip cluster name %s does not end with %s.", name, kops.GossipSuffix),
+				},
+			}
+			return
+		}
+	}
+}
+
+// Validate the cloud of the Cluster on CREATE and UPDATE
+// The machine types and zones of Spec.KopsConfig and Spec.Config must be ones
+// of the cloud provider, Spec.Config naming another cloud is rejected
+func ValidateCloud(cluster clusteroperatorv1alpha1.Cluster, review *v1beta1.AdmissionReview) {
+	if err := checkCloud(cluster.Spec); err != nil {
+		review.Response = &v1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &v1.Status{
+				Message: "Request rejected, " + err.Error() + ".",
+			},
+		}
+	}
+}
+
+func checkCloud(spec clusteroperatorv1alpha1.ClusterSpec) error {
+	kc := spec.KopsConfig
+	provider := kc.CloudProvider
+	var m *kops.Manifest
+	if spec.Config != "" {
+		// Malformed manifests are reported by kops
+		if parsed, err := kops.ParseManifest(spec.Config); err == nil {
+			m = parsed
+		}
+	}
+	if m != nil && m.CloudProvider() != "" {
+		if provider != "" && provider != m.CloudProvider() {
+			return fmt.Errorf("Cluster Spec.KopsConfig is for %s and Spec.Config for %s", provider, m.CloudProvider())
+		}
+		provider = m.CloudProvider()
+	}
+
+	cloud, err := kops.CloudFor(provider)
+	if err != nil {
+		return err
+	}
+	if kc.MasterMachineType != "" && kc.MasterEc2 != "" && kc.MasterMachineType != kc.MasterEc2 {
+		return fmt.Errorf("Cluster Spec.KopsConfig master_ec2 is the deprecated alias of master_machine_type, set only one")
+	}
+	if kc.WorkerMachineType != "" && kc.WorkerEc2 != "" && kc.WorkerMachineType != kc.WorkerEc2 {
+		return fmt.Errorf("Cluster Spec.KopsConfig worker_ec2 is the deprecated alias of worker_machine_type, set only one")
+	}
+	for _, machineType := range []string{kc.MasterType(), kc.WorkerType()} {
+		if machineType == "" {
+			continue
+		}
+		if err := cloud.ValidateMachineType(machineType); err != nil {
+			return err
+		}
+	}
+	for _, zone := range kc.Zones {
+		if err := cloud.ValidateZone(zone); err != nil {
+			return err
+		}
+	}
+	if kc.StateStore != "" {
+		if _, err := kops.StateStoreScheme(kc.StateStore); err != nil {
+			return err
+		}
+	}
+	if m != nil {
+		return cloud.ValidateManifest(m)
+	}
+	return nil
+}
+
+// Validate Cluster Spec.ConfigFrom on CREATE and UPDATE
+// The manifest comes from one place and each source references one key
+func ValidateConfigFrom(cluster clusteroperatorv1alpha1.Cluster, review *v1beta1.AdmissionReview) {
+	if len(cluster.Spec.ConfigFrom) == 0 {
+		return
+	}
+
+	message := ""
+	switch {
+	case cluster.Spec.Config != "":
+		message = "Cluster Spec.Config and Spec.ConfigFrom cannot be set together"
+	case cluster.Spec.TemplateRef != nil:
+		message = "Cluster Spec.TemplateRef and Spec.ConfigFrom cannot be set together"
+	}
+	for i, source := range cluster.Spec.ConfigFrom {
+		if (source.ConfigMapKeyRef == nil) == (source.SecretKeyRef == nil) {
+			message = fmt.Sprintf("Cluster Spec.ConfigFrom[%d] must reference either a ConfigMap or a Secret key", i)
+		}
+	}
+	if message != "" {
+		review.Response = &v1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &v1.Status{
+				Message: "Request rejected, " + message + ".",
+			},
+		}
+	}
+}
+
+// Validate Cluster Spec.Patches on CREATE and UPDATE
+// Patches must apply cleanly to Spec.Config, they are only checked to be well
+// formed when the manifest comes from a template or Spec.ConfigFrom
+func ValidatePatches(cluster clusteroperatorv1alpha1.Cluster, review *v1beta1.AdmissionReview) {
+	if len(cluster.Spec.Patches) == 0 {
+		return
+	}
+
+	var err error
+	if cluster.Spec.Config != "" {
+		var m *kops.Manifest
+		if m, err = kops.ParseManifest(cluster.Spec.Config); err == nil {
+			err = m.ApplyPatches(cluster.Spec.Patches)
+		}
+	} else {
+		for _, p := range cluster.Spec.Patches {
+			if err = kops.CheckPatch(p); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		review.Response = &v1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &v1.Status{
+				Message: "Request rejected, Cluster Spec.Patches do not apply: " + err.Error() + ".",
+			},
+		}
+	}
+}
+
+// Validate Cluster Spec.StateStoreRef on CREATE, and on UPDATE when it changes
+// Rejects stores that do not exist or do not allow the namespace of the Cluster
+func (ca *ClusterAdmission) ValidateStateStore(cluster clusteroperatorv1alpha1.Cluster, review *v1beta1.AdmissionReview) {
+	name := cluster.Spec.StateStoreRef
+	if name == "" {
+		return
+	}
+	namespace := review.Request.Namespace
+	if namespace == "" {
+		namespace = cluster.Namespace
+	}
+
+	message, err := ca.checkStateStore(name, namespace)
+	if err != nil {
+		message = fmt.Sprintf("StateStore %s cannot be checked: %s", name, err)
+	}
+	if message != "" {
+		review.Response = &v1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &v1.Status{
+				Message: "Request rejected, " + message + ".",
+			},
+		}
+	}
+}
+
+// checkStateStore returns why Clusters in the namespace cannot use the store, empty if they can
+func (ca *ClusterAdmission) checkStateStore(name, namespace string) (string, error) {
+	if ca.Client == nil {
+		return "", fmt.Errorf("no client configured")
+	}
+
+	store := &clusteroperatorv1alpha1.StateStore{}
+	err := ca.Client.Get(context.TODO(), types.NamespacedName{Name: name}, store)
+	if errors.IsNotFound(err) {
+		return fmt.Sprintf("StateStore %s not found", name), nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	ns := &corev1.Namespace{}
+	if err := ca.Client.Get(context.TODO(), types.NamespacedName{Name: namespace}, ns); err != nil {
+		return "", err
+	}
+	allowed, err := store.AllowsNamespace(ns)
+	if err != nil {
+		return "", err
+	}
+	if !allowed {
+		return fmt.Sprintf("StateStore %s does not allow Clusters in namespace %s", name, namespace), nil
+	}
+	return "", nil
+}
+
+// Validate Cluster Spec.Topology on CREATE and UPDATE
+// Bastions need a private topology, the access CIDRs must parse and the
+// networking of Spec.Config must route the pods of private subnets
+func ValidateTopology(cluster clusteroperatorv1alpha1.Cluster, review *v1beta1.AdmissionReview) {
+	if err := checkTopology(cluster.Spec); err != nil {
+		review.Response = &v1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &v1.Status{
+				Message: "Request rejected, " + err.Error() + ".",
+			},
+		}
+	}
+}
+
+func checkTopology(spec clusteroperatorv1alpha1.ClusterSpec) error {
+	t := spec.Topology
+	if t == nil {
+		return nil
+	}
+	if t.Bastion != nil && t.Type != clusteroperatorv1alpha1.TopologyPrivate {
+		return fmt.Errorf("Cluster Spec.Topology.Bastion needs a private topology")
+	}
+	for _, cidr := range append(append([]string{}, t.SSHAccess...), t.KubernetesAPIAccess...) {
+		if _, err := kops.OpenCIDR(cidr); err != nil {
+			return fmt.Errorf("Cluster Spec.Topology: %s", err)
+		}
+	}
+	if spec.Config == "" {
+		return nil
+	}
+
+	// Malformed manifests and patches are reported on their own
+	m, err := kops.ParseManifest(spec.Config)
+	if err != nil {
+		return nil
+	}
+	if err := m.SetTopology(*t); err != nil {
+		return nil
+	}
+	if err := m.ApplyPatches(spec.Patches); err != nil {
+		return nil
+	}
+	if err := m.CheckNetworking(); err != nil {
+		return fmt.Errorf("Cluster Spec.Config: %s", err)
+	}
+	return nil
+}
+
+// productionFieldsChanged reports if the update changes what the production
+// policy checks
+func productionFieldsChanged(oldCluster, newCluster clusteroperatorv1alpha1.Cluster) bool {
+	return !reflect.DeepEqual(oldCluster.Spec.Topology, newCluster.Spec.Topology) ||
+		oldCluster.Spec.Config != newCluster.Spec.Config ||
+		!reflect.DeepEqual(oldCluster.Spec.Patches, newCluster.Spec.Patches)
+}
+
+// Validate the spot settings of the Cluster on CREATE and UPDATE
+// Rejects spot instances for the masters, losing them takes the control
+// plane down
+func ValidateSpot(cluster clusteroperatorv1alpha1.Cluster, review *v1beta1.AdmissionReview) {
+	if err := checkSpot(cluster.Spec); err != nil {
+		review.Response = &v1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &v1.Status{
+				Message: "Request rejected, " + err.Error() + ".",
+			},
+		}
+	}
+}
+
+func checkSpot(spec clusteroperatorv1alpha1.ClusterSpec) error {
+	if spot := spec.KopsConfig.WorkerSpot; spot != nil {
+		if cloud := spec.KopsConfig.CloudProvider; cloud != "" && cloud != kops.CloudAWS {
+			return fmt.Errorf("Cluster Spec.KopsConfig.WorkerSpot is not supported on %s", cloud)
+		}
+		if spot.OnDemandBase != nil && *spot.OnDemandBase < 0 {
+			return fmt.Errorf("Cluster Spec.KopsConfig.WorkerSpot.OnDemandBase cannot be negative")
+		}
+		if spot.OnDemandAboveBase != nil && (*spot.OnDemandAboveBase < 0 || *spot.OnDemandAboveBase > 100) {
+			return fmt.Errorf("Cluster Spec.KopsConfig.WorkerSpot.OnDemandAboveBase must be a percentage")
+		}
+		switch spot.AllocationStrategy {
+		case "", clusteroperatorv1alpha1.SpotLowestPrice, clusteroperatorv1alpha1.SpotCapacityOptimized:
+		default:
+			return fmt.Errorf("Cluster Spec.KopsConfig.WorkerSpot.AllocationStrategy %q is not %s or %s",
+				spot.AllocationStrategy, clusteroperatorv1alpha1.SpotLowestPrice, clusteroperatorv1alpha1.SpotCapacityOptimized)
+		}
+	}
+	if spec.Config == "" {
+		return nil
+	}
+
+	// Malformed manifests and patches are reported on their own
+	m, err := kops.ParseManifest(spec.Config)
+	if err != nil {
+		return nil
+	}
+	if spec.Topology != nil {
+		if err := m.SetTopology(*spec.Topology); err != nil {
+			return nil
+		}
+	}
+	if spec.KopsConfig.WorkerSpot != nil {
+		if err := m.SetWorkerSpot(*spec.KopsConfig.WorkerSpot); err != nil {
+			return fmt.Errorf("Cluster Spec.KopsConfig.WorkerSpot: %s", err)
+		}
+	}
+	if err := m.ApplyPatches(spec.Patches); err != nil {
+		return nil
+	}
+	if err := m.CheckMasterSpot(); err != nil {
+		return fmt.Errorf("Cluster Spec.Config: %s", err)
+	}
+	return nil
+}
+
+// Validate Clusters of production namespaces on CREATE and on UPDATE of the
+// topology, the manifest or the patches
+// Rejects public topologies and sshAccess or kubernetesApiAccess open to
+// every address, kops opens them when they are not set
+func (ca *ClusterAdmission) ValidateProductionPolicy(cluster clusteroperatorv1alpha1.Cluster, review *v1beta1.AdmissionReview) {
+	if ca.ProductionNamespaces == nil || ca.ProductionNamespaces.Empty() {
+		return
+	}
+	namespace := review.Request.Namespace
+	if namespace == "" {
+		namespace = cluster.Namespace
+	}
+
+	message, err := ca.checkProductionPolicy(cluster.Spec, namespace)
+	if err != nil {
+		message = fmt.Sprintf("namespace %s cannot be checked: %s", namespace, err)
+	}
+	if message != "" {
+		review.Response = &v1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &v1.Status{
+				Message: "Request rejected, " + message + ".",
+			},
+		}
+	}
+}
+
+// checkProductionPolicy returns why the Cluster breaks the policy of the
+// namespace, empty if it does not. Spec.Config is checked with the topology
+// and the patches applied, Spec.Topology when the manifest comes from a
+// template or Spec.ConfigFrom.
+func (ca *ClusterAdmission) checkProductionPolicy(spec clusteroperatorv1alpha1.ClusterSpec, namespace string) (string, error) {
+	if ca.Client == nil {
+		return "", fmt.Errorf("no client configured")
+	}
+	ns := &corev1.Namespace{}
+	if err := ca.Client.Get(context.TODO(), types.NamespacedName{Name: namespace}, ns); err != nil {
+		return "", err
+	}
+	if !ca.ProductionNamespaces.Matches(labels.Set(ns.Labels)) {
+		return "", nil
+	}
+
+	private := spec.Topology != nil && spec.Topology.Type == clusteroperatorv1alpha1.TopologyPrivate
+	var ssh, api []string
+	if spec.Topology != nil {
+		ssh, api = spec.Topology.SSHAccess, spec.Topology.KubernetesAPIAccess
+	}
+	if spec.Config != "" {
+		m, err := kops.ParseManifest(spec.Config)
+		if err != nil {
+			return "", err
+		}
+		if spec.Topology != nil {
+			if err := m.SetTopology(*spec.Topology); err != nil {
+				return "", err
+			}
+		}
+		if err := m.ApplyPatches(spec.Patches); err != nil {
+			return "", err
+		}
+		private = m.Private()
+		ssh, api = m.AccessCIDRs()
+	}
+
+	if !private {
+		return fmt.Sprintf("Clusters in production namespace %s must use a private topology", namespace), nil
+	}
+	for _, access := range []struct {
+		field string
+		cidrs []string
+	}{{"sshAccess", ssh}, {"kubernetesApiAccess", api}} {
+		if len(access.cidrs) == 0 {
+			return fmt.Sprintf("Clusters in production namespace %s must restrict %s", namespace, access.field), nil
+		}
+		for _, cidr := range access.cidrs {
+			open, err := kops.OpenCIDR(cidr)
+			if err != nil {
+				return fmt.Sprintf("%s: %s", access.field, err), nil
+			}
+			if open {
+				return fmt.Sprintf("Clusters in production namespace %s cannot open %s to %s", namespace, access.field, cidr), nil
+			}
+		}
+	}
+	return "", nil
+}
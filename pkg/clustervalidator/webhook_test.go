@@ -10,9 +10,14 @@ import (
 	"strings"
 	"testing"
 
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
 	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 var (
@@ -168,6 +173,36 @@ var (
 			},
 		},
 	}
+	// Mock Admission Request for deleting a Cluster resource with deletion protection enabled
+	// Expect to be rejected by the validating webhook
+	AdmissionRequestDeleteProtected = v1beta1.AdmissionReview{
+		TypeMeta: v1.TypeMeta{
+			Kind: "AdmissionReview",
+		},
+		Request: &v1beta1.AdmissionRequest{
+			UID: "e911857d-c318-11e8-bbad-025000000001",
+			Kind: v1.GroupVersionKind{
+				Group:   "cluster-operator.infobloxopen.github.com",
+				Version: "v1alpha1",
+				Kind:    "Cluster",
+			},
+			Operation: "DELETE",
+			OldObject: runtime.RawExtension{
+				Raw: []byte(`{
+					"apiVersion": "cluster-operator.infobloxopen.github.com/v1alpha1",
+					"kind": "Cluster",
+					"metadata": {
+						"name": "example-cluster",
+						"namespace": "scoleman"
+					},
+					"spec": {
+						"name": "scoleman",
+						"deletionProtection": true
+					}
+				}`),
+			},
+		},
+	}
 )
 
 // Helper function to decode admission review response sent from the validating webhook sever
@@ -190,7 +225,12 @@ func encodeRequest(review *v1beta1.AdmissionReview) []byte {
 // Helper function to submit a mock admission review to the validating webhook server
 // mock - the mock admission review to send to the server to test
 func GetAdmissionReviewForTest(mock v1beta1.AdmissionReview) (*v1beta1.AdmissionReview, error) {
-	nsc := &ClusterAdmission{}
+	return getAdmissionReviewWith(&ClusterAdmission{}, mock)
+}
+
+// Helper function to submit a mock admission review to a validating webhook
+// server for the admission controller
+func getAdmissionReviewWith(nsc *ClusterAdmission, mock v1beta1.AdmissionReview) (*v1beta1.AdmissionReview, error) {
 	server := httptest.NewServer(GetAdmissionServerNoSSL(nsc, ":8080").Handler)
 	requestString := string(encodeRequest(&mock))
 	myr := strings.NewReader(requestString)
@@ -260,3 +300,456 @@ func TestUpdateDiffName(t *testing.T) {
 		t.Error("Update allowed CR with name change, should block")
 	}
 }
+
+// Test delete Cluster with Spec.DeletionProtection enabled
+// Expect the delete to be rejected
+func TestDeleteProtected(t *testing.T) {
+	review, err := GetAdmissionReviewForTest(AdmissionRequestDeleteProtected)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if review.Response.Allowed {
+		t.Error("Delete allowed CR with deletion protection, should block")
+	}
+}
+
+// Mock Admission Request creating a Cluster in the namespace using the state store
+func admissionRequestCreateWithStateStore(namespace, store string) v1beta1.AdmissionReview {
+	return v1beta1.AdmissionReview{
+		TypeMeta: v1.TypeMeta{
+			Kind: "AdmissionReview",
+		},
+		Request: &v1beta1.AdmissionRequest{
+			UID: "e911857d-c318-11e8-bbad-025000000001",
+			Kind: v1.GroupVersionKind{
+				Group:   "cluster-operator.infobloxopen.github.com",
+				Version: "v1alpha1",
+				Kind:    "Cluster",
+			},
+			Namespace: namespace,
+			Operation: "CREATE",
+			Object: runtime.RawExtension{
+				Raw: []byte(`{
+					"apiVersion": "cluster-operator.infobloxopen.github.com/v1alpha1",
+					"kind": "Cluster",
+					"metadata": {
+						"name": "example-cluster",
+						"namespace": "` + namespace + `"
+					},
+					"spec": {
+						"name": "example",
+						"stateStoreRef": "` + store + `"
+					}
+				}`),
+			},
+		},
+	}
+}
+
+// Test create Cluster referencing a StateStore
+// Expect Clusters to be rejected unless the store allows their namespace
+func TestCreateStateStore(t *testing.T) {
+	s := runtime.NewScheme()
+	clientgoscheme.AddToScheme(s)
+	clusteroperatorv1alpha1.SchemeBuilder.AddToScheme(s)
+	store := &clusteroperatorv1alpha1.StateStore{
+		ObjectMeta: v1.ObjectMeta{Name: "production"},
+		Spec: clusteroperatorv1alpha1.StateStoreSpec{
+			URL: "s3://kops-production",
+			AllowedNamespaces: &v1.LabelSelector{
+				MatchLabels: map[string]string{"environment": "production"},
+			},
+		},
+	}
+	nsc := &ClusterAdmission{
+		Client: fake.NewFakeClientWithScheme(s,
+			store,
+			&corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "prod", Labels: map[string]string{"environment": "production"}}},
+			&corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "dev"}},
+		),
+	}
+
+	tests := []struct {
+		namespace string
+		store     string
+		allowed   bool
+	}{
+		{namespace: "prod", store: "production", allowed: true},
+		{namespace: "dev", store: "production", allowed: false},
+		{namespace: "prod", store: "missing", allowed: false},
+		{namespace: "dev", store: "", allowed: true},
+	}
+	for _, tt := range tests {
+		review, err := getAdmissionReviewWith(nsc, admissionRequestCreateWithStateStore(tt.namespace, tt.store))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if review.Response.Allowed != tt.allowed {
+			t.Error("Expected allowed", tt.allowed, "for", tt.namespace, tt.store, "got", review.Response.Result)
+		}
+	}
+
+	// Clusters of a deleted store can still be updated, the operator removes
+	// its finalizer
+	update := admissionRequestUpdateWithSpecs(`{"name": "example", "stateStoreRef": "missing"}`,
+		`{"name": "example", "stateStoreRef": "missing", "provider": "kops"}`)
+	review, err := getAdmissionReviewWith(nsc, update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !review.Response.Allowed {
+		t.Error("Expected the update of a Cluster of a deleted store to be allowed got", review.Response.Result)
+	}
+}
+
+func admissionRequestCreateWithSpec(spec string) v1beta1.AdmissionReview {
+	review := admissionRequestCreateWithStateStore("test", "")
+	review.Request.Object.Raw = []byte(`{
+		"apiVersion": "cluster-operator.infobloxopen.github.com/v1alpha1",
+		"kind": "Cluster",
+		"metadata": {
+			"name": "example-cluster",
+			"namespace": "test"
+		},
+		"spec": ` + spec + `
+	}`)
+	return review
+}
+
+// Test create Cluster with spec.configFrom
+// Expect the manifest to come from a single place
+func TestCreateConfigFrom(t *testing.T) {
+	tests := []struct {
+		spec    string
+		allowed bool
+	}{
+		{spec: `{"name": "example", "configFrom": [{"configMapKeyRef": {"name": "kops", "key": "cluster.yaml"}}]}`, allowed: true},
+		{spec: `{"name": "example", "config": "kind: Cluster", "configFrom": [{"secretKeyRef": {"name": "kops", "key": "cluster.yaml"}}]}`, allowed: false},
+		{spec: `{"name": "example", "templateRef": {"name": "standard"}, "configFrom": [{"secretKeyRef": {"name": "kops", "key": "cluster.yaml"}}]}`, allowed: false},
+		{spec: `{"name": "example", "configFrom": [{}]}`, allowed: false},
+	}
+	for _, tt := range tests {
+		review, err := GetAdmissionReviewForTest(admissionRequestCreateWithSpec(tt.spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if review.Response.Allowed != tt.allowed {
+			t.Error("Expected allowed", tt.allowed, "for", tt.spec, "got", review.Response.Result)
+		}
+	}
+}
+
+// Test create Cluster with spec.patches
+// Expect patches that do not apply to the manifest to be rejected
+func TestCreatePatches(t *testing.T) {
+	config := `"apiVersion: kops.k8s.io/v1alpha2\nkind: InstanceGroup\nmetadata:\n  name: nodes\nspec:\n  maxSize: 2\n"`
+	tests := []struct {
+		spec    string
+		allowed bool
+	}{
+		{spec: `{"name": "example", "config": ` + config + `, "patches": [{"target": {"kind": "InstanceGroup", "name": "nodes"}, "patch": "spec:\n  maxSize: 5"}]}`, allowed: true},
+		{spec: `{"name": "example", "config": ` + config + `, "patches": [{"target": {"kind": "InstanceGroup", "name": "bastions"}, "patch": "spec:\n  maxSize: 5"}]}`, allowed: false},
+		{spec: `{"name": "example", "config": ` + config + `, "patches": [{"target": {"kind": "InstanceGroup"}, "type": "json", "patch": "[{\"op\": \"remove\", \"path\": \"/spec/minSize\"}]"}]}`, allowed: false},
+		{spec: `{"name": "example", "templateRef": {"name": "standard"}, "patches": [{"target": {"kind": "Cluster"}, "type": "json", "patch": "not a patch"}]}`, allowed: false},
+		{spec: `{"name": "example", "templateRef": {"name": "standard"}, "patches": [{"target": {"kind": "Cluster"}, "patch": "spec:\n  cloudLabels:\n    team: a"}]}`, allowed: true},
+	}
+	for _, tt := range tests {
+		review, err := GetAdmissionReviewForTest(admissionRequestCreateWithSpec(tt.spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if review.Response.Allowed != tt.allowed {
+			t.Error("Expected allowed", tt.allowed, "for", tt.spec, "got", review.Response.Result)
+		}
+	}
+}
+
+// Mock Admission Request updating a Cluster from the old to the new spec
+func admissionRequestUpdateWithSpecs(oldSpec, newSpec string) v1beta1.AdmissionReview {
+	review := admissionRequestCreateWithSpec(newSpec)
+	review.Request.Operation = "UPDATE"
+	review.Request.OldObject.Raw = admissionRequestCreateWithSpec(oldSpec).Request.Object.Raw
+	return review
+}
+
+// Test update Cluster changing Spec.Provider
+// Expect the provider to be set once
+func TestUpdateProvider(t *testing.T) {
+	tests := []struct {
+		oldSpec string
+		newSpec string
+		allowed bool
+	}{
+		{`{"name": "example"}`, `{"name": "example", "provider": "capi"}`, true},
+		{`{"name": "example", "provider": "capi"}`, `{"name": "example", "provider": "capi"}`, true},
+		{`{"name": "example", "provider": "kops"}`, `{"name": "example", "provider": "capi"}`, false},
+		{`{"name": "example", "provider": "kops"}`, `{"name": "example"}`, false},
+		{`{"name": "example"}`, `{"name": "example", "provider": "capd"}`, false},
+	}
+	for _, tt := range tests {
+		review, err := GetAdmissionReviewForTest(admissionRequestUpdateWithSpecs(tt.oldSpec, tt.newSpec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if review.Response.Allowed != tt.allowed {
+			t.Error("Expected allowed", tt.allowed, "for", tt.oldSpec, "to", tt.newSpec, "got", review.Response.Result)
+		}
+	}
+}
+
+// Test create Cluster with Spec.Provider
+// Expect unknown providers to be rejected
+func TestCreateProvider(t *testing.T) {
+	tests := []struct {
+		spec    string
+		allowed bool
+	}{
+		{`{"name": "example"}`, true},
+		{`{"name": "example", "provider": "kind"}`, true},
+		{`{"name": "example", "provider": "capd"}`, false},
+	}
+	for _, tt := range tests {
+		review, err := GetAdmissionReviewForTest(admissionRequestCreateWithSpec(tt.spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if review.Response.Allowed != tt.allowed {
+			t.Error("Expected allowed", tt.allowed, "for", tt.spec, "got", review.Response.Result)
+		}
+	}
+}
+
+// Test create Cluster on GCE
+// Expect machine types, zones and state stores of other clouds to be rejected
+func TestCreateCloud(t *testing.T) {
+	gce := `"apiVersion: kops.k8s.io/v1alpha2\nkind: Cluster\nmetadata:\n  name: example.k8s.local\nspec:\n  cloudProvider: gce\n---\napiVersion: kops.k8s.io/v1alpha2\nkind: InstanceGroup\nmetadata:\n  name: nodes\nspec:\n  machineType: MACHINE\n  zones:\n  - us-central1-a\n"`
+	tests := []struct {
+		spec    string
+		allowed bool
+	}{
+		{spec: `{"name": "example", "kops_config": {"cloud_provider": "gce", "master_machine_type": "n1-standard-1", "zones": ["us-central1-a"], "state_store": "gs://kops"}}`, allowed: true},
+		{spec: `{"name": "example", "kops_config": {"cloud_provider": "gce", "master_machine_type": "t3.medium"}}`, allowed: false},
+		{spec: `{"name": "example", "kops_config": {"cloud_provider": "gce", "master_ec2": "t3.medium"}}`, allowed: false},
+		{spec: `{"name": "example", "kops_config": {"cloud_provider": "gce", "worker_machine_type": "n1-standard-2", "worker_ec2": "n1-standard-4"}}`, allowed: false},
+		{spec: `{"name": "example", "kops_config": {"state_store": "file:///var/kops"}}`, allowed: false},
+		{spec: `{"name": "example", "kops_config": {"cloud_provider": "gce", "zones": ["us-east-2a"]}}`, allowed: false},
+		{spec: `{"name": "example", "kops_config": {"cloud_provider": "openstack", "state_store": "azure://kops"}}`, allowed: false},
+		{spec: `{"name": "example", "kops_config": {"zones": ["us-central1-a"]}}`, allowed: false},
+		{spec: `{"name": "example", "config": ` + strings.Replace(gce, "MACHINE", "n1-standard-2", 1) + `}`, allowed: true},
+		{spec: `{"name": "example", "config": ` + strings.Replace(gce, "MACHINE", "t2.micro", 1) + `}`, allowed: false},
+		{spec: `{"name": "example", "kops_config": {"cloud_provider": "aws"}, "config": ` + strings.Replace(gce, "MACHINE", "n1-standard-2", 1) + `}`, allowed: false},
+	}
+	for _, tt := range tests {
+		review, err := GetAdmissionReviewForTest(admissionRequestCreateWithSpec(tt.spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if review.Response.Allowed != tt.allowed {
+			t.Error("Expected allowed", tt.allowed, "for", tt.spec, "got", review.Response.Result)
+		}
+	}
+}
+
+// Test create and update gossip Clusters
+// Expect kops names without .k8s.local and changes of Spec.DNS to be rejected
+func TestGossip(t *testing.T) {
+	config := `"apiVersion: kops.k8s.io/v1alpha2\nkind: Cluster\nmetadata:\n  name: NAME\nspec:\n  kubernetesVersion: 1.16.7\n"`
+	tests := []struct {
+		oldSpec string
+		newSpec string
+		allowed bool
+	}{
+		{newSpec: `{"name": "example", "dns": "gossip"}`, allowed: true},
+		{newSpec: `{"name": "example", "dns": "gossip", "kops_config": {"name": "example.k8s.local"}}`, allowed: true},
+		{newSpec: `{"name": "example", "dns": "gossip", "kops_config": {"name": "example.soheil.belamaric.com"}}`, allowed: false},
+		{newSpec: `{"name": "example", "dns": "gossip", "config": ` + strings.Replace(config, "NAME", "example.k8s.local", 1) + `}`, allowed: true},
+		{newSpec: `{"name": "example", "dns": "gossip", "config": ` + strings.Replace(config, "NAME", "example.example.com", 1) + `}`, allowed: false},
+		{oldSpec: `{"name": "example", "dns": "gossip"}`, newSpec: `{"name": "example", "dns": "gossip"}`, allowed: true},
+		{oldSpec: `{"name": "example"}`, newSpec: `{"name": "example", "dns": "zone"}`, allowed: true},
+		{oldSpec: `{"name": "example"}`, newSpec: `{"name": "example", "dns": "gossip"}`, allowed: false},
+		{oldSpec: `{"name": "example", "dns": "gossip"}`, newSpec: `{"name": "example", "dns": "zone"}`, allowed: false},
+	}
+	for _, tt := range tests {
+		request := admissionRequestCreateWithSpec(tt.newSpec)
+		if tt.oldSpec != "" {
+			request = admissionRequestUpdateWithSpecs(tt.oldSpec, tt.newSpec)
+		}
+		review, err := GetAdmissionReviewForTest(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if review.Response.Allowed != tt.allowed {
+			t.Error("Expected allowed", tt.allowed, "for", tt.oldSpec, "to", tt.newSpec, "got", review.Response.Result)
+		}
+	}
+}
+
+// Test create Cluster with spec.topology
+// Expect bastions of public clusters and malformed CIDRs to be rejected
+func TestCreateTopology(t *testing.T) {
+	kubenet := `"apiVersion: kops.k8s.io/v1alpha2\nkind: Cluster\nmetadata:\n  name: example.k8s.local\nspec:\n  networking:\n    kubenet: {}\n  subnets:\n  - name: us-east-2a\n    type: Public\n    zone: us-east-2a\n"`
+	tests := []struct {
+		spec    string
+		allowed bool
+	}{
+		{spec: `{"name": "example", "topology": {"type": "private", "bastion": {}, "sshAccess": ["10.0.0.0/8"]}}`, allowed: true},
+		{spec: `{"name": "example", "topology": {"type": "public", "bastion": {}}}`, allowed: false},
+		{spec: `{"name": "example", "topology": {"type": "private", "kubernetesApiAccess": ["10.0.0.1"]}}`, allowed: false},
+		{spec: `{"name": "example", "config": ` + kubenet + `, "topology": {"type": "private"}}`, allowed: false},
+		{spec: `{"name": "example", "config": ` + kubenet + `, "topology": {"type": "private"}, "patches": [{"target": {"kind": "Cluster"}, "patch": "spec:\n  networking:\n    kubenet: null\n    calico: {}\n"}]}`, allowed: true},
+		{spec: `{"name": "example", "config": ` + kubenet + `, "topology": {"type": "public", "sshAccess": ["10.0.0.0/8"]}}`, allowed: true},
+	}
+	for _, tt := range tests {
+		review, err := GetAdmissionReviewForTest(admissionRequestCreateWithSpec(tt.spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if review.Response.Allowed != tt.allowed {
+			t.Error("Expected allowed", tt.allowed, "for", tt.spec, "got", review.Response.Result)
+		}
+	}
+}
+
+// Test update Cluster changing Spec.Topology.Type
+// Expect the subnets of a cluster to be fixed once it exists
+func TestUpdateTopologyType(t *testing.T) {
+	tests := []struct {
+		oldSpec string
+		newSpec string
+		allowed bool
+	}{
+		{`{"name": "example"}`, `{"name": "example", "topology": {"type": "public", "sshAccess": ["10.0.0.0/8"]}}`, true},
+		{`{"name": "example", "topology": {"type": "private"}}`, `{"name": "example", "topology": {"type": "private", "sshAccess": ["10.0.0.0/8"]}}`, true},
+		{`{"name": "example"}`, `{"name": "example", "topology": {"type": "private"}}`, false},
+		{`{"name": "example", "topology": {"type": "private"}}`, `{"name": "example", "topology": {"type": "public"}}`, false},
+	}
+	for _, tt := range tests {
+		review, err := GetAdmissionReviewForTest(admissionRequestUpdateWithSpecs(tt.oldSpec, tt.newSpec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if review.Response.Allowed != tt.allowed {
+			t.Error("Expected allowed", tt.allowed, "for", tt.oldSpec, "to", tt.newSpec, "got", review.Response.Result)
+		}
+	}
+}
+
+// Test create Cluster with spot instances
+// Expect spot masters and malformed worker spot settings to be rejected
+func TestCreateSpot(t *testing.T) {
+	config := `"apiVersion: kops.k8s.io/v1alpha2\nkind: InstanceGroup\nmetadata:\n  name: master-us-east-2a\nspec:\n  role: Master\n  minSize: 1\n---\napiVersion: kops.k8s.io/v1alpha2\nkind: InstanceGroup\nmetadata:\n  name: nodes\nspec:\n  role: Node\n"`
+	tests := []struct {
+		spec    string
+		allowed bool
+	}{
+		{spec: `{"name": "example", "config": ` + config + `, "kops_config": {"worker_spot": {"machineTypes": ["t3.large"], "onDemandBase": 1, "allocationStrategy": "capacity-optimized"}}}`, allowed: true},
+		{spec: `{"name": "example", "kops_config": {"worker_spot": {"onDemandAboveBase": 150}}}`, allowed: false},
+		{spec: `{"name": "example", "kops_config": {"worker_spot": {"allocationStrategy": "cheapest"}}}`, allowed: false},
+		{spec: `{"name": "example", "kops_config": {"cloud_provider": "gce", "worker_spot": {"maxPrice": "0.05"}}}`, allowed: false},
+		{spec: `{"name": "example", "config": "kind: Cluster\nspec:\n  cloudProvider: openstack\n", "kops_config": {"worker_spot": {"maxPrice": "0.05"}}}`, allowed: false},
+		{spec: `{"name": "example", "config": ` + config + `, "patches": [{"target": {"kind": "InstanceGroup", "name": "master-us-east-2a"}, "patch": "spec:\n  maxPrice: \"0.05\""}]}`, allowed: false},
+		{spec: `{"name": "example", "config": ` + config + `, "patches": [{"target": {"kind": "InstanceGroup", "name": "master-us-east-2a"}, "patch": "spec:\n  mixedInstancesPolicy:\n    onDemandAboveBase: 100"}]}`, allowed: true},
+		{spec: `{"name": "example", "config": ` + config + `, "patches": [{"target": {"kind": "InstanceGroup", "name": "master-us-east-2a"}, "patch": "spec:\n  mixedInstancesPolicy:\n    onDemandAboveBase: 0"}]}`, allowed: false},
+	}
+	for _, tt := range tests {
+		review, err := GetAdmissionReviewForTest(admissionRequestCreateWithSpec(tt.spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if review.Response.Allowed != tt.allowed {
+			t.Error("Expected allowed", tt.allowed, "for", tt.spec, "got", review.Response.Result)
+		}
+	}
+}
+
+// Test create Cluster in a production namespace
+// Expect public topologies and open access CIDRs to be rejected there only
+func TestCreateProductionPolicy(t *testing.T) {
+	s := runtime.NewScheme()
+	clientgoscheme.AddToScheme(s)
+	selector, _ := labels.Parse("environment=production")
+	nsc := &ClusterAdmission{
+		Client: fake.NewFakeClientWithScheme(s,
+			&corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "prod", Labels: map[string]string{"environment": "production"}}},
+			&corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "dev"}},
+		),
+		ProductionNamespaces: selector,
+	}
+
+	public := `"apiVersion: kops.k8s.io/v1alpha2\nkind: Cluster\nmetadata:\n  name: example.k8s.local\nspec:\n  kubernetesApiAccess:\n  - 0.0.0.0/0\n  sshAccess:\n  - 0.0.0.0/0\n  topology:\n    masters: public\n    nodes: public\n"`
+	private := `{"type": "private", "sshAccess": ["10.0.0.0/8"], "kubernetesApiAccess": ["10.0.0.0/8"]}`
+	tests := []struct {
+		namespace string
+		spec      string
+		allowed   bool
+	}{
+		{namespace: "dev", spec: `{"name": "example", "config": ` + public + `}`, allowed: true},
+		{namespace: "prod", spec: `{"name": "example", "config": ` + public + `}`, allowed: false},
+		{namespace: "prod", spec: `{"name": "example", "config": ` + public + `, "topology": ` + private + `}`, allowed: true},
+		{namespace: "prod", spec: `{"name": "example", "config": ` + public + `, "topology": {"type": "private", "sshAccess": ["10.0.0.0/8"]}}`, allowed: false},
+		{namespace: "prod", spec: `{"name": "example", "topology": ` + private + `}`, allowed: true},
+		{namespace: "prod", spec: `{"name": "example", "topology": {"type": "private", "sshAccess": ["10.0.0.0/8"]}}`, allowed: false},
+		{namespace: "prod", spec: `{"name": "example", "topology": {"type": "private", "sshAccess": ["10.0.0.0/8"], "kubernetesApiAccess": ["::/0"]}}`, allowed: false},
+		{namespace: "prod", spec: `{"name": "example", "topology": {"type": "public", "sshAccess": ["10.0.0.0/8"], "kubernetesApiAccess": ["10.0.0.0/8"]}}`, allowed: false},
+		{namespace: "prod", spec: `{"name": "example", "config": ` + public + `, "topology": ` + private + `, "patches": [{"target": {"kind": "Cluster"}, "patch": "spec:\n  sshAccess:\n  - 0.0.0.0/0\n"}]}`, allowed: false},
+	}
+	for _, tt := range tests {
+		request := admissionRequestCreateWithSpec(tt.spec)
+		request.Request.Namespace = tt.namespace
+		review, err := getAdmissionReviewWith(nsc, request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if review.Response.Allowed != tt.allowed {
+			t.Error("Expected allowed", tt.allowed, "in", tt.namespace, "for", tt.spec, "got", review.Response.Result)
+		}
+	}
+}
+
+// Test update Cluster in a production namespace
+// Expect only changes of the topology or the access to be checked, and
+// Clusters being deleted to be allowed
+func TestUpdateProductionPolicy(t *testing.T) {
+	s := runtime.NewScheme()
+	clientgoscheme.AddToScheme(s)
+	selector, _ := labels.Parse("environment=production")
+	nsc := &ClusterAdmission{
+		Client: fake.NewFakeClientWithScheme(s,
+			&corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "prod", Labels: map[string]string{"environment": "production"}}},
+		),
+		ProductionNamespaces: selector,
+	}
+
+	public := `{"name": "example", "topology": {"type": "public", "sshAccess": ["0.0.0.0/0"]}}`
+	tests := []struct {
+		name      string
+		namespace string
+		oldSpec   string
+		newSpec   string
+		deleting  bool
+		allowed   bool
+	}{
+		{name: "finalizer update", namespace: "prod", oldSpec: public, newSpec: public, allowed: true},
+		{name: "deleting", namespace: "prod", oldSpec: public, newSpec: `{"name": "example", "topology": {"type": "public", "sshAccess": ["0.0.0.0/0", "10.0.0.0/8"]}}`, deleting: true, allowed: true},
+		{name: "access change", namespace: "prod", oldSpec: public, newSpec: `{"name": "example", "topology": {"type": "public", "sshAccess": ["0.0.0.0/0", "10.0.0.0/8"]}}`, allowed: false},
+		// The Namespace cannot be read, unrelated updates go through
+		{name: "unknown namespace", namespace: "missing", oldSpec: public, newSpec: public, allowed: true},
+	}
+	for _, tt := range tests {
+		request := admissionRequestUpdateWithSpecs(tt.oldSpec, tt.newSpec)
+		request.Request.Namespace = tt.namespace
+		if tt.deleting {
+			request.Request.Object.Raw = []byte(strings.Replace(string(request.Request.Object.Raw),
+				`"namespace": "test"`, `"namespace": "test", "deletionTimestamp": "2020-06-01T00:00:00Z"`, 1))
+		}
+		review, err := getAdmissionReviewWith(nsc, request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if review.Response.Allowed != tt.allowed {
+			t.Error(tt.name, "expected allowed", tt.allowed, "got", review.Response.Result)
+		}
+	}
+}
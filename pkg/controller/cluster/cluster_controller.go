@@ -8,8 +8,11 @@ import (
 
 	"github.com/infobloxopen/cluster-operator/kops"
 	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/infobloxopen/cluster-operator/pkg/defaults"
+	"github.com/infobloxopen/cluster-operator/pkg/provisioner"
 
 	"github.com/infobloxopen/cluster-operator/utils"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 
 	// "k8s.io/apimachinery/pkg/api/errors"
@@ -18,6 +21,9 @@ import (
 	//"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 
 	//"k8s.io/kops/cmd/kops"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -36,7 +42,11 @@ var log = logf.Log.WithName("controller_cluster")
 // Add creates a new Cluster Controller and adds it to the Manager. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
 func Add(cfg ReconcilerConfig) error {
-	return add(cfg.Mgr, newReconciler(cfg))
+	r, err := newReconciler(cfg)
+	if err != nil {
+		return err
+	}
+	return add(cfg.Mgr, r)
 }
 
 type ReconcilerConfig struct {
@@ -44,8 +54,25 @@ type ReconcilerConfig struct {
 	Reap bool
 }
 
-func newReconciler(cfg ReconcilerConfig) reconcile.Reconciler {
-	return &ReconcileCluster{client: cfg.Mgr.GetClient(), scheme: cfg.Mgr.GetScheme(), reap: cfg.Reap}
+func newReconciler(cfg ReconcilerConfig) (reconcile.Reconciler, error) {
+	// Pod logs are not served by the controller-runtime client
+	clientset, err := kubernetes.NewForConfig(cfg.Mgr.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	r := &ReconcileCluster{
+		client:    cfg.Mgr.GetClient(),
+		scheme:    cfg.Mgr.GetScheme(),
+		recorder:  cfg.Mgr.GetEventRecorderFor("cluster-controller"),
+		logs:      clientsetLogReader{clientset: clientset},
+		reap:      cfg.Reap,
+		simulator: provisioner.NewSimulator(),
+	}
+	if url := viper.GetString("defaults.source.url"); url != "" {
+		r.defaultsSource = defaults.NewHTTPSource(url,
+			viper.GetDuration("defaults.source.timeout"), viper.GetDuration("defaults.source.cache.ttl"))
+	}
+	return r, nil
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
@@ -58,6 +85,16 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 
 	pred := predicate.Funcs{
 		UpdateFunc: func(e event.UpdateEvent) bool {
+			// Annotations do not bump the generation but pausing and forced
+			// deletion are driven by them
+			if controlAnnotationsChanged(e.MetaOld.GetAnnotations(), e.MetaNew.GetAnnotations()) {
+				return true
+			}
+
+			// Labels copied into the kops manifest have to be kept in sync
+			if propagatedLabelsChanged(e.MetaOld, e.MetaNew) {
+				return true
+			}
 
 			if e.MetaNew.GetGeneration() == e.MetaOld.GetGeneration() {
 				return false
@@ -77,11 +114,87 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
-	// TODO(user): Modify this to be the types you create that are owned by the primary resource
-	// Watch for changes to secondary resource Pods and requeue the owner Cluster
-	err = c.Watch(&source.Kind{Type: &corev1.Pod{}}, &handler.EnqueueRequestForOwner{
+	// Only status changes of kops Jobs and their Pods move a Cluster forward,
+	// creating and removing them is done by the controller itself
+	jobPred := predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return false },
+		DeleteFunc:  func(e event.DeleteEvent) bool { return false },
+		GenericFunc: func(e event.GenericEvent) bool { return false },
+	}
+
+	// Watch for changes to the kops Jobs and requeue the owner Cluster
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, &handler.EnqueueRequestForOwner{
 		IsController: true,
 		OwnerType:    &clusteroperatorv1alpha1.Cluster{},
+	}, jobPred)
+	if err != nil {
+		return err
+	}
+
+	// Pods are owned by the Jobs, they carry the name of the Cluster in a label
+	err = c.Watch(&source.Kind{Type: &corev1.Pod{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			name, ok := o.Meta.GetLabels()[jobClusterLabel]
+			if !ok {
+				return nil
+			}
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: o.Meta.GetNamespace(), Name: name}}}
+		}),
+	}, jobPred)
+	if err != nil {
+		return err
+	}
+
+	// Watch the StateStores so Clusters waiting for theirs are picked up,
+	// probe results do not change the generation
+	err = c.Watch(&source.Kind{Type: &clusteroperatorv1alpha1.StateStore{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			return clustersUsingStateStore(mgr.GetClient(), o.Meta.GetName())
+		}),
+	}, predicate.GenerationChangedPredicate{})
+	if err != nil {
+		return err
+	}
+
+	// Watch the ClusterTemplates so template changes are rendered again
+	err = c.Watch(&source.Kind{Type: &clusteroperatorv1alpha1.ClusterTemplate{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			return clustersUsingTemplate(mgr.GetClient(), o.Meta.GetNamespace(), o.Meta.GetName())
+		}),
+	}, predicate.GenerationChangedPredicate{})
+	if err != nil {
+		return err
+	}
+
+	// Watch the NodePools so they are applied with their Cluster
+	err = c.Watch(&source.Kind{Type: &clusteroperatorv1alpha1.NodePool{}}, nodePoolHandler, nodePoolPred)
+	if err != nil {
+		return err
+	}
+
+	// Watch the ConfigMaps of spec.configFrom so changes are applied
+	err = c.Watch(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			// ConfigMaps of the kops Jobs are never referenced
+			if _, ok := o.Meta.GetLabels()[jobClusterLabel]; ok {
+				return nil
+			}
+			return clustersUsingConfigMap(mgr.GetClient(), o.Meta.GetNamespace(), o.Meta.GetName())
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch the credentials and spec.configFrom Secrets so changes take effect
+	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			// Secrets of the kops Jobs are never referenced
+			if _, ok := o.Meta.GetLabels()[jobClusterLabel]; ok {
+				return nil
+			}
+			return clustersUsingSecret(mgr.GetClient(), o.Meta.GetNamespace(), o.Meta.GetName())
+		}),
 	})
 	if err != nil {
 		return err
@@ -97,9 +210,18 @@ var _ reconcile.Reconciler = &ReconcileCluster{}
 type ReconcileCluster struct {
 	// This client, initialized using mgr.Client() above, is a split client
 	// that reads objects from the cache and writes to the apiserver
-	client client.Client
-	scheme *runtime.Scheme
-	reap   bool
+	client   client.Client
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
+	logs     podLogReader
+	// jobOutputs keeps the outputs of the finished kops Jobs of a pass
+	jobOutputs jobOutputs
+	reap       bool
+	// defaultsSource is the external defaults lookup, nil when
+	// defaults.source.url is not configured
+	defaultsSource defaults.Source
+	// simulator keeps the clusters of the simulated provider
+	simulator *provisioner.Simulator
 }
 
 // Reconcile reads that state of the cluster for a Cluster object and makes changes based on the state read
@@ -109,6 +231,32 @@ type ReconcileCluster struct {
 // The Controller will requeue the Request to be processed again if the returned error is non-nil or
 // Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
 func (r *ReconcileCluster) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	if viper.GetString("kops.executor") != kops.JobExecutorName {
+		return r.reconcileCluster(request)
+	}
+
+	// The pass resumes at the pending step once its Job finished
+	running, err := r.kopsJobRunning(request.NamespacedName)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	result, err := reconcile.Result{}, kops.ErrPending
+	if !running {
+		result, err = r.reconcileCluster(request)
+	}
+	if err == kops.ErrPending {
+		// The Job watch requeues the Cluster once the kops step finished
+		log.Info("Waiting for kops job", "Request.Namespace", request.Namespace, "Request.Name", request.Name)
+		return reconcile.Result{RequeueAfter: jobPollInterval}, nil
+	}
+	// The pass is over, the next one runs every step again
+	if cerr := r.cleanupJobs(request.NamespacedName); cerr != nil {
+		log.Error(cerr, "error removing finished kops jobs", "Request.Namespace", request.Namespace, "Request.Name", request.Name)
+	}
+	return result, err
+}
+
+func (r *ReconcileCluster) reconcileCluster(request reconcile.Request) (reconcile.Result, error) {
 	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
 	reqLogger.Info("Reconciling Cluster")
 
@@ -121,27 +269,70 @@ func (r *ReconcileCluster) Reconcile(request reconcile.Request) (reconcile.Resul
 			// Request object not found, could have been deleted after reconcile request.
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
-			return reconcile.Result{}, nil
+			setPausedMetric(request.NamespacedName, false)
+			// NodePools created after their Cluster went away are released
+			return reconcile.Result{}, r.releaseNodePools(request.Namespace, request.Name)
 		}
 		// Error reading the object - requeue the request.
 		return reconcile.Result{}, err
 	}
 	//Finalizer name
 	clusterFinalizer := "cluster.finalizer.cluster-operator.infobloxopen.github.com"
-	// TODO - We should maybe catch lack of kops configuration earlier in operator startup
-	k, err := kops.NewKops()
-	if err != nil {
-		reqLogger.Error(err, "kops.NewKops Failed")
+	paused := instance.IsPaused()
+	setPausedMetric(request.NamespacedName, paused)
+	if err := r.updatePausedCondition(instance, paused); err != nil {
 		return reconcile.Result{}, err
 	}
 
-	kc := CheckKopsDefaultConfig(instance.Spec)
 	// If the cluster is not waiting for deletion, handle it normally
 	if instance.ObjectMeta.DeletionTimestamp.IsZero() {
+		// Add the finalizer and update the object
+		if !utils.Contains(instance.ObjectMeta.Finalizers, clusterFinalizer) {
+			instance.ObjectMeta.Finalizers = append(instance.ObjectMeta.Finalizers, clusterFinalizer)
+			if err := r.client.Update(context.TODO(), instance); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+
+		// Paused clusters are left alone, no defaults lookup, state store,
+		// credentials or spec change
+		if paused {
+			reqLogger.Info("Cluster is paused, skipping reconcile")
+			return reconcile.Result{}, nil
+		}
+
+		// TODO - We should maybe catch lack of kops configuration earlier in operator startup
+		kc, defaultsSource, err := r.kopsConfig(instance)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		p, release, ok, err := r.provisioner(instance, &kc)
+		if !ok || err != nil {
+			return reconcile.Result{RequeueAfter: provisionerRetryInterval}, err
+		}
+		defer release()
+
+		// Clusters created before spec.provider record theirs so it cannot
+		// be changed later
+		if instance.Spec.Provider == "" && instance.Status.Phase != "" {
+			instance.Spec.Provider = providerName(instance)
+			if err := r.updateSpec(instance); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+
+		// Templates render the key of the Secret
+		key, ok, err := r.loadSSHKey(instance)
+		if !ok || err != nil {
+			return reconcile.Result{}, err
+		}
+		if ok, err := r.renderTemplate(instance, kc, key); !ok || err != nil {
+			return reconcile.Result{}, err
+		}
 
 		// If no phase set default to pending for the initial phase
 		if instance.Status.Phase == "" {
-			instance.Spec.KopsConfig = CheckKopsDefaultConfig(instance.Spec)
+			instance.Spec.KopsConfig = kc
 			// The following routine will remove any clusters from the state store that are not in etcd
 			// This will run whenever a cluster is created on the state store its beeing created in
 			if r.reap == true {
@@ -154,7 +345,7 @@ func (r *ReconcileCluster) Reconcile(request reconcile.Request) (reconcile.Resul
 				}
 
 				// List clusters in current state store
-				ssClusters, err := k.ListClusters(instance.Spec.KopsConfig.StateStore)
+				ssClusters, err := p.ListClusters(instance.Spec.KopsConfig.StateStore)
 				if err != nil {
 					reqLogger.Error(err, "Cannot list clusters")
 					return reconcile.Result{}, err
@@ -182,7 +373,7 @@ func (r *ReconcileCluster) Reconcile(request reconcile.Request) (reconcile.Resul
 					for _, cluster := range badClusters {
 						reqLogger.Info("Deleting cluster " + cluster)
 						tempKopsConfig := clusteroperatorv1alpha1.KopsConfig{StateStore: instance.Spec.KopsConfig.StateStore, Name: cluster}
-						err := k.DeleteCluster(tempKopsConfig)
+						err := p.DeleteCluster(tempKopsConfig)
 						if err != nil {
 							reqLogger.Error(err, "Cannot delete cluster from stat store")
 							return reconcile.Result{}, err
@@ -192,22 +383,68 @@ func (r *ReconcileCluster) Reconcile(request reconcile.Request) (reconcile.Resul
 				}
 			}
 
-			instance.Status.Phase = clusteroperatorv1alpha1.ClusterPending
+			// Persist the merged defaults so later changes to them do not
+			// affect the cluster
 			instance.Spec.KopsConfig = kc
+			instance.Spec.Provider = providerName(instance)
+			if err := r.updateSpec(instance); err != nil {
+				return reconcile.Result{}, err
+			}
+			instance.Status.Phase = clusteroperatorv1alpha1.ClusterPending
+			instance.Status.DefaultsSource = defaultsSource
 		}
-		// Add the finalizer and update the object
-		if !utils.Contains(instance.ObjectMeta.Finalizers, clusterFinalizer) {
-			instance.ObjectMeta.Finalizers = append(instance.ObjectMeta.Finalizers, clusterFinalizer)
-			if err := r.client.Update(context.TODO(), instance); err != nil {
+		manifest, ok, err := r.resolveConfig(instance)
+		if !ok || err != nil {
+			return reconcile.Result{}, err
+		}
+		if manifest, err = withSSHKey(manifest, key); err != nil {
+			reqLogger.Error(err, "error setting the SSH key")
+			return reconcile.Result{}, err
+		}
+		// Make sure the kops release can manage the requested Kubernetes version
+		if _, err := p.Version(); err != nil {
+			reqLogger.Error(err, "error getting the kops version")
+			return reconcile.Result{}, err
+		}
+		kopsVersion, err := p.CheckCompatibility(desiredKubernetesVersion(instance, manifest))
+		if err != nil {
+			reqLogger.Error(err, "kops is not compatible")
+			return reconcile.Result{}, r.setKopsCompatible(instance, "KubernetesVersionNotSupported", err)
+		}
+		if err := r.setKopsCompatible(instance, "", nil); err != nil {
+			return reconcile.Result{}, err
+		}
+		if kopsVersion != "" {
+			// Persisted with the next status update of the phase
+			instance.Status.KopsVersion = kopsVersion
+		}
+
+		// Kubernetes version changes of a running cluster go through their own phase
+		rendered := instance
+		if upgradeRequested(instance) {
+			supported, err := r.checkUpgrade(instance, p)
+			if err != nil {
+				reqLogger.Error(err, "error checking the upgrade")
 				return reconcile.Result{}, err
 			}
+			if supported {
+				return r.reconcileUpgrade(instance, p, kc, manifest)
+			}
+			rendered = runningSpec(instance)
+		} else if err := r.clearUnsupportedUpgrade(instance); err != nil {
+			return reconcile.Result{}, err
 		}
 
 		//go through the cycle of phases
 		//PENDING: CREATING CLUSTER
 		reqLogger.Info("Phase: PENDING")
+		spec, err := desiredSpec(rendered, manifest)
+		if err != nil {
+			reqLogger.Error(err, "error rendering kops manifest")
+			return reconcile.Result{}, err
+		}
 		//creating cluster
-		err := k.ReplaceCluster(instance.Spec)
+		err = p.ReplaceCluster(spec)
 
 		if err != nil {
 			reqLogger.Error(err, "error creating cluster")
@@ -215,6 +452,12 @@ func (r *ReconcileCluster) Reconcile(request reconcile.Request) (reconcile.Resul
 		}
 		reqLogger.Info("Cluster Config Updated")
 
+		pools, err := r.applyNodePools(instance, p, kc, spec.Config)
+		if err != nil {
+			reqLogger.Error(err, "error applying node pools")
+			return reconcile.Result{}, err
+		}
+
 		instance.Status.Phase = clusteroperatorv1alpha1.ClusterUpdate
 		if err := r.client.Status().Update(context.TODO(), instance); err != nil {
 			return reconcile.Result{}, err
@@ -223,7 +466,7 @@ func (r *ReconcileCluster) Reconcile(request reconcile.Request) (reconcile.Resul
 		//UPDATIG: UPDATING CLUSTER
 		reqLogger.Info("Phase: UPDATE")
 
-		err = k.UpdateCluster(kc)
+		err = p.UpdateCluster(kc)
 
 		if err != nil {
 			reqLogger.Error(err, "error updating cluster")
@@ -245,12 +488,16 @@ func (r *ReconcileCluster) Reconcile(request reconcile.Request) (reconcile.Resul
 		}
 
 		var config clusteroperatorv1alpha1.KubeConfig
-		config, err = k.GetKubeConfig(kc)
+		config, err = p.GetKubeConfig(kc)
 		if err != nil {
 			return reconcile.Result{}, err
 		}
 
 		instance.Status.KubeConfig = config
+		if err := r.publishKubeConfig(instance, config); err != nil {
+			reqLogger.Error(err, "error publishing kubeconfig")
+			return reconcile.Result{}, err
+		}
 		reqLogger.Info("KUBECONFIG Updated")
 
 		//rolling udpates
@@ -260,14 +507,23 @@ func (r *ReconcileCluster) Reconcile(request reconcile.Request) (reconcile.Resul
 		// Some changes will require rebuilding the nodes (for example, resizing nodes or changing the AMI)
 		// We call rolling-update to apply these changes
 		if instance.Status.Validated {
-			err = k.RollingUpdateCluster(kc)
+			if err := r.rollNodePools(pools, p, kc); err != nil {
+				reqLogger.Error(err, "error performing rolling update on node pools")
+				return reconcile.Result{}, err
+			}
+			err = rollingUpdate(instance, p, kc, key)
 			if err != nil {
 				reqLogger.Error(err, "error performing rolling update on cluster")
 				return reconcile.Result{}, err
 			}
 			reqLogger.Info("Rolling Update Complete")
+			r.recordSSHKey(instance, key)
 		} else {
 			reqLogger.Info("Cluster not validated yet... Skipping rolling update for now")
+			// A rotation is only recorded once its instances were replaced
+			if !key.forceRoll(instance) {
+				r.recordSSHKey(instance, key)
+			}
 		}
 
 		instance.Status.Phase = clusteroperatorv1alpha1.ClusterSetup
@@ -280,7 +536,22 @@ func (r *ReconcileCluster) Reconcile(request reconcile.Request) (reconcile.Resul
 		// Setenv required if not using default .kube/config,
 		// the --kubeconfig option does not currently work for kops validate (1.18.2-alpha2)
 		os.Setenv("KUBECONFIG", "tmp/config-"+kc.Name)
-		status, err := k.ValidateCluster(kc)
+		status, err := p.ValidateCluster(kc)
+		if err == kops.ErrPending {
+			return reconcile.Result{}, err
+		}
+		// The on-demand/spot mix is reported as kops stores it
+		groups, igErr := p.GetInstanceGroups(kc)
+		if igErr == kops.ErrPending {
+			return reconcile.Result{}, igErr
+		} else if igErr != nil {
+			reqLogger.Info("Instance groups not read", "error", igErr.Error())
+		} else {
+			instance.Status.InstanceGroups = groups
+		}
+		if err := r.updateNodePoolStatus(pools, status, groups); err != nil {
+			return reconcile.Result{}, err
+		}
 
 		instance.Status.KopsStatus = clusteroperatorv1alpha1.KopsStatus{}
 		if err != nil {
@@ -295,6 +566,13 @@ func (r *ReconcileCluster) Reconcile(request reconcile.Request) (reconcile.Resul
 			reqLogger.Info("Cluster Created")
 			instance.Status.Phase = clusteroperatorv1alpha1.ClusterDone
 			instance.Status.Validated = true
+			if v := manifestKubernetesVersion(spec.Config); v != "" {
+				instance.Status.KubernetesVersion = clusteroperatorv1alpha1.KubernetesVersionStatus{
+					Version: v,
+					Masters: v,
+					Nodes:   v,
+				}
+			}
 			reqLogger.Info("Phase: DONE")
 			if err := r.client.Status().Update(context.TODO(), instance); err != nil {
 				return reconcile.Result{}, err
@@ -311,22 +589,66 @@ func (r *ReconcileCluster) Reconcile(request reconcile.Request) (reconcile.Resul
 		return reconcile.Result{RequeueAfter: time.Minute * 5}, nil
 
 	} else if utils.Contains(instance.ObjectMeta.Finalizers, clusterFinalizer) {
+		if paused && !instance.IsForceDeleted() {
+			reqLogger.Info("Cluster is paused, deletion blocked until resumed or " +
+				clusteroperatorv1alpha1.ForceDeleteAnnotation + " is set")
+			return reconcile.Result{}, nil
+		}
 
-		//check if cluster still exists
-		exists, err := k.GetCluster(instance.Spec.KopsConfig)
-		if !exists {
-			reqLogger.WithValues("error", err).Info("Cluster is already deleted...")
-		} else if err != nil {
-			reqLogger.WithValues("error", err).Info("Error getting cluster")
-			return reconcile.Result{}, err
+		// The webhook rejects deletion of protected clusters, this catches
+		// deletes that bypassed it
+		if instance.Spec.DeletionProtection {
+			reqLogger.Info("Cluster has deletion protection enabled, deletion blocked")
+			r.recorder.Event(instance, corev1.EventTypeWarning, "DeletionBlocked",
+				"spec.deletionProtection is enabled, disable it to delete the cluster")
+			return reconcile.Result{}, nil
+		}
+
+		if instance.Spec.DeletionPolicy == clusteroperatorv1alpha1.DeletionPolicyRetain {
+			reqLogger.Info("Deletion policy is Retain, leaving cloud resources and kops state in place")
+			r.recorder.Event(instance, corev1.EventTypeNormal, "Retained",
+				"Cluster "+instance.Spec.KopsConfig.Name+" was retained in "+instance.Spec.KopsConfig.StateStore)
 		} else {
-			err = k.DeleteCluster(instance.Spec.KopsConfig)
+			// Retained clusters are left alone, so only these need the state
+			// store and the credentials
+			// Deleted Clusters are never completed from the defaults, their
+			// merged config was persisted before kops ran
+			kc := CheckKopsDefaultConfig(instance.Spec, nil)
+			p, release, ok, err := r.provisioner(instance, &kc)
+			if !ok || err != nil {
+				return reconcile.Result{RequeueAfter: provisionerRetryInterval}, err
+			}
+			defer release()
+			//check if cluster still exists
+			exists, err := p.GetCluster(instance.Spec.KopsConfig)
 			if err != nil {
-				//error deleting cluster
+				// The cluster may still exist, keep the finalizer
+				reqLogger.WithValues("error", err).Info("Error getting cluster")
 				return reconcile.Result{}, err
+			} else if !exists {
+				reqLogger.Info("Cluster is already deleted...")
+			} else {
+				preview, err := p.DeleteClusterPreview(instance.Spec.KopsConfig)
+				if err != nil {
+					reqLogger.Error(err, "error previewing cluster deletion")
+					return reconcile.Result{}, err
+				}
+				if err := r.recordDeletionPreview(instance, preview); err != nil {
+					return reconcile.Result{}, err
+				}
+
+				err = p.DeleteCluster(instance.Spec.KopsConfig)
+				if err != nil {
+					//error deleting cluster
+					return reconcile.Result{}, err
+				}
 			}
 		}
 
+		if err := r.releaseNodePools(instance.Namespace, instance.Name); err != nil {
+			return reconcile.Result{}, err
+		}
+
 		// our finalizer is present, so delete cluster first
 		// remove our finalizer from the list and update it.
 		instance.ObjectMeta.Finalizers = utils.Remove(instance.ObjectMeta.Finalizers, clusterFinalizer)
@@ -342,40 +664,159 @@ func (r *ReconcileCluster) Reconcile(request reconcile.Request) (reconcile.Resul
 	return reconcile.Result{}, nil
 }
 
+// maxEventMessage keeps event messages well below the size the API server accepts
+const maxEventMessage = 1000
+
+// recordDeletionPreview keeps the kops delete preview in the status and an event
+// so there is a record of what was removed
+func (r *ReconcileCluster) recordDeletionPreview(instance *clusteroperatorv1alpha1.Cluster, preview string) error {
+	message := preview
+	if len(message) > maxEventMessage {
+		message = message[:maxEventMessage] + "..."
+	}
+	r.recorder.Event(instance, corev1.EventTypeNormal, "DeletionPreview", message)
+
+	instance.Status.DeletionPreview = preview
+	return r.client.Status().Update(context.TODO(), instance)
+}
+
+// setKopsCompatible reports if the kops release of the Cluster can manage it,
+// an empty reason and nil error mark it as compatible
+func (r *ReconcileCluster) setKopsCompatible(instance *clusteroperatorv1alpha1.Cluster, reason string, err error) error {
+	condition := clusteroperatorv1alpha1.ClusterCondition{
+		Type:    clusteroperatorv1alpha1.ClusterConditionKopsCompatible,
+		Status:  corev1.ConditionTrue,
+		Reason:  "Compatible",
+		Message: "kops " + instance.Spec.KopsVersion + " can manage the cluster",
+	}
+	if instance.Spec.KopsVersion == "" {
+		condition.Message = "default kops can manage the cluster"
+	}
+	if err != nil {
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = reason
+		condition.Message = err.Error()
+		r.recorder.Event(instance, corev1.EventTypeWarning, reason, err.Error())
+	} else if instance.Status.GetCondition(clusteroperatorv1alpha1.ClusterConditionKopsCompatible) == nil {
+		// Only report once there is something to report
+		return nil
+	}
+
+	if !instance.Status.SetCondition(condition) {
+		return nil
+	}
+	return r.client.Status().Update(context.TODO(), instance)
+}
+
+// controlAnnotations are the annotations that change the reconcile behaviour
+var controlAnnotations = []string{
+	clusteroperatorv1alpha1.PausedAnnotation,
+	clusteroperatorv1alpha1.ForceDeleteAnnotation,
+}
+
+func controlAnnotationsChanged(old, new map[string]string) bool {
+	for _, a := range controlAnnotations {
+		if old[a] != new[a] {
+			return true
+		}
+	}
+	return false
+}
+
+// updateSpec updates the Cluster and keeps the status set earlier in the
+// pass, the object returned by the Update only has the stored status
+func (r *ReconcileCluster) updateSpec(instance *clusteroperatorv1alpha1.Cluster) error {
+	status := instance.Status.DeepCopy()
+	if err := r.client.Update(context.TODO(), instance); err != nil {
+		return err
+	}
+	instance.Status = *status
+	return nil
+}
+
+// updatePausedCondition keeps the Paused condition in sync with the spec and annotations
+func (r *ReconcileCluster) updatePausedCondition(instance *clusteroperatorv1alpha1.Cluster, paused bool) error {
+	condition := clusteroperatorv1alpha1.ClusterCondition{
+		Type:    clusteroperatorv1alpha1.ClusterConditionPaused,
+		Status:  corev1.ConditionTrue,
+		Reason:  "Paused",
+		Message: "Reconciliation is paused by spec.paused or the " + clusteroperatorv1alpha1.PausedAnnotation + " annotation",
+	}
+	if !paused {
+		// Nothing to report for clusters that were never paused
+		if instance.Status.GetCondition(clusteroperatorv1alpha1.ClusterConditionPaused) == nil {
+			return nil
+		}
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = "Resumed"
+		condition.Message = "Reconciliation is active"
+	}
+
+	if !instance.Status.SetCondition(condition) {
+		return nil
+	}
+	return r.client.Status().Update(context.TODO(), instance)
+}
+
 // Get Kops Default Config Resource
-func CheckKopsDefaultConfig(c clusteroperatorv1alpha1.ClusterSpec) clusteroperatorv1alpha1.KopsConfig {
-	// If KopsConfig is not defined in CR, use default
-	// TODO: Right now this only checks if the values are there. Eventually
-	// we want to use a few inputs to pull information from the CMDB or
-	// another controller that would hold the config information based on
-	// the supplied infra info
-
-	// Due to changes to use Kops manifests, the only required fields are Name and StateStore
+// Settings of the Cluster win over the defaults, which win over the operator
+// kops.cluster.dns.zone and kops.state.store. Gossip clusters are named
+// <name>.k8s.local. The defaults are nil once the
+// Cluster was created, its merged config is persisted in the spec then.
+func CheckKopsDefaultConfig(c clusteroperatorv1alpha1.ClusterSpec, clusterDefaults *clusteroperatorv1alpha1.ClusterDefaultsSpec) clusteroperatorv1alpha1.KopsConfig {
+	if clusterDefaults == nil {
+		clusterDefaults = &clusteroperatorv1alpha1.ClusterDefaultsSpec{}
+	}
+
+	dnsZone := viper.GetString("kops.cluster.dns.zone")
+	if len(clusterDefaults.DNSZone) > 0 {
+		dnsZone = clusterDefaults.DNSZone
+	}
+	name := c.Name + "." + dnsZone
+	// Gossip clusters do not need a DNS zone
+	if c.DNS == clusteroperatorv1alpha1.DNSGossip {
+		name = c.Name + kops.GossipSuffix
+	}
 	defaultConfig := clusteroperatorv1alpha1.KopsConfig{
-		Name:        c.Name + "." + viper.GetString("kops.cluster.dns.zone"),
+		Name:        name,
 		StateStore:  viper.GetString("kops.state.store"),
-		// MasterCount: 1,
-		// MasterEc2:   "t2.micro",
-		// WorkerCount: 2,
-		// WorkerEc2:   "t2.micro",
-		// Vpc:         "vpc-0a75b33895655b46a",
-		// Zones:       []string{"us-east-2a", "us-east-2b"},
+		MasterCount: clusterDefaults.MasterCount,
+		WorkerCount: clusterDefaults.WorkerCount,
+		Vpc:         clusterDefaults.VPC,
+		Zones:       clusterDefaults.Zones,
+		Image:       clusterDefaults.Image,
+
+		MasterMachineType: clusterDefaults.MasterInstanceType,
+		WorkerMachineType: clusterDefaults.WorkerInstanceType,
+		CloudProvider:     clusterDefaults.CloudProvider,
+	}
+
+	if len(clusterDefaults.StateStore) > 0 {
+		defaultConfig.StateStore = clusterDefaults.StateStore
+	}
+
+	if len(c.KopsConfig.Name) > 0 {
+		defaultConfig.Name = c.KopsConfig.Name
+	}
+
+	if len(c.KopsConfig.StateStore) > 0 {
+		defaultConfig.StateStore = c.KopsConfig.StateStore
 	}
 
 	if c.KopsConfig.MasterCount > 0 {
 		defaultConfig.MasterCount = c.KopsConfig.MasterCount
 	}
 
-	if len(c.KopsConfig.MasterEc2) != 0 {
-		defaultConfig.MasterEc2 = c.KopsConfig.MasterEc2
+	if len(c.KopsConfig.MasterType()) != 0 {
+		defaultConfig.MasterMachineType = c.KopsConfig.MasterType()
 	}
 
 	if (c.KopsConfig.WorkerCount) > 0 {
 		defaultConfig.WorkerCount = c.KopsConfig.WorkerCount
 	}
 
-	if len(c.KopsConfig.WorkerEc2) > 0 {
-		defaultConfig.WorkerEc2 = c.KopsConfig.WorkerEc2
+	if len(c.KopsConfig.WorkerType()) > 0 {
+		defaultConfig.WorkerMachineType = c.KopsConfig.WorkerType()
 	}
 
 	if len(c.KopsConfig.Vpc) > 0 {
@@ -383,7 +824,43 @@ func CheckKopsDefaultConfig(c clusteroperatorv1alpha1.ClusterSpec) clusteroperat
 	}
 
 	if len(c.KopsConfig.Zones) > 0 {
-		c.KopsConfig.Zones = c.KopsConfig.Zones
+		defaultConfig.Zones = c.KopsConfig.Zones
+	}
+
+	if len(c.KopsConfig.Image) > 0 {
+		defaultConfig.Image = c.KopsConfig.Image
+	}
+
+	if len(c.KopsConfig.CloudProvider) > 0 {
+		defaultConfig.CloudProvider = c.KopsConfig.CloudProvider
+	}
+
+	// Clusters on a named cloud get its machine types and zones
+	if cloud, err := kops.CloudFor(defaultConfig.CloudProvider); err == nil && len(defaultConfig.CloudProvider) > 0 {
+		if len(defaultConfig.MasterMachineType) == 0 {
+			defaultConfig.MasterMachineType = cloud.MasterMachineType
+		}
+		if len(defaultConfig.WorkerMachineType) == 0 {
+			defaultConfig.WorkerMachineType = cloud.NodeMachineType
+		}
+		if len(defaultConfig.Zones) == 0 {
+			defaultConfig.Zones = cloud.Zones
+		}
+	}
+
+	if len(clusterDefaults.Labels) > 0 || len(c.KopsConfig.Labels) > 0 {
+		defaultConfig.Labels = map[string]string{}
+		for k, v := range clusterDefaults.Labels {
+			defaultConfig.Labels[k] = v
+		}
+		for k, v := range c.KopsConfig.Labels {
+			defaultConfig.Labels[k] = v
+		}
+	}
+
+	defaultConfig.WorkerSpot = clusterDefaults.WorkerSpot
+	if c.KopsConfig.WorkerSpot != nil {
+		defaultConfig.WorkerSpot = c.KopsConfig.WorkerSpot
 	}
 
 	return defaultConfig
@@ -1,8 +1,23 @@
 package cluster
 
 import (
-	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"context"
+	"errors"
 	"testing"
+
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/infobloxopen/cluster-operator/pkg/provisioner"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 type testZone struct {
@@ -10,7 +25,7 @@ type testZone struct {
 	found bool
 }
 
-func CheckKopsDefaultConfigTest(t *testing.T) {
+func TestCheckKopsDefaultConfig(t *testing.T) {
 	instance := &clusteroperatorv1alpha1.Cluster{}
 	instance.Name = "TEST"
 	
@@ -18,9 +33,9 @@ func CheckKopsDefaultConfigTest(t *testing.T) {
 		// FIXME - Pickup DNS zone from Operator Config
 		Name:        instance.Name + ".soheil.belamaric.com",
 		MasterCount: 1,
-		MasterEc2:   "t2.micro",
+		MasterMachineType:   "t2.micro",
 		WorkerCount: 2,
-		WorkerEc2:   "t2.micro",
+		WorkerMachineType:   "t2.micro",
 		// FIXME - Pickup state store from Operator Config
 		StateStore:  "s3://kops.state.seizadi.infoblox.com",
 		Vpc:         "vpc-0a75b33895655b46a",
@@ -31,8 +46,29 @@ func CheckKopsDefaultConfigTest(t *testing.T) {
 	for _, z := range defaultConfig.Zones {
 		testZones = append(testZones, testZone{ z, false})
 	}
+
+	viper.Set("kops.cluster.dns.zone", "soheil.belamaric.com")
+	viper.Set("kops.state.store", defaultConfig.StateStore)
+	defer viper.Set("kops.cluster.dns.zone", "")
+	defer viper.Set("kops.state.store", "")
+	instance.Spec.Name = instance.Name
+	clusterDefaults := &clusteroperatorv1alpha1.ClusterDefaultsSpec{
+		MasterCount:        defaultConfig.MasterCount,
+		MasterInstanceType: defaultConfig.MasterMachineType,
+		WorkerCount:        defaultConfig.WorkerCount,
+		WorkerInstanceType: defaultConfig.WorkerMachineType,
+		VPC:                defaultConfig.Vpc,
+		Zones:              defaultConfig.Zones,
+	}
 	
-	config := CheckKopsDefaultConfig(instance.Spec)
+	config := CheckKopsDefaultConfig(instance.Spec, clusterDefaults)
+	for i := range testZones {
+		for _, z := range config.Zones {
+			if z == testZones[i].value {
+				testZones[i].found = true
+			}
+		}
+	}
 	
 	if (config.Name != defaultConfig.Name) {
 		t.Error("Expected ", defaultConfig.Name, "got ", config.Name)
@@ -42,16 +78,16 @@ func CheckKopsDefaultConfigTest(t *testing.T) {
 		t.Error("Expected ", defaultConfig.MasterCount, "got ", config.MasterCount)
 	}
 	
-	if (config.MasterEc2 != defaultConfig.MasterEc2) {
-		t.Error("Expected ", defaultConfig.MasterEc2, "got ", config.MasterEc2)
+	if (config.MasterMachineType != defaultConfig.MasterMachineType) {
+		t.Error("Expected ", defaultConfig.MasterMachineType, "got ", config.MasterMachineType)
 	}
 	
 	if (config.WorkerCount != defaultConfig.WorkerCount) {
 		t.Error("Expected ", defaultConfig.WorkerCount, "got ", config.WorkerCount)
 	}
 	
-	if (config.WorkerEc2 != defaultConfig.WorkerEc2) {
-		t.Error("Expected ", defaultConfig.WorkerEc2, "got ", config.WorkerEc2)
+	if (config.WorkerMachineType != defaultConfig.WorkerMachineType) {
+		t.Error("Expected ", defaultConfig.WorkerMachineType, "got ", config.WorkerMachineType)
 	}
 	
 	if (config.StateStore != defaultConfig.StateStore) {
@@ -72,3 +108,264 @@ func CheckKopsDefaultConfigTest(t *testing.T) {
 		t.Error("Expected Zone size", len(defaultConfig.Zones), "got ", len(config.Zones))
 	}
 }
+
+// The zones of the spec used to be assigned to themselves, Clusters always
+// got the zones of the defaults
+func TestCheckKopsDefaultConfigZones(t *testing.T) {
+	viper.Set("kops.cluster.dns.zone", "example.com")
+	defer viper.Set("kops.cluster.dns.zone", "")
+	spec := clusteroperatorv1alpha1.ClusterSpec{
+		Name:       "test",
+		KopsConfig: clusteroperatorv1alpha1.KopsConfig{Zones: []string{"us-west-2c"}},
+	}
+	clusterDefaults := &clusteroperatorv1alpha1.ClusterDefaultsSpec{Zones: []string{"us-east-2a", "us-east-2b"}}
+
+	config := CheckKopsDefaultConfig(spec, clusterDefaults)
+	if len(config.Zones) != 1 || config.Zones[0] != "us-west-2c" {
+		t.Error("Expected the zones of the spec", "got", config.Zones)
+	}
+}
+
+func newTestReconciler(objs ...runtime.Object) *ReconcileCluster {
+	s := scheme.Scheme
+	clusteroperatorv1alpha1.SchemeBuilder.AddToScheme(s)
+	return &ReconcileCluster{
+		client:    fake.NewFakeClientWithScheme(s, objs...),
+		scheme:    s,
+		recorder:  record.NewFakeRecorder(100),
+		simulator: provisioner.NewSimulator(),
+	}
+}
+
+// statusSubresourceClient updates Clusters like the API server with the status
+// subresource, the object gets the stored status back
+type statusSubresourceClient struct {
+	client.Client
+}
+
+func (c statusSubresourceClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	cluster, ok := obj.(*clusteroperatorv1alpha1.Cluster)
+	if !ok {
+		return c.Client.Update(ctx, obj, opts...)
+	}
+	stored := &clusteroperatorv1alpha1.Cluster{}
+	if err := c.Client.Get(ctx, types.NamespacedName{Name: cluster.Name, Namespace: cluster.Namespace}, stored); err != nil {
+		return err
+	}
+	cluster.Status = stored.Status
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func TestUpdateSpecKeepsStatus(t *testing.T) {
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-cluster", Namespace: "test"},
+		Spec:       clusteroperatorv1alpha1.ClusterSpec{Name: "test"},
+	}
+	r := newTestReconciler(instance)
+	r.client = statusSubresourceClient{r.client}
+
+	instance.Status.Template.Config = "kind: Cluster"
+	instance.Status.SSHKey.Fingerprint = testSSHKeyFingerprint
+	instance.Spec.Provider = provisioner.SimulatedName
+	if err := r.updateSpec(instance); err != nil {
+		t.Fatal(err)
+	}
+	if instance.Status.Template.Config != "kind: Cluster" || instance.Status.SSHKey.Fingerprint != testSSHKeyFingerprint {
+		t.Error("Expected the status of the pass to be kept got", instance.Status.Template, instance.Status.SSHKey)
+	}
+	cluster := &clusteroperatorv1alpha1.Cluster{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, cluster); err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Spec.Provider != provisioner.SimulatedName {
+		t.Error("Expected the spec to be updated got", cluster.Spec.Provider)
+	}
+}
+
+func TestReconcilePaused(t *testing.T) {
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "example-cluster",
+			Namespace:   "test",
+			Annotations: map[string]string{clusteroperatorv1alpha1.PausedAnnotation: "true"},
+		},
+		Spec: clusteroperatorv1alpha1.ClusterSpec{
+			Name:       "test",
+			KopsConfig: clusteroperatorv1alpha1.KopsConfig{Name: "test.example.com"},
+		},
+	}
+	r := newTestReconciler(instance)
+	key := types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}
+
+	res, err := r.Reconcile(reconcile.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	if res.Requeue || res.RequeueAfter != 0 {
+		t.Error("Expected paused cluster not to be requeued got", res)
+	}
+
+	cluster := &clusteroperatorv1alpha1.Cluster{}
+	if err := r.client.Get(context.TODO(), key, cluster); err != nil {
+		t.Fatal(err)
+	}
+	if !cluster.Status.IsConditionTrue(clusteroperatorv1alpha1.ClusterConditionPaused) {
+		t.Error("Expected Paused condition to be True got", cluster.Status.Conditions)
+	}
+	if cluster.Status.Phase != "" {
+		t.Error("Expected no phase transition while paused got", cluster.Status.Phase)
+	}
+	if len(cluster.Finalizers) != 1 {
+		t.Error("Expected finalizer to be added while paused got", cluster.Finalizers)
+	}
+	if v := testutil.ToFloat64(pausedClustersGauge); v != 1 {
+		t.Error("Expected 1 paused cluster got", v)
+	}
+
+	// Deletion is blocked while paused
+	now := metav1.Now()
+	cluster.DeletionTimestamp = &now
+	if err := r.client.Update(context.TODO(), cluster); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	if err := r.client.Get(context.TODO(), key, cluster); err != nil {
+		t.Fatal(err)
+	}
+	if len(cluster.Finalizers) != 1 {
+		t.Error("Expected finalizer to block deletion while paused got", cluster.Finalizers)
+	}
+
+	// Nothing is loaded while paused, the defaults, credentials and state
+	// store may all be unavailable
+	for name, spec := range map[string]clusteroperatorv1alpha1.ClusterSpec{
+		"missing credentials": {Name: "test", CredentialsRef: &corev1.LocalObjectReference{Name: "missing"}},
+		"deleted state store": {Name: "test", StateStoreRef: "deleted"},
+		"unknown kops version": {Name: "test", KopsVersion: "1.99"},
+	} {
+		instance := &clusteroperatorv1alpha1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "example-cluster",
+				Namespace:   "test",
+				Annotations: map[string]string{clusteroperatorv1alpha1.PausedAnnotation: "true"},
+			},
+			Spec: spec,
+		}
+		instance.Status.Phase = clusteroperatorv1alpha1.ClusterDone
+		r := newTestReconciler(instance)
+		r.defaultsSource = fakeDefaultsSource{err: errors.New("connection refused")}
+
+		if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+			t.Error(name, "expected no error got", err)
+		}
+		if err := r.client.Get(context.TODO(), key, cluster); err != nil {
+			t.Fatal(err)
+		}
+		if cluster.Spec.Provider != "" || len(cluster.Status.Conditions) != 1 {
+			t.Error(name, "expected only the Paused condition and no spec change got", cluster.Spec.Provider, cluster.Status.Conditions)
+		}
+	}
+}
+
+func TestControlAnnotationsChanged(t *testing.T) {
+	old := map[string]string{"foo": "bar"}
+	if controlAnnotationsChanged(old, map[string]string{"foo": "baz"}) {
+		t.Error("Expected unrelated annotation change to be ignored")
+	}
+	if !controlAnnotationsChanged(old, map[string]string{clusteroperatorv1alpha1.PausedAnnotation: "true"}) {
+		t.Error("Expected paused annotation change to be detected")
+	}
+}
+
+func TestReconcileDeletion(t *testing.T) {
+	now := metav1.Now()
+	tests := []struct {
+		name          string
+		spec          clusteroperatorv1alpha1.ClusterSpec
+		wantFinalizer bool
+	}{
+		{"protected", clusteroperatorv1alpha1.ClusterSpec{Name: "test", DeletionProtection: true}, true},
+		{"retained", clusteroperatorv1alpha1.ClusterSpec{Name: "test", DeletionPolicy: clusteroperatorv1alpha1.DeletionPolicyRetain}, false},
+		// The credentials Secret may go away with the namespace
+		{"retained without credentials", clusteroperatorv1alpha1.ClusterSpec{Name: "test", DeletionPolicy: clusteroperatorv1alpha1.DeletionPolicyRetain,
+			CredentialsRef: &corev1.LocalObjectReference{Name: "missing"}}, false},
+		{"retained without state store", clusteroperatorv1alpha1.ClusterSpec{Name: "test", DeletionPolicy: clusteroperatorv1alpha1.DeletionPolicyRetain,
+			StateStoreRef: "deleted"}, false},
+		{"unknown kops version", clusteroperatorv1alpha1.ClusterSpec{Name: "test", KopsVersion: "1.99", Provider: provisioner.SimulatedName}, false},
+	}
+
+	for _, tt := range tests {
+		instance := &clusteroperatorv1alpha1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "example-cluster",
+				Namespace:         "test",
+				DeletionTimestamp: &now,
+				Finalizers:        []string{"cluster.finalizer.cluster-operator.infobloxopen.github.com"},
+			},
+			Spec: tt.spec,
+		}
+		r := newTestReconciler(instance)
+		// Deletions never look up defaults, the CMDB may be down
+		r.defaultsSource = fakeDefaultsSource{err: errors.New("connection refused")}
+		key := types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}
+
+		if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+			t.Fatal(tt.name, "expected no error got", err)
+		}
+
+		cluster := &clusteroperatorv1alpha1.Cluster{}
+		if err := r.client.Get(context.TODO(), key, cluster); err != nil {
+			t.Fatal(err)
+		}
+		if got := len(cluster.Finalizers) > 0; got != tt.wantFinalizer {
+			t.Error(tt.name, "expected finalizer", tt.wantFinalizer, "got", cluster.Finalizers)
+		}
+	}
+}
+
+func TestReconcileDeletionGetFailure(t *testing.T) {
+	now := metav1.Now()
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "example-cluster",
+			Namespace:         "test",
+			DeletionTimestamp: &now,
+			Finalizers:        []string{"cluster.finalizer.cluster-operator.infobloxopen.github.com"},
+			Annotations:       map[string]string{clusteroperatorv1alpha1.SimulateFailureAnnotation: "get"},
+		},
+		Spec: clusteroperatorv1alpha1.ClusterSpec{Name: "test", Provider: provisioner.SimulatedName},
+	}
+	r := newTestReconciler(instance)
+	key := types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}
+
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err == nil {
+		t.Error("Expected the get failure to be returned")
+	}
+	cluster := &clusteroperatorv1alpha1.Cluster{}
+	if err := r.client.Get(context.TODO(), key, cluster); err != nil {
+		t.Fatal(err)
+	}
+	if len(cluster.Finalizers) == 0 {
+		t.Error("Expected the finalizer to be kept while the cluster may exist")
+	}
+}
+
+func TestUpgradeRequested(t *testing.T) {
+	instance := &clusteroperatorv1alpha1.Cluster{}
+	instance.Spec.KubernetesVersion = "1.17.4"
+	if upgradeRequested(instance) {
+		t.Error("Expected no upgrade before the cluster reports a version")
+	}
+
+	instance.Status.KubernetesVersion.Version = "1.16.7"
+	if !upgradeRequested(instance) {
+		t.Error("Expected upgrade from 1.16.7 to 1.17.4")
+	}
+
+	instance.Status.KubernetesVersion.Version = "1.17.4"
+	if upgradeRequested(instance) {
+		t.Error("Expected no upgrade once versions match")
+	}
+}
@@ -0,0 +1,140 @@
+package cluster
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// resolveConfig returns the kops manifest of the Cluster, Spec.Config, the
+// one rendered from spec.templateRef, see renderTemplate, or the keys of
+// spec.configFrom which is not stored in the Cluster. The hash of the
+// keys is kept in the status and the ConfigResolved condition reports missing
+// ones. It returns false when the Cluster cannot be reconciled until they are
+// created, the ConfigMap and Secret watches requeue it then.
+func (r *ReconcileCluster) resolveConfig(instance *clusteroperatorv1alpha1.Cluster) (string, bool, error) {
+	if instance.Spec.TemplateRef != nil {
+		return instance.Status.Template.Config, true, nil
+	}
+	if len(instance.Spec.ConfigFrom) == 0 {
+		return instance.Spec.Config, true, nil
+	}
+
+	var docs []string
+	for _, source := range instance.Spec.ConfigFrom {
+		doc, found, err := r.configSource(instance.Namespace, source)
+		if err != nil {
+			return "", false, err
+		}
+		if !found {
+			return "", false, r.setCondition(instance, clusteroperatorv1alpha1.ClusterConditionConfigResolved,
+				corev1.ConditionFalse, "ConfigNotFound", configSourceName(source)+" not found")
+		}
+		if doc = strings.TrimSpace(doc); doc != "" {
+			docs = append(docs, strings.TrimPrefix(doc, "---\n"))
+		}
+	}
+	config := strings.Join(docs, "\n---\n") + "\n"
+
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(config)))
+	changed := instance.Status.SetCondition(clusteroperatorv1alpha1.ClusterCondition{
+		Type:    clusteroperatorv1alpha1.ClusterConditionConfigResolved,
+		Status:  corev1.ConditionTrue,
+		Reason:  "ConfigResolved",
+		Message: fmt.Sprintf("Resolved %d config sources", len(instance.Spec.ConfigFrom)),
+	})
+	if instance.Status.ConfigHash != hash {
+		if instance.Status.ConfigHash != "" {
+			r.recorder.Event(instance, corev1.EventTypeNormal, "ConfigChanged", "The config sources changed")
+		}
+		instance.Status.ConfigHash = hash
+		changed = true
+	}
+	if !changed {
+		return config, true, nil
+	}
+	return config, true, r.client.Status().Update(context.TODO(), instance)
+}
+
+// configSource returns the content of the key, found is false when the key
+// is missing and not optional
+func (r *ReconcileCluster) configSource(namespace string, source clusteroperatorv1alpha1.ConfigSource) (string, bool, error) {
+	switch {
+	case source.ConfigMapKeyRef != nil:
+		ref := source.ConfigMapKeyRef
+		configMap := &corev1.ConfigMap{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: ref.Name}, configMap)
+		if err != nil && !errors.IsNotFound(err) {
+			return "", false, err
+		}
+		doc, ok := configMap.Data[ref.Key]
+		return doc, ok || optional(ref.Optional), nil
+	case source.SecretKeyRef != nil:
+		ref := source.SecretKeyRef
+		secret := &corev1.Secret{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: ref.Name}, secret)
+		if err != nil && !errors.IsNotFound(err) {
+			return "", false, err
+		}
+		doc, ok := secret.Data[ref.Key]
+		return string(doc), ok || optional(ref.Optional), nil
+	}
+	return "", false, nil
+}
+
+func optional(o *bool) bool {
+	return o != nil && *o
+}
+
+// configSourceName describes the source in conditions
+func configSourceName(source clusteroperatorv1alpha1.ConfigSource) string {
+	switch {
+	case source.ConfigMapKeyRef != nil:
+		return fmt.Sprintf("ConfigMap %s key %s", source.ConfigMapKeyRef.Name, source.ConfigMapKeyRef.Key)
+	case source.SecretKeyRef != nil:
+		return fmt.Sprintf("Secret %s key %s", source.SecretKeyRef.Name, source.SecretKeyRef.Key)
+	}
+	return "Config source without reference"
+}
+
+// usesConfigSource reports if the Cluster reads its config from the ConfigMap
+// or, when secret is set, the Secret
+func usesConfigSource(cluster *clusteroperatorv1alpha1.Cluster, name string, secret bool) bool {
+	for _, source := range cluster.Spec.ConfigFrom {
+		if !secret && source.ConfigMapKeyRef != nil && source.ConfigMapKeyRef.Name == name {
+			return true
+		}
+		if secret && source.SecretKeyRef != nil && source.SecretKeyRef.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// clustersUsingConfigMap returns the Clusters reading their config from the ConfigMap
+func clustersUsingConfigMap(c client.Client, namespace, name string) []reconcile.Request {
+	clusters := &clusteroperatorv1alpha1.ClusterList{}
+	if err := c.List(context.TODO(), clusters, client.InNamespace(namespace)); err != nil {
+		log.Error(err, "error listing clusters", "ConfigMap.Namespace", namespace, "ConfigMap.Name", name)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range clusters.Items {
+		cluster := &clusters.Items[i]
+		if usesConfigSource(cluster, name, false) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: cluster.Namespace, Name: cluster.Name},
+			})
+		}
+	}
+	return requests
+}
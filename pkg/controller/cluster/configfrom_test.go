@@ -0,0 +1,80 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestResolveConfig(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "kops", Namespace: "test"},
+		Data:       map[string]string{"cluster.yaml": "kind: Cluster\n"},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "kops", Namespace: "test"},
+		Data:       map[string][]byte{"ssh.yaml": []byte("---\nkind: SSHCredential\n")},
+	}
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-cluster", Namespace: "test"},
+		Spec: clusteroperatorv1alpha1.ClusterSpec{
+			ConfigFrom: []clusteroperatorv1alpha1.ConfigSource{
+				{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "kops"}, Key: "cluster.yaml"}},
+				{SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "kops"}, Key: "ssh.yaml"}},
+			},
+		},
+	}
+	r := newTestReconciler(instance)
+
+	_, ok, err := r.resolveConfig(instance)
+	if ok || err != nil {
+		t.Fatal("Expected missing ConfigMap to stop the cluster got", ok, err)
+	}
+	c := instance.Status.GetCondition(clusteroperatorv1alpha1.ClusterConditionConfigResolved)
+	if c == nil || c.Status != corev1.ConditionFalse || c.Reason != "ConfigNotFound" {
+		t.Error("Expected ConfigNotFound condition got", c)
+	}
+	if got := clustersUsingConfigMap(r.client, "test", "kops"); len(got) != 1 {
+		t.Error("Expected the cluster to be requeued by its ConfigMap got", got)
+	}
+	if got := clustersUsingSecret(r.client, "test", "kops"); len(got) != 1 {
+		t.Error("Expected the cluster to be requeued by its Secret got", got)
+	}
+
+	if err := r.client.Create(context.TODO(), configMap); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.client.Create(context.TODO(), secret); err != nil {
+		t.Fatal(err)
+	}
+	manifest, ok, err := r.resolveConfig(instance)
+	if !ok || err != nil {
+		t.Fatal("Expected resolved config got", ok, err)
+	}
+	if manifest != "kind: Cluster\n---\nkind: SSHCredential\n" {
+		t.Error("Expected concatenated documents got", manifest)
+	}
+	if instance.Spec.Config != "" {
+		t.Error("Expected the resolved config to stay out of the spec got", instance.Spec.Config)
+	}
+	hash := instance.Status.ConfigHash
+	if hash == "" {
+		t.Error("Expected config hash in status")
+	}
+
+	configMap.Data["cluster.yaml"] = "kind: Cluster\nspec: {}\n"
+	if err := r.client.Update(context.TODO(), configMap); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.resolveConfig(instance); err != nil {
+		t.Fatal(err)
+	}
+	if instance.Status.ConfigHash == hash {
+		t.Error("Expected config hash to change with the ConfigMap")
+	}
+}
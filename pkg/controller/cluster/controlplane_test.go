@@ -0,0 +1,116 @@
+//go:build integration
+// +build integration
+
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"k8s.io/client-go/rest"
+)
+
+// The suite starts kube-apiserver and etcd itself rather than through
+// controller-runtime envtest, envtest pulls ginkgo, gomega and their
+// dependencies into the vendor directory for what is two processes and a
+// health check. The binaries are the ones of the kubebuilder release, see
+// KUBEBUILDER_ASSETS.
+
+const defaultControlPlaneAssets = "/usr/local/kubebuilder/bin"
+
+// controlPlane is a local etcd and kube-apiserver, the suite talks to the
+// insecure port of the apiserver
+type controlPlane struct {
+	cmds []*exec.Cmd
+}
+
+func controlPlaneAsset(name string) string {
+	dir := os.Getenv("KUBEBUILDER_ASSETS")
+	if dir == "" {
+		dir = defaultControlPlaneAssets
+	}
+	return filepath.Join(dir, name)
+}
+
+func freePort() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port), nil
+}
+
+// startControlPlane runs etcd and kube-apiserver with their data in dir and
+// returns once both report healthy
+func startControlPlane(dir string) (*controlPlane, *rest.Config, error) {
+	var ports [4]string
+	for i := range ports {
+		port, err := freePort()
+		if err != nil {
+			return nil, nil, err
+		}
+		ports[i] = port
+	}
+	etcdURL := "http://127.0.0.1:" + ports[0]
+	apiserverURL := "http://127.0.0.1:" + ports[2]
+
+	c := &controlPlane{}
+	if err := c.start(etcdURL+"/health", "etcd",
+		"--data-dir="+filepath.Join(dir, "etcd"),
+		"--listen-client-urls="+etcdURL,
+		"--advertise-client-urls="+etcdURL,
+		"--listen-peer-urls=http://127.0.0.1:"+ports[1],
+	); err != nil {
+		c.stop()
+		return nil, nil, fmt.Errorf("etcd: %v", err)
+	}
+	// The admission plugins are the defaults, which call the webhooks,
+	// without ServiceAccount as there is no controller to create the tokens
+	if err := c.start(apiserverURL+"/healthz", "kube-apiserver",
+		"--advertise-address=127.0.0.1",
+		"--etcd-servers="+etcdURL,
+		"--cert-dir="+filepath.Join(dir, "apiserver"),
+		"--insecure-bind-address=127.0.0.1",
+		"--insecure-port="+ports[2],
+		"--secure-port="+ports[3],
+		"--disable-admission-plugins=ServiceAccount",
+		"--service-cluster-ip-range=10.0.0.0/24",
+	); err != nil {
+		c.stop()
+		return nil, nil, fmt.Errorf("kube-apiserver: %v", err)
+	}
+	return c, &rest.Config{Host: apiserverURL}, nil
+}
+
+func (c *controlPlane) start(health string, name string, args ...string) error {
+	cmd := exec.Command(controlPlaneAsset(name), args...)
+	if os.Getenv("KUBEBUILDER_ATTACH_CONTROL_PLANE_OUTPUT") == "true" {
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	c.cmds = append(c.cmds, cmd)
+	return poll(func() bool {
+		resp, err := http.Get(health)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	})
+}
+
+// stop kills the apiserver before etcd
+func (c *controlPlane) stop() {
+	for i := len(c.cmds) - 1; i >= 0; i-- {
+		c.cmds[i].Process.Kill()
+		c.cmds[i].Wait()
+	}
+}
@@ -0,0 +1,137 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// setCredentials runs the kops commands of the Cluster with the credentials
+// of the Secret referenced by spec.credentialsRef and reports the
+// CredentialsValid condition. It returns false when the Cluster cannot be
+// reconciled until the Secret is fixed, the Secret watch requeues it then.
+func (r *ReconcileCluster) setCredentials(instance *clusteroperatorv1alpha1.Cluster, k *kops.KopsCmd, kc *clusteroperatorv1alpha1.KopsConfig) (bool, error) {
+	ref := instance.Spec.CredentialsRef
+	if ref == nil {
+		// Nothing to report for clusters that always used the operator credentials
+		if instance.Status.GetCondition(clusteroperatorv1alpha1.ClusterConditionCredentialsValid) == nil {
+			return true, nil
+		}
+		return true, r.setCredentialsCondition(instance, corev1.ConditionTrue, "OperatorCredentials",
+			"Using the operator credentials")
+	}
+
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: instance.Namespace, Name: ref.Name}, secret)
+	if errors.IsNotFound(err) {
+		return false, r.setCredentialsCondition(instance, corev1.ConditionFalse, "SecretNotFound",
+			fmt.Sprintf("Secret %s not found", ref.Name))
+	}
+	if err != nil {
+		return false, err
+	}
+
+	credentials := CredentialsFromSecret(secret)
+	if err := credentials.Validate(); err != nil {
+		return false, r.setCredentialsCondition(instance, corev1.ConditionFalse, "InvalidCredentials",
+			fmt.Sprintf("Secret %s: %s", ref.Name, err))
+	}
+	if cloud := clusterCloud(instance, kc); credentials.Cloud() != cloud {
+		return false, r.setCredentialsCondition(instance, corev1.ConditionFalse, "CloudMismatch",
+			fmt.Sprintf("Secret %s holds %s credentials, the cluster runs on %s", ref.Name, credentials.Cloud(), cloud))
+	}
+	if err := k.SetCredentials(kc.Name, credentials); err != nil {
+		return false, err
+	}
+
+	message := "Using the access key from Secret " + ref.Name
+	switch {
+	case credentials.Cloud() == kops.CloudGCE:
+		message = "Using the GCE service account from Secret " + ref.Name
+	case credentials.Cloud() == kops.CloudOpenStack:
+		message = "Using the OpenStack clouds.yaml from Secret " + ref.Name
+	case credentials.RoleARN != "":
+		message = "Assuming role " + credentials.RoleARN + " with credentials from Secret " + ref.Name
+	}
+	return true, r.setCredentialsCondition(instance, corev1.ConditionTrue, "SecretLoaded", message)
+}
+
+// clusterCloud returns the cloud provider of the Cluster, the one named by
+// its manifest wins over the kops config
+func clusterCloud(instance *clusteroperatorv1alpha1.Cluster, kc *clusteroperatorv1alpha1.KopsConfig) string {
+	if instance.Spec.Config != "" {
+		if m, err := kops.ParseManifest(instance.Spec.Config); err == nil && m.CloudProvider() != "" {
+			return m.CloudProvider()
+		}
+	}
+	if kc.CloudProvider != "" {
+		return kc.CloudProvider
+	}
+	return kops.CloudAWS
+}
+
+// CredentialsFromSecret reads kops credentials from the Credentials* keys of the Secret
+func CredentialsFromSecret(secret *corev1.Secret) kops.Credentials {
+	return kops.Credentials{
+		AccessKeyID:     string(secret.Data[clusteroperatorv1alpha1.CredentialsAccessKeyID]),
+		SecretAccessKey: string(secret.Data[clusteroperatorv1alpha1.CredentialsSecretAccessKey]),
+		RoleARN:         string(secret.Data[clusteroperatorv1alpha1.CredentialsRoleARN]),
+		ExternalID:      string(secret.Data[clusteroperatorv1alpha1.CredentialsExternalID]),
+
+		GCEServiceAccount:   string(secret.Data[clusteroperatorv1alpha1.CredentialsGCEServiceAccount]),
+		OpenStackCloudsYAML: string(secret.Data[clusteroperatorv1alpha1.CredentialsOpenStackCloudsYAML]),
+		OpenStackCloud:      string(secret.Data[clusteroperatorv1alpha1.CredentialsOpenStackCloud]),
+	}
+}
+
+func (r *ReconcileCluster) setCredentialsCondition(instance *clusteroperatorv1alpha1.Cluster, status corev1.ConditionStatus, reason, message string) error {
+	return r.setCondition(instance, clusteroperatorv1alpha1.ClusterConditionCredentialsValid, status, reason, message)
+}
+
+// setCondition persists the condition when it changed, a warning event is
+// recorded when it turns False
+func (r *ReconcileCluster) setCondition(instance *clusteroperatorv1alpha1.Cluster, t clusteroperatorv1alpha1.ClusterConditionType, status corev1.ConditionStatus, reason, message string) error {
+	changed := instance.Status.SetCondition(clusteroperatorv1alpha1.ClusterCondition{
+		Type:    t,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+	if !changed {
+		return nil
+	}
+	if status == corev1.ConditionFalse {
+		r.recorder.Event(instance, corev1.EventTypeWarning, reason, message)
+	}
+	return r.client.Status().Update(context.TODO(), instance)
+}
+
+// clustersUsingSecret returns the Clusters referencing the Secret in
+// spec.credentialsRef, spec.sshKeySecretRef or spec.configFrom
+func clustersUsingSecret(c client.Client, namespace, name string) []reconcile.Request {
+	clusters := &clusteroperatorv1alpha1.ClusterList{}
+	if err := c.List(context.TODO(), clusters, client.InNamespace(namespace)); err != nil {
+		log.Error(err, "error listing clusters", "Secret.Namespace", namespace, "Secret.Name", name)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range clusters.Items {
+		cluster := &clusters.Items[i]
+		credentials, sshKey := cluster.Spec.CredentialsRef, cluster.Spec.SSHKeySecretRef
+		if (credentials != nil && credentials.Name == name) || (sshKey != nil && sshKey.Name == name) ||
+			usesConfigSource(cluster, name, true) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: cluster.Namespace, Name: cluster.Name},
+			})
+		}
+	}
+	return requests
+}
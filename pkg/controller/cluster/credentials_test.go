@@ -0,0 +1,169 @@
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestSetCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	viper.Set("kops.executor", kops.ContainerExecutorName)
+	viper.Set("kops.workspace.dir", dir)
+	viper.Set("aws.access.key.id", "AKIAOPERATOR")
+	defer func() {
+		viper.Set("kops.executor", "")
+		viper.Set("kops.workspace.dir", "")
+		viper.Set("aws.access.key.id", "")
+	}()
+
+	secret := func(data map[string]string) *corev1.Secret {
+		s := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "aws", Namespace: "test"},
+			Data:       map[string][]byte{},
+		}
+		for k, v := range data {
+			s.Data[k] = []byte(v)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name   string
+		secret *corev1.Secret
+		cloud  string
+		ok     bool
+		reason string
+		env    map[string]string
+	}{
+		{
+			name:   "missing secret",
+			ok:     false,
+			reason: "SecretNotFound",
+		},
+		{
+			name:   "incomplete key pair",
+			secret: secret(map[string]string{clusteroperatorv1alpha1.CredentialsAccessKeyID: "AKIA"}),
+			ok:     false,
+			reason: "InvalidCredentials",
+		},
+		{
+			name: "key pair",
+			secret: secret(map[string]string{
+				clusteroperatorv1alpha1.CredentialsAccessKeyID:     "AKIA",
+				clusteroperatorv1alpha1.CredentialsSecretAccessKey: "secret",
+			}),
+			ok:     true,
+			reason: "SecretLoaded",
+			env:    map[string]string{"AWS_ACCESS_KEY_ID": "AKIA", "AWS_SECRET_ACCESS_KEY": "secret"},
+		},
+		{
+			name: "assume role",
+			secret: secret(map[string]string{
+				clusteroperatorv1alpha1.CredentialsRoleARN:    "arn:aws:iam::123456789012:role/kops",
+				clusteroperatorv1alpha1.CredentialsExternalID: "cluster-operator",
+			}),
+			ok:     true,
+			reason: "SecretLoaded",
+			env:    map[string]string{"AWS_PROFILE": "cluster-operator", "AWS_SDK_LOAD_CONFIG": "1", "AWS_ACCESS_KEY_ID": "AKIAOPERATOR"},
+		},
+		{
+			name: "gce service account",
+			secret: secret(map[string]string{
+				clusteroperatorv1alpha1.CredentialsGCEServiceAccount: `{"type": "service_account"}`,
+			}),
+			cloud:  kops.CloudGCE,
+			ok:     true,
+			reason: "SecretLoaded",
+		},
+		{
+			name: "gce service account of an aws cluster",
+			secret: secret(map[string]string{
+				clusteroperatorv1alpha1.CredentialsGCEServiceAccount: `{"type": "service_account"}`,
+			}),
+			ok:     false,
+			reason: "CloudMismatch",
+		},
+		{
+			name: "openstack clouds",
+			secret: secret(map[string]string{
+				clusteroperatorv1alpha1.CredentialsOpenStackCloudsYAML: "clouds:\n  production: {}\n",
+				clusteroperatorv1alpha1.CredentialsOpenStackCloud:      "production",
+			}),
+			cloud:  kops.CloudOpenStack,
+			ok:     true,
+			reason: "SecretLoaded",
+			env:    map[string]string{"OS_CLOUD": "production", "AWS_ACCESS_KEY_ID": ""},
+		},
+		{
+			name: "mixed clouds",
+			secret: secret(map[string]string{
+				clusteroperatorv1alpha1.CredentialsGCEServiceAccount: `{"type": "service_account"}`,
+				clusteroperatorv1alpha1.CredentialsAccessKeyID:       "AKIA",
+			}),
+			ok:     false,
+			reason: "InvalidCredentials",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &clusteroperatorv1alpha1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "example-cluster", Namespace: "test"},
+				Spec: clusteroperatorv1alpha1.ClusterSpec{
+					CredentialsRef: &corev1.LocalObjectReference{Name: "aws"},
+				},
+			}
+			objs := []runtime.Object{instance}
+			if tt.secret != nil {
+				objs = append(objs, tt.secret)
+			}
+			r := newTestReconciler(objs...)
+			k, err := kops.NewKops("example.cluster.com", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			kc := &clusteroperatorv1alpha1.KopsConfig{Name: "example.cluster.com", CloudProvider: tt.cloud}
+			ok, err := r.setCredentials(instance, k, kc)
+			if err != nil {
+				t.Fatal("Expected no error got", err)
+			}
+			if ok != tt.ok {
+				t.Error("Expected", tt.ok, "got", ok)
+			}
+			c := instance.Status.GetCondition(clusteroperatorv1alpha1.ClusterConditionCredentialsValid)
+			if c == nil || c.Reason != tt.reason {
+				t.Error("Expected CredentialsValid reason", tt.reason, "got", c)
+			}
+			env := k.ExecutorConfig().Env
+			for key, value := range tt.env {
+				if env[key] != value {
+					t.Error("Expected", key, "=", value, "got", env[key])
+				}
+			}
+			if tt.name == "assume role" {
+				data, err := ioutil.ReadFile(env["AWS_CONFIG_FILE"])
+				if err != nil {
+					t.Fatal("Expected AWS config file got", err)
+				}
+				want := "[profile cluster-operator]\nrole_arn = arn:aws:iam::123456789012:role/kops\n" +
+					"external_id = cluster-operator\ncredential_source = Environment\n"
+				if string(data) != want {
+					t.Error("Expected", want, "got", string(data))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/infobloxopen/cluster-operator/pkg/defaults"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// defaultsSourceOperator is the defaults source of Clusters created without
+// any ClusterDefaults or external defaults
+const defaultsSourceOperator = "operator"
+
+// kopsConfig returns the kops config of the Cluster and, for new Clusters,
+// the sources of the defaults it was completed from, highest precedence
+// first. The external defaults source wins over the ClusterDefaults.
+func (r *ReconcileCluster) kopsConfig(instance *clusteroperatorv1alpha1.Cluster) (clusteroperatorv1alpha1.KopsConfig, string, error) {
+	if instance.Status.Phase != "" {
+		return CheckKopsDefaultConfig(instance.Spec, nil), "", nil
+	}
+
+	spec := *instance.Spec.DeepCopy()
+	var sources []string
+	overrides, err := r.lookupDefaults(instance)
+	if err != nil {
+		return clusteroperatorv1alpha1.KopsConfig{}, "", err
+	}
+	if overrides != nil {
+		mergeKopsConfig(&spec.KopsConfig, overrides)
+		sources = append(sources, r.defaultsSource.String())
+	}
+
+	cd, found, err := r.clusterDefaults(instance.Namespace)
+	if err != nil {
+		return clusteroperatorv1alpha1.KopsConfig{}, "", err
+	}
+	// The operator cloud only applies to new Clusters, existing ones
+	// without a cloud provider run on aws
+	if spec.KopsConfig.CloudProvider == "" && (cd == nil || cd.CloudProvider == "") {
+		spec.KopsConfig.CloudProvider = viper.GetString("kops.cloud.provider")
+	}
+	sources = append(sources, found...)
+	if len(sources) == 0 {
+		sources = append(sources, defaultsSourceOperator)
+	}
+	kc := CheckKopsDefaultConfig(spec, cd)
+	// Without a DNS zone the kops name would be "<name>.", the Cluster waits
+	// for one to be configured
+	if strings.HasSuffix(kc.Name, ".") {
+		r.recorder.Event(instance, corev1.EventTypeWarning, "NoDNSZone",
+			"No DNS zone, set dnsZone in a ClusterDefaults, kops.cluster.dns.zone or spec.dns gossip")
+		return clusteroperatorv1alpha1.KopsConfig{}, "", fmt.Errorf("no DNS zone for cluster %s", instance.Name)
+	}
+	return kc, strings.Join(sources, ","), nil
+}
+
+// lookupDefaults asks the external defaults source for the overrides of the
+// Cluster and reports the DefaultsResolved condition. Clusters are not
+// created while the lookup fails, the error requeues them.
+func (r *ReconcileCluster) lookupDefaults(instance *clusteroperatorv1alpha1.Cluster) (*clusteroperatorv1alpha1.KopsConfig, error) {
+	if r.defaultsSource == nil {
+		return nil, nil
+	}
+
+	overrides, err := r.defaultsSource.Lookup(defaults.QueryFor(instance))
+	if err != nil {
+		if err := r.setDefaultsCondition(instance, corev1.ConditionFalse, "LookupFailed",
+			fmt.Sprintf("Looking up defaults in %s: %s", r.defaultsSource, err)); err != nil {
+			return nil, err
+		}
+		return nil, err
+	}
+	if overrides == nil {
+		return nil, r.setDefaultsCondition(instance, corev1.ConditionTrue, "NoOverrides",
+			fmt.Sprintf("%s has no defaults for the cluster", r.defaultsSource))
+	}
+	return overrides, r.setDefaultsCondition(instance, corev1.ConditionTrue, "OverridesLoaded",
+		fmt.Sprintf("Using defaults from %s", r.defaultsSource))
+}
+
+func (r *ReconcileCluster) setDefaultsCondition(instance *clusteroperatorv1alpha1.Cluster, status corev1.ConditionStatus, reason, message string) error {
+	return r.setCondition(instance, clusteroperatorv1alpha1.ClusterConditionDefaultsResolved, status, reason, message)
+}
+
+// mergeKopsConfig fills the fields kc does not set from the overrides
+func mergeKopsConfig(kc *clusteroperatorv1alpha1.KopsConfig, overrides *clusteroperatorv1alpha1.KopsConfig) {
+	if len(kc.Name) == 0 {
+		kc.Name = overrides.Name
+	}
+	if len(kc.StateStore) == 0 {
+		kc.StateStore = overrides.StateStore
+	}
+	if kc.MasterCount == 0 {
+		kc.MasterCount = overrides.MasterCount
+	}
+	if len(kc.MasterType()) == 0 {
+		kc.MasterMachineType = overrides.MasterType()
+	}
+	if kc.WorkerCount == 0 {
+		kc.WorkerCount = overrides.WorkerCount
+	}
+	if len(kc.WorkerType()) == 0 {
+		kc.WorkerMachineType = overrides.WorkerType()
+	}
+	if len(kc.Vpc) == 0 {
+		kc.Vpc = overrides.Vpc
+	}
+	if len(kc.Zones) == 0 {
+		kc.Zones = overrides.Zones
+	}
+	if len(kc.Image) == 0 {
+		kc.Image = overrides.Image
+	}
+	if len(kc.CloudProvider) == 0 {
+		kc.CloudProvider = overrides.CloudProvider
+	}
+	if kc.WorkerSpot == nil {
+		kc.WorkerSpot = overrides.WorkerSpot
+	}
+	for k, v := range overrides.Labels {
+		if kc.Labels == nil {
+			kc.Labels = map[string]string{}
+		}
+		if _, ok := kc.Labels[k]; !ok {
+			kc.Labels[k] = v
+		}
+	}
+}
+
+// clusterDefaults returns the ClusterDefaults of the namespace merged over
+// the one in the operator defaults.namespace, and the names of those found,
+// the namespace first. It returns nil when neither exists.
+func (r *ReconcileCluster) clusterDefaults(namespace string) (*clusteroperatorv1alpha1.ClusterDefaultsSpec, []string, error) {
+	namespaces := []string{namespace}
+	if fallback := viper.GetString("defaults.namespace"); fallback != "" && fallback != namespace {
+		namespaces = append(namespaces, fallback)
+	}
+
+	var merged *clusteroperatorv1alpha1.ClusterDefaultsSpec
+	var found []string
+	for _, ns := range namespaces {
+		defaults := &clusteroperatorv1alpha1.ClusterDefaults{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: ns, Name: clusteroperatorv1alpha1.ClusterDefaultsName}, defaults)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if merged == nil {
+			merged = defaults.Spec.DeepCopy()
+		} else {
+			mergeClusterDefaults(merged, &defaults.Spec)
+		}
+		found = append(found, defaults.Namespace+"/"+defaults.Name)
+	}
+	return merged, found, nil
+}
+
+// mergeClusterDefaults fills the fields cd does not set from the fallback
+func mergeClusterDefaults(cd *clusteroperatorv1alpha1.ClusterDefaultsSpec, fallback *clusteroperatorv1alpha1.ClusterDefaultsSpec) {
+	if len(cd.DNSZone) == 0 {
+		cd.DNSZone = fallback.DNSZone
+	}
+	if len(cd.StateStore) == 0 {
+		cd.StateStore = fallback.StateStore
+	}
+	if cd.MasterCount == 0 {
+		cd.MasterCount = fallback.MasterCount
+	}
+	if len(cd.MasterInstanceType) == 0 {
+		cd.MasterInstanceType = fallback.MasterInstanceType
+	}
+	if cd.WorkerCount == 0 {
+		cd.WorkerCount = fallback.WorkerCount
+	}
+	if len(cd.WorkerInstanceType) == 0 {
+		cd.WorkerInstanceType = fallback.WorkerInstanceType
+	}
+	if len(cd.Zones) == 0 {
+		cd.Zones = fallback.Zones
+	}
+	if len(cd.VPC) == 0 {
+		cd.VPC = fallback.VPC
+	}
+	if len(cd.CloudProvider) == 0 {
+		cd.CloudProvider = fallback.CloudProvider
+	}
+	if len(cd.Image) == 0 {
+		cd.Image = fallback.Image
+	}
+	if cd.WorkerSpot == nil {
+		cd.WorkerSpot = fallback.WorkerSpot
+	}
+	for k, v := range fallback.Labels {
+		if cd.Labels == nil {
+			cd.Labels = map[string]string{}
+		}
+		if _, ok := cd.Labels[k]; !ok {
+			cd.Labels[k] = v
+		}
+	}
+}
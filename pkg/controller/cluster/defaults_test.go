@@ -0,0 +1,235 @@
+package cluster
+
+import (
+	"errors"
+	"testing"
+
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/infobloxopen/cluster-operator/pkg/defaults"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestKopsConfigDefaults(t *testing.T) {
+	viper.Set("defaults.namespace", "cluster-operator")
+	viper.Set("kops.cluster.dns.zone", "operator.example.com")
+	defer func() {
+		viper.Set("defaults.namespace", "")
+		viper.Set("kops.cluster.dns.zone", "")
+	}()
+
+	fallback := &clusteroperatorv1alpha1.ClusterDefaults{
+		ObjectMeta: metav1.ObjectMeta{Name: clusteroperatorv1alpha1.ClusterDefaultsName, Namespace: "cluster-operator"},
+		Spec: clusteroperatorv1alpha1.ClusterDefaultsSpec{
+			DNSZone:    "fallback.example.com",
+			StateStore: "s3://fallback",
+			Labels:     map[string]string{"env": "fallback", "owner": "platform"},
+		},
+	}
+	defaults := &clusteroperatorv1alpha1.ClusterDefaults{
+		ObjectMeta: metav1.ObjectMeta{Name: clusteroperatorv1alpha1.ClusterDefaultsName, Namespace: "test"},
+		Spec: clusteroperatorv1alpha1.ClusterDefaultsSpec{
+			DNSZone:            "test.example.com",
+			WorkerCount:        3,
+			WorkerInstanceType: "m5.large",
+			Zones:              []string{"us-west-2a"},
+			Labels:             map[string]string{"team": "defaults", "env": "test"},
+			WorkerSpot:         &clusteroperatorv1alpha1.SpotSpec{MaxPrice: "0.05"},
+		},
+	}
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-cluster", Namespace: "test"},
+		Spec: clusteroperatorv1alpha1.ClusterSpec{
+			Name: "example",
+			KopsConfig: clusteroperatorv1alpha1.KopsConfig{
+				WorkerCount: 5,
+				Labels:      map[string]string{"team": "cluster"},
+			},
+		},
+	}
+
+	r := newTestReconciler(fallback, defaults)
+	kc, source, err := r.kopsConfig(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source != "test/default,cluster-operator/default" {
+		t.Error("Expected namespace and cluster wide defaults got", source)
+	}
+	if kc.Name != "example.test.example.com" {
+		t.Error("Expected name in the namespace DNS zone got", kc.Name)
+	}
+	if kc.StateStore != "s3://fallback" {
+		t.Error("Expected the cluster wide state store got", kc.StateStore)
+	}
+	if kc.WorkerCount != 5 || kc.WorkerMachineType != "m5.large" || len(kc.Zones) != 1 {
+		t.Error("Expected cluster settings to win over the defaults got", kc)
+	}
+	if kc.Labels["team"] != "cluster" || kc.Labels["env"] != "test" || kc.Labels["owner"] != "platform" {
+		t.Error("Expected merged labels got", kc.Labels)
+	}
+	if kc.WorkerSpot == nil || kc.WorkerSpot.MaxPrice != "0.05" {
+		t.Error("Expected the workers of the namespace on spot instances got", kc.WorkerSpot)
+	}
+
+	r = newTestReconciler(fallback)
+	kc, source, _ = r.kopsConfig(instance)
+	if source != "cluster-operator/default" || kc.Name != "example.fallback.example.com" || kc.StateStore != "s3://fallback" {
+		t.Error("Expected cluster wide defaults got", source, kc)
+	}
+
+	r = newTestReconciler()
+	kc, source, _ = r.kopsConfig(instance)
+	if source != defaultsSourceOperator || kc.Name != "example.operator.example.com" {
+		t.Error("Expected operator defaults got", source, kc.Name)
+	}
+
+	// Without any DNS zone the Cluster waits for one
+	viper.Set("kops.cluster.dns.zone", "")
+	r = newTestReconciler()
+	if _, _, err := r.kopsConfig(instance); err == nil {
+		t.Error("Expected an error without a DNS zone")
+	}
+
+	// Defaults only complete new Clusters
+	instance.Status.Phase = clusteroperatorv1alpha1.ClusterDone
+	instance.Spec.KopsConfig.Name = "example.test.example.com"
+	r = newTestReconciler(fallback)
+	kc, source, _ = r.kopsConfig(instance)
+	if source != "" || kc.Name != "example.test.example.com" || kc.WorkerMachineType != "" {
+		t.Error("Expected persisted config got", source, kc)
+	}
+}
+
+type fakeDefaultsSource struct {
+	overrides *clusteroperatorv1alpha1.KopsConfig
+	err       error
+}
+
+func (f fakeDefaultsSource) Lookup(q defaults.Query) (*clusteroperatorv1alpha1.KopsConfig, error) {
+	return f.overrides, f.err
+}
+
+func (f fakeDefaultsSource) String() string {
+	return "http://cmdb"
+}
+
+func TestKopsConfigDefaultsSource(t *testing.T) {
+	cd := &clusteroperatorv1alpha1.ClusterDefaults{
+		ObjectMeta: metav1.ObjectMeta{Name: clusteroperatorv1alpha1.ClusterDefaultsName, Namespace: "test"},
+		Spec: clusteroperatorv1alpha1.ClusterDefaultsSpec{
+			DNSZone:     "test.example.com",
+			WorkerCount: 2,
+			MasterCount: 1,
+		},
+	}
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-cluster", Namespace: "test"},
+		Spec: clusteroperatorv1alpha1.ClusterSpec{
+			Name:       "example",
+			KopsConfig: clusteroperatorv1alpha1.KopsConfig{MasterCount: 3},
+		},
+	}
+	r := newTestReconciler(cd, instance)
+	r.defaultsSource = fakeDefaultsSource{overrides: &clusteroperatorv1alpha1.KopsConfig{WorkerCount: 6, MasterCount: 5}}
+
+	kc, source, err := r.kopsConfig(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source != "http://cmdb,test/default" {
+		t.Error("Expected external and namespace defaults got", source)
+	}
+	if kc.WorkerCount != 6 || kc.MasterCount != 3 || kc.Name != "example.test.example.com" {
+		t.Error("Expected overrides between the cluster and its defaults got", kc)
+	}
+	if c := instance.Status.GetCondition(clusteroperatorv1alpha1.ClusterConditionDefaultsResolved); c == nil || c.Reason != "OverridesLoaded" {
+		t.Error("Expected OverridesLoaded condition got", c)
+	}
+
+	r.defaultsSource = fakeDefaultsSource{err: errors.New("connection refused")}
+	if _, _, err := r.kopsConfig(instance); err == nil {
+		t.Error("Expected lookup error")
+	}
+	c := instance.Status.GetCondition(clusteroperatorv1alpha1.ClusterConditionDefaultsResolved)
+	if c == nil || c.Status != corev1.ConditionFalse || c.Reason != "LookupFailed" {
+		t.Error("Expected LookupFailed condition got", c)
+	}
+}
+
+func TestKopsConfigCloudDefaults(t *testing.T) {
+	viper.Set("kops.cloud.provider", "aws")
+	viper.Set("kops.cluster.dns.zone", "operator.example.com")
+	defer func() {
+		viper.Set("kops.cloud.provider", "")
+		viper.Set("kops.cluster.dns.zone", "")
+	}()
+
+	cd := &clusteroperatorv1alpha1.ClusterDefaults{
+		ObjectMeta: metav1.ObjectMeta{Name: clusteroperatorv1alpha1.ClusterDefaultsName, Namespace: "test"},
+		Spec: clusteroperatorv1alpha1.ClusterDefaultsSpec{
+			DNSZone:            "test.example.com",
+			CloudProvider:      "gce",
+			WorkerInstanceType: "n1-standard-4",
+		},
+	}
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-cluster", Namespace: "test"},
+		Spec:       clusteroperatorv1alpha1.ClusterSpec{Name: "example"},
+	}
+
+	kc, _, err := newTestReconciler(cd).kopsConfig(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kc.CloudProvider != "gce" || kc.MasterMachineType != "n1-standard-1" || kc.WorkerMachineType != "n1-standard-4" ||
+		len(kc.Zones) != 1 || kc.Zones[0] != "us-central1-a" {
+		t.Error("Expected the gce defaults below the ClusterDefaults got", kc)
+	}
+
+	kc, _, _ = newTestReconciler().kopsConfig(instance)
+	if kc.CloudProvider != "aws" || kc.MasterMachineType != "t3.medium" {
+		t.Error("Expected the operator cloud got", kc)
+	}
+
+	// Existing Clusters do not follow the operator cloud
+	instance.Status.Phase = clusteroperatorv1alpha1.ClusterDone
+	kc, _, _ = newTestReconciler().kopsConfig(instance)
+	if kc.CloudProvider != "" || kc.MasterMachineType != "" {
+		t.Error("Expected the persisted config got", kc)
+	}
+}
+
+func TestKopsConfigGossip(t *testing.T) {
+	viper.Set("kops.cluster.dns.zone", "")
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-cluster", Namespace: "test"},
+		Spec:       clusteroperatorv1alpha1.ClusterSpec{Name: "example", DNS: clusteroperatorv1alpha1.DNSGossip},
+	}
+
+	kc, _, err := newTestReconciler().kopsConfig(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kc.Name != "example.k8s.local" {
+		t.Error("Expected the gossip name without a DNS zone got", kc.Name)
+	}
+}
+
+func TestCheckKopsDefaultConfigMachineTypeAliases(t *testing.T) {
+	spec := clusteroperatorv1alpha1.ClusterSpec{
+		Name: "example",
+		KopsConfig: clusteroperatorv1alpha1.KopsConfig{
+			Name:              "example.k8s.local",
+			CloudProvider:     "gce",
+			MasterEc2:         "n1-standard-2",
+			WorkerEc2:         "n1-standard-4",
+			WorkerMachineType: "n1-standard-8",
+		},
+	}
+	kc := CheckKopsDefaultConfig(spec, nil)
+	if kc.MasterMachineType != "n1-standard-2" || kc.WorkerMachineType != "n1-standard-8" {
+		t.Error("Expected the machine types with master_ec2 as an alias got", kc.MasterMachineType, kc.WorkerMachineType)
+	}
+}
@@ -0,0 +1,443 @@
+//go:build integration
+// +build integration
+
+package cluster
+
+// The integration suite runs the controller and the webhook against a local
+// kube-apiserver and etcd, see controlplane_test.go, kops is simulated by
+// cmd/fakekops. It needs the kubebuilder binaries, see KUBEBUILDER_ASSETS:
+//
+//	go test -tags integration ./pkg/controller/cluster/
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/infobloxopen/cluster-operator/pkg/apis"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/infobloxopen/cluster-operator/pkg/clustervalidator"
+	"github.com/spf13/viper"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	integrationNamespace = "default"
+	integrationDNSZone   = "k8s.local"
+	integrationTimeout   = time.Minute
+)
+
+var (
+	// integrationClient reads from the apiserver, not the cache of the manager
+	integrationClient client.Client
+	// integrationStateStore is the directory fakekops keeps the kops state in
+	integrationStateStore string
+	fakekopsPath          string
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(runIntegration(m))
+}
+
+func runIntegration(m *testing.M) int {
+	root, err := filepath.Abs("../../..")
+	if err != nil {
+		return integrationSetupFailed("resolving the repository", err)
+	}
+	dir, err := ioutil.TempDir("", "cluster-operator-integration")
+	if err != nil {
+		return integrationSetupFailed("creating the work directory", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fakekopsPath = filepath.Join(dir, "fakekops")
+	build := exec.Command("go", "build", "-o", fakekopsPath, "./cmd/fakekops")
+	build.Dir = root
+	build.Stdout, build.Stderr = os.Stdout, os.Stderr
+	if err := build.Run(); err != nil {
+		return integrationSetupFailed("building fakekops", err)
+	}
+
+	// The controller keeps kops manifests and kubeconfigs relative to its
+	// working directory
+	if err := os.Chdir(dir); err != nil {
+		return integrationSetupFailed("changing to the work directory", err)
+	}
+	integrationStateStore = filepath.Join(dir, "state")
+	viper.Set("kops.path", fakekopsPath)
+	viper.Set("kops.state.store", "file://"+integrationStateStore)
+	viper.Set("kops.state.store.file", true)
+	viper.Set("kops.cluster.dns.zone", integrationDNSZone)
+	viper.Set("tmp.dir", dir)
+
+	cp, cfg, err := startControlPlane(dir)
+	if err != nil {
+		return integrationSetupFailed("starting the control plane", err)
+	}
+	defer cp.stop()
+
+	s := runtime.NewScheme()
+	for _, add := range []func(*runtime.Scheme) error{scheme.AddToScheme, apis.AddToScheme} {
+		if err := add(s); err != nil {
+			return integrationSetupFailed("building the scheme", err)
+		}
+	}
+	integrationClient, err = client.New(cfg, client.Options{Scheme: s})
+	if err != nil {
+		return integrationSetupFailed("creating the client", err)
+	}
+	if err := installCRDs(filepath.Join(root, "deploy/cluster-operator/crds")); err != nil {
+		return integrationSetupFailed("installing the CRDs", err)
+	}
+	stopWebhook, err := installWebhook(cfg)
+	if err != nil {
+		return integrationSetupFailed("installing the webhook", err)
+	}
+	defer stopWebhook()
+
+	mgr, err := manager.New(cfg, manager.Options{Scheme: s, MetricsBindAddress: "0"})
+	if err != nil {
+		return integrationSetupFailed("creating the manager", err)
+	}
+	if err := Add(ReconcilerConfig{Mgr: mgr, Reap: true}); err != nil {
+		return integrationSetupFailed("adding the controller", err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		if err := mgr.Start(stop); err != nil {
+			fmt.Fprintln(os.Stderr, "manager stopped:", err)
+		}
+	}()
+
+	return m.Run()
+}
+
+func integrationSetupFailed(step string, err error) int {
+	fmt.Fprintln(os.Stderr, "integration setup failed", step+":", err)
+	return 1
+}
+
+// installCRDs creates the CRDs of the chart and waits for them to be served
+func installCRDs(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		crd := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(data, &crd.Object); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		if err := integrationClient.Create(context.TODO(), crd); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		if err := poll(func() bool {
+			if err := integrationClient.Get(context.TODO(), types.NamespacedName{Name: crd.GetName()}, crd); err != nil {
+				return false
+			}
+			conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+			for _, c := range conditions {
+				if c, ok := c.(map[string]interface{}); ok && c["type"] == "Established" && c["status"] == "True" {
+					return true
+				}
+			}
+			return false
+		}); err != nil {
+			return fmt.Errorf("%s not established: %v", crd.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// installWebhook serves the cluster validator over TLS and registers it the
+// way the chart does, it returns once the apiserver calls it
+func installWebhook(cfg *rest.Config) (func(), error) {
+	admission, err := clustervalidator.NewClusterAdmission(cfg)
+	if err != nil {
+		return nil, err
+	}
+	server := httptest.NewUnstartedServer(clustervalidator.GetAdmissionServerNoSSL(admission, "").Handler)
+	server.StartTLS()
+
+	url := server.URL + "/validate"
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	failurePolicy := admissionregistrationv1.Fail
+	scope := admissionregistrationv1.NamespacedScope
+	webhook := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-validator"},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{{
+			Name: "cluster-validator.cluster-operator.infobloxopen.github.com",
+			Rules: []admissionregistrationv1.RuleWithOperations{{
+				Operations: []admissionregistrationv1.OperationType{
+					admissionregistrationv1.Create, admissionregistrationv1.Update, admissionregistrationv1.Delete},
+				Rule: admissionregistrationv1.Rule{
+					APIGroups:   []string{clusteroperatorv1alpha1.SchemeGroupVersion.Group},
+					APIVersions: []string{clusteroperatorv1alpha1.SchemeGroupVersion.Version},
+					Resources:   []string{"clusters"},
+					Scope:       &scope,
+				},
+			}},
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				URL:      &url,
+				CABundle: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}),
+			},
+			AdmissionReviewVersions: []string{"v1beta1"},
+			SideEffects:             &sideEffects,
+			FailurePolicy:           &failurePolicy,
+		}},
+	}
+	if err := integrationClient.Create(context.TODO(), webhook); err != nil {
+		server.Close()
+		return nil, err
+	}
+
+	// Webhook configurations take a moment to be picked up by the apiserver
+	invalid := newIntegrationCluster("webhook-probe", 1)
+	invalid.Spec.ConfigFrom = []clusteroperatorv1alpha1.ConfigSource{{}}
+	if err := poll(func() bool {
+		return integrationClient.Create(context.TODO(), invalid, client.DryRunAll) != nil
+	}); err != nil {
+		server.Close()
+		return nil, fmt.Errorf("webhook not called: %v", err)
+	}
+	return server.Close, nil
+}
+
+func poll(cond func() bool) error {
+	deadline := time.Now().Add(integrationTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s", integrationTimeout)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return nil
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	if err := poll(cond); err != nil {
+		t.Fatal("Waiting for", what, err)
+	}
+}
+
+// integrationManifest returns a kops manifest with a master and nodes
+func integrationManifest(name string, nodes int) string {
+	return fmt.Sprintf(`apiVersion: kops.k8s.io/v1alpha2
+kind: Cluster
+metadata:
+  name: %[1]s.%[2]s
+spec:
+  kubernetesVersion: 1.16.7
+---
+apiVersion: kops.k8s.io/v1alpha2
+kind: InstanceGroup
+metadata:
+  name: master-us-east-2a
+  labels:
+    kops.k8s.io/cluster: %[1]s.%[2]s
+spec:
+  role: Master
+  minSize: 1
+  subnets:
+  - us-east-2a
+---
+apiVersion: kops.k8s.io/v1alpha2
+kind: InstanceGroup
+metadata:
+  name: nodes
+  labels:
+    kops.k8s.io/cluster: %[1]s.%[2]s
+spec:
+  role: Node
+  minSize: %[3]d
+`, name, integrationDNSZone, nodes)
+}
+
+func newIntegrationCluster(name string, nodes int) *clusteroperatorv1alpha1.Cluster {
+	return &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: integrationNamespace},
+		Spec: clusteroperatorv1alpha1.ClusterSpec{
+			Name:   name,
+			Config: integrationManifest(name, nodes),
+		},
+	}
+}
+
+func integrationKey(name string) types.NamespacedName {
+	return types.NamespacedName{Namespace: integrationNamespace, Name: name}
+}
+
+func getIntegrationCluster(t *testing.T, name string) *clusteroperatorv1alpha1.Cluster {
+	t.Helper()
+	cluster := &clusteroperatorv1alpha1.Cluster{}
+	if err := integrationClient.Get(context.TODO(), integrationKey(name), cluster); err != nil {
+		t.Fatal(err)
+	}
+	return cluster
+}
+
+// updateIntegrationCluster retries the change on conflicts with the controller
+func updateIntegrationCluster(t *testing.T, name string, change func(*clusteroperatorv1alpha1.Cluster)) {
+	t.Helper()
+	waitFor(t, "update of "+name, func() bool {
+		cluster := getIntegrationCluster(t, name)
+		change(cluster)
+		return integrationClient.Update(context.TODO(), cluster) == nil
+	})
+}
+
+func createAndWaitDone(t *testing.T, cluster *clusteroperatorv1alpha1.Cluster) *clusteroperatorv1alpha1.Cluster {
+	t.Helper()
+	if err := integrationClient.Create(context.TODO(), cluster); err != nil {
+		t.Fatal("Expected the cluster to be admitted got", err)
+	}
+	waitFor(t, cluster.Name+" to be Done", func() bool {
+		return getIntegrationCluster(t, cluster.Name).Status.Phase == clusteroperatorv1alpha1.ClusterDone
+	})
+	return getIntegrationCluster(t, cluster.Name)
+}
+
+func deleteAndWaitGone(t *testing.T, name string) {
+	t.Helper()
+	if err := integrationClient.Delete(context.TODO(), getIntegrationCluster(t, name)); err != nil {
+		t.Fatal("Expected the cluster to be deleted got", err)
+	}
+	waitFor(t, name+" to be removed", func() bool {
+		err := integrationClient.Get(context.TODO(), integrationKey(name), &clusteroperatorv1alpha1.Cluster{})
+		return errors.IsNotFound(err)
+	})
+}
+
+func inStateStore(name string) bool {
+	_, err := os.Stat(filepath.Join(integrationStateStore, name+"."+integrationDNSZone, "config.yaml"))
+	return err == nil
+}
+
+func TestIntegrationCreate(t *testing.T) {
+	cluster := createAndWaitDone(t, newIntegrationCluster("create", 2))
+
+	if !cluster.Status.Validated || len(cluster.Status.KopsStatus.Nodes) != 3 {
+		t.Error("Expected a validated cluster with 3 nodes got", cluster.Status)
+	}
+	if cluster.Status.KubernetesVersion.Version != "1.16.7" {
+		t.Error("Expected Kubernetes 1.16.7 got", cluster.Status.KubernetesVersion)
+	}
+	if len(cluster.Finalizers) != 1 {
+		t.Error("Expected the finalizer got", cluster.Finalizers)
+	}
+	if cluster.Spec.KopsConfig.Name != "create."+integrationDNSZone {
+		t.Error("Expected the kops config to be persisted got", cluster.Spec.KopsConfig)
+	}
+	if !inStateStore("create") {
+		t.Error("Expected the cluster in the state store")
+	}
+}
+
+func TestIntegrationSpecUpdate(t *testing.T) {
+	createAndWaitDone(t, newIntegrationCluster("update", 1))
+
+	updateIntegrationCluster(t, "update", func(c *clusteroperatorv1alpha1.Cluster) {
+		c.Spec.Config = integrationManifest("update", 3)
+	})
+	waitFor(t, "the new nodes", func() bool {
+		cluster := getIntegrationCluster(t, "update")
+		return cluster.Status.Phase == clusteroperatorv1alpha1.ClusterDone && len(cluster.Status.KopsStatus.Nodes) == 4
+	})
+}
+
+func TestIntegrationValidationFailure(t *testing.T) {
+	os.Setenv("FAKEKOPS_FAIL", "validate")
+	defer os.Unsetenv("FAKEKOPS_FAIL")
+
+	if err := integrationClient.Create(context.TODO(), newIntegrationCluster("invalid", 1)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "validation", func() bool {
+		return getIntegrationCluster(t, "invalid").Status.Phase == clusteroperatorv1alpha1.ClusterSetup
+	})
+	time.Sleep(2 * time.Second)
+	if cluster := getIntegrationCluster(t, "invalid"); cluster.Status.Phase != clusteroperatorv1alpha1.ClusterSetup || cluster.Status.Validated {
+		t.Error("Expected the cluster to wait in Setup got", cluster.Status.Phase, cluster.Status.Validated)
+	}
+
+	os.Unsetenv("FAKEKOPS_FAIL")
+	deleteAndWaitGone(t, "invalid")
+}
+
+func TestIntegrationDeletion(t *testing.T) {
+	createAndWaitDone(t, newIntegrationCluster("delete", 1))
+
+	deleteAndWaitGone(t, "delete")
+	if inStateStore("delete") {
+		t.Error("Expected the cluster to be removed from the state store")
+	}
+}
+
+func TestIntegrationReaper(t *testing.T) {
+	// An orphan is in the state store without a Cluster resource
+	manifest := filepath.Join(integrationStateStore, "..", "orphan.yaml")
+	if err := ioutil.WriteFile(manifest, []byte(integrationManifest("orphan", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(fakekopsPath, "replace", "cluster", "-f", manifest,
+		"--state=file://"+integrationStateStore, "--force").CombinedOutput()
+	if err != nil {
+		t.Fatal(string(out), err)
+	}
+
+	createAndWaitDone(t, newIntegrationCluster("reaper", 1))
+	if inStateStore("orphan") {
+		t.Error("Expected the orphan to be reaped")
+	}
+	if !inStateStore("reaper") || !inStateStore("create") {
+		t.Error("Expected the clusters with resources to be kept")
+	}
+}
+
+func TestIntegrationWebhook(t *testing.T) {
+	invalid := newIntegrationCluster("rejected", 1)
+	invalid.Spec.ConfigFrom = []clusteroperatorv1alpha1.ConfigSource{{
+		ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "kops"}, Key: "cluster.yaml"},
+	}}
+	if err := integrationClient.Create(context.TODO(), invalid); err == nil || !strings.Contains(err.Error(), "denied") {
+		t.Error("Expected configFrom with config to be denied got", err)
+	}
+
+	protected := newIntegrationCluster("protected", 1)
+	protected.Spec.DeletionProtection = true
+	createAndWaitDone(t, protected)
+	if err := integrationClient.Delete(context.TODO(), getIntegrationCluster(t, "protected")); err == nil {
+		t.Error("Expected deletion of a protected cluster to be denied")
+	}
+
+	updateIntegrationCluster(t, "protected", func(c *clusteroperatorv1alpha1.Cluster) {
+		c.Spec.DeletionProtection = false
+	})
+	deleteAndWaitGone(t, "protected")
+}
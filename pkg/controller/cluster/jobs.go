@@ -0,0 +1,600 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/spf13/viper"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// jobClusterLabel selects the Jobs and Pods running kops for a Cluster
+	jobClusterLabel = "cluster-operator.infobloxopen.github.com/cluster"
+	// jobContainer is the name of the init container running kops
+	jobContainer = "kops"
+	// jobKubeConfigContainer writes the kubeconfig kops exported to the
+	// output Secret of the Job once kops succeeded
+	jobKubeConfigContainer = "kubeconfig"
+	// jobKubeConfigKey is the key of the kubeconfig in the output Secret
+	jobKubeConfigKey = "kubeconfig"
+	// jobInputDir is where the workspace files are mounted from the ConfigMap
+	jobInputDir = "/kops/input"
+	// jobCredentialsDir is where the credential files of the Cluster are
+	// mounted from its credentials Secret
+	jobCredentialsDir = "/kops/credentials"
+	// jobTokenDir is where the token allowed to write the output Secret is
+	// mounted, only in the kubeconfig container
+	jobTokenDir     = "/kops/token"
+	jobLogTailLines = 20
+	// jobPollInterval requeues the Cluster in case a Job event is missed
+	jobPollInterval = time.Minute
+)
+
+// jobCredentialVars are never passed from the operator to a Job, it reads
+// them from the credentials Secret of the Cluster
+var jobCredentialVars = map[string]bool{
+	"AWS_ACCESS_KEY_ID":              true,
+	"AWS_SECRET_ACCESS_KEY":          true,
+	"AWS_SESSION_TOKEN":              true,
+	"GOOGLE_APPLICATION_CREDENTIALS": true,
+	"OS_CLIENT_CONFIG_FILE":          true,
+}
+
+// podLogReader returns the logs of the kops container of a Pod
+type podLogReader interface {
+	PodLogs(namespace, name string) ([]byte, error)
+}
+
+type clientsetLogReader struct {
+	clientset kubernetes.Interface
+}
+
+func (c clientsetLogReader) PodLogs(namespace, name string) ([]byte, error) {
+	return c.clientset.CoreV1().Pods(namespace).GetLogs(name, &corev1.PodLogOptions{Container: jobContainer}).DoRaw()
+}
+
+// jobOutput is what a finished kops Job returned
+type jobOutput struct {
+	output []byte
+	err    error
+}
+
+// jobOutputs keeps the outputs of the finished kops Jobs until cleanupJobs
+// removes them, the steps a pass runs again before the pending one resume
+// from it without reading the Job logs or updating the status
+type jobOutputs struct {
+	mu      sync.Mutex
+	outputs map[string]jobOutput
+}
+
+func (o *jobOutputs) get(name string) (jobOutput, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	out, ok := o.outputs[name]
+	return out, ok
+}
+
+func (o *jobOutputs) set(name string, out jobOutput) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.outputs == nil {
+		o.outputs = map[string]jobOutput{}
+	}
+	o.outputs[name] = out
+}
+
+func (o *jobOutputs) forget(name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.outputs, name)
+}
+
+// jobExecutor runs kops command lines as Jobs owned by the Cluster, so long
+// steps neither block a reconcile worker nor die with the operator. The Job
+// is named after the command and its inputs. No pass runs while a Job of the
+// Cluster is running, the next one resumes at the pending step and gets the
+// results of the steps before it from jobOutputs. Finished Jobs are removed
+// by cleanupJobs once the pass is over.
+type jobExecutor struct {
+	client   client.Client
+	logs     podLogReader
+	outputs  *jobOutputs
+	instance *clusteroperatorv1alpha1.Cluster
+	scheme   *runtime.Scheme
+	config   kops.ExecutorConfig
+}
+
+func (r *ReconcileCluster) newJobExecutor(instance *clusteroperatorv1alpha1.Cluster, config kops.ExecutorConfig) *jobExecutor {
+	return &jobExecutor{
+		client:   r.client,
+		logs:     r.logs,
+		outputs:  &r.jobOutputs,
+		instance: instance,
+		scheme:   r.scheme,
+		config:   config,
+	}
+}
+
+func (e *jobExecutor) RunStreamingCmd(cmd string) error {
+	_, err := e.RunCmd(cmd)
+	return err
+}
+
+// RunCmd starts the Job for the command line and returns kops.ErrPending
+// until it finished, then the output of kops
+func (e *jobExecutor) RunCmd(cmd string) (*bytes.Buffer, error) {
+	inputs, err := e.inputs()
+	if err != nil {
+		return nil, err
+	}
+	name := jobName(e.instance.Name, cmd, inputs, e.config.Env)
+	if out, ok := e.outputs.get(name); ok {
+		return bytes.NewBuffer(out.output), out.err
+	}
+
+	job := &batchv1.Job{}
+	err = e.client.Get(context.TODO(), types.NamespacedName{Namespace: e.instance.Namespace, Name: name}, job)
+	if errors.IsNotFound(err) {
+		log.Info("Starting kops job", "Job.Name", name, "command", cmd)
+		job, err = e.createJob(name, cmd, inputs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status := clusteroperatorv1alpha1.KopsJobStatus{
+		Name:      name,
+		Command:   cmd,
+		Phase:     clusteroperatorv1alpha1.KopsJobRunning,
+		StartTime: job.Status.StartTime,
+	}
+	switch {
+	case job.Status.Succeeded > 0:
+		status.Phase = clusteroperatorv1alpha1.KopsJobSucceeded
+	case job.Status.Failed > 0:
+		status.Phase = clusteroperatorv1alpha1.KopsJobFailed
+		status.ExitCode = 1
+	default:
+		if err := e.createInputs(job, inputs); err != nil {
+			return nil, err
+		}
+		if err := e.createOutput(job); err != nil {
+			return nil, err
+		}
+		if err := e.setStatus(status); err != nil {
+			return nil, err
+		}
+		return nil, kops.ErrPending
+	}
+	status.CompletionTime = job.Status.CompletionTime
+
+	output, err := e.output(job, &status)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.setStatus(status); err != nil {
+		return nil, err
+	}
+	out := jobOutput{output: output}
+	if status.Phase == clusteroperatorv1alpha1.KopsJobFailed {
+		// Like the local executor the output tells why kops failed
+		out.err = fmt.Errorf("kops job %s failed: exit status %d", name, status.ExitCode)
+	}
+	e.outputs.set(name, out)
+	return bytes.NewBuffer(output), out.err
+}
+
+// output reads the logs of the finished Job, writes the kubeconfig of its
+// output Secret to the local workspace and records the exit code and log tail
+func (e *jobExecutor) output(job *batchv1.Job, status *clusteroperatorv1alpha1.KopsJobStatus) ([]byte, error) {
+	secret := &corev1.Secret{}
+	err := e.client.Get(context.TODO(), types.NamespacedName{Namespace: job.Namespace, Name: jobOutputName(job.Name)}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	if kubeConfig := secret.Data[jobKubeConfigKey]; len(kubeConfig) > 0 {
+		path := e.config.Env["KUBECONFIG"]
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(path, kubeConfig, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	pods := &corev1.PodList{}
+	err = e.client.List(context.TODO(), pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name})
+	if err != nil {
+		return nil, err
+	}
+
+	var pod *corev1.Pod
+	for i := range pods.Items {
+		for _, c := range append(pods.Items[i].Status.InitContainerStatuses, pods.Items[i].Status.ContainerStatuses...) {
+			if c.State.Terminated == nil {
+				continue
+			}
+			switch {
+			case c.Name == jobContainer:
+				pod = &pods.Items[i]
+				status.ExitCode = c.State.Terminated.ExitCode
+			case c.Name == jobKubeConfigContainer && status.ExitCode == 0:
+				// kops succeeded but its kubeconfig was not returned
+				status.ExitCode = c.State.Terminated.ExitCode
+			}
+		}
+	}
+	if pod == nil {
+		// The Pod is gone, only the Job outcome is known
+		return nil, nil
+	}
+
+	output, err := e.logs.PodLogs(pod.Namespace, pod.Name)
+	if err != nil {
+		return nil, err
+	}
+	status.LogTail = logTail(output, jobLogTailLines)
+	return output, nil
+}
+
+// env returns the environment of the kops container. The credentials of the
+// operator are never passed, the Job reads the ones of the Cluster from its
+// credentials Secret and runs with the identity of its Pod without one.
+func (e *jobExecutor) env() ([]corev1.EnvVar, []corev1.Volume, []corev1.VolumeMount) {
+	names := make([]string, 0, len(e.config.Env))
+	for name := range e.config.Env {
+		if !jobCredentialVars[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	env := make([]corev1.EnvVar, 0, len(names)+2)
+	for _, name := range names {
+		env = append(env, corev1.EnvVar{Name: name, Value: e.config.Env[name]})
+	}
+
+	ref := e.instance.Spec.CredentialsRef
+	if ref == nil {
+		return env, nil, nil
+	}
+	// The files kops reads depend on the cloud of the credentials
+	var file, key string
+	switch {
+	case e.config.Env["GOOGLE_APPLICATION_CREDENTIALS"] != "":
+		file, key = "GOOGLE_APPLICATION_CREDENTIALS", clusteroperatorv1alpha1.CredentialsGCEServiceAccount
+	case e.config.Env["OS_CLIENT_CONFIG_FILE"] != "":
+		file, key = "OS_CLIENT_CONFIG_FILE", clusteroperatorv1alpha1.CredentialsOpenStackCloudsYAML
+	default:
+		// The role of the AWS config file is assumed with these keys, the
+		// operator keys do not reach the Job
+		optional := true
+		for _, v := range [][2]string{
+			{"AWS_ACCESS_KEY_ID", clusteroperatorv1alpha1.CredentialsAccessKeyID},
+			{"AWS_SECRET_ACCESS_KEY", clusteroperatorv1alpha1.CredentialsSecretAccessKey},
+		} {
+			env = append(env, corev1.EnvVar{
+				Name: v[0],
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: *ref, Key: v[1], Optional: &optional},
+				},
+			})
+		}
+		return env, nil, nil
+	}
+
+	env = append(env, corev1.EnvVar{Name: file, Value: filepath.Join(jobCredentialsDir, key)})
+	volume := corev1.Volume{
+		Name: "credentials",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: ref.Name,
+				Items:      []corev1.KeyToPath{{Key: key, Path: key}},
+			},
+		},
+	}
+	mount := corev1.VolumeMount{Name: "credentials", MountPath: jobCredentialsDir, ReadOnly: true}
+	return env, []corev1.Volume{volume}, []corev1.VolumeMount{mount}
+}
+
+// createJob creates the Job running the command line, the workspace is an
+// emptyDir seeded from the ConfigMap. kops runs in an init container, the
+// kubeconfig it exported is then patched into the output Secret of the Job
+// with a token only allowed to write that Secret, it never reaches the logs.
+func (e *jobExecutor) createJob(name, cmd string, inputs map[string]string) (*batchv1.Job, error) {
+	workspace := e.config.Workspace
+	script := "cp -L " + jobInputDir + "/* " + workspace + "/ 2>/dev/null\n" + cmd + "\n"
+	kubeConfigScript := "[ -f \"$EXPORTED_KUBECONFIG\" ] || exit 0\n" +
+		// The token controller fills the token Secret asynchronously
+		"for i in $(seq 60); do [ -s " + jobTokenDir + "/token ] && break; sleep 5; done\n" +
+		"exec kubectl --server=https://$KUBERNETES_SERVICE_HOST:$KUBERNETES_SERVICE_PORT" +
+		" --certificate-authority=" + jobTokenDir + "/ca.crt --token=\"$(cat " + jobTokenDir + "/token)\"" +
+		" --namespace=" + e.instance.Namespace + " patch secret " + jobOutputName(name) + " --type=merge" +
+		" -p \"{\\\"data\\\":{\\\"" + jobKubeConfigKey + "\\\":\\\"$(base64 -w0 \"$EXPORTED_KUBECONFIG\")\\\"}}\"\n"
+
+	env, volumes, mounts := e.env()
+	// Failed steps are retried by the controller
+	var backoffLimit int32
+	labels := map[string]string{jobClusterLabel: e.instance.Name}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: e.instance.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					InitContainers: []corev1.Container{{
+						Name:       jobContainer,
+						Image:      e.config.Image,
+						Command:    []string{"/bin/sh", "-c", script},
+						WorkingDir: workspace,
+						Env:        env,
+						VolumeMounts: append([]corev1.VolumeMount{
+							{Name: "input", MountPath: jobInputDir, ReadOnly: true},
+							{Name: "workspace", MountPath: workspace},
+						}, mounts...),
+					}},
+					Containers: []corev1.Container{{
+						Name:    jobKubeConfigContainer,
+						Image:   viper.GetString("kops.job.kubectl.image"),
+						Command: []string{"/bin/sh", "-c", kubeConfigScript},
+						// Not KUBECONFIG, kubectl would read it
+						Env: []corev1.EnvVar{{Name: "EXPORTED_KUBECONFIG", Value: e.config.Env["KUBECONFIG"]}},
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "workspace", MountPath: workspace, ReadOnly: true},
+							{Name: "token", MountPath: jobTokenDir, ReadOnly: true},
+						},
+					}},
+					Volumes: append([]corev1.Volume{
+						{
+							Name: "input",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{Name: name},
+								},
+							},
+						},
+						{
+							Name:         "workspace",
+							VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+						},
+						{
+							Name:         "token",
+							VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: jobTokenName(name)}},
+						},
+					}, volumes...),
+				},
+			},
+		},
+	}
+	if err := controllerutil.SetControllerReference(e.instance, job, e.scheme); err != nil {
+		return nil, err
+	}
+	if err := e.client.Create(context.TODO(), job); err != nil {
+		return nil, err
+	}
+	if err := e.createInputs(job, inputs); err != nil {
+		return nil, err
+	}
+	return job, e.createOutput(job)
+}
+
+// createInputs creates the ConfigMap of the Job if it is missing, it is
+// owned by the Job and removed with it
+func (e *jobExecutor) createInputs(job *batchv1.Job, inputs map[string]string) error {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      job.Name,
+			Namespace: job.Namespace,
+			Labels:    map[string]string{jobClusterLabel: e.instance.Name},
+		},
+		Data: inputs,
+	}
+	if err := controllerutil.SetControllerReference(job, configMap, e.scheme); err != nil {
+		return err
+	}
+	if err := e.client.Create(context.TODO(), configMap); err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// jobObject is an object owned by a Job
+type jobObject interface {
+	metav1.Object
+	runtime.Object
+}
+
+// createOutput creates the output Secret of the Job if it is missing, with
+// the ServiceAccount, token and Role its kubeconfig container writes it with.
+// The Role only allows reading and patching that Secret, they are all owned
+// by the Job and removed with it.
+func (e *jobExecutor) createOutput(job *batchv1.Job) error {
+	meta := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{
+			Name:      name,
+			Namespace: job.Namespace,
+			Labels:    map[string]string{jobClusterLabel: e.instance.Name},
+		}
+	}
+	token := &corev1.Secret{ObjectMeta: meta(jobTokenName(job.Name)), Type: corev1.SecretTypeServiceAccountToken}
+	token.Annotations = map[string]string{corev1.ServiceAccountNameKey: job.Name}
+	// The ServiceAccount goes first, the token controller removes tokens of
+	// missing ones
+	objects := []jobObject{
+		&corev1.Secret{ObjectMeta: meta(jobOutputName(job.Name)), Type: corev1.SecretTypeOpaque},
+		&corev1.ServiceAccount{ObjectMeta: meta(job.Name)},
+		token,
+		&rbacv1.Role{
+			ObjectMeta: meta(job.Name),
+			Rules: []rbacv1.PolicyRule{{
+				APIGroups:     []string{""},
+				Resources:     []string{"secrets"},
+				ResourceNames: []string{jobOutputName(job.Name)},
+				Verbs:         []string{"get", "patch"},
+			}},
+		},
+		&rbacv1.RoleBinding{
+			ObjectMeta: meta(job.Name),
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: job.Name},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: job.Name, Namespace: job.Namespace}},
+		},
+	}
+	for _, obj := range objects {
+		if err := controllerutil.SetControllerReference(job, obj, e.scheme); err != nil {
+			return err
+		}
+		if err := e.client.Create(context.TODO(), obj); err != nil && !errors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// inputs returns the workspace files the command reads, the manifests and
+// AWS config files. Scripts, credentials, OpenStack clouds.yaml files and
+// kubeconfigs are not passed.
+func (e *jobExecutor) inputs() (map[string]string, error) {
+	inputs := map[string]string{}
+	files, err := ioutil.ReadDir(e.config.Workspace)
+	if os.IsNotExist(err) {
+		return inputs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if !f.Mode().IsRegular() || strings.HasPrefix(f.Name(), "clouds-") ||
+			!(strings.HasSuffix(f.Name(), ".yaml") || strings.HasPrefix(f.Name(), "aws-config-")) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(e.config.Workspace, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		inputs[f.Name()] = string(data)
+	}
+	return inputs, nil
+}
+
+// setStatus records the Job in the Cluster status when it changed
+func (e *jobExecutor) setStatus(status clusteroperatorv1alpha1.KopsJobStatus) error {
+	if reflect.DeepEqual(e.instance.Status.KopsJob, status) {
+		return nil
+	}
+	e.instance.Status.KopsJob = status
+	return e.client.Status().Update(context.TODO(), e.instance)
+}
+
+// kopsJobRunning reports whether a kops Job of the Cluster has not finished
+func (r *ReconcileCluster) kopsJobRunning(key types.NamespacedName) (bool, error) {
+	jobs := &batchv1.JobList{}
+	err := r.client.List(context.TODO(), jobs, client.InNamespace(key.Namespace), client.MatchingLabels{jobClusterLabel: key.Name})
+	if err != nil {
+		return false, err
+	}
+	for i := range jobs.Items {
+		if !jobFinished(&jobs.Items[i]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// cleanupJobs removes the finished kops Jobs of the Cluster and their
+// outputs at the end of a reconcile pass, the next pass runs every step again
+func (r *ReconcileCluster) cleanupJobs(key types.NamespacedName) error {
+	jobs := &batchv1.JobList{}
+	err := r.client.List(context.TODO(), jobs, client.InNamespace(key.Namespace), client.MatchingLabels{jobClusterLabel: key.Name})
+	if err != nil {
+		return err
+	}
+	for i := range jobs.Items {
+		if !jobFinished(&jobs.Items[i]) {
+			continue
+		}
+		err := r.client.Delete(context.TODO(), &jobs.Items[i], client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		r.jobOutputs.forget(jobs.Items[i].Name)
+	}
+	return nil
+}
+
+func jobFinished(job *batchv1.Job) bool {
+	return job.Status.Succeeded > 0 || job.Status.Failed > 0
+}
+
+// jobName is unique for the Cluster, command line, inputs and environment,
+// rotated credentials start new Jobs. It stays within the 63 characters
+// allowed in the job-name label.
+func jobName(clusterName, cmd string, inputs, env map[string]string) string {
+	h := sha256.New()
+	h.Write([]byte(cmd))
+	for _, m := range []map[string]string{inputs, env} {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			h.Write([]byte{0})
+			h.Write([]byte(k))
+			h.Write([]byte{0})
+			h.Write([]byte(m[k]))
+		}
+	}
+
+	if len(clusterName) > 40 {
+		clusterName = clusterName[:40]
+	}
+	clusterName = strings.TrimRight(clusterName, "-.")
+	return "kops-" + clusterName + "-" + hex.EncodeToString(h.Sum(nil))[:10]
+}
+
+// jobOutputName is the name of the Secret the Job returns the kubeconfig in
+func jobOutputName(job string) string {
+	return job + "-kubeconfig"
+}
+
+// jobTokenName is the name of the token Secret of the Job ServiceAccount
+func jobTokenName(job string) string {
+	return job + "-token"
+}
+
+// logTail returns the last lines of the output
+func logTail(output []byte, lines int) string {
+	all := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	return strings.Join(all, "\n")
+}
@@ -0,0 +1,255 @@
+package cluster
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type fakeLogReader map[string]string
+
+func (f fakeLogReader) PodLogs(namespace, name string) ([]byte, error) {
+	return []byte(f[name]), nil
+}
+
+// finishJob marks the Job done and adds its Pod the way the Job controller would
+func finishJob(t *testing.T, r *ReconcileCluster, name string, exitCode int32) {
+	key := types.NamespacedName{Namespace: "test", Name: name}
+	job := &batchv1.Job{}
+	if err := r.client.Get(context.TODO(), key, job); err != nil {
+		t.Fatal(err)
+	}
+	if exitCode == 0 {
+		job.Status.Succeeded = 1
+	} else {
+		job.Status.Failed = 1
+	}
+	if err := r.client.Update(context.TODO(), job); err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-pod",
+			Namespace: "test",
+			Labels:    map[string]string{"job-name": name},
+		},
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{{
+				Name:  jobContainer,
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode}},
+			}},
+		},
+	}
+	if err := r.client.Create(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJobExecutor(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+	if err := ioutil.WriteFile(filepath.Join(workspace, "test.yaml"), []byte("kind: Cluster"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-cluster", Namespace: "test", UID: "1234"},
+		Spec: clusteroperatorv1alpha1.ClusterSpec{
+			CredentialsRef: &corev1.LocalObjectReference{Name: "credentials"},
+		},
+	}
+	r := newTestReconciler(instance)
+	logs := fakeLogReader{}
+	r.logs = logs
+	kubeConfig := filepath.Join(workspace, "config-test")
+	e := r.newJobExecutor(instance, kops.ExecutorConfig{
+		Image:     "kops:test",
+		Workspace: workspace,
+		Env:       map[string]string{"KUBECONFIG": kubeConfig, "AWS_SECRET_ACCESS_KEY": "secret"},
+	})
+
+	cmd := "kops export kubecfg --name=test"
+	if _, err := e.RunCmd(cmd); err != kops.ErrPending {
+		t.Fatal("Expected pending got", err)
+	}
+
+	name := instance.Status.KopsJob.Name
+	if instance.Status.KopsJob.Phase != clusteroperatorv1alpha1.KopsJobRunning {
+		t.Error("Expected running job in status got", instance.Status.KopsJob)
+	}
+	key := types.NamespacedName{Namespace: "test", Name: name}
+	job := &batchv1.Job{}
+	if err := r.client.Get(context.TODO(), key, job); err != nil {
+		t.Fatal("Expected job to be created got", err)
+	}
+	if owner := metav1.GetControllerOf(job); owner == nil || owner.Name != instance.Name {
+		t.Error("Expected job to be owned by the cluster got", job.OwnerReferences)
+	}
+	configMap := &corev1.ConfigMap{}
+	if err := r.client.Get(context.TODO(), key, configMap); err != nil {
+		t.Fatal("Expected workspace config map got", err)
+	}
+	if configMap.Data["test.yaml"] != "kind: Cluster" {
+		t.Error("Expected manifest in config map got", configMap.Data)
+	}
+	if err := r.client.Get(context.TODO(), key, &corev1.Secret{}); err == nil {
+		t.Error("Expected no copy of the credentials in a Secret")
+	}
+	output := &corev1.Secret{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "test", Name: jobOutputName(name)}, output); err != nil {
+		t.Fatal("Expected the output Secret got", err)
+	}
+	if owner := metav1.GetControllerOf(output); owner == nil || owner.Name != name {
+		t.Error("Expected the output Secret to be owned by the job got", output.OwnerReferences)
+	}
+	role := &rbacv1.Role{}
+	if err := r.client.Get(context.TODO(), key, role); err != nil {
+		t.Fatal("Expected the role of the job got", err)
+	}
+	if rules := role.Rules; len(rules) != 1 || len(rules[0].ResourceNames) != 1 || rules[0].ResourceNames[0] != output.Name {
+		t.Error("Expected the role to only allow the output Secret got", rules)
+	}
+	spec := job.Spec.Template.Spec
+	if len(spec.InitContainers) != 1 || len(spec.Containers) != 1 {
+		t.Fatal("Expected kops to run before the kubeconfig container got", spec.InitContainers, spec.Containers)
+	}
+	for _, m := range spec.InitContainers[0].VolumeMounts {
+		if m.MountPath == jobTokenDir {
+			t.Error("Expected the token not to be mounted in the kops container")
+		}
+	}
+	for _, v := range spec.InitContainers[0].Env {
+		if v.Value == "secret" {
+			t.Error("Expected credentials not to be passed in the job got", v)
+		}
+		if v.Name == "AWS_SECRET_ACCESS_KEY" && (v.ValueFrom == nil || v.ValueFrom.SecretKeyRef.Name != "credentials") {
+			t.Error("Expected the key from the credentials Secret of the cluster got", v)
+		}
+	}
+
+	// A replayed step finds the same Job
+	if _, err := e.RunCmd(cmd); err != kops.ErrPending {
+		t.Fatal("Expected pending got", err)
+	}
+
+	// The kubeconfig container patches the output Secret
+	output.Data = map[string][]byte{jobKubeConfigKey: []byte("apiVersion: v1")}
+	if err := r.client.Update(context.TODO(), output); err != nil {
+		t.Fatal(err)
+	}
+	finishJob(t, r, name, 0)
+	logs[name+"-pod"] = "line 1\nline 2\n"
+	out, err := e.RunCmd(cmd)
+	if err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	if out.String() != "line 1\nline 2\n" {
+		t.Error("Expected kops output got", out.String())
+	}
+	if data, _ := ioutil.ReadFile(kubeConfig); string(data) != "apiVersion: v1" {
+		t.Error("Expected exported kubeconfig got", string(data))
+	}
+	if s := instance.Status.KopsJob; s.Phase != clusteroperatorv1alpha1.KopsJobSucceeded || s.LogTail != "line 1\nline 2" {
+		t.Error("Expected succeeded job with log tail got", s)
+	}
+
+	// A resumed pass gets the output without reading the logs again
+	delete(logs, name+"-pod")
+	if out, err := e.RunCmd(cmd); err != nil || out.String() != "line 1\nline 2\n" {
+		t.Error("Expected the kept output got", out, err)
+	}
+
+	failing := "kops validate cluster --name=test"
+	if _, err := e.RunCmd(failing); err != kops.ErrPending {
+		t.Fatal("Expected pending got", err)
+	}
+	finishJob(t, r, instance.Status.KopsJob.Name, 2)
+	_, err = e.RunCmd(failing)
+	if err == nil || !strings.Contains(err.Error(), "exit status 2") {
+		t.Error("Expected exit status 2 got", err)
+	}
+	if s := instance.Status.KopsJob; s.Phase != clusteroperatorv1alpha1.KopsJobFailed || s.ExitCode != 2 {
+		t.Error("Expected failed job in status got", s)
+	}
+	if running, err := r.kopsJobRunning(types.NamespacedName{Namespace: "test", Name: instance.Name}); running || err != nil {
+		t.Error("Expected no running job got", running, err)
+	}
+
+	if err := r.cleanupJobs(types.NamespacedName{Namespace: "test", Name: instance.Name}); err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	jobs := &batchv1.JobList{}
+	if err := r.client.List(context.TODO(), jobs); err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs.Items) != 0 {
+		t.Error("Expected finished jobs to be removed got", len(jobs.Items))
+	}
+	if _, ok := r.jobOutputs.get(name); ok {
+		t.Error("Expected the outputs of removed jobs to be forgotten")
+	}
+}
+
+func TestJobExecutorEnv(t *testing.T) {
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-cluster", Namespace: "test"},
+	}
+	e := newTestReconciler(instance).newJobExecutor(instance, kops.ExecutorConfig{
+		Env: map[string]string{
+			"KOPS_STATE_STORE":               "gs://state",
+			"AWS_ACCESS_KEY_ID":              "operator",
+			"GOOGLE_APPLICATION_CREDENTIALS": "/workspace/gce-credentials-test.json",
+		},
+	})
+	env, volumes, _ := e.env()
+	if len(env) != 1 || env[0].Name != "KOPS_STATE_STORE" || len(volumes) != 0 {
+		t.Error("Expected only the state store without a credentials Secret got", env, volumes)
+	}
+
+	instance.Spec.CredentialsRef = &corev1.LocalObjectReference{Name: "gce"}
+	env, volumes, mounts := e.env()
+	var path string
+	for _, v := range env {
+		if v.Name == "GOOGLE_APPLICATION_CREDENTIALS" {
+			path = v.Value
+		}
+	}
+	if path != filepath.Join(jobCredentialsDir, clusteroperatorv1alpha1.CredentialsGCEServiceAccount) {
+		t.Error("Expected the key mounted from the Secret got", env)
+	}
+	if len(volumes) != 1 || volumes[0].Secret.SecretName != "gce" || len(mounts) != 1 {
+		t.Error("Expected the credentials Secret to be mounted got", volumes, mounts)
+	}
+}
+
+func TestJobName(t *testing.T) {
+	inputs := map[string]string{"test.yaml": "kind: Cluster"}
+	name := jobName(strings.Repeat("a", 60), "kops get cluster", inputs, nil)
+	if len(name) > 63 {
+		t.Error("Expected name to fit in a label got", name)
+	}
+	if name != jobName(strings.Repeat("a", 60), "kops get cluster", inputs, nil) {
+		t.Error("Expected stable name")
+	}
+	if name == jobName(strings.Repeat("a", 60), "kops get cluster", map[string]string{"test.yaml": "kind: InstanceGroup"}, nil) {
+		t.Error("Expected name to change with the inputs")
+	}
+	if name == jobName(strings.Repeat("a", 60), "kops get cluster", inputs, map[string]string{"AWS_ACCESS_KEY_ID": "rotated"}) {
+		t.Error("Expected name to change with the credentials")
+	}
+}
@@ -0,0 +1,64 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/yaml"
+)
+
+// kubeConfigSecretKey is the key of the kubeconfig in the kubeconfig Secret,
+// the one Cluster API uses
+const kubeConfigSecretKey = "value"
+
+// kubeConfigSecretName returns the name of the Secret the admin kubeconfig
+// of the Cluster is published in
+func kubeConfigSecretName(instance *clusteroperatorv1alpha1.Cluster) string {
+	return instance.Name + "-kubeconfig"
+}
+
+// publishKubeConfig keeps the kubeconfig in a Secret owned by the Cluster, so
+// it can be mounted without reading the status. Secrets of the same name the
+// Cluster does not own are left alone.
+func (r *ReconcileCluster) publishKubeConfig(instance *clusteroperatorv1alpha1.Cluster, config clusteroperatorv1alpha1.KubeConfig) error {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: instance.Namespace, Name: kubeConfigSecretName(instance)}
+	err = r.client.Get(context.TODO(), key, secret)
+	if errors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+			Data:       map[string][]byte{kubeConfigSecretKey: data},
+		}
+		if err := controllerutil.SetControllerReference(instance, secret, r.scheme); err != nil {
+			return err
+		}
+		return r.client.Create(context.TODO(), secret)
+	}
+	if err != nil {
+		return err
+	}
+	// The Secret of a Cluster API cluster is kept by Cluster API
+	if !metav1.IsControlledBy(secret, instance) {
+		return nil
+	}
+
+	if bytes.Equal(secret.Data[kubeConfigSecretKey], data) {
+		return nil
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[kubeConfigSecretKey] = data
+	return r.client.Update(context.TODO(), secret)
+}
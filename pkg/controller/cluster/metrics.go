@@ -0,0 +1,37 @@
+package cluster
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	pausedClustersGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cluster_operator_paused_clusters",
+		Help: "Number of Cluster resources whose reconciliation is paused",
+	})
+
+	pausedClustersMu sync.Mutex
+	pausedClusters   = map[types.NamespacedName]bool{}
+)
+
+func init() {
+	// Register custom metrics with the global prometheus registry
+	metrics.Registry.MustRegister(pausedClustersGauge)
+}
+
+// setPausedMetric records whether the Cluster is paused and refreshes the gauge
+func setPausedMetric(key types.NamespacedName, paused bool) {
+	pausedClustersMu.Lock()
+	defer pausedClustersMu.Unlock()
+
+	if paused {
+		pausedClusters[key] = true
+	} else {
+		delete(pausedClusters, key)
+	}
+	pausedClustersGauge.Set(float64(len(pausedClusters)))
+}
@@ -0,0 +1,202 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/infobloxopen/cluster-operator/pkg/provisioner"
+	"github.com/infobloxopen/cluster-operator/utils"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// nodePoolFinalizer keeps a NodePool until its InstanceGroup is deleted
+const nodePoolFinalizer = "nodepool.finalizer.cluster-operator.infobloxopen.github.com"
+
+// NodePools are applied by the reconcile of their Cluster, so every kops
+// command of a cluster runs in the same pass
+var nodePoolHandler = &handler.EnqueueRequestsFromMapFunc{
+	ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+		pool, ok := o.Object.(*clusteroperatorv1alpha1.NodePool)
+		if !ok || pool.Spec.ClusterRef == "" {
+			return nil
+		}
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: pool.Namespace, Name: pool.Spec.ClusterRef}}}
+	}),
+}
+
+// Status updates of the NodePools are made by the controller itself, kubectl
+// scale changes the generation
+var nodePoolPred = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return e.MetaNew.GetGeneration() != e.MetaOld.GetGeneration() ||
+			!e.MetaNew.GetDeletionTimestamp().IsZero()
+	},
+	DeleteFunc: func(e event.DeleteEvent) bool { return false },
+}
+
+// nodePools returns the NodePools referencing the Cluster
+func (r *ReconcileCluster) nodePools(namespace, name string) ([]clusteroperatorv1alpha1.NodePool, error) {
+	pools := &clusteroperatorv1alpha1.NodePoolList{}
+	if err := r.client.List(context.TODO(), pools, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	var items []clusteroperatorv1alpha1.NodePool
+	for _, pool := range pools.Items {
+		if pool.Spec.ClusterRef == name {
+			items = append(items, pool)
+		}
+	}
+	return items, nil
+}
+
+// applyNodePools replaces the InstanceGroups of the NodePools of the Cluster
+// and deletes the ones of deleted NodePools, the next update applies them.
+// It returns the NodePools that are applied. A NodePool that cannot be
+// applied reports why in its status and leaves the others alone.
+func (r *ReconcileCluster) applyNodePools(instance *clusteroperatorv1alpha1.Cluster, p provisioner.Provisioner, kc clusteroperatorv1alpha1.KopsConfig, config string) ([]clusteroperatorv1alpha1.NodePool, error) {
+	pools, err := r.nodePools(instance.Namespace, instance.Name)
+	if err != nil || len(pools) == 0 {
+		return nil, err
+	}
+	m, err := kops.ParseManifest(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []clusteroperatorv1alpha1.NodePool
+	for i := range pools {
+		pool := &pools[i]
+		if !pool.DeletionTimestamp.IsZero() {
+			if !utils.Contains(pool.Finalizers, nodePoolFinalizer) {
+				continue
+			}
+			if err := p.DeleteInstanceGroup(kc, pool.Name); err != nil {
+				return nil, err
+			}
+			pool.Finalizers = utils.Remove(pool.Finalizers, nodePoolFinalizer)
+			if err := r.client.Update(context.TODO(), pool); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if !utils.Contains(pool.Finalizers, nodePoolFinalizer) {
+			pool.Finalizers = append(pool.Finalizers, nodePoolFinalizer)
+			if err := r.client.Update(context.TODO(), pool); err != nil {
+				return nil, err
+			}
+		}
+		if err := replaceNodePool(p, kc, m, pool); err == kops.ErrPending {
+			return nil, err
+		} else if err != nil {
+			log.Info("NodePool not applied", "NodePool.Namespace", pool.Namespace, "NodePool.Name", pool.Name, "error", err.Error())
+			if err := r.setNodePoolMessage(pool, err.Error()); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := r.setNodePoolMessage(pool, ""); err != nil {
+			return nil, err
+		}
+		applied = append(applied, *pool)
+	}
+	return applied, nil
+}
+
+// replaceNodePool renders the InstanceGroup of the NodePool from the manifest
+// of its Cluster and replaces it
+func replaceNodePool(p provisioner.Provisioner, kc clusteroperatorv1alpha1.KopsConfig, m *kops.Manifest, pool *clusteroperatorv1alpha1.NodePool) error {
+	if m.Find("InstanceGroup", pool.Name) != nil {
+		return fmt.Errorf("InstanceGroup %q is part of the config of the Cluster", pool.Name)
+	}
+	ig, err := m.NodePoolInstanceGroup(pool.Name, pool.Spec)
+	if err != nil {
+		return err
+	}
+	manifest, err := (&kops.Manifest{Documents: []map[string]interface{}{ig}}).String()
+	if err != nil {
+		return err
+	}
+	return p.ReplaceInstanceGroup(kc, manifest)
+}
+
+func (r *ReconcileCluster) setNodePoolMessage(pool *clusteroperatorv1alpha1.NodePool, message string) error {
+	if pool.Status.Message == message {
+		return nil
+	}
+	pool.Status.Message = message
+	return r.client.Status().Update(context.TODO(), pool)
+}
+
+// rollNodePools rolls the InstanceGroup of every NodePool changed since it
+// was last rolled, the other groups of the cluster are left alone
+func (r *ReconcileCluster) rollNodePools(pools []clusteroperatorv1alpha1.NodePool, p provisioner.Provisioner, kc clusteroperatorv1alpha1.KopsConfig) error {
+	for i := range pools {
+		pool := &pools[i]
+		if pool.Status.ObservedGeneration == pool.Generation {
+			continue
+		}
+		if err := p.RollingUpdateInstanceGroup(kc, pool.Name); err != nil {
+			return err
+		}
+		pool.Status.ObservedGeneration = pool.Generation
+		if err := r.client.Status().Update(context.TODO(), pool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateNodePoolStatus records the size of every NodePool, the ready nodes
+// kops validate reported and the desired on-demand/spot mix of its InstanceGroup.
+// The mix is kept when the groups could not be read.
+func (r *ReconcileCluster) updateNodePoolStatus(pools []clusteroperatorv1alpha1.NodePool, status clusteroperatorv1alpha1.KopsStatus, groups []clusteroperatorv1alpha1.InstanceGroupStatus) error {
+	for i := range pools {
+		pool := &pools[i]
+		replicas := pool.Spec.MinSize
+		ready := int32(kops.ReadyNodes(status, pool.Name, int(replicas)))
+		onDemand, spot := pool.Status.DesiredOnDemandReplicas, pool.Status.DesiredSpotReplicas
+		for _, g := range groups {
+			if g.Name == pool.Name {
+				onDemand, spot = g.DesiredOnDemand, g.DesiredSpot
+			}
+		}
+		if pool.Status.Replicas == replicas && pool.Status.ReadyReplicas == ready &&
+			pool.Status.DesiredOnDemandReplicas == onDemand && pool.Status.DesiredSpotReplicas == spot {
+			continue
+		}
+		pool.Status.Replicas, pool.Status.ReadyReplicas = replicas, ready
+		pool.Status.DesiredOnDemandReplicas, pool.Status.DesiredSpotReplicas = onDemand, spot
+		if err := r.client.Status().Update(context.TODO(), pool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseNodePools removes the finalizers of the NodePools of a deleted
+// Cluster, their InstanceGroups went with it
+func (r *ReconcileCluster) releaseNodePools(namespace, name string) error {
+	pools, err := r.nodePools(namespace, name)
+	if err != nil {
+		return err
+	}
+	for i := range pools {
+		pool := &pools[i]
+		if !utils.Contains(pool.Finalizers, nodePoolFinalizer) {
+			continue
+		}
+		pool.Finalizers = utils.Remove(pool.Finalizers, nodePoolFinalizer)
+		if err := r.client.Update(context.TODO(), pool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
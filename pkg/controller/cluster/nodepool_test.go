@@ -0,0 +1,126 @@
+package cluster
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/infobloxopen/cluster-operator/pkg/provisioner"
+	"github.com/infobloxopen/cluster-operator/utils"
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcileNodePools(t *testing.T) {
+	viper.Set("provider", provisioner.SimulatedName)
+	viper.Set("simulated.convergence", time.Duration(0))
+	defer viper.Set("provider", "")
+	defer os.RemoveAll("tmp")
+
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-cluster", Namespace: "test"},
+		Spec: clusteroperatorv1alpha1.ClusterSpec{
+			Name:       "test",
+			Config:     testLabelsManifest,
+			KopsConfig: clusteroperatorv1alpha1.KopsConfig{Name: "test.example.com", StateStore: "s3://test"},
+		},
+	}
+	onDemandBase := int32(1)
+	gpu := &clusteroperatorv1alpha1.NodePool{
+		ObjectMeta: metav1.ObjectMeta{Name: "gpu", Namespace: "test", Generation: 1},
+		Spec: clusteroperatorv1alpha1.NodePoolSpec{
+			ClusterRef: "example-cluster",
+			MinSize:    2,
+			Taints:     []string{"dedicated=gpu:NoSchedule"},
+			Spot:       &clusteroperatorv1alpha1.SpotSpec{OnDemandBase: &onDemandBase},
+		},
+	}
+	// The Cluster config already has an InstanceGroup named nodes
+	clash := &clusteroperatorv1alpha1.NodePool{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodes", Namespace: "test", Generation: 1},
+		Spec:       clusteroperatorv1alpha1.NodePoolSpec{ClusterRef: "example-cluster", MinSize: 1},
+	}
+	r := newTestReconciler(instance, gpu, clash)
+	key := types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}
+	poolKey := types.NamespacedName{Name: gpu.Name, Namespace: gpu.Namespace}
+	var pool *clusteroperatorv1alpha1.NodePool
+	reconcileAndGet := func() {
+		if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+			t.Fatal("Expected no error got", err)
+		}
+		pool = &clusteroperatorv1alpha1.NodePool{}
+		if err := r.client.Get(context.TODO(), poolKey, pool); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reconcileAndGet()
+	if !utils.Contains(pool.Finalizers, nodePoolFinalizer) {
+		t.Error("Expected the finalizer on the node pool got", pool.Finalizers)
+	}
+	if pool.Status.Replicas != 2 || pool.Status.ReadyReplicas != 2 || pool.Status.Message != "" {
+		t.Error("Expected 2 ready replicas got", pool.Status)
+	}
+	if pool.Status.DesiredOnDemandReplicas != 1 || pool.Status.DesiredSpotReplicas != 1 {
+		t.Error("Expected 1 on-demand and 1 spot replica got", pool.Status)
+	}
+	cluster := &clusteroperatorv1alpha1.Cluster{}
+	if err := r.client.Get(context.TODO(), key, cluster); err != nil {
+		t.Fatal(err)
+	}
+	if groups := cluster.Status.InstanceGroups; len(groups) != 2 || groups[1].Name != "gpu" || groups[1].DesiredSpot != 1 {
+		t.Error("Expected the instance groups of the cluster and the node pool got", groups)
+	}
+	// The new cluster is not rolled before it validated
+	if pool.Status.ObservedGeneration != 0 {
+		t.Error("Expected the node pool not to be rolled yet got", pool.Status.ObservedGeneration)
+	}
+	clashed := &clusteroperatorv1alpha1.NodePool{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "nodes", Namespace: "test"}, clashed); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(clashed.Status.Message, "part of the config of the Cluster") {
+		t.Error("Expected the node pool named like a group of the Cluster to be rejected got", clashed.Status)
+	}
+
+	reconcileAndGet()
+	if pool.Status.ObservedGeneration != 1 {
+		t.Error("Expected the node pool to be rolled got", pool.Status.ObservedGeneration)
+	}
+
+	// kubectl scale sets minSize
+	pool.Spec.MinSize = 3
+	pool.Generation = 2
+	if err := r.client.Update(context.TODO(), pool); err != nil {
+		t.Fatal(err)
+	}
+	reconcileAndGet()
+	if pool.Status.Replicas != 3 || pool.Status.ReadyReplicas != 3 || pool.Status.ObservedGeneration != 2 {
+		t.Error("Expected the node pool to be scaled to 3 got", pool.Status)
+	}
+
+	p := r.simulator.For(instance)
+	preview, err := p.DeleteClusterPreview(instance.Spec.KopsConfig)
+	if err != nil || strings.Count(preview, "\tgpu.") != 3 {
+		t.Error("Expected 3 instances of the node pool got", preview, err)
+	}
+
+	now := metav1.Now()
+	pool.DeletionTimestamp = &now
+	if err := r.client.Update(context.TODO(), pool); err != nil {
+		t.Fatal(err)
+	}
+	reconcileAndGet()
+	if utils.Contains(pool.Finalizers, nodePoolFinalizer) {
+		t.Error("Expected the finalizer to be removed got", pool.Finalizers)
+	}
+	preview, err = p.DeleteClusterPreview(instance.Spec.KopsConfig)
+	if err != nil || strings.Contains(preview, "\tgpu.") {
+		t.Error("Expected the instances of the node pool to be terminated got", preview, err)
+	}
+}
@@ -0,0 +1,94 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/infobloxopen/cluster-operator/pkg/provisioner"
+	"github.com/spf13/viper"
+)
+
+// providerName returns the provider of the Cluster, the one of the operator
+// until the Cluster records it. Clusters created before spec.provider are
+// kops clusters whatever the provider of the operator is.
+func providerName(instance *clusteroperatorv1alpha1.Cluster) string {
+	if instance.Spec.Provider != "" {
+		return instance.Spec.Provider
+	}
+	if instance.Status.Phase != "" {
+		return provisioner.KopsName
+	}
+	if p := viper.GetString("provider"); p != "" {
+		return p
+	}
+	return provisioner.KopsName
+}
+
+// provisionerRetryInterval is how often a Cluster the provisioner cannot be
+// set up for is reconciled again. The kops registry of the operator config
+// is not watched, a fixed registry is only picked up by retrying.
+const provisionerRetryInterval = time.Minute * 5
+
+// provisioner returns what manages the Cluster with the kops runner of kc,
+// the state store and the credentials of the Cluster are loaded into them.
+// It returns false when the kops version, the state store or the
+// credentials cannot be used, see provisionerRetryInterval. release removes the credentials written for
+// the runner once the reconcile is done with it.
+func (r *ReconcileCluster) provisioner(instance *clusteroperatorv1alpha1.Cluster, kc *clusteroperatorv1alpha1.KopsConfig) (p provisioner.Provisioner, release func(), ok bool, err error) {
+	k, err := kops.NewKops(kc.Name, instance.Spec.KopsVersion)
+	if err != nil {
+		log.Error(err, "kops.NewKops Failed", "Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+		if err := r.setKopsCompatible(instance, "KopsVersionNotFound", err); err != nil || instance.DeletionTimestamp.IsZero() {
+			return nil, nil, false, err
+		}
+		// Deletion does not depend on the kops release, the default one
+		// removes the cluster so it is not stuck behind the registry
+		if k, err = kops.NewKops(kc.Name, ""); err != nil {
+			return nil, nil, false, err
+		}
+	}
+	release = func() {
+		if err := k.RemoveCredentials(); err != nil {
+			log.Error(err, "error removing kops credentials", "Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+		}
+	}
+	defer func() {
+		if !ok || err != nil {
+			release()
+		}
+	}()
+
+	// The Job executor captures the environment both are loaded into
+	if ok, err := r.setStateStore(instance, k, kc); !ok || err != nil {
+		return nil, nil, false, err
+	}
+	if ok, err := r.setCredentials(instance, k, kc); !ok || err != nil {
+		return nil, nil, false, err
+	}
+	if viper.GetString("kops.executor") == kops.JobExecutorName {
+		k.SetExecutor(r.newJobExecutor(instance, k.ExecutorConfig()))
+	}
+	p, err = r.provisionerFor(instance, k)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return p, release, true, nil
+}
+
+// provisionerFor returns what creates the cloud resources of the Cluster
+func (r *ReconcileCluster) provisionerFor(instance *clusteroperatorv1alpha1.Cluster, k *kops.KopsCmd) (provisioner.Provisioner, error) {
+	switch p := providerName(instance); p {
+	case provisioner.KopsName:
+		return k, nil
+	case provisioner.SimulatedName:
+		return r.simulator.For(instance), nil
+	case provisioner.KindName:
+		return provisioner.NewKind(instance), nil
+	case provisioner.CAPIName:
+		return provisioner.NewCAPI(r.client, instance)
+	default:
+		return nil, fmt.Errorf("unknown provider %q", p)
+	}
+}
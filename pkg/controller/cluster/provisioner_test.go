@@ -0,0 +1,143 @@
+package cluster
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/infobloxopen/cluster-operator/pkg/provisioner"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcileSimulated(t *testing.T) {
+	viper.Set("provider", provisioner.SimulatedName)
+	viper.Set("simulated.convergence", time.Duration(0))
+	defer viper.Set("provider", "")
+	defer viper.Set("simulated.convergence", time.Duration(0))
+	defer os.RemoveAll("tmp")
+
+	tests := []struct {
+		name          string
+		annotations   map[string]string
+		wantPhase     clusteroperatorv1alpha1.ClusterPhase
+		wantValidated bool
+	}{
+		{"converged", nil, clusteroperatorv1alpha1.ClusterDone, true},
+		{"validate failure", map[string]string{clusteroperatorv1alpha1.SimulateFailureAnnotation: "validate"},
+			clusteroperatorv1alpha1.ClusterSetup, false},
+	}
+
+	for _, tt := range tests {
+		instance := &clusteroperatorv1alpha1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "example-cluster",
+				Namespace:   "test",
+				Annotations: tt.annotations,
+			},
+			Spec: clusteroperatorv1alpha1.ClusterSpec{
+				Name:       "test",
+				Config:     testLabelsManifest,
+				KopsConfig: clusteroperatorv1alpha1.KopsConfig{Name: "test.example.com", StateStore: "s3://test"},
+			},
+		}
+		r := newTestReconciler(instance)
+		key := types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}
+
+		if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+			t.Fatal(tt.name, "expected no error got", err)
+		}
+
+		cluster := &clusteroperatorv1alpha1.Cluster{}
+		if err := r.client.Get(context.TODO(), key, cluster); err != nil {
+			t.Fatal(err)
+		}
+		if cluster.Status.Phase != tt.wantPhase || cluster.Status.Validated != tt.wantValidated {
+			t.Error(tt.name, "expected phase", tt.wantPhase, "validated", tt.wantValidated,
+				"got", cluster.Status.Phase, cluster.Status.Validated)
+		}
+		if cluster.Spec.Provider != provisioner.SimulatedName {
+			t.Error(tt.name, "expected the provider to be recorded got", cluster.Spec.Provider)
+		}
+		if tt.wantValidated && len(cluster.Status.KopsStatus.Nodes) != 1 {
+			t.Error(tt.name, "expected the node of the instance group got", cluster.Status.KopsStatus.Nodes)
+		}
+
+		secret := &corev1.Secret{}
+		if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "test", Name: "example-cluster-kubeconfig"}, secret); err != nil {
+			t.Fatal(tt.name, "expected the kubeconfig secret got", err)
+		}
+		if !strings.Contains(string(secret.Data[kubeConfigSecretKey]), "https://api.test.example.com") ||
+			len(secret.OwnerReferences) != 1 {
+			t.Error(tt.name, "expected the kubeconfig owned by the cluster got", secret)
+		}
+	}
+}
+
+func TestProvisionerFor(t *testing.T) {
+	defer viper.Set("provider", "")
+	r := newTestReconciler()
+
+	viper.Set("provider", "")
+	if p, err := r.provisionerFor(&clusteroperatorv1alpha1.Cluster{}, nil); err != nil || p == nil {
+		t.Error("Expected kops by default got", p, err)
+	}
+	viper.Set("provider", "unknown")
+	if _, err := r.provisionerFor(&clusteroperatorv1alpha1.Cluster{}, nil); err == nil {
+		t.Error("Expected unknown provider to fail")
+	}
+	// spec.provider takes precedence over the provider of the operator
+	instance := &clusteroperatorv1alpha1.Cluster{Spec: clusteroperatorv1alpha1.ClusterSpec{Provider: provisioner.KindName}}
+	if p, err := r.provisionerFor(instance, nil); err != nil || p == nil {
+		t.Error("Expected the kind provider of the spec got", p, err)
+	}
+	if name := providerName(instance); name != provisioner.KindName {
+		t.Error("Expected", provisioner.KindName, "got", name)
+	}
+	// Clusters created before spec.provider do not follow the operator
+	viper.Set("provider", provisioner.SimulatedName)
+	instance = &clusteroperatorv1alpha1.Cluster{Status: clusteroperatorv1alpha1.ClusterStatus{Phase: clusteroperatorv1alpha1.ClusterDone}}
+	if name := providerName(instance); name != provisioner.KopsName {
+		t.Error("Expected", provisioner.KopsName, "for an existing Cluster got", name)
+	}
+	instance.Status.Phase = ""
+	if name := providerName(instance); name != provisioner.SimulatedName {
+		t.Error("Expected", provisioner.SimulatedName, "for a new Cluster got", name)
+	}
+}
+
+// The kops registry is not watched, a Cluster asking for a release that is
+// not configured is retried until the operator config has it
+func TestReconcileUnknownKopsVersion(t *testing.T) {
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-cluster", Namespace: "test"},
+		Spec: clusteroperatorv1alpha1.ClusterSpec{
+			Name:        "test",
+			KopsVersion: "1.99",
+			KopsConfig:  clusteroperatorv1alpha1.KopsConfig{Name: "test.example.com"},
+		},
+	}
+	r := newTestReconciler(instance)
+	key := types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}
+
+	res, err := r.Reconcile(reconcile.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	if res.RequeueAfter != provisionerRetryInterval {
+		t.Error("Expected the cluster to be retried got", res)
+	}
+	cluster := &clusteroperatorv1alpha1.Cluster{}
+	if err := r.client.Get(context.TODO(), key, cluster); err != nil {
+		t.Fatal(err)
+	}
+	if c := cluster.Status.GetCondition(clusteroperatorv1alpha1.ClusterConditionKopsCompatible); c == nil || c.Reason != "KopsVersionNotFound" {
+		t.Error("Expected the KopsVersionNotFound condition got", c)
+	}
+}
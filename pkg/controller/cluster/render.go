@@ -0,0 +1,160 @@
+package cluster
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// renderConfig builds the kops manifest that is applied for the Cluster from
+// its config, see resolveConfig, the patches and the structured fields of
+// the spec. Gossip clusters get the DNS settings gossip needs, the topology
+// and the worker spot settings are applied before the patches so they can
+// still adjust them. Manifests running the masters on spot instances are
+// rejected, the webhook cannot check the ones of spec.configFrom.
+func renderConfig(instance *clusteroperatorv1alpha1.Cluster, manifest string) (string, error) {
+	cloudLabels := mappedLabels(instance, viper.GetString("labels.cloud"))
+	nodeLabels := mappedLabels(instance, viper.GetString("labels.node"))
+	gossip := instance.Spec.DNS == clusteroperatorv1alpha1.DNSGossip
+	if instance.Spec.KubernetesVersion == "" && len(instance.Spec.Patches) == 0 &&
+		len(cloudLabels) == 0 && len(nodeLabels) == 0 && !gossip && instance.Spec.Topology == nil &&
+		instance.Spec.KopsConfig.WorkerSpot == nil {
+		// Malformed manifests are reported by kops
+		if m, err := kops.ParseManifest(manifest); err == nil {
+			return manifest, m.CheckMasterSpot()
+		}
+		return manifest, nil
+	}
+
+	m, err := kops.ParseManifest(manifest)
+	if err != nil {
+		return "", err
+	}
+
+	// Patches may still configure the load balancer of the API server
+	if gossip {
+		if err := m.SetGossipDNS(); err != nil {
+			return "", err
+		}
+	}
+	if instance.Spec.Topology != nil {
+		if err := m.SetTopology(*instance.Spec.Topology); err != nil {
+			return "", err
+		}
+	}
+
+	if spot := instance.Spec.KopsConfig.WorkerSpot; spot != nil {
+		if err := m.SetWorkerSpot(*spot); err != nil {
+			return "", err
+		}
+	}
+
+	if err := m.ApplyPatches(instance.Spec.Patches); err != nil {
+		return "", err
+	}
+	if err := m.CheckMasterSpot(); err != nil {
+		return "", err
+	}
+	if instance.Spec.Topology != nil {
+		if err := m.CheckNetworking(); err != nil {
+			return "", err
+		}
+	}
+
+	if err := m.SetCloudLabels(cloudLabels); err != nil {
+		return "", err
+	}
+	if err := m.SetNodeLabels(nodeLabels); err != nil {
+		return "", err
+	}
+
+	if instance.Spec.KubernetesVersion != "" {
+		if err := m.SetKubernetesVersion(instance.Spec.KubernetesVersion); err != nil {
+			return "", err
+		}
+	}
+
+	return m.String()
+}
+
+// desiredSpec returns a copy of the spec carrying the rendered kops manifest
+func desiredSpec(instance *clusteroperatorv1alpha1.Cluster, manifest string) (clusteroperatorv1alpha1.ClusterSpec, error) {
+	spec := *instance.Spec.DeepCopy()
+	config, err := renderConfig(instance, manifest)
+	if err != nil {
+		return spec, err
+	}
+	spec.Config = config
+	return spec, nil
+}
+
+// manifestKubernetesVersion returns the Kubernetes version the rendered
+// manifest asks for, empty if it cannot be determined
+func manifestKubernetesVersion(config string) string {
+	m, err := kops.ParseManifest(config)
+	if err != nil {
+		return ""
+	}
+	return m.KubernetesVersion()
+}
+
+// desiredKubernetesVersion returns the Kubernetes version the Cluster asks for
+func desiredKubernetesVersion(instance *clusteroperatorv1alpha1.Cluster, manifest string) string {
+	if instance.Spec.KubernetesVersion != "" {
+		return instance.Spec.KubernetesVersion
+	}
+	if config, err := renderConfig(instance, manifest); err == nil {
+		return manifestKubernetesVersion(config)
+	}
+	return manifestKubernetesVersion(manifest)
+}
+
+// parseLabelMapping parses a label mapping like the labels.cloud flag, a
+// comma separated list of Cluster keys optionally renamed for kops, e.g.
+// team,cost-center=CostCenter
+func parseLabelMapping(s string) map[string]string {
+	mapping := map[string]string{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		from, to := entry, entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			from, to = strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+		}
+		if from != "" && to != "" {
+			mapping[from] = to
+		}
+	}
+	return mapping
+}
+
+// mappedLabels returns the labels and annotations of the object selected by
+// the mapping under their kops keys, labels win over annotations
+func mappedLabels(o metav1.Object, mapping string) map[string]string {
+	labels := map[string]string{}
+	for from, to := range parseLabelMapping(mapping) {
+		if v, ok := o.GetLabels()[from]; ok {
+			labels[to] = v
+		} else if v, ok := o.GetAnnotations()[from]; ok {
+			labels[to] = v
+		}
+	}
+	return labels
+}
+
+// propagatedLabelsChanged reports if a metadata change alters the labels
+// copied into the kops manifest
+func propagatedLabelsChanged(old, new metav1.Object) bool {
+	for _, mapping := range []string{viper.GetString("labels.cloud"), viper.GetString("labels.node")} {
+		if !reflect.DeepEqual(mappedLabels(old, mapping), mappedLabels(new, mapping)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package cluster
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const testLabelsManifest = `apiVersion: kops.k8s.io/v1alpha2
+kind: Cluster
+metadata:
+  name: test.example.com
+spec:
+  kubernetesVersion: 1.16.7
+---
+apiVersion: kops.k8s.io/v1alpha2
+kind: InstanceGroup
+metadata:
+  name: nodes
+spec:
+  role: Node
+`
+
+func TestParseLabelMapping(t *testing.T) {
+	mapping := parseLabelMapping("team, cost-center=CostCenter,,=x")
+	if len(mapping) != 2 || mapping["team"] != "team" || mapping["cost-center"] != "CostCenter" {
+		t.Error("Expected team and cost-center mappings got", mapping)
+	}
+}
+
+func TestRenderConfigLabels(t *testing.T) {
+	viper.Set("labels.cloud", "team,cost-center=CostCenter")
+	viper.Set("labels.node", "env")
+	defer viper.Set("labels.cloud", "")
+	defer viper.Set("labels.node", "")
+
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "example-cluster",
+			Labels:      map[string]string{"team": "infra", "env": "prod", "other": "x"},
+			Annotations: map[string]string{"cost-center": "1234", "team": "ignored"},
+		},
+	}
+
+	config, err := renderConfig(instance, testLabelsManifest)
+	if err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	for _, want := range []string{"team: infra", "CostCenter: \"1234\"", "env: prod"} {
+		if !strings.Contains(config, want) {
+			t.Error("Expected rendered config to contain", want, "got", config)
+		}
+	}
+	if strings.Contains(config, "other") {
+		t.Error("Expected unmapped labels to be left out got", config)
+	}
+
+	changed := instance.DeepCopy()
+	changed.Labels["other"] = "y"
+	if propagatedLabelsChanged(instance, changed) {
+		t.Error("Expected change of an unmapped label to be ignored")
+	}
+	changed.Labels["env"] = "dev"
+	if !propagatedLabelsChanged(instance, changed) {
+		t.Error("Expected change of a mapped label to be detected")
+	}
+}
+
+func TestRenderConfigGossip(t *testing.T) {
+	manifest := strings.Replace(testLabelsManifest, "test.example.com\nspec:\n", "test.k8s.local\nspec:\n  dnsZone: example.com\n", 1)
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-cluster"},
+		Spec:       clusteroperatorv1alpha1.ClusterSpec{DNS: clusteroperatorv1alpha1.DNSGossip},
+	}
+
+	config, err := renderConfig(instance, manifest)
+	if err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	m, err := kops.ParseManifest(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _ := m.Cluster()
+	if zone, ok, _ := unstructured.NestedString(doc, "spec", "dnsZone"); ok {
+		t.Error("Expected the DNS zone to be removed got", zone)
+	}
+	if dns, _, _ := unstructured.NestedString(doc, "spec", "topology", "dns", "type"); dns != "Private" {
+		t.Error("Expected private DNS topology got", dns)
+	}
+	if lb, _, _ := unstructured.NestedString(doc, "spec", "api", "loadBalancer", "type"); lb != "Public" {
+		t.Error("Expected a public API load balancer got", lb)
+	}
+
+	// Patches configure the load balancer
+	instance.Spec.Patches = []clusteroperatorv1alpha1.ManifestPatch{{
+		Target: clusteroperatorv1alpha1.PatchTarget{Kind: "Cluster"},
+		Patch:  "spec:\n  api:\n    loadBalancer:\n      type: Internal\n",
+	}}
+	config, err = renderConfig(instance, manifest)
+	if err != nil || !strings.Contains(config, "type: Internal") {
+		t.Error("Expected the patched load balancer got", config, err)
+	}
+}
+
+func TestRenderConfigTopology(t *testing.T) {
+	manifest := strings.Replace(testLabelsManifest, "spec:\n  kubernetesVersion", "spec:\n  subnets:\n  - name: us-east-2a\n    type: Public\n    zone: us-east-2a\n  kubernetesVersion", 1)
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-cluster"},
+		Spec: clusteroperatorv1alpha1.ClusterSpec{
+			Topology: &clusteroperatorv1alpha1.TopologySpec{
+				Type:            clusteroperatorv1alpha1.TopologyPrivate,
+				APILoadBalancer: clusteroperatorv1alpha1.LoadBalancerInternal,
+				Bastion:         &clusteroperatorv1alpha1.BastionSpec{MachineType: "t3.small"},
+			},
+		},
+	}
+
+	config, err := renderConfig(instance, manifest)
+	if err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	m, err := kops.ParseManifest(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Private() {
+		t.Error("Expected a private topology got", config)
+	}
+	doc, _ := m.Cluster()
+	if lb, _, _ := unstructured.NestedString(doc, "spec", "api", "loadBalancer", "type"); lb != "Internal" {
+		t.Error("Expected an internal API load balancer got", lb)
+	}
+	bastion := m.Find("InstanceGroup", kops.BastionInstanceGroup)
+	if machineType, _, _ := unstructured.NestedString(bastion, "spec", "machineType"); machineType != "t3.small" {
+		t.Error("Expected the bastion machine type got", machineType)
+	}
+}
+
+func TestRenderConfigWorkerSpot(t *testing.T) {
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-cluster"},
+		Spec: clusteroperatorv1alpha1.ClusterSpec{
+			KopsConfig: clusteroperatorv1alpha1.KopsConfig{
+				WorkerSpot: &clusteroperatorv1alpha1.SpotSpec{MachineTypes: []string{"t3.large"}, MaxPrice: "0.05"},
+			},
+			// Patches still adjust the rendered policy
+			Patches: []clusteroperatorv1alpha1.ManifestPatch{{
+				Target: clusteroperatorv1alpha1.PatchTarget{Kind: "InstanceGroup", Name: "nodes"},
+				Patch:  "spec:\n  mixedInstancesPolicy:\n    onDemandBase: 2",
+			}},
+		},
+	}
+
+	config, err := renderConfig(instance, testLabelsManifest)
+	if err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	m, err := kops.ParseManifest(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := m.Find("InstanceGroup", "nodes")
+	instances, _, _ := unstructured.NestedStringSlice(nodes, "spec", "mixedInstancesPolicy", "instances")
+	base, _, _ := unstructured.NestedFieldNoCopy(nodes, "spec", "mixedInstancesPolicy", "onDemandBase")
+	if price, _, _ := unstructured.NestedString(nodes, "spec", "maxPrice"); price != "0.05" || len(instances) != 1 || base != float64(2) {
+		t.Error("Expected the nodes on spot instances got", config)
+	}
+
+	// Manifests of spec.configFrom are checked without any spec setting
+	spotMaster := testLabelsManifest + "---\napiVersion: kops.k8s.io/v1alpha2\nkind: InstanceGroup\nmetadata:\n  name: master-us-east-2a\nspec:\n  role: Master\n  maxPrice: \"0.05\"\n"
+	if _, err := renderConfig(&clusteroperatorv1alpha1.Cluster{}, spotMaster); err == nil {
+		t.Error("Expected the master on spot instances to be rejected")
+	}
+}
@@ -0,0 +1,111 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/infobloxopen/cluster-operator/pkg/provisioner"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// sshKey is the public key of the Cluster read from spec.sshKeySecretRef
+type sshKey struct {
+	publicKey   string
+	fingerprint string
+}
+
+// rotated reports if the key replaces the one applied to the cluster
+func (s sshKey) rotated(instance *clusteroperatorv1alpha1.Cluster) bool {
+	applied := instance.Status.SSHKey.Fingerprint
+	return s.fingerprint != "" && applied != "" && applied != s.fingerprint
+}
+
+// loadSSHKey reads the public key of spec.sshKeySecretRef and reports the
+// SSHKeyValid condition. It returns false when the Cluster cannot be
+// reconciled until the Secret is fixed, the Secret watch requeues it then.
+func (r *ReconcileCluster) loadSSHKey(instance *clusteroperatorv1alpha1.Cluster) (sshKey, bool, error) {
+	ref := instance.Spec.SSHKeySecretRef
+	if ref == nil {
+		// The key in the manifest is used as is
+		return sshKey{}, true, nil
+	}
+
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: instance.Namespace, Name: ref.Name}, secret)
+	if errors.IsNotFound(err) {
+		return sshKey{}, false, r.setSSHKeyCondition(instance, corev1.ConditionFalse, "SecretNotFound",
+			fmt.Sprintf("Secret %s not found", ref.Name))
+	}
+	if err != nil {
+		return sshKey{}, false, err
+	}
+
+	publicKey, ok := secret.Data[ref.Key]
+	if !ok {
+		return sshKey{}, false, r.setSSHKeyCondition(instance, corev1.ConditionFalse, "KeyNotFound",
+			fmt.Sprintf("Secret %s has no key %s", ref.Name, ref.Key))
+	}
+	fingerprint, err := kops.SSHPublicKeyFingerprint(string(publicKey))
+	if err != nil {
+		return sshKey{}, false, r.setSSHKeyCondition(instance, corev1.ConditionFalse, "InvalidKey",
+			fmt.Sprintf("Secret %s key %s: %s", ref.Name, ref.Key, err))
+	}
+
+	return sshKey{publicKey: string(publicKey), fingerprint: fingerprint}, true,
+		r.setSSHKeyCondition(instance, corev1.ConditionTrue, "SecretLoaded",
+			fmt.Sprintf("Using the SSH key %s from Secret %s", fingerprint, ref.Name))
+}
+
+// withSSHKey returns the manifest with the key in its SSHCredential documents
+func withSSHKey(manifest string, key sshKey) (string, error) {
+	if key.publicKey == "" {
+		return manifest, nil
+	}
+	m, err := kops.ParseManifest(manifest)
+	if err != nil {
+		return "", err
+	}
+	if err := m.SetSSHPublicKey(key.publicKey); err != nil {
+		return "", err
+	}
+	return m.String()
+}
+
+// forceRoll reports whether the rotation of the key must replace every
+// instance, it stays pending until the forced rolling update succeeded
+func (s sshKey) forceRoll(instance *clusteroperatorv1alpha1.Cluster) bool {
+	return s.rotated(instance) && instance.Spec.SSHKeyRotationPolicy != clusteroperatorv1alpha1.SSHKeyRotationNone
+}
+
+// rollingUpdate replaces the instances that need it, all of them when the
+// SSH key was rotated and the policy asks for it
+func rollingUpdate(instance *clusteroperatorv1alpha1.Cluster, p provisioner.Provisioner, kc clusteroperatorv1alpha1.KopsConfig, key sshKey) error {
+	if key.forceRoll(instance) {
+		return p.ForceRollingUpdateCluster(kc)
+	}
+	return p.RollingUpdateCluster(kc)
+}
+
+// recordSSHKey keeps the fingerprint of the applied key in the status, it is
+// persisted with the next status update
+func (r *ReconcileCluster) recordSSHKey(instance *clusteroperatorv1alpha1.Cluster, key sshKey) {
+	if key.fingerprint == "" {
+		return
+	}
+	if key.rotated(instance) {
+		now := metav1.Now()
+		instance.Status.SSHKey.LastRotationTime = &now
+		r.recorder.Event(instance, corev1.EventTypeNormal, "SSHKeyRotated",
+			"SSH key "+instance.Status.SSHKey.Fingerprint+" replaced by "+key.fingerprint)
+	}
+	instance.Status.SSHKey.Fingerprint = key.fingerprint
+}
+
+func (r *ReconcileCluster) setSSHKeyCondition(instance *clusteroperatorv1alpha1.Cluster, status corev1.ConditionStatus, reason, message string) error {
+	return r.setCondition(instance, clusteroperatorv1alpha1.ClusterConditionSSHKeyValid, status, reason, message)
+}
@@ -0,0 +1,101 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	testSSHKey            = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAAgQDVmj8grzDAUFLn3l0rIbKIYESr+5IaYGanTIL8IpKYJuJ5pOszNCZ3lbGWXKT9mNn1BTMXv3Ui0xKrdhrjoegHfAyHgjMcYHppwNH9p3VeqSlg4BH6oGrPEtuU12w/kBeMPwYKaK4rWlEaQBcBHeqLJevkWIQC/HrBdqXZe1I3JQ== test"
+	testSSHKeyFingerprint = "e3:30:e0:fd:02:39:cf:f6:9c:0b:b3:57:43:25:74:20"
+)
+
+func TestLoadSSHKey(t *testing.T) {
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-cluster", Namespace: "test"},
+		Spec: clusteroperatorv1alpha1.ClusterSpec{
+			SSHKeySecretRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "ssh"}, Key: "id_rsa.pub"},
+		},
+	}
+	r := newTestReconciler(instance)
+
+	if _, ok, err := r.loadSSHKey(instance); ok || err != nil {
+		t.Fatal("Expected missing Secret to stop the cluster got", ok, err)
+	}
+	c := instance.Status.GetCondition(clusteroperatorv1alpha1.ClusterConditionSSHKeyValid)
+	if c == nil || c.Status != corev1.ConditionFalse || c.Reason != "SecretNotFound" {
+		t.Error("Expected SecretNotFound condition got", c)
+	}
+	if got := clustersUsingSecret(r.client, "test", "ssh"); len(got) != 1 {
+		t.Error("Expected the cluster to be requeued by its Secret got", got)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ssh", Namespace: "test"},
+		Data:       map[string][]byte{"id_rsa.pub": []byte("not a key")},
+	}
+	if err := r.client.Create(context.TODO(), secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := r.loadSSHKey(instance); ok || err != nil {
+		t.Fatal("Expected invalid key to stop the cluster got", ok, err)
+	}
+	c = instance.Status.GetCondition(clusteroperatorv1alpha1.ClusterConditionSSHKeyValid)
+	if c == nil || c.Reason != "InvalidKey" {
+		t.Error("Expected InvalidKey condition got", c)
+	}
+
+	secret.Data["id_rsa.pub"] = []byte(testSSHKey)
+	if err := r.client.Update(context.TODO(), secret); err != nil {
+		t.Fatal(err)
+	}
+	key, ok, err := r.loadSSHKey(instance)
+	if !ok || err != nil {
+		t.Fatal("Expected the key to be loaded got", ok, err)
+	}
+	if key.fingerprint != testSSHKeyFingerprint {
+		t.Error("Expected fingerprint", testSSHKeyFingerprint, "got", key.fingerprint)
+	}
+	if key.rotated(instance) {
+		t.Error("Expected the first key not to be a rotation")
+	}
+
+	manifest, err := withSSHKey("kind: Cluster\nmetadata:\n  name: test.example.com\n", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := kops.ParseManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc := m.Find("SSHCredential", ""); doc == nil || doc["spec"].(map[string]interface{})["publicKey"] != testSSHKey {
+		t.Error("Expected the key in an SSHCredential document got", manifest)
+	}
+
+	r.recordSSHKey(instance, key)
+	if instance.Status.SSHKey.Fingerprint != testSSHKeyFingerprint || instance.Status.SSHKey.LastRotationTime != nil {
+		t.Error("Expected the fingerprint to be recorded without rotation got", instance.Status.SSHKey)
+	}
+
+	instance.Status.SSHKey.Fingerprint = "00:11"
+	if !key.rotated(instance) {
+		t.Error("Expected a changed key to be a rotation")
+	}
+	if !key.forceRoll(instance) {
+		t.Error("Expected the rotation to wait for a forced rolling update")
+	}
+	instance.Spec.SSHKeyRotationPolicy = clusteroperatorv1alpha1.SSHKeyRotationNone
+	if key.forceRoll(instance) {
+		t.Error("Expected no forced rolling update with policy", instance.Spec.SSHKeyRotationPolicy)
+	}
+	r.recordSSHKey(instance, key)
+	if instance.Status.SSHKey.Fingerprint != testSSHKeyFingerprint || instance.Status.SSHKey.LastRotationTime == nil {
+		t.Error("Expected the rotation to be recorded got", instance.Status.SSHKey)
+	}
+}
@@ -0,0 +1,112 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// setStateStore points the kops commands of the Cluster at the StateStore
+// referenced by spec.stateStoreRef and reports the StateStoreValid condition.
+// The credentials of the store are loaded first so the ones of the Cluster
+// take precedence. It returns false when the Cluster cannot be reconciled
+// until the store is fixed.
+func (r *ReconcileCluster) setStateStore(instance *clusteroperatorv1alpha1.Cluster, k *kops.KopsCmd, kc *clusteroperatorv1alpha1.KopsConfig) (bool, error) {
+	name := instance.Spec.StateStoreRef
+	if name == "" {
+		// The store may come from the Cluster or its ClusterDefaults, which
+		// the webhook does not see
+		if kc.StateStore != "" {
+			if _, err := kops.StateStoreScheme(kc.StateStore); err != nil {
+				return false, r.setStateStoreCondition(instance, corev1.ConditionFalse, "InvalidStateStore", err.Error())
+			}
+			k.SetStateStore(kc.StateStore, "")
+		}
+		// Nothing to report for clusters that always used the operator state store
+		if instance.Status.GetCondition(clusteroperatorv1alpha1.ClusterConditionStateStoreValid) == nil {
+			return true, nil
+		}
+		return true, r.setStateStoreCondition(instance, corev1.ConditionTrue, "OperatorStateStore",
+			"Using the operator state store "+kc.StateStore)
+	}
+
+	store := &clusteroperatorv1alpha1.StateStore{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: name}, store)
+	if errors.IsNotFound(err) {
+		return false, r.setStateStoreCondition(instance, corev1.ConditionFalse, "StateStoreNotFound",
+			fmt.Sprintf("StateStore %s not found", name))
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// The webhook rejects these, this catches Clusters created before the
+	// store policy changed
+	namespace := &corev1.Namespace{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: instance.Namespace}, namespace); err != nil {
+		return false, err
+	}
+	allowed, err := store.AllowsNamespace(namespace)
+	if err != nil || !allowed {
+		message := fmt.Sprintf("StateStore %s does not allow namespace %s", name, instance.Namespace)
+		if err != nil {
+			message = fmt.Sprintf("StateStore %s: %s", name, err)
+		}
+		return false, r.setStateStoreCondition(instance, corev1.ConditionFalse, "NamespaceNotAllowed", message)
+	}
+
+	if ref := store.Spec.CredentialsRef; ref != nil {
+		secret := &corev1.Secret{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, secret)
+		if errors.IsNotFound(err) {
+			return false, r.setStateStoreCondition(instance, corev1.ConditionFalse, "CredentialsNotFound",
+				fmt.Sprintf("StateStore %s: Secret %s/%s not found", name, ref.Namespace, ref.Name))
+		}
+		if err != nil {
+			return false, err
+		}
+		credentials := CredentialsFromSecret(secret)
+		if err := credentials.Validate(); err != nil {
+			return false, r.setStateStoreCondition(instance, corev1.ConditionFalse, "InvalidCredentials",
+				fmt.Sprintf("StateStore %s: Secret %s/%s: %s", name, ref.Namespace, ref.Name, err))
+		}
+		if err := k.SetCredentials(kc.Name, credentials); err != nil {
+			return false, err
+		}
+	}
+
+	k.SetStateStore(store.Spec.URL, store.Spec.Region)
+	kc.StateStore = store.Spec.URL
+	return true, r.setStateStoreCondition(instance, corev1.ConditionTrue, "StateStoreLoaded",
+		fmt.Sprintf("Using StateStore %s (%s)", name, store.Spec.URL))
+}
+
+func (r *ReconcileCluster) setStateStoreCondition(instance *clusteroperatorv1alpha1.Cluster, status corev1.ConditionStatus, reason, message string) error {
+	return r.setCondition(instance, clusteroperatorv1alpha1.ClusterConditionStateStoreValid, status, reason, message)
+}
+
+// clustersUsingStateStore returns the Clusters referencing the StateStore in spec.stateStoreRef
+func clustersUsingStateStore(c client.Client, name string) []reconcile.Request {
+	clusters := &clusteroperatorv1alpha1.ClusterList{}
+	if err := c.List(context.TODO(), clusters); err != nil {
+		log.Error(err, "error listing clusters", "StateStore.Name", name)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, cluster := range clusters.Items {
+		if cluster.Spec.StateStoreRef == name {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: cluster.Namespace, Name: cluster.Name},
+			})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,214 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/template"
+
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// templateData is what ClusterTemplates are executed with
+type templateData struct {
+	// Name is the name of the Cluster spec and ClusterName the kops
+	// cluster name, Name in DNSZone
+	Name        string
+	ClusterName string
+	DNSZone     string
+	StateStore  string
+	Values      map[string]string
+	// CloudProvider, the machine types and Zones come from the kops config
+	CloudProvider     string
+	MasterMachineType string
+	NodeMachineType   string
+	Zones             []string
+	// sshKey is the key of spec.sshKeySecretRef
+	sshKey string
+}
+
+// SSHKey returns the public key of spec.sshKeySecretRef, or without one the
+// key of the operator kops.ssh.key setting, which holds either the key or
+// the path of its file
+func (d templateData) SSHKey() (string, error) {
+	if d.sshKey != "" {
+		return d.sshKey, nil
+	}
+	key := viper.GetString("kops.ssh.key")
+	if strings.HasPrefix(key, "ssh-") {
+		return key, nil
+	}
+	data, err := ioutil.ReadFile(key)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// renderTemplate renders the kops manifest from the ClusterTemplate
+// referenced by spec.templateRef into Status.Template and reports the
+// TemplateRendered condition. Spec.Config is left to the user. The manifest
+// is rendered again when the values change, or the template changes unless
+// the update policy is Manual. A rotated SSH key counts as changed values.
+// It returns false when the Cluster cannot be reconciled until the template
+// or values are fixed.
+func (r *ReconcileCluster) renderTemplate(instance *clusteroperatorv1alpha1.Cluster, kc clusteroperatorv1alpha1.KopsConfig, key sshKey) (bool, error) {
+	ref := instance.Spec.TemplateRef
+	if ref == nil {
+		return true, nil
+	}
+
+	tmpl := &clusteroperatorv1alpha1.ClusterTemplate{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: instance.Namespace, Name: ref.Name}, tmpl)
+	if errors.IsNotFound(err) {
+		return false, r.setTemplateCondition(instance, corev1.ConditionFalse, "TemplateNotFound",
+			fmt.Sprintf("ClusterTemplate %s not found", ref.Name))
+	}
+	if err != nil {
+		return false, err
+	}
+
+	valuesHash := hashValues(instance.Spec.Values, key.fingerprint)
+	last := instance.Status.Template
+	rendered := last.Config != "" && last.Name == ref.Name
+	outdated := last.Revision != tmpl.Generation
+	if rendered && last.ValuesHash == valuesHash &&
+		(!outdated || instance.Spec.TemplateUpdatePolicy == clusteroperatorv1alpha1.TemplateUpdateManual) {
+		if outdated {
+			return true, r.setTemplateCondition(instance, corev1.ConditionTrue, "TemplateOutdated",
+				fmt.Sprintf("Rendered from revision %d of ClusterTemplate %s, revision %d is not applied with the Manual update policy",
+					last.Revision, ref.Name, tmpl.Generation))
+		}
+		return true, nil
+	}
+
+	values, err := templateValues(tmpl, instance.Spec.Values)
+	if err != nil {
+		return false, r.setTemplateCondition(instance, corev1.ConditionFalse, "InvalidValues",
+			fmt.Sprintf("ClusterTemplate %s: %s", ref.Name, err))
+	}
+	config, err := executeTemplate(tmpl, templateData{
+		Name:        instance.Spec.Name,
+		ClusterName: kc.Name,
+		DNSZone:     strings.TrimPrefix(kc.Name, instance.Spec.Name+"."),
+		StateStore:  kc.StateStore,
+		Values:      values,
+
+		CloudProvider:     kc.CloudProvider,
+		MasterMachineType: kc.MasterMachineType,
+		NodeMachineType:   kc.WorkerMachineType,
+		Zones:             kc.Zones,
+		sshKey:            key.publicKey,
+	})
+	if err != nil {
+		return false, r.setTemplateCondition(instance, corev1.ConditionFalse, "RenderFailed",
+			fmt.Sprintf("ClusterTemplate %s: %s", ref.Name, err))
+	}
+
+	instance.Status.Template = clusteroperatorv1alpha1.TemplateStatus{
+		Name:       ref.Name,
+		Revision:   tmpl.Generation,
+		ValuesHash: valuesHash,
+		Config:     config,
+	}
+	changed := instance.Status.SetCondition(clusteroperatorv1alpha1.ClusterCondition{
+		Type:    clusteroperatorv1alpha1.ClusterConditionTemplateRendered,
+		Status:  corev1.ConditionTrue,
+		Reason:  "Rendered",
+		Message: fmt.Sprintf("Rendered from revision %d of ClusterTemplate %s", tmpl.Generation, ref.Name),
+	})
+	if changed {
+		r.recorder.Event(instance, corev1.EventTypeNormal, "TemplateRendered",
+			fmt.Sprintf("Rendered config from revision %d of ClusterTemplate %s", tmpl.Generation, ref.Name))
+	}
+	return true, r.client.Status().Update(context.TODO(), instance)
+}
+
+// templateValues checks the values of the Cluster against the ones the
+// template declares and adds the defaults
+func templateValues(tmpl *clusteroperatorv1alpha1.ClusterTemplate, set map[string]string) (map[string]string, error) {
+	values := map[string]string{}
+	declared := map[string]bool{}
+	for _, v := range tmpl.Spec.Values {
+		declared[v.Name] = true
+		value, ok := set[v.Name]
+		if !ok {
+			if v.Required && v.Default == "" {
+				return nil, fmt.Errorf("value %s is required", v.Name)
+			}
+			value = v.Default
+		}
+		values[v.Name] = value
+	}
+
+	var unknown []string
+	for name, value := range set {
+		if len(declared) > 0 && !declared[name] {
+			unknown = append(unknown, name)
+		}
+		values[name] = value
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("values %s are not declared", strings.Join(unknown, ", "))
+	}
+	return values, nil
+}
+
+// executeTemplate renders the kops manifest, missing keys are errors rather
+// than empty fields
+func executeTemplate(tmpl *clusteroperatorv1alpha1.ClusterTemplate, data templateData) (string, error) {
+	t, err := template.New(tmpl.Name).Option("missingkey=error").Parse(tmpl.Spec.Template)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// hashValues identifies the values and the fingerprint of the SSH key of
+// the Secret, maps are marshalled with sorted keys
+func hashValues(values map[string]string, fingerprint string) string {
+	data, _ := json.Marshal(values)
+	if fingerprint != "" {
+		data = append(data, fingerprint...)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data))[:16]
+}
+
+func (r *ReconcileCluster) setTemplateCondition(instance *clusteroperatorv1alpha1.Cluster, status corev1.ConditionStatus, reason, message string) error {
+	return r.setCondition(instance, clusteroperatorv1alpha1.ClusterConditionTemplateRendered, status, reason, message)
+}
+
+// clustersUsingTemplate returns the Clusters referencing the ClusterTemplate in spec.templateRef
+func clustersUsingTemplate(c client.Client, namespace, name string) []reconcile.Request {
+	clusters := &clusteroperatorv1alpha1.ClusterList{}
+	if err := c.List(context.TODO(), clusters, client.InNamespace(namespace)); err != nil {
+		log.Error(err, "error listing clusters", "ClusterTemplate.Namespace", namespace, "ClusterTemplate.Name", name)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, cluster := range clusters.Items {
+		if ref := cluster.Spec.TemplateRef; ref != nil && ref.Name == name {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: cluster.Namespace, Name: cluster.Name},
+			})
+		}
+	}
+	return requests
+}
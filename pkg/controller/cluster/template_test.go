@@ -0,0 +1,155 @@
+package cluster
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const testTemplate = `apiVersion: kops.k8s.io/v1alpha2
+kind: Cluster
+metadata:
+  name: {{ .ClusterName }}
+spec:
+  configBase: {{ .StateStore }}/{{ .ClusterName }}
+  masterPublicName: api.{{ .Name }}.{{ .DNSZone }}
+  networkID: {{ .Values.vpc }}
+  kubernetesVersion: {{ .Values.kubernetesVersion }}
+---
+apiVersion: kops.k8s.io/v1alpha2
+kind: SSHCredential
+spec:
+  publicKey: "{{ .SSHKey }}"
+`
+
+func TestRenderTemplate(t *testing.T) {
+	viper.Set("kops.ssh.key", "ssh-rsa AAAA test")
+	defer viper.Set("kops.ssh.key", "")
+
+	tmpl := &clusteroperatorv1alpha1.ClusterTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "standard", Namespace: "test", Generation: 1},
+		Spec: clusteroperatorv1alpha1.ClusterTemplateSpec{
+			Template: testTemplate,
+			Values: []clusteroperatorv1alpha1.TemplateValue{
+				{Name: "vpc", Required: true},
+				{Name: "kubernetesVersion", Default: "1.16.7"},
+			},
+		},
+	}
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-cluster", Namespace: "test"},
+		Spec: clusteroperatorv1alpha1.ClusterSpec{
+			Name:        "example",
+			TemplateRef: &corev1.LocalObjectReference{Name: "standard"},
+		},
+	}
+	kc := clusteroperatorv1alpha1.KopsConfig{Name: "example.test.example.com", StateStore: "s3://store"}
+	r := newTestReconciler(tmpl, instance)
+
+	ok, err := r.renderTemplate(instance, kc, sshKey{})
+	if ok || err != nil {
+		t.Fatal("Expected missing value to stop the cluster got", ok, err)
+	}
+	c := instance.Status.GetCondition(clusteroperatorv1alpha1.ClusterConditionTemplateRendered)
+	if c == nil || c.Reason != "InvalidValues" || !strings.Contains(c.Message, "vpc") {
+		t.Error("Expected InvalidValues condition got", c)
+	}
+
+	instance.Spec.Values = map[string]string{"vpc": "vpc-1234"}
+	if ok, err := r.renderTemplate(instance, kc, sshKey{}); !ok || err != nil {
+		t.Fatal("Expected rendered template got", ok, err)
+	}
+	for _, want := range []string{
+		"name: example.test.example.com",
+		"configBase: s3://store/example.test.example.com",
+		"masterPublicName: api.example.test.example.com",
+		"networkID: vpc-1234",
+		"kubernetesVersion: 1.16.7",
+		`publicKey: "ssh-rsa AAAA test"`,
+	} {
+		if !strings.Contains(instance.Status.Template.Config, want) {
+			t.Error("Expected", want, "in config got", instance.Status.Template.Config)
+		}
+	}
+	if s := instance.Status.Template; s.Name != "standard" || s.Revision != 1 {
+		t.Error("Expected template revision in status got", s)
+	}
+	// The spec is left to the user, the manifest is reconciled from the status
+	stored := &clusteroperatorv1alpha1.Cluster{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Spec.Config != "" || stored.Status.Template.Config != instance.Status.Template.Config {
+		t.Error("Expected the rendered config in the status only got", stored.Spec.Config)
+	}
+	if config, ok, err := r.resolveConfig(instance); !ok || err != nil || config != instance.Status.Template.Config {
+		t.Error("Expected the rendered config to be resolved got", config, ok, err)
+	}
+
+	// Template changes are rendered with the Auto policy
+	tmpl.Spec.Template = strings.Replace(testTemplate, "networkID", "networkId", 1)
+	tmpl.Generation = 2
+	if err := r.client.Update(context.TODO(), tmpl); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := r.renderTemplate(instance, kc, sshKey{}); !ok || err != nil {
+		t.Fatal("Expected rendered template got", ok, err)
+	}
+	if !strings.Contains(instance.Status.Template.Config, "networkId: vpc-1234") || instance.Status.Template.Revision != 2 {
+		t.Error("Expected revision 2 to be rendered got", instance.Status.Template)
+	}
+
+	// and kept with the Manual policy until the values change
+	instance.Spec.TemplateUpdatePolicy = clusteroperatorv1alpha1.TemplateUpdateManual
+	tmpl.Spec.Template = testTemplate
+	tmpl.Generation = 3
+	if err := r.client.Update(context.TODO(), tmpl); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := r.renderTemplate(instance, kc, sshKey{}); !ok || err != nil {
+		t.Fatal("Expected no error got", ok, err)
+	}
+	if instance.Status.Template.Revision != 2 {
+		t.Error("Expected revision 2 to be kept got", instance.Status.Template)
+	}
+	if c := instance.Status.GetCondition(clusteroperatorv1alpha1.ClusterConditionTemplateRendered); c == nil || c.Reason != "TemplateOutdated" {
+		t.Error("Expected TemplateOutdated condition got", c)
+	}
+	instance.Spec.Values["vpc"] = "vpc-5678"
+	if ok, err := r.renderTemplate(instance, kc, sshKey{}); !ok || err != nil {
+		t.Fatal("Expected rendered template got", ok, err)
+	}
+	if !strings.Contains(instance.Status.Template.Config, "networkID: vpc-5678") || instance.Status.Template.Revision != 3 {
+		t.Error("Expected new values to render revision 3 got", instance.Status.Template)
+	}
+
+	// The key of the Secret replaces the one of the operator, rotating it renders again
+	key := sshKey{publicKey: testSSHKey, fingerprint: testSSHKeyFingerprint}
+	if ok, err := r.renderTemplate(instance, kc, key); !ok || err != nil {
+		t.Fatal("Expected rendered template got", ok, err)
+	}
+	if !strings.Contains(instance.Status.Template.Config, testSSHKey) {
+		t.Error("Expected the key of the Secret in config got", instance.Status.Template.Config)
+	}
+}
+
+func TestTemplateValues(t *testing.T) {
+	tmpl := &clusteroperatorv1alpha1.ClusterTemplate{
+		Spec: clusteroperatorv1alpha1.ClusterTemplateSpec{
+			Values: []clusteroperatorv1alpha1.TemplateValue{{Name: "vpc"}},
+		},
+	}
+	if _, err := templateValues(tmpl, map[string]string{"vcp": "typo"}); err == nil {
+		t.Error("Expected undeclared value to be rejected")
+	}
+	values, err := templateValues(&clusteroperatorv1alpha1.ClusterTemplate{}, map[string]string{"any": "value"})
+	if err != nil || values["any"] != "value" {
+		t.Error("Expected any value without declarations got", values, err)
+	}
+}
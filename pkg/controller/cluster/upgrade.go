@@ -0,0 +1,240 @@
+package cluster
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/infobloxopen/cluster-operator/pkg/provisioner"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// upgradeRequested reports if the Kubernetes version of a running cluster has
+// to change or an upgrade is still in progress
+func upgradeRequested(instance *clusteroperatorv1alpha1.Cluster) bool {
+	if instance.Status.Upgrade.To != "" {
+		return true
+	}
+	desired := instance.Spec.KubernetesVersion
+	current := instance.Status.KubernetesVersion.Version
+	return desired != "" && current != "" && desired != current
+}
+
+// checkUpgrade reports if the cluster can move to Spec.KubernetesVersion, an
+// upgrade in progress is carried on. An upgrade kops or the version skew does
+// not allow is reported in the UpgradeFailed condition, the cluster is then
+// reconciled at the version it runs until the spec changes.
+func (r *ReconcileCluster) checkUpgrade(instance *clusteroperatorv1alpha1.Cluster, p provisioner.Provisioner) (bool, error) {
+	target := instance.Spec.KubernetesVersion
+	if instance.Status.Upgrade.To == target {
+		return true, nil
+	}
+	from := instance.Status.KubernetesVersion.Version
+	kopsVersion, err := p.Version()
+	if err != nil {
+		return false, err
+	}
+	// Providers without a kops version only check the version skew
+	check := kops.CheckVersionSkew(from, target)
+	if kopsVersion != "" {
+		check = kops.CheckKubernetesUpgrade(from, target, kopsVersion)
+	}
+	if check == nil {
+		return true, nil
+	}
+	changed := instance.Status.SetCondition(clusteroperatorv1alpha1.ClusterCondition{
+		Type:    clusteroperatorv1alpha1.ClusterConditionUpgradeFailed,
+		Status:  corev1.ConditionTrue,
+		Reason:  "UpgradeNotSupported",
+		Message: check.Error(),
+	})
+	if !changed {
+		return false, nil
+	}
+	log.Info("Upgrade not supported", "Request.Namespace", instance.Namespace, "Request.Name", instance.Name, "reason", check.Error())
+	r.recorder.Event(instance, corev1.EventTypeWarning, "UpgradeNotSupported", check.Error())
+	return false, r.client.Status().Update(context.TODO(), instance)
+}
+
+// clearUnsupportedUpgrade drops the UpgradeFailed condition of an unsupported
+// upgrade once the spec asks for the running version again
+func (r *ReconcileCluster) clearUnsupportedUpgrade(instance *clusteroperatorv1alpha1.Cluster) error {
+	c := instance.Status.GetCondition(clusteroperatorv1alpha1.ClusterConditionUpgradeFailed)
+	if c == nil || c.Reason != "UpgradeNotSupported" {
+		return nil
+	}
+	instance.Status.SetCondition(clusteroperatorv1alpha1.ClusterCondition{
+		Type:    clusteroperatorv1alpha1.ClusterConditionUpgradeFailed,
+		Status:  corev1.ConditionFalse,
+		Reason:  "UpgradeCancelled",
+		Message: "Running Kubernetes " + instance.Status.KubernetesVersion.Version,
+	})
+	return r.client.Status().Update(context.TODO(), instance)
+}
+
+// runningSpec returns the Cluster with Spec.KubernetesVersion set to the
+// version the cluster runs, for rendering while an upgrade is not supported
+func runningSpec(instance *clusteroperatorv1alpha1.Cluster) *clusteroperatorv1alpha1.Cluster {
+	running := instance.DeepCopy()
+	running.Spec.KubernetesVersion = instance.Status.KubernetesVersion.Version
+	return running
+}
+
+// reconcileUpgrade moves the cluster to Spec.KubernetesVersion, see
+// checkUpgrade. The kops state is updated first, then masters are rolled and
+// validated before the nodes. Every stage, and whether its instance groups
+// were rolled, is recorded in the status so requeues and a restarted
+// operator pick up where they left off.
+func (r *ReconcileCluster) reconcileUpgrade(instance *clusteroperatorv1alpha1.Cluster, p provisioner.Provisioner, kc clusteroperatorv1alpha1.KopsConfig, manifest string) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+	status := &instance.Status
+	target := instance.Spec.KubernetesVersion
+
+	// A new target version starts the upgrade over from the apply stage
+	if status.Upgrade.To != target {
+		from := status.KubernetesVersion.Version
+		reqLogger.Info("Upgrading Kubernetes", "from", from, "to", target)
+		status.Upgrade = clusteroperatorv1alpha1.UpgradeStatus{
+			From:  from,
+			To:    target,
+			Stage: clusteroperatorv1alpha1.UpgradeStageApply,
+		}
+		status.Phase = clusteroperatorv1alpha1.ClusterUpgrading
+		status.SetCondition(clusteroperatorv1alpha1.ClusterCondition{
+			Type:    clusteroperatorv1alpha1.ClusterConditionUpgradeFailed,
+			Status:  corev1.ConditionFalse,
+			Reason:  "Upgrading",
+			Message: "Upgrading from " + from + " to " + target,
+		})
+		if err := r.client.Status().Update(context.TODO(), instance); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	os.Setenv("KUBECONFIG", "tmp/config-"+kc.Name)
+
+	switch status.Upgrade.Stage {
+	case clusteroperatorv1alpha1.UpgradeStageApply:
+		reqLogger.Info("Upgrade stage: APPLY")
+		spec, err := desiredSpec(instance, manifest)
+		if err != nil {
+			return r.failUpgrade(instance, "RenderFailed", err)
+		}
+		if err := p.ReplaceCluster(spec); err != nil {
+			return r.failUpgrade(instance, "ReplaceFailed", err)
+		}
+		if err := p.UpdateCluster(kc); err != nil {
+			return r.failUpgrade(instance, "UpdateFailed", err)
+		}
+
+		status.Upgrade.Stage = clusteroperatorv1alpha1.UpgradeStageMasters
+		if err := r.client.Status().Update(context.TODO(), instance); err != nil {
+			return reconcile.Result{}, err
+		}
+		fallthrough
+
+	case clusteroperatorv1alpha1.UpgradeStageMasters:
+		reqLogger.Info("Upgrade stage: MASTERS")
+		if !status.Upgrade.Rolled {
+			if err := p.RollingUpdateRole(kc, "Master"); err != nil {
+				return r.failUpgrade(instance, "MasterRollFailed", err)
+			}
+			status.Upgrade.Rolled = true
+			if err := r.client.Status().Update(context.TODO(), instance); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+		validated, err := r.validateUpgrade(p, kc)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if !validated {
+			reqLogger.Info("Masters not validated yet, waiting before rolling nodes")
+			return reconcile.Result{RequeueAfter: time.Minute * 5}, nil
+		}
+
+		status.KubernetesVersion.Masters = target
+		status.Upgrade.Stage = clusteroperatorv1alpha1.UpgradeStageNodes
+		status.Upgrade.Rolled = false
+		if err := r.client.Status().Update(context.TODO(), instance); err != nil {
+			return reconcile.Result{}, err
+		}
+		fallthrough
+
+	case clusteroperatorv1alpha1.UpgradeStageNodes:
+		reqLogger.Info("Upgrade stage: NODES")
+		if !status.Upgrade.Rolled {
+			if err := p.RollingUpdateRole(kc, "Node"); err != nil {
+				return r.failUpgrade(instance, "NodeRollFailed", err)
+			}
+			status.Upgrade.Rolled = true
+			if err := r.client.Status().Update(context.TODO(), instance); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+		validated, err := r.validateUpgrade(p, kc)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if !validated {
+			reqLogger.Info("Nodes not validated yet")
+			return reconcile.Result{RequeueAfter: time.Minute * 5}, nil
+		}
+	}
+
+	reqLogger.Info("Upgrade complete", "version", target)
+	status.KubernetesVersion = clusteroperatorv1alpha1.KubernetesVersionStatus{
+		Version: target,
+		Masters: target,
+		Nodes:   target,
+	}
+	status.Upgrade = clusteroperatorv1alpha1.UpgradeStatus{}
+	status.Phase = clusteroperatorv1alpha1.ClusterDone
+	status.SetCondition(clusteroperatorv1alpha1.ClusterCondition{
+		Type:    clusteroperatorv1alpha1.ClusterConditionUpgradeFailed,
+		Status:  corev1.ConditionFalse,
+		Reason:  "Upgraded",
+		Message: "Running Kubernetes " + target,
+	})
+	if err := r.client.Status().Update(context.TODO(), instance); err != nil {
+		return reconcile.Result{}, err
+	}
+	r.recorder.Event(instance, corev1.EventTypeNormal, "Upgraded", "Kubernetes upgraded to "+target)
+
+	return reconcile.Result{RequeueAfter: time.Minute * 10}, nil
+}
+
+// validateUpgrade gates the next stage on kops validating the cluster. A
+// validation still running in a Job is returned as kops.ErrPending, the
+// other errors are a cluster not validated yet.
+func (r *ReconcileCluster) validateUpgrade(p provisioner.Provisioner, kc clusteroperatorv1alpha1.KopsConfig) (bool, error) {
+	status, err := p.ValidateCluster(kc)
+	if err == kops.ErrPending {
+		return false, err
+	}
+	return err == nil && len(status.Failures) == 0 && len(status.Nodes) > 0, nil
+}
+
+// failUpgrade reports why the current stage failed in the UpgradeFailed
+// condition, the stage is retried after five minutes
+func (r *ReconcileCluster) failUpgrade(instance *clusteroperatorv1alpha1.Cluster, reason string, err error) (reconcile.Result, error) {
+	if err == kops.ErrPending {
+		// The stage is still running in a Job
+		return reconcile.Result{}, err
+	}
+	log.Error(err, "upgrade failed", "Request.Namespace", instance.Namespace, "Request.Name", instance.Name, "reason", reason)
+	instance.Status.SetCondition(clusteroperatorv1alpha1.ClusterCondition{
+		Type:    clusteroperatorv1alpha1.ClusterConditionUpgradeFailed,
+		Status:  corev1.ConditionTrue,
+		Reason:  reason,
+		Message: err.Error(),
+	})
+	r.recorder.Event(instance, corev1.EventTypeWarning, "UpgradeFailed", err.Error())
+	if err := r.client.Status().Update(context.TODO(), instance); err != nil {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{RequeueAfter: time.Minute * 5}, nil
+}
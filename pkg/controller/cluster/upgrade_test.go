@@ -0,0 +1,167 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/infobloxopen/cluster-operator/pkg/provisioner"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+// upgradeProvisioner counts the rolls of each role, the cluster validates
+// once validated is set unless validateErr is
+type upgradeProvisioner struct {
+	provisioner.Provisioner
+	rolls       map[string]int
+	rollErr     error
+	validated   bool
+	validateErr error
+}
+
+func (p *upgradeProvisioner) Version() (string, error) { return "", nil }
+
+func (p *upgradeProvisioner) ReplaceCluster(clusteroperatorv1alpha1.ClusterSpec) error { return nil }
+
+func (p *upgradeProvisioner) UpdateCluster(clusteroperatorv1alpha1.KopsConfig) error { return nil }
+
+func (p *upgradeProvisioner) RollingUpdateRole(kc clusteroperatorv1alpha1.KopsConfig, role string) error {
+	if p.rollErr != nil {
+		return p.rollErr
+	}
+	p.rolls[role]++
+	return nil
+}
+
+func (p *upgradeProvisioner) ValidateCluster(clusteroperatorv1alpha1.KopsConfig) (clusteroperatorv1alpha1.KopsStatus, error) {
+	if p.validateErr != nil {
+		return clusteroperatorv1alpha1.KopsStatus{}, p.validateErr
+	}
+	if !p.validated {
+		return clusteroperatorv1alpha1.KopsStatus{}, errors.New("not ready")
+	}
+	return clusteroperatorv1alpha1.KopsStatus{Nodes: []clusteroperatorv1alpha1.KopsNode{{Name: "node"}}}, nil
+}
+
+func newUpgradeCluster(from, to string) *clusteroperatorv1alpha1.Cluster {
+	instance := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-cluster", Namespace: "test"},
+		Spec: clusteroperatorv1alpha1.ClusterSpec{
+			Name:              "test",
+			KubernetesVersion: to,
+			KopsConfig:        clusteroperatorv1alpha1.KopsConfig{Name: "test.example.com", StateStore: "s3://test"},
+		},
+	}
+	instance.Status.KubernetesVersion.Version = from
+	return instance
+}
+
+func TestReconcileUpgradeSkipsRolledStages(t *testing.T) {
+	instance := newUpgradeCluster("1.16.7", "1.17.4")
+	r := newTestReconciler(instance)
+	p := &upgradeProvisioner{rolls: map[string]int{}}
+	kc := instance.Spec.KopsConfig
+
+	for i := 0; i < 3; i++ {
+		res, err := r.reconcileUpgrade(instance, p, kc, testLabelsManifest)
+		if err != nil {
+			t.Fatal("Expected no error got", err)
+		}
+		if res.RequeueAfter == 0 {
+			t.Error("Expected a requeue while the masters do not validate")
+		}
+	}
+	if p.rolls["Master"] != 1 {
+		t.Error("Expected the masters to be rolled once", "got", p.rolls["Master"])
+	}
+	cluster := &clusteroperatorv1alpha1.Cluster{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, cluster); err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Status.Upgrade.Stage != clusteroperatorv1alpha1.UpgradeStageMasters || !cluster.Status.Upgrade.Rolled {
+		t.Error("Expected the rolled Masters stage to be recorded", "got", cluster.Status.Upgrade)
+	}
+
+	p.validated = true
+	if _, err := r.reconcileUpgrade(instance, p, kc, testLabelsManifest); err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	if p.rolls["Master"] != 1 || p.rolls["Node"] != 1 {
+		t.Error("Expected one roll of masters and nodes", "got", p.rolls)
+	}
+	if instance.Status.KubernetesVersion.Version != "1.17.4" || instance.Status.Upgrade.To != "" {
+		t.Error("Expected the upgrade to complete", "got", instance.Status.KubernetesVersion, instance.Status.Upgrade)
+	}
+}
+
+func TestReconcileUpgradePendingValidation(t *testing.T) {
+	instance := newUpgradeCluster("1.16.7", "1.17.4")
+	r := newTestReconciler(instance)
+	p := &upgradeProvisioner{rolls: map[string]int{}, validateErr: kops.ErrPending}
+
+	if _, err := r.reconcileUpgrade(instance, p, instance.Spec.KopsConfig, testLabelsManifest); err != kops.ErrPending {
+		t.Error("Expected the running validation Job to be waited for got", err)
+	}
+	if instance.Status.Upgrade.Stage != clusteroperatorv1alpha1.UpgradeStageMasters || !instance.Status.Upgrade.Rolled {
+		t.Error("Expected the rolled Masters stage to be kept got", instance.Status.Upgrade)
+	}
+}
+
+func TestFailUpgradeRequeues(t *testing.T) {
+	instance := newUpgradeCluster("1.16.7", "1.17.4")
+	r := newTestReconciler(instance)
+	p := &upgradeProvisioner{rolls: map[string]int{}, rollErr: errors.New("roll failed")}
+
+	res, err := r.reconcileUpgrade(instance, p, instance.Spec.KopsConfig, testLabelsManifest)
+	if err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	if res.RequeueAfter == 0 {
+		t.Error("Expected the failed stage to be retried")
+	}
+	c := instance.Status.GetCondition(clusteroperatorv1alpha1.ClusterConditionUpgradeFailed)
+	if c == nil || c.Status != corev1.ConditionTrue || c.Reason != "MasterRollFailed" {
+		t.Error("Expected the UpgradeFailed condition", "got", c)
+	}
+}
+
+func TestCheckUpgradeNotSupported(t *testing.T) {
+	instance := newUpgradeCluster("1.15.3", "1.17.4")
+	r := newTestReconciler(instance)
+	recorder := r.recorder.(*record.FakeRecorder)
+	p := &upgradeProvisioner{rolls: map[string]int{}}
+
+	for i := 0; i < 2; i++ {
+		supported, err := r.checkUpgrade(instance, p)
+		if err != nil {
+			t.Fatal("Expected no error got", err)
+		}
+		if supported {
+			t.Error("Expected the upgrade skipping 1.16 to be rejected")
+		}
+	}
+	c := instance.Status.GetCondition(clusteroperatorv1alpha1.ClusterConditionUpgradeFailed)
+	if c == nil || c.Status != corev1.ConditionTrue || c.Reason != "UpgradeNotSupported" {
+		t.Error("Expected the UpgradeNotSupported condition", "got", c)
+	}
+	if len(recorder.Events) != 1 {
+		t.Error("Expected a single event", "got", len(recorder.Events))
+	}
+	if v := runningSpec(instance).Spec.KubernetesVersion; v != "1.15.3" {
+		t.Error("Expected the cluster to be rendered at the running version", "got", v)
+	}
+
+	instance.Spec.KubernetesVersion = "1.15.3"
+	if err := r.clearUnsupportedUpgrade(instance); err != nil {
+		t.Fatal(err)
+	}
+	c = instance.Status.GetCondition(clusteroperatorv1alpha1.ClusterConditionUpgradeFailed)
+	if c == nil || c.Status != corev1.ConditionFalse {
+		t.Error("Expected the condition to be cleared once the spec runs the current version", "got", c)
+	}
+}
@@ -0,0 +1,137 @@
+package statestore
+
+import (
+	"context"
+	"time"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/infobloxopen/cluster-operator/pkg/controller/cluster"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("controller_statestore")
+
+// Add creates a new StateStore Controller probing the stores and adds it to the Manager
+func Add(cfg cluster.ReconcilerConfig) error {
+	return add(cfg.Mgr, newReconciler(cfg.Mgr))
+}
+
+func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+	return &ReconcileStateStore{
+		client: mgr.GetClient(),
+		probe:  kopsProbe,
+	}
+}
+
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	c, err := controller.New("statestore-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Probe results are written to the status, which does not change the generation
+	return c.Watch(&source.Kind{Type: &clusteroperatorv1alpha1.StateStore{}}, &handler.EnqueueRequestForObject{},
+		predicate.GenerationChangedPredicate{})
+}
+
+// probeFunc checks the store can be listed with the credentials, nil when
+// the operator credentials are used
+type probeFunc func(store *clusteroperatorv1alpha1.StateStore, credentials *kops.Credentials) error
+
+// kopsProbe lists the store with kops. Jobs need a Cluster to own them, so
+// stores are probed with the kops binary of the operator in job mode.
+func kopsProbe(store *clusteroperatorv1alpha1.StateStore, credentials *kops.Credentials) error {
+	if _, err := kops.StateStoreScheme(store.Spec.URL); err != nil {
+		return err
+	}
+	k, err := kops.NewKops(store.Name, "")
+	if err != nil {
+		return err
+	}
+	if viper.GetString("kops.executor") == kops.JobExecutorName {
+		k.SetExecutor(kops.LocalExecutor{Env: k.ExecutorConfig().Env})
+	}
+	if credentials != nil {
+		if err := k.SetCredentials(store.Name, *credentials); err != nil {
+			return err
+		}
+	}
+	k.SetStateStore(store.Spec.URL, store.Spec.Region)
+	return k.ProbeStateStore()
+}
+
+// blank assignment to verify that ReconcileStateStore implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileStateStore{}
+
+// ReconcileStateStore probes StateStores periodically and reports if they are reachable
+type ReconcileStateStore struct {
+	client client.Client
+	probe  probeFunc
+}
+
+// Reconcile probes the StateStore and requeues it for the next probe after
+// statestore.probe.interval
+func (r *ReconcileStateStore) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Name", request.Name)
+
+	store := &clusteroperatorv1alpha1.StateStore{}
+	err := r.client.Get(context.TODO(), request.NamespacedName, store)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	err = r.probeStore(store)
+	now := metav1.Now()
+	store.Status.LastProbeTime = &now
+	store.Status.Reachable = err == nil
+	store.Status.Message = ""
+	if err != nil {
+		reqLogger.Info("State store is not reachable", "url", store.Spec.URL, "error", err.Error())
+		store.Status.Message = err.Error()
+	}
+	if err := r.client.Status().Update(context.TODO(), store); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{RequeueAfter: probeInterval()}, nil
+}
+
+func (r *ReconcileStateStore) probeStore(store *clusteroperatorv1alpha1.StateStore) error {
+	ref := store.Spec.CredentialsRef
+	if ref == nil {
+		return r.probe(store, nil)
+	}
+
+	secret := &corev1.Secret{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, secret); err != nil {
+		return err
+	}
+	credentials := cluster.CredentialsFromSecret(secret)
+	if err := credentials.Validate(); err != nil {
+		return err
+	}
+	return r.probe(store, &credentials)
+}
+
+func probeInterval() time.Duration {
+	if d := viper.GetDuration("statestore.probe.interval"); d > 0 {
+		return d
+	}
+	return 5 * time.Minute
+}
@@ -0,0 +1,85 @@
+package statestore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcileProbe(t *testing.T) {
+	s := scheme.Scheme
+	clusteroperatorv1alpha1.SchemeBuilder.AddToScheme(s)
+
+	tests := []struct {
+		name        string
+		credentials *corev1.SecretReference
+		probeErr    error
+		reachable   bool
+	}{
+		{name: "reachable", reachable: true},
+		{name: "unreachable", probeErr: fmt.Errorf("exit status 1: AccessDenied"), reachable: false},
+		{name: "credentials", credentials: &corev1.SecretReference{Namespace: "operator", Name: "aws"}, reachable: true},
+		{name: "missing credentials", credentials: &corev1.SecretReference{Namespace: "operator", Name: "missing"}, reachable: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &clusteroperatorv1alpha1.StateStore{
+				ObjectMeta: metav1.ObjectMeta{Name: "team-a"},
+				Spec: clusteroperatorv1alpha1.StateStoreSpec{
+					URL:            "s3://kops-team-a",
+					CredentialsRef: tt.credentials,
+				},
+			}
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "operator", Name: "aws"},
+				Data: map[string][]byte{
+					clusteroperatorv1alpha1.CredentialsAccessKeyID:     []byte("AKIA"),
+					clusteroperatorv1alpha1.CredentialsSecretAccessKey: []byte("secret"),
+				},
+			}
+
+			var probed *kops.Credentials
+			r := &ReconcileStateStore{
+				client: fake.NewFakeClientWithScheme(s, store, secret),
+				probe: func(store *clusteroperatorv1alpha1.StateStore, credentials *kops.Credentials) error {
+					probed = credentials
+					return tt.probeErr
+				},
+			}
+
+			res, err := r.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "team-a"}})
+			if err != nil {
+				t.Fatal("Expected no error got", err)
+			}
+			if res.RequeueAfter == 0 {
+				t.Error("Expected the store to be probed again")
+			}
+
+			updated := &clusteroperatorv1alpha1.StateStore{}
+			if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "team-a"}, updated); err != nil {
+				t.Fatal(err)
+			}
+			if updated.Status.Reachable != tt.reachable {
+				t.Error("Expected reachable", tt.reachable, "got", updated.Status)
+			}
+			if updated.Status.LastProbeTime == nil {
+				t.Error("Expected probe time to be set")
+			}
+			if !tt.reachable && updated.Status.Message == "" {
+				t.Error("Expected a message for unreachable stores")
+			}
+			if tt.name == "credentials" && (probed == nil || probed.AccessKeyID != "AKIA") {
+				t.Error("Expected probe with the store credentials got", probed)
+			}
+		})
+	}
+}
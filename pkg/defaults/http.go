@@ -0,0 +1,112 @@
+package defaults
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+)
+
+// HTTPSource posts the Query as JSON to a URL which answers with the
+// KopsConfig overrides as JSON, or 404 when it has none. Answers are cached
+// for the TTL so reconciles do not hammer the service.
+type HTTPSource struct {
+	url    string
+	client *http.Client
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	config  *clusteroperatorv1alpha1.KopsConfig
+	expires time.Time
+}
+
+// NewHTTPSource returns a Source giving up on requests after the
+// timeout and caching answers for the ttl
+func NewHTTPSource(url string, timeout, ttl time.Duration) *HTTPSource {
+	return &HTTPSource{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+		ttl:    ttl,
+		now:    time.Now,
+		cache:  map[string]cacheEntry{},
+	}
+}
+
+func (s *HTTPSource) String() string {
+	return s.url
+}
+
+// Lookup returns the cached overrides of the Query or asks the service.
+// Failures are not cached.
+func (s *HTTPSource) Lookup(q Query) (*clusteroperatorv1alpha1.KopsConfig, error) {
+	body, err := json.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+	key := string(body)
+
+	s.mu.Lock()
+	entry, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok && s.now().Before(entry.expires) {
+		return entry.config, nil
+	}
+
+	config, err := s.post(body)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.evict()
+	s.cache[key] = cacheEntry{config: config, expires: s.now().Add(s.ttl)}
+	s.mu.Unlock()
+	return config, nil
+}
+
+// evict drops the expired answers, e.g. of deleted Clusters or of changed
+// labels, which would never be asked for again. The caller holds mu.
+func (s *HTTPSource) evict() {
+	now := s.now()
+	for key, entry := range s.cache {
+		if !now.Before(entry.expires) {
+			delete(s.cache, key)
+		}
+	}
+}
+
+func (s *HTTPSource) post(body []byte) (*clusteroperatorv1alpha1.KopsConfig, error) {
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%s answered %s: %s", s.url, resp.Status, bytes.TrimSpace(data))
+	}
+
+	config := &clusteroperatorv1alpha1.KopsConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%s answered invalid JSON: %v", s.url, err)
+	}
+	return config, nil
+}
@@ -0,0 +1,102 @@
+package defaults
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newFakeCMDB answers with the overrides of the team label of the query and
+// counts the requests
+func newFakeCMDB(teams map[string]clusteroperatorv1alpha1.KopsConfig, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		q := Query{}
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		config, ok := teams[q.Labels["team"]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(config)
+	}))
+}
+
+func TestHTTPSource(t *testing.T) {
+	requests := 0
+	server := newFakeCMDB(map[string]clusteroperatorv1alpha1.KopsConfig{
+		"a": {WorkerCount: 4, Zones: []string{"us-west-2a"}},
+	}, &requests)
+	defer server.Close()
+
+	s := NewHTTPSource(server.URL, time.Second, time.Minute)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	cluster := &clusteroperatorv1alpha1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "example",
+			Namespace:   "test",
+			Labels:      map[string]string{"team": "a"},
+			Annotations: map[string]string{lastAppliedAnnotation: "{}"},
+		},
+	}
+	q := QueryFor(cluster)
+	if q.Annotations != nil {
+		t.Error("Expected last applied configuration to be left out got", q.Annotations)
+	}
+	config, err := s.Lookup(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config == nil || config.WorkerCount != 4 || len(config.Zones) != 1 {
+		t.Error("Expected overrides of team a got", config)
+	}
+
+	if _, err := s.Lookup(q); err != nil || requests != 1 {
+		t.Error("Expected cached answer got", requests, "requests", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := s.Lookup(q); err != nil || requests != 2 {
+		t.Error("Expected expired answer to be refreshed got", requests, "requests", err)
+	}
+
+	cluster.Labels["team"] = "b"
+	if config, err := s.Lookup(QueryFor(cluster)); err != nil || config != nil {
+		t.Error("Expected no overrides for unknown team got", config, err)
+	}
+	now = now.Add(2 * time.Minute)
+	cluster.Labels["team"] = "c"
+	if _, err := s.Lookup(QueryFor(cluster)); err != nil || len(s.cache) != 1 {
+		t.Error("Expected expired answers to be evicted got", len(s.cache), "cached", err)
+	}
+}
+
+func TestHTTPSourceFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := NewHTTPSource(server.URL, time.Second, time.Minute).Lookup(Query{Name: "example"})
+	if err == nil || !strings.Contains(err.Error(), "database unavailable") {
+		t.Error("Expected service error got", err)
+	}
+
+	_, err = NewHTTPSource(server.URL+"/slow", 50*time.Millisecond, time.Minute).Lookup(Query{Name: "example"})
+	if err == nil || !strings.Contains(err.Error(), "Timeout") {
+		t.Error("Expected timeout got", err)
+	}
+}
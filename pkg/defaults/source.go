@@ -0,0 +1,46 @@
+// Package defaults looks up the kops config of new Clusters in external
+// systems such as a CMDB
+package defaults
+
+import (
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+)
+
+// lastAppliedAnnotation is left out of queries, it holds the whole object
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// Query identifies the Cluster defaults are looked up for. The labels and
+// annotations carry the infra info, e.g. environment, region and team.
+type Query struct {
+	Namespace   string            `json:"namespace"`
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// QueryFor returns the Query of the Cluster
+func QueryFor(cluster *clusteroperatorv1alpha1.Cluster) Query {
+	q := Query{
+		Namespace: cluster.Namespace,
+		Name:      cluster.Name,
+		Labels:    cluster.Labels,
+	}
+	for k, v := range cluster.Annotations {
+		if k == lastAppliedAnnotation {
+			continue
+		}
+		if q.Annotations == nil {
+			q.Annotations = map[string]string{}
+		}
+		q.Annotations[k] = v
+	}
+	return q
+}
+
+// Source looks up KopsConfig overrides of new Clusters
+type Source interface {
+	// Lookup returns nil when the source has no overrides for the Cluster
+	Lookup(q Query) (*clusteroperatorv1alpha1.KopsConfig, error)
+	// String names the source in the Cluster status
+	String() string
+}
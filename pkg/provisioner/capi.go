@@ -0,0 +1,607 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// CAPIRefAnnotation marks the objects of the infrastructure provider
+	// template the Cluster and MachineDeployment reference, see the capiRef
+	// values
+	CAPIRefAnnotation = "cluster-operator.infobloxopen.github.com/capi-ref"
+	// ClusterLabel is set to the name of the Cluster on the objects created
+	// for it
+	ClusterLabel = "cluster-operator.infobloxopen.github.com/cluster"
+
+	capiVersion = "cluster.x-k8s.io/v1alpha3"
+	// capiClusterNameLabel is the label Cluster API selects the machines of
+	// a cluster with
+	capiClusterNameLabel = "cluster.x-k8s.io/cluster-name"
+	// capiControlPlaneLabel is set on control plane machines
+	capiControlPlaneLabel = "cluster.x-k8s.io/control-plane"
+	// capiRestartedAtAnnotation is changed on the machine template of the
+	// MachineDeployment to replace every machine
+	capiRestartedAtAnnotation = "cluster-operator.infobloxopen.github.com/restarted-at"
+)
+
+// capiRef values of CAPIRefAnnotation
+const (
+	refInfrastructure       = "infrastructure"
+	refControlPlane         = "control-plane"
+	refWorkerInfrastructure = "worker-infrastructure"
+	refWorkerBootstrap      = "worker-bootstrap"
+)
+
+// capdTemplate is the infrastructure provider template used when
+// capi.template is not set, it runs the clusters in docker with CAPD
+const capdTemplate = `apiVersion: cluster.x-k8s.io/v1alpha3
+kind: Cluster
+spec:
+  clusterNetwork:
+    pods:
+      cidrBlocks:
+      - 192.168.0.0/16
+    services:
+      cidrBlocks:
+      - 10.128.0.0/12
+    serviceDomain: cluster.local
+---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha3
+kind: DockerCluster
+metadata:
+  name: {{ .Name }}
+  annotations:
+    cluster-operator.infobloxopen.github.com/capi-ref: infrastructure
+---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha3
+kind: DockerMachineTemplate
+metadata:
+  name: {{ .Name }}-control-plane
+spec:
+  template:
+    spec:
+      extraMounts:
+      - containerPath: /var/run/docker.sock
+        hostPath: /var/run/docker.sock
+---
+apiVersion: controlplane.cluster.x-k8s.io/v1alpha3
+kind: KubeadmControlPlane
+metadata:
+  name: {{ .Name }}-control-plane
+  annotations:
+    cluster-operator.infobloxopen.github.com/capi-ref: control-plane
+spec:
+  replicas: {{ .ControlPlaneReplicas }}
+  version: {{ .KubernetesVersion }}
+  infrastructureTemplate:
+    apiVersion: infrastructure.cluster.x-k8s.io/v1alpha3
+    kind: DockerMachineTemplate
+    name: {{ .Name }}-control-plane
+  kubeadmConfigSpec:
+    clusterConfiguration:
+      controllerManager:
+        extraArgs:
+          enable-hostpath-provisioner: "true"
+    initConfiguration:
+      nodeRegistration:
+        kubeletExtraArgs:
+          eviction-hard: nodefs.available<0%,nodefs.inodesFree<0%,imagefs.available<0%
+    joinConfiguration:
+      nodeRegistration:
+        kubeletExtraArgs:
+          eviction-hard: nodefs.available<0%,nodefs.inodesFree<0%,imagefs.available<0%
+---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha3
+kind: DockerMachineTemplate
+metadata:
+  name: {{ .Name }}-md-0
+  annotations:
+    cluster-operator.infobloxopen.github.com/capi-ref: worker-infrastructure
+spec:
+  template:
+    spec: {}
+---
+apiVersion: bootstrap.cluster.x-k8s.io/v1alpha3
+kind: KubeadmConfigTemplate
+metadata:
+  name: {{ .Name }}-md-0
+  annotations:
+    cluster-operator.infobloxopen.github.com/capi-ref: worker-bootstrap
+spec:
+  template:
+    spec:
+      joinConfiguration:
+        nodeRegistration:
+          kubeletExtraArgs:
+            eviction-hard: nodefs.available<0%,nodefs.inodesFree<0%,imagefs.available<0%
+`
+
+// capiValues are passed to the infrastructure provider template
+type capiValues struct {
+	Name                 string
+	Namespace            string
+	KubernetesVersion    string
+	ControlPlaneReplicas int
+	WorkerReplicas       int
+	// Values are the values of the Cluster
+	Values map[string]string
+}
+
+// capi creates a Cluster API Cluster named after the Cluster resource in its
+// namespace, with a MachineDeployment for the workers. The infrastructure,
+// control plane and bootstrap objects come from the capi.template file.
+// The kops names passed to the methods are ignored.
+type capi struct {
+	client    client.Client
+	name      string
+	namespace string
+	values    map[string]string
+	template  string
+	// now is replaced by tests
+	now func() time.Time
+}
+
+// NewCAPI returns the provisioner of the Cluster API cluster of the Cluster
+func NewCAPI(c client.Client, instance *clusteroperatorv1alpha1.Cluster) (Provisioner, error) {
+	p := &capi{
+		client:    c,
+		name:      instance.Name,
+		namespace: instance.Namespace,
+		values:    instance.Spec.Values,
+		template:  capdTemplate,
+		now:       time.Now,
+	}
+	if path := viper.GetString("capi.template"); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		p.template = string(data)
+	}
+	return p, nil
+}
+
+func (p *capi) machineDeploymentName() string {
+	return p.name + "-md-0"
+}
+
+// objects renders the template and returns the objects of the cluster, the
+// Cluster and the MachineDeployment come last
+func (p *capi) objects(values capiValues) ([]*unstructured.Unstructured, error) {
+	tmpl, err := template.New("capi").Option("missingkey=error").Parse(p.template)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, values); err != nil {
+		return nil, err
+	}
+
+	cluster := p.object(capiVersion, "Cluster", p.name)
+	machineDeployment := p.object(capiVersion, "MachineDeployment", p.machineDeploymentName())
+	refs := map[string]map[string]interface{}{}
+	var objects []*unstructured.Unstructured
+	for _, doc := range strings.Split(out.String(), "\n---") {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		o := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(doc), &o.Object); err != nil {
+			return nil, err
+		}
+		if len(o.Object) == 0 {
+			continue
+		}
+		// Cluster and MachineDeployment documents are the base of the ones
+		// the operator creates
+		if o.GetAPIVersion() == capiVersion && (o.GetKind() == "Cluster" || o.GetKind() == "MachineDeployment") {
+			base := cluster
+			if o.GetKind() == "MachineDeployment" {
+				base = machineDeployment
+			}
+			if spec, ok := o.Object["spec"].(map[string]interface{}); ok {
+				base.Object["spec"] = spec
+			}
+			continue
+		}
+		if o.GetName() == "" {
+			return nil, fmt.Errorf("%s in capi template has no name", o.GetKind())
+		}
+		if ref := o.GetAnnotations()[CAPIRefAnnotation]; ref != "" {
+			refs[ref] = map[string]interface{}{
+				"apiVersion": o.GetAPIVersion(),
+				"kind":       o.GetKind(),
+				"name":       o.GetName(),
+			}
+		}
+		o.SetNamespace(p.namespace)
+		o.SetLabels(mergeLabels(o.GetLabels(), map[string]string{ClusterLabel: p.name}))
+		objects = append(objects, o)
+	}
+
+	for _, ref := range []string{refInfrastructure, refControlPlane, refWorkerInfrastructure, refWorkerBootstrap} {
+		if refs[ref] == nil {
+			return nil, fmt.Errorf("capi template has no object annotated %s: %s", CAPIRefAnnotation, ref)
+		}
+		refs[ref]["namespace"] = p.namespace
+	}
+
+	selector := map[string]interface{}{capiClusterNameLabel: p.name}
+	fields := []struct {
+		o     *unstructured.Unstructured
+		value interface{}
+		path  []string
+	}{
+		{cluster, refs[refInfrastructure], []string{"spec", "infrastructureRef"}},
+		{cluster, refs[refControlPlane], []string{"spec", "controlPlaneRef"}},
+		{machineDeployment, p.name, []string{"spec", "clusterName"}},
+		{machineDeployment, int64(values.WorkerReplicas), []string{"spec", "replicas"}},
+		{machineDeployment, selector, []string{"spec", "selector", "matchLabels"}},
+		{machineDeployment, selector, []string{"spec", "template", "metadata", "labels"}},
+		{machineDeployment, p.name, []string{"spec", "template", "spec", "clusterName"}},
+		{machineDeployment, values.KubernetesVersion, []string{"spec", "template", "spec", "version"}},
+		{machineDeployment, refs[refWorkerBootstrap], []string{"spec", "template", "spec", "bootstrap", "configRef"}},
+		{machineDeployment, refs[refWorkerInfrastructure], []string{"spec", "template", "spec", "infrastructureRef"}},
+	}
+	for _, f := range fields {
+		if err := unstructured.SetNestedField(f.o.Object, runtimeValue(f.value), f.path...); err != nil {
+			return nil, err
+		}
+	}
+	return append(objects, cluster, machineDeployment), nil
+}
+
+// object returns an empty object of the cluster
+func (p *capi) object(apiVersion, kind, name string) *unstructured.Unstructured {
+	o := &unstructured.Unstructured{}
+	o.SetAPIVersion(apiVersion)
+	o.SetKind(kind)
+	o.SetNamespace(p.namespace)
+	o.SetName(name)
+	o.SetLabels(map[string]string{ClusterLabel: p.name})
+	return o
+}
+
+// runtimeValue deep copies maps so they can be set on several objects
+func runtimeValue(v interface{}) interface{} {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		c := map[string]interface{}{}
+		for k, v := range m {
+			c[k] = runtimeValue(v)
+		}
+		return c
+	}
+	return v
+}
+
+func mergeLabels(labels, extra map[string]string) map[string]string {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for k, v := range extra {
+		labels[k] = v
+	}
+	return labels
+}
+
+// ReplaceCluster creates the objects of the cluster and updates the spec of
+// the existing ones. Machine templates are treated as immutable, like
+// Cluster API does, changes to them only apply to new clusters.
+func (p *capi) ReplaceCluster(cluster clusteroperatorv1alpha1.ClusterSpec) error {
+	masters, workers, err := nodeCounts(cluster)
+	if err != nil {
+		return err
+	}
+	if masters == 0 {
+		masters = 1
+	}
+	m, err := kops.ParseManifest(cluster.Config)
+	if err != nil {
+		return err
+	}
+	version := m.KubernetesVersion()
+	if version != "" && !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	objects, err := p.objects(capiValues{
+		Name:                 p.name,
+		Namespace:            p.namespace,
+		KubernetesVersion:    version,
+		ControlPlaneReplicas: masters,
+		WorkerReplicas:       workers,
+		Values:               p.values,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, o := range objects {
+		existing := &unstructured.Unstructured{}
+		existing.SetGroupVersionKind(o.GroupVersionKind())
+		err := p.client.Get(context.TODO(), types.NamespacedName{Namespace: o.GetNamespace(), Name: o.GetName()}, existing)
+		if errors.IsNotFound(err) {
+			if err := p.client.Create(context.TODO(), o); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(o.GetKind(), "Template") {
+			continue
+		}
+
+		// Fields Cluster API sets, such as the control plane endpoint, are kept
+		spec, _, _ := unstructured.NestedMap(existing.Object, "spec")
+		desired, _, _ := unstructured.NestedMap(o.Object, "spec")
+		merged := mergeMaps(runtimeValue(spec).(map[string]interface{}), desired)
+		if reflect.DeepEqual(spec, merged) {
+			continue
+		}
+		if err := unstructured.SetNestedMap(existing.Object, merged, "spec"); err != nil {
+			return err
+		}
+		if err := p.client.Update(context.TODO(), existing); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeMaps sets the fields of src on dst, nested maps are merged
+func mergeMaps(dst, src map[string]interface{}) map[string]interface{} {
+	for k, v := range src {
+		if m, ok := v.(map[string]interface{}); ok {
+			if d, ok := dst[k].(map[string]interface{}); ok {
+				dst[k] = mergeMaps(d, m)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+// UpdateCluster does nothing, the Cluster API controllers apply the objects
+func (p *capi) UpdateCluster(kc clusteroperatorv1alpha1.KopsConfig) error {
+	return nil
+}
+
+// GetCluster reports if any object of the cluster is left
+func (p *capi) GetCluster(kc clusteroperatorv1alpha1.KopsConfig) (bool, error) {
+	objects, err := p.existing()
+	return len(objects) > 0, err
+}
+
+// existing returns the objects of the cluster that exist, the Cluster first
+func (p *capi) existing() ([]*unstructured.Unstructured, error) {
+	objects, err := p.objects(capiValues{Name: p.name, Namespace: p.namespace, Values: p.values})
+	if err != nil {
+		return nil, err
+	}
+	// The Cluster and MachineDeployment are rendered last
+	n := len(objects)
+	objects = append(objects[n-2:], objects[:n-2]...)
+
+	var found []*unstructured.Unstructured
+	for _, o := range objects {
+		existing := &unstructured.Unstructured{}
+		existing.SetGroupVersionKind(o.GroupVersionKind())
+		err := p.client.Get(context.TODO(), types.NamespacedName{Namespace: o.GetNamespace(), Name: o.GetName()}, existing)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, existing)
+	}
+	return found, nil
+}
+
+// RollingUpdateCluster does nothing, Cluster API rolls out spec changes
+func (p *capi) RollingUpdateCluster(kc clusteroperatorv1alpha1.KopsConfig) error {
+	return nil
+}
+
+// ForceRollingUpdateCluster replaces the control plane machines with
+// upgradeAfter and the workers by changing their template
+func (p *capi) ForceRollingUpdateCluster(kc clusteroperatorv1alpha1.KopsConfig) error {
+	objects, err := p.existing()
+	if err != nil {
+		return err
+	}
+	now := p.now().UTC().Format(time.RFC3339)
+	for _, o := range objects {
+		switch {
+		case o.GetKind() == "MachineDeployment":
+			err = unstructured.SetNestedField(o.Object, now, "spec", "template", "metadata", "annotations", capiRestartedAtAnnotation)
+		case o.GetAnnotations()[CAPIRefAnnotation] == refControlPlane:
+			err = unstructured.SetNestedField(o.Object, now, "spec", "upgradeAfter")
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := p.client.Update(context.TODO(), o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RollingUpdateRole does nothing, Cluster API rolls out spec changes
+func (p *capi) RollingUpdateRole(kc clusteroperatorv1alpha1.KopsConfig, role string) error {
+	return nil
+}
+
+// ReplaceInstanceGroup fails, Cluster API clusters have no instance groups
+func (p *capi) ReplaceInstanceGroup(kc clusteroperatorv1alpha1.KopsConfig, manifest string) error {
+	return fmt.Errorf("Cluster API clusters do not support node pools")
+}
+
+// RollingUpdateInstanceGroup fails, Cluster API clusters have no instance groups
+func (p *capi) RollingUpdateInstanceGroup(kc clusteroperatorv1alpha1.KopsConfig, name string) error {
+	return fmt.Errorf("Cluster API clusters do not support node pools")
+}
+
+// DeleteInstanceGroup does nothing, the group was never created
+func (p *capi) DeleteInstanceGroup(kc clusteroperatorv1alpha1.KopsConfig, name string) error {
+	return nil
+}
+
+// GetInstanceGroups returns nothing, Cluster API clusters have no instance groups
+func (p *capi) GetInstanceGroups(kc clusteroperatorv1alpha1.KopsConfig) ([]clusteroperatorv1alpha1.InstanceGroupStatus, error) {
+	return nil, nil
+}
+
+// ValidateCluster reports the machines of the cluster as nodes, it fails
+// until the infrastructure and control plane are ready and every machine
+// is running
+func (p *capi) ValidateCluster(kc clusteroperatorv1alpha1.KopsConfig) (clusteroperatorv1alpha1.KopsStatus, error) {
+	status := clusteroperatorv1alpha1.KopsStatus{}
+	cluster := p.object(capiVersion, "Cluster", p.name)
+	if err := p.client.Get(context.TODO(), types.NamespacedName{Namespace: p.namespace, Name: p.name}, cluster); err != nil {
+		return status, err
+	}
+	for _, field := range []string{"infrastructureReady", "controlPlaneReady"} {
+		if ready, _, _ := unstructured.NestedBool(cluster.Object, "status", field); !ready {
+			status.Failures = append(status.Failures, clusteroperatorv1alpha1.KopsFailure{
+				Type:    "Cluster",
+				Name:    p.name,
+				Message: "Cluster API reports " + field + " false",
+			})
+		}
+	}
+
+	machines := &unstructured.UnstructuredList{}
+	machines.SetAPIVersion(capiVersion)
+	machines.SetKind("MachineList")
+	if err := p.client.List(context.TODO(), machines, client.InNamespace(p.namespace),
+		client.MatchingLabels{capiClusterNameLabel: p.name}); err != nil {
+		return status, err
+	}
+	for _, m := range machines.Items {
+		phase, _, _ := unstructured.NestedString(m.Object, "status", "phase")
+		nodeName, _, _ := unstructured.NestedString(m.Object, "status", "nodeRef", "name")
+		if phase != "Running" || nodeName == "" {
+			status.Failures = append(status.Failures, clusteroperatorv1alpha1.KopsFailure{
+				Type:    "Machine",
+				Name:    m.GetName(),
+				Message: fmt.Sprintf("machine %q is %s", m.GetName(), strings.ToLower(phase)),
+			})
+			continue
+		}
+		node := clusteroperatorv1alpha1.KopsNode{
+			Name:     nodeName,
+			Role:     "node",
+			Hostname: nodeName,
+			Status:   string(corev1.ConditionTrue),
+		}
+		node.Zone, _, _ = unstructured.NestedString(m.Object, "spec", "failureDomain")
+		if _, ok := m.GetLabels()[capiControlPlaneLabel]; ok {
+			node.Role = "master"
+		}
+		status.Nodes = append(status.Nodes, node)
+	}
+	if len(machines.Items) == 0 {
+		status.Failures = append(status.Failures, clusteroperatorv1alpha1.KopsFailure{
+			Type:    "Machine",
+			Name:    p.name,
+			Message: "the cluster has no machines",
+		})
+	}
+	if len(status.Failures) > 0 {
+		return status, fmt.Errorf("Validation Failed: %d failures", len(status.Failures))
+	}
+	return status, nil
+}
+
+// GetKubeConfig reads the kubeconfig Secret Cluster API creates
+func (p *capi) GetKubeConfig(kc clusteroperatorv1alpha1.KopsConfig) (clusteroperatorv1alpha1.KubeConfig, error) {
+	config := clusteroperatorv1alpha1.KubeConfig{}
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: p.namespace, Name: p.name + "-kubeconfig"}
+	if err := p.client.Get(context.TODO(), key, secret); err != nil {
+		return config, err
+	}
+	data, ok := secret.Data["value"]
+	if !ok {
+		return config, fmt.Errorf("secret %s has no key value", key)
+	}
+	err := yaml.Unmarshal(data, &config)
+	return config, err
+}
+
+// DeleteCluster deletes the Cluster, the other objects once Cluster API
+// removed it. It fails while the deletion is in progress so it is retried.
+func (p *capi) DeleteCluster(kc clusteroperatorv1alpha1.KopsConfig) error {
+	objects, err := p.existing()
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		if o.GetAPIVersion() == capiVersion && o.GetKind() == "Cluster" {
+			if o.GetDeletionTimestamp() == nil {
+				if err := p.client.Delete(context.TODO(), o); err != nil && !errors.IsNotFound(err) {
+					return err
+				}
+			}
+			return fmt.Errorf("waiting for Cluster API to delete cluster %s", p.name)
+		}
+		if err := p.client.Delete(context.TODO(), o); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteClusterPreview lists the objects of the cluster
+func (p *capi) DeleteClusterPreview(kc clusteroperatorv1alpha1.KopsConfig) (string, error) {
+	objects, err := p.existing()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString("KIND\tNAME\n")
+	for _, o := range objects {
+		fmt.Fprintf(&b, "%s\t%s\n", o.GetKind(), o.GetName())
+	}
+	return b.String(), nil
+}
+
+// ListClusters returns no clusters, Cluster API clusters do not belong to a
+// state store and are never reaped
+func (p *capi) ListClusters(stateStore string) ([]string, error) {
+	return nil, nil
+}
+
+// Version returns no kops version, kops does not limit the Kubernetes
+// versions of Cluster API clusters
+func (p *capi) Version() (string, error) {
+	return "", nil
+}
+
+func (p *capi) CheckCompatibility(kubernetesVersion string) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,197 @@
+package provisioner
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// capiScheme registers the Cluster API kinds of the CAPD template as
+// unstructured objects
+func capiScheme() *runtime.Scheme {
+	s := runtime.NewScheme()
+	if err := scheme.AddToScheme(s); err != nil {
+		panic(err)
+	}
+	for _, gvk := range []schema.GroupVersionKind{
+		{Group: "cluster.x-k8s.io", Version: "v1alpha3", Kind: "Cluster"},
+		{Group: "cluster.x-k8s.io", Version: "v1alpha3", Kind: "MachineDeployment"},
+		{Group: "cluster.x-k8s.io", Version: "v1alpha3", Kind: "Machine"},
+		{Group: "infrastructure.cluster.x-k8s.io", Version: "v1alpha3", Kind: "DockerCluster"},
+		{Group: "infrastructure.cluster.x-k8s.io", Version: "v1alpha3", Kind: "DockerMachineTemplate"},
+		{Group: "controlplane.cluster.x-k8s.io", Version: "v1alpha3", Kind: "KubeadmControlPlane"},
+		{Group: "bootstrap.cluster.x-k8s.io", Version: "v1alpha3", Kind: "KubeadmConfigTemplate"},
+	} {
+		s.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+		list := gvk
+		list.Kind += "List"
+		s.AddKnownTypeWithName(list, &unstructured.UnstructuredList{})
+	}
+	return s
+}
+
+func testMachine(name string, phase string, controlPlane bool) *unstructured.Unstructured {
+	m := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec":   map[string]interface{}{"failureDomain": "us-east-2a"},
+		"status": map[string]interface{}{"phase": phase, "nodeRef": map[string]interface{}{"name": name}},
+	}}
+	m.SetAPIVersion(capiVersion)
+	m.SetKind("Machine")
+	m.SetNamespace("test")
+	m.SetName(name)
+	labels := map[string]string{capiClusterNameLabel: "example"}
+	if controlPlane {
+		labels[capiControlPlaneLabel] = ""
+	}
+	m.SetLabels(labels)
+	return m
+}
+
+func getObject(c client.Client, apiVersion, kind, name string) (*unstructured.Unstructured, error) {
+	o := &unstructured.Unstructured{}
+	o.SetAPIVersion(apiVersion)
+	o.SetKind(kind)
+	err := c.Get(context.TODO(), types.NamespacedName{Namespace: "test", Name: name}, o)
+	return o, err
+}
+
+func TestCAPI(t *testing.T) {
+	c := fake.NewFakeClientWithScheme(capiScheme())
+	instance := &clusteroperatorv1alpha1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "test"}}
+	p, err := NewCAPI(c, instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kc := clusteroperatorv1alpha1.KopsConfig{Name: "example.k8s.local"}
+	spec := clusteroperatorv1alpha1.ClusterSpec{Config: testManifest, KopsConfig: kc}
+
+	if exists, err := p.GetCluster(kc); exists || err != nil {
+		t.Error("Expected no cluster before the replace got", exists, err)
+	}
+	if err := p.ReplaceCluster(spec); err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	if exists, err := p.GetCluster(kc); !exists || err != nil {
+		t.Error("Expected the cluster to exist got", exists, err)
+	}
+
+	cluster, err := getObject(c, capiVersion, "Cluster", "example")
+	if err != nil {
+		t.Fatal("Expected the Cluster API Cluster got", err)
+	}
+	if kind, _, _ := unstructured.NestedString(cluster.Object, "spec", "controlPlaneRef", "kind"); kind != "KubeadmControlPlane" {
+		t.Error("Expected the control plane reference got", cluster.Object["spec"])
+	}
+	if cidrs, _, _ := unstructured.NestedStringSlice(cluster.Object, "spec", "clusterNetwork", "pods", "cidrBlocks"); len(cidrs) != 1 {
+		t.Error("Expected the cluster network of the template got", cluster.Object["spec"])
+	}
+	if cluster.GetLabels()[ClusterLabel] != "example" {
+		t.Error("Expected the Cluster label got", cluster.GetLabels())
+	}
+
+	md, err := getObject(c, capiVersion, "MachineDeployment", "example-md-0")
+	if err != nil {
+		t.Fatal("Expected the MachineDeployment got", err)
+	}
+	if replicas, _, _ := unstructured.NestedInt64(md.Object, "spec", "replicas"); replicas != 2 {
+		t.Error("Expected the minSize of the nodes group as replicas got", replicas)
+	}
+	if version, _, _ := unstructured.NestedString(md.Object, "spec", "template", "spec", "version"); version != "v1.16.7" {
+		t.Error("Expected the Kubernetes version of the manifest got", version)
+	}
+	if name, _, _ := unstructured.NestedString(md.Object, "spec", "template", "spec", "bootstrap", "configRef", "name"); name != "example-md-0" {
+		t.Error("Expected the bootstrap reference got", name)
+	}
+
+	// Replacing with more workers updates the MachineDeployment
+	spec.KopsConfig.WorkerCount = 3
+	if err := p.ReplaceCluster(spec); err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	md, _ = getObject(c, capiVersion, "MachineDeployment", "example-md-0")
+	if replicas, _, _ := unstructured.NestedInt64(md.Object, "spec", "replicas"); replicas != 3 {
+		t.Error("Expected the replicas to be updated got", replicas)
+	}
+
+	if _, err := p.ValidateCluster(kc); err == nil {
+		t.Error("Expected the cluster not to be ready")
+	}
+	unstructured.SetNestedField(cluster.Object, true, "status", "infrastructureReady")
+	unstructured.SetNestedField(cluster.Object, true, "status", "controlPlaneReady")
+	if err := c.Update(context.TODO(), cluster); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*unstructured.Unstructured{
+		testMachine("example-control-plane-1", "Running", true),
+		testMachine("example-md-0-1", "Provisioning", false),
+	} {
+		if err := c.Create(context.TODO(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status, err := p.ValidateCluster(kc)
+	if err == nil || len(status.Failures) != 1 || status.Failures[0].Name != "example-md-0-1" {
+		t.Error("Expected the provisioning machine to fail validation got", status, err)
+	}
+	if len(status.Nodes) != 1 || status.Nodes[0].Role != "master" || status.Nodes[0].Zone != "us-east-2a" {
+		t.Error("Expected the running control plane machine as master got", status.Nodes)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "example-kubeconfig"},
+		Data:       map[string][]byte{"value": []byte(testKindKubeConfig)},
+	}
+	if err := c.Create(context.TODO(), secret); err != nil {
+		t.Fatal(err)
+	}
+	config, err := p.GetKubeConfig(kc)
+	if err != nil || config.CurrentContext != "kind-example" {
+		t.Error("Expected the kubeconfig of Cluster API got", config, err)
+	}
+
+	p.(*capi).now = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+	if err := p.ForceRollingUpdateCluster(kc); err != nil {
+		t.Fatal(err)
+	}
+	kcp, _ := getObject(c, "controlplane.cluster.x-k8s.io/v1alpha3", "KubeadmControlPlane", "example-control-plane")
+	if after, _, _ := unstructured.NestedString(kcp.Object, "spec", "upgradeAfter"); after != "2020-01-02T03:04:05Z" {
+		t.Error("Expected the control plane to be rolled got", after)
+	}
+
+	if preview, err := p.DeleteClusterPreview(kc); err != nil || !strings.Contains(preview, "KubeadmControlPlane") {
+		t.Error("Expected the preview to list the objects got", preview, err)
+	}
+	// The Cluster is deleted first, the fake client removes it at once
+	if err := p.DeleteCluster(kc); err == nil {
+		t.Error("Expected to wait for the Cluster deletion")
+	}
+	if err := p.DeleteCluster(kc); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := p.GetCluster(kc); exists || err != nil {
+		t.Error("Expected every object to be deleted got", exists, err)
+	}
+}
+
+func TestCAPITemplate(t *testing.T) {
+	p := &capi{name: "example", namespace: "test", template: "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: example\n"}
+	if _, err := p.objects(capiValues{Name: "example"}); err == nil {
+		t.Error("Expected a template without references to fail")
+	}
+	p.template = "{{ .Unknown }}"
+	if _, err := p.objects(capiValues{Name: "example"}); err == nil {
+		t.Error("Expected unknown template values to fail")
+	}
+}
@@ -0,0 +1,284 @@
+package provisioner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/infobloxopen/cluster-operator/utils"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+)
+
+// kind runs a local kind cluster named after the Cluster resource and its
+// namespace. The cluster has one control plane node and a worker node for
+// each worker of the kops config. The kops names passed to the methods are
+// ignored.
+type kind struct {
+	name string
+	path string
+	// image is the kind node image, the default of kind when empty
+	image string
+	// dir holds the kind configs and kubeconfigs of the clusters
+	dir string
+	// internal exports kubeconfigs reaching the API server on the docker
+	// network, for operators running in a container next to the nodes
+	internal bool
+	executor kops.Executor
+	// nodes lists the nodes of the cluster, tests replace it
+	nodes func(kubeconfig []byte) ([]corev1.Node, error)
+}
+
+// NewKind returns the provisioner of the kind cluster of the Cluster
+func NewKind(instance *clusteroperatorv1alpha1.Cluster) Provisioner {
+	return &kind{
+		name:     kindName(instance),
+		path:     viper.GetString("kind.path"),
+		image:    viper.GetString("kind.node.image"),
+		dir:      viper.GetString("tmp.dir"),
+		internal: viper.GetBool("kind.kubeconfig.internal"),
+		executor: kops.LocalExecutor{},
+		nodes:    listNodes,
+	}
+}
+
+// kindName returns the kind cluster name of the Cluster, Clusters of the
+// same name in other namespaces get their own kind cluster. Namespaces have
+// no dots, so names cannot collide.
+func kindName(instance *clusteroperatorv1alpha1.Cluster) string {
+	return instance.Namespace + "." + instance.Name
+}
+
+func (p *kind) configPath() string {
+	return filepath.Join(p.dir, "kind-"+p.name+".yaml")
+}
+
+func (p *kind) kubeConfigPath() string {
+	return filepath.Join(p.dir, "kind-config-"+p.name)
+}
+
+// ReplaceCluster writes the kind config of the cluster, the worker count
+// defaults to the minSize of the Node instance groups of the manifest
+func (p *kind) ReplaceCluster(cluster clusteroperatorv1alpha1.ClusterSpec) error {
+	_, workers, err := nodeCounts(cluster)
+	if err != nil {
+		return err
+	}
+
+	config := "kind: Cluster\napiVersion: kind.x-k8s.io/v1alpha4\nnodes:\n- role: control-plane\n"
+	for i := 0; i < workers; i++ {
+		config += "- role: worker\n"
+	}
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return err
+	}
+	return utils.CopyBufferContentsToFile([]byte(config), p.configPath())
+}
+
+// UpdateCluster creates the kind cluster when it does not exist. Nodes of
+// kind clusters cannot be added or replaced, config changes only take effect
+// once the Cluster is recreated.
+func (p *kind) UpdateCluster(kc clusteroperatorv1alpha1.KopsConfig) error {
+	exists, err := p.GetCluster(kc)
+	if err != nil || exists {
+		return err
+	}
+	cmd := p.path + " create cluster" +
+		" --name=" + p.name +
+		" --config=" + p.configPath() +
+		" --kubeconfig=" + p.kubeConfigPath()
+	if p.image != "" {
+		cmd += " --image=" + p.image
+	}
+	return p.executor.RunStreamingCmd(cmd)
+}
+
+func (p *kind) GetCluster(kc clusteroperatorv1alpha1.KopsConfig) (bool, error) {
+	out, err := p.executor.RunCmd(p.path + " get clusters")
+	if err != nil {
+		return false, err
+	}
+	for _, name := range strings.Split(out.String(), "\n") {
+		if strings.TrimSpace(name) == p.name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RollingUpdateCluster does nothing, kind nodes cannot be replaced
+func (p *kind) RollingUpdateCluster(kc clusteroperatorv1alpha1.KopsConfig) error {
+	return nil
+}
+
+// ForceRollingUpdateCluster does nothing, kind nodes cannot be replaced
+func (p *kind) ForceRollingUpdateCluster(kc clusteroperatorv1alpha1.KopsConfig) error {
+	return nil
+}
+
+// RollingUpdateRole does nothing, kind nodes cannot be replaced
+func (p *kind) RollingUpdateRole(kc clusteroperatorv1alpha1.KopsConfig, role string) error {
+	return nil
+}
+
+// ReplaceInstanceGroup fails, kind clusters have no instance groups
+func (p *kind) ReplaceInstanceGroup(kc clusteroperatorv1alpha1.KopsConfig, manifest string) error {
+	return fmt.Errorf("kind clusters do not support node pools")
+}
+
+// RollingUpdateInstanceGroup fails, kind clusters have no instance groups
+func (p *kind) RollingUpdateInstanceGroup(kc clusteroperatorv1alpha1.KopsConfig, name string) error {
+	return fmt.Errorf("kind clusters do not support node pools")
+}
+
+// DeleteInstanceGroup does nothing, the group was never created
+func (p *kind) DeleteInstanceGroup(kc clusteroperatorv1alpha1.KopsConfig, name string) error {
+	return nil
+}
+
+// GetInstanceGroups returns nothing, kind clusters have no instance groups
+func (p *kind) GetInstanceGroups(kc clusteroperatorv1alpha1.KopsConfig) ([]clusteroperatorv1alpha1.InstanceGroupStatus, error) {
+	return nil, nil
+}
+
+// ValidateCluster reports the nodes of the kind cluster, it fails until
+// every node is Ready
+func (p *kind) ValidateCluster(kc clusteroperatorv1alpha1.KopsConfig) (clusteroperatorv1alpha1.KopsStatus, error) {
+	status := clusteroperatorv1alpha1.KopsStatus{}
+	kubeconfig, err := p.kubeConfig()
+	if err != nil {
+		return status, err
+	}
+	nodes, err := p.nodes(kubeconfig)
+	if err != nil {
+		return status, err
+	}
+
+	for _, n := range nodes {
+		node := clusteroperatorv1alpha1.KopsNode{
+			Name:     n.Name,
+			Zone:     n.Labels["topology.kubernetes.io/zone"],
+			Role:     "node",
+			Hostname: n.Labels["kubernetes.io/hostname"],
+			Status:   string(corev1.ConditionUnknown),
+		}
+		for _, label := range []string{"node-role.kubernetes.io/master", "node-role.kubernetes.io/control-plane"} {
+			if _, ok := n.Labels[label]; ok {
+				node.Role = "master"
+			}
+		}
+		for _, c := range n.Status.Conditions {
+			if c.Type == corev1.NodeReady {
+				node.Status = string(c.Status)
+			}
+		}
+		if node.Status != string(corev1.ConditionTrue) {
+			status.Failures = append(status.Failures, clusteroperatorv1alpha1.KopsFailure{
+				Type:    "Node",
+				Name:    n.Name,
+				Message: fmt.Sprintf("node %q is not ready", n.Name),
+			})
+			continue
+		}
+		status.Nodes = append(status.Nodes, node)
+	}
+	if len(nodes) == 0 {
+		status.Failures = append(status.Failures, clusteroperatorv1alpha1.KopsFailure{
+			Type:    "Node",
+			Name:    p.name,
+			Message: "the cluster has no nodes",
+		})
+	}
+	if len(status.Failures) > 0 {
+		return status, fmt.Errorf("Validation Failed: %d failures", len(status.Failures))
+	}
+	return status, nil
+}
+
+// kubeConfig returns the admin kubeconfig kind exports for the cluster
+func (p *kind) kubeConfig() ([]byte, error) {
+	cmd := p.path + " get kubeconfig --name=" + p.name
+	if p.internal {
+		cmd += " --internal"
+	}
+	out, err := p.executor.RunCmd(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func (p *kind) GetKubeConfig(kc clusteroperatorv1alpha1.KopsConfig) (clusteroperatorv1alpha1.KubeConfig, error) {
+	config := clusteroperatorv1alpha1.KubeConfig{}
+	kubeconfig, err := p.kubeConfig()
+	if err != nil {
+		return config, err
+	}
+	err = yaml.Unmarshal(kubeconfig, &config)
+	return config, err
+}
+
+func (p *kind) DeleteCluster(kc clusteroperatorv1alpha1.KopsConfig) error {
+	err := p.executor.RunStreamingCmd(p.path + " delete cluster" +
+		" --name=" + p.name +
+		" --kubeconfig=" + p.kubeConfigPath())
+	if err != nil {
+		return err
+	}
+	for _, path := range []string{p.configPath(), p.kubeConfigPath()} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteClusterPreview lists the node containers of the cluster
+func (p *kind) DeleteClusterPreview(kc clusteroperatorv1alpha1.KopsConfig) (string, error) {
+	out, err := p.executor.RunCmd(p.path + " get nodes --name=" + p.name)
+	if err != nil {
+		return "", err
+	}
+	return "kind cluster " + p.name + "\n" + out.String(), nil
+}
+
+// ListClusters returns no clusters, kind clusters do not belong to a state
+// store and are never reaped
+func (p *kind) ListClusters(stateStore string) ([]string, error) {
+	return nil, nil
+}
+
+// Version fails, kind clusters are not upgraded in place
+func (p *kind) Version() (string, error) {
+	return "", fmt.Errorf("kind clusters cannot be upgraded, recreate the Cluster")
+}
+
+// CheckCompatibility reports an unknown kops version, the node image
+// decides the Kubernetes version of kind clusters
+func (p *kind) CheckCompatibility(kubernetesVersion string) (string, error) {
+	return "", nil
+}
+
+// listNodes returns the nodes of the cluster the kubeconfig points at
+func listNodes(kubeconfig []byte) ([]corev1.Node, error) {
+	config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := clientset.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return nodes.Items, nil
+}
@@ -0,0 +1,189 @@
+package provisioner
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testKindKubeConfig = `apiVersion: v1
+kind: Config
+current-context: kind-example
+clusters:
+- name: kind-example
+  cluster:
+    server: https://127.0.0.1:32768
+    certificate-authority-data: Y2E=
+contexts:
+- name: kind-example
+  context:
+    cluster: kind-example
+    user: kind-example
+users:
+- name: kind-example
+  user:
+    client-certificate-data: Y2VydA==
+    client-key-data: a2V5
+`
+
+// fakeKind answers the kind commands with the clusters it created
+type fakeKind struct {
+	clusters map[string]bool
+	cmds     []string
+}
+
+func (f *fakeKind) RunStreamingCmd(cmd string) error {
+	_, err := f.RunCmd(cmd)
+	return err
+}
+
+func (f *fakeKind) RunCmd(cmd string) (*bytes.Buffer, error) {
+	f.cmds = append(f.cmds, cmd)
+	var name string
+	for _, arg := range strings.Fields(cmd) {
+		if strings.HasPrefix(arg, "--name=") {
+			name = strings.TrimPrefix(arg, "--name=")
+		}
+	}
+	switch {
+	case strings.HasPrefix(cmd, "kind create cluster"):
+		f.clusters[name] = true
+	case strings.HasPrefix(cmd, "kind delete cluster"):
+		delete(f.clusters, name)
+	case strings.HasPrefix(cmd, "kind get clusters"):
+		var names []string
+		for n := range f.clusters {
+			names = append(names, n)
+		}
+		return bytes.NewBufferString(strings.Join(names, "\n") + "\n"), nil
+	case strings.HasPrefix(cmd, "kind get kubeconfig"):
+		if !f.clusters[name] {
+			return nil, fmt.Errorf("exit status 1")
+		}
+		return bytes.NewBufferString(testKindKubeConfig), nil
+	case strings.HasPrefix(cmd, "kind get nodes"):
+		return bytes.NewBufferString(name + "-control-plane\n" + name + "-worker\n"), nil
+	}
+	return &bytes.Buffer{}, nil
+}
+
+func testNode(name string, ready corev1.ConditionStatus, labels map[string]string) corev1.Node {
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+			{Type: corev1.NodeReady, Status: ready},
+		}},
+	}
+}
+
+func TestKind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	executor := &fakeKind{clusters: map[string]bool{}}
+	ready := corev1.ConditionFalse
+	p := &kind{
+		name:     "example",
+		path:     "kind",
+		dir:      dir,
+		executor: executor,
+		nodes: func(kubeconfig []byte) ([]corev1.Node, error) {
+			if string(kubeconfig) != testKindKubeConfig {
+				return nil, fmt.Errorf("unexpected kubeconfig %s", kubeconfig)
+			}
+			return []corev1.Node{
+				testNode("example-control-plane", corev1.ConditionTrue, map[string]string{"node-role.kubernetes.io/master": ""}),
+				testNode("example-worker", ready, nil),
+			}, nil
+		},
+	}
+	kc := clusteroperatorv1alpha1.KopsConfig{Name: "example.k8s.local", WorkerCount: 2}
+
+	if err := p.ReplaceCluster(clusteroperatorv1alpha1.ClusterSpec{Config: testManifest, KopsConfig: kc}); err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	config, err := ioutil.ReadFile(p.configPath())
+	if err != nil || strings.Count(string(config), "role: worker") != 2 {
+		t.Error("Expected kind config with 2 workers got", string(config), err)
+	}
+	if exists, err := p.GetCluster(kc); exists || err != nil {
+		t.Error("Expected no kind cluster before the update got", exists, err)
+	}
+
+	if err := p.UpdateCluster(kc); err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	if !executor.clusters["example"] {
+		t.Error("Expected the kind cluster to be named after the Cluster got", executor.cmds)
+	}
+	// Updating an existing cluster leaves it alone
+	if err := p.UpdateCluster(kc); err != nil || strings.Count(strings.Join(executor.cmds, "\n"), "create cluster") != 1 {
+		t.Error("Expected the cluster to be created once got", executor.cmds, err)
+	}
+
+	kubeconfig, err := p.GetKubeConfig(kc)
+	if err != nil || kubeconfig.CurrentContext != "kind-example" || kubeconfig.Users[0].Users.ClientKeyData != "a2V5" {
+		t.Error("Expected the kubeconfig of kind got", kubeconfig, err)
+	}
+
+	status, err := p.ValidateCluster(kc)
+	if err == nil || len(status.Failures) != 1 || status.Failures[0].Name != "example-worker" {
+		t.Error("Expected the worker not to be ready got", status, err)
+	}
+	ready = corev1.ConditionTrue
+	status, err = p.ValidateCluster(kc)
+	if err != nil || len(status.Nodes) != 2 || status.Nodes[0].Role != "master" || status.Nodes[1].Role != "node" {
+		t.Error("Expected a ready master and node got", status, err)
+	}
+
+	if preview, err := p.DeleteClusterPreview(kc); err != nil || !strings.Contains(preview, "example-worker") {
+		t.Error("Expected the preview to list the nodes got", preview, err)
+	}
+	if err := p.DeleteCluster(kc); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := p.GetCluster(kc); exists {
+		t.Error("Expected the kind cluster to be deleted")
+	}
+	if _, err := os.Stat(p.configPath()); !os.IsNotExist(err) {
+		t.Error("Expected the kind config to be removed got", err)
+	}
+}
+
+func TestKindName(t *testing.T) {
+	a := &clusteroperatorv1alpha1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "team-a"}}
+	b := &clusteroperatorv1alpha1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "team-b"}}
+	if kindName(a) == kindName(b) {
+		t.Error("Expected Clusters of different namespaces to get their own kind cluster got", kindName(a))
+	}
+	if name := kindName(a); name != "team-a.example" {
+		t.Error("Expected team-a.example got", name)
+	}
+}
+
+func TestKindWorkersFromManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := &kind{name: "example", dir: dir}
+	if err := p.ReplaceCluster(clusteroperatorv1alpha1.ClusterSpec{Config: testManifest}); err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	config, _ := ioutil.ReadFile(p.configPath())
+	if strings.Count(string(config), "role: worker") != 2 || strings.Count(string(config), "role: control-plane") != 1 {
+		t.Error("Expected the minSize of the nodes group as workers got", string(config))
+	}
+}
@@ -0,0 +1,74 @@
+// Package provisioner abstracts what creates and manages the cloud resources
+// of a Cluster, so the controller drives kops and the alternative providers
+// through the same phases.
+package provisioner
+
+import (
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+)
+
+// Providers selectable with spec.provider or the provider setting
+const (
+	// KopsName runs kops against the cloud of the cluster
+	KopsName = "kops"
+	// SimulatedName keeps the clusters in the memory of the operator, no
+	// cloud resources are created
+	SimulatedName = "simulated"
+	// KindName runs a local kind cluster for every Cluster
+	KindName = "kind"
+	// CAPIName creates Cluster API objects in the cluster of the operator
+	CAPIName = "capi"
+)
+
+// Names are the providers a Cluster can select
+var Names = []string{KopsName, SimulatedName, KindName, CAPIName}
+
+// Provisioner is what the controller creates, updates, validates and
+// deletes clusters with. The methods follow the kops commands.
+type Provisioner interface {
+	// ReplaceCluster stores the manifest of the spec, creating the cluster
+	// when it does not exist yet
+	ReplaceCluster(cluster clusteroperatorv1alpha1.ClusterSpec) error
+	// UpdateCluster applies the stored manifest to the cloud
+	UpdateCluster(cluster clusteroperatorv1alpha1.KopsConfig) error
+	// GetCluster reports if the cluster exists, it is unknown when an error
+	// is returned
+	GetCluster(cluster clusteroperatorv1alpha1.KopsConfig) (bool, error)
+	// RollingUpdateCluster replaces the instances not running the applied manifest
+	RollingUpdateCluster(cluster clusteroperatorv1alpha1.KopsConfig) error
+	// ForceRollingUpdateCluster replaces every instance
+	ForceRollingUpdateCluster(cluster clusteroperatorv1alpha1.KopsConfig) error
+	// RollingUpdateRole rolls the instances of the role, Master or Node
+	RollingUpdateRole(cluster clusteroperatorv1alpha1.KopsConfig, role string) error
+	// ReplaceInstanceGroup stores the manifest of a single instance group,
+	// UpdateCluster applies it
+	ReplaceInstanceGroup(cluster clusteroperatorv1alpha1.KopsConfig, manifest string) error
+	// RollingUpdateInstanceGroup rolls the instances of the group
+	RollingUpdateInstanceGroup(cluster clusteroperatorv1alpha1.KopsConfig, name string) error
+	// DeleteInstanceGroup removes the group and its instances
+	DeleteInstanceGroup(cluster clusteroperatorv1alpha1.KopsConfig, name string) error
+	// GetInstanceGroups returns the size and the on-demand/spot mix of the
+	// instance groups of the cluster
+	GetInstanceGroups(cluster clusteroperatorv1alpha1.KopsConfig) ([]clusteroperatorv1alpha1.InstanceGroupStatus, error)
+	// ValidateCluster returns the nodes of the cluster, it fails until the
+	// cluster is ready
+	ValidateCluster(cluster clusteroperatorv1alpha1.KopsConfig) (clusteroperatorv1alpha1.KopsStatus, error)
+	// GetKubeConfig returns the admin kubeconfig of the cluster
+	GetKubeConfig(cluster clusteroperatorv1alpha1.KopsConfig) (clusteroperatorv1alpha1.KubeConfig, error)
+	// DeleteCluster removes the cloud resources and the state of the cluster
+	DeleteCluster(cluster clusteroperatorv1alpha1.KopsConfig) error
+	// DeleteClusterPreview lists the resources DeleteCluster would remove
+	DeleteClusterPreview(cluster clusteroperatorv1alpha1.KopsConfig) (string, error)
+	// ListClusters returns the names of the clusters in the state store
+	ListClusters(stateStore string) ([]string, error)
+	// Version returns the kops version the provisioner behaves like, empty
+	// when kops does not limit the Kubernetes versions
+	Version() (string, error)
+	// CheckCompatibility verifies the Kubernetes version can be managed and
+	// returns the kops version, empty when it is unknown
+	CheckCompatibility(kubernetesVersion string) (string, error)
+}
+
+// blank assignment to verify that KopsCmd implements Provisioner
+var _ Provisioner = &kops.KopsCmd{}
@@ -0,0 +1,529 @@
+package provisioner
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/infobloxopen/cluster-operator/pkg/simulation"
+	"github.com/spf13/viper"
+)
+
+// SimulatedKopsVersion is the kops release the simulated provider reports
+// when the Cluster does not request one
+const SimulatedKopsVersion = "1.17.1"
+
+// Simulator keeps the state of simulated clusters for the lifetime of the
+// operator. Instances launched by updates and rolling updates only join the
+// cluster once simulated.convergence passed, so validation fails until then.
+type Simulator struct {
+	mu       sync.Mutex
+	clusters map[string]*simulatedCluster
+	// now is replaced by tests
+	now func() time.Time
+}
+
+// simulatedCluster is a cluster of a state store
+type simulatedCluster struct {
+	manifest string
+	// revisions identify the replaced manifest of every instance group,
+	// applied the ones the last update applied
+	revisions map[string]string
+	applied   map[string]string
+	instances []simulatedInstance
+	// serial numbers the instances so replaced ones get new names
+	serial int
+}
+
+// simulatedInstance is a machine of an instance group, it is ready once
+// readyAt passed
+type simulatedInstance struct {
+	name     string
+	group    string
+	role     string
+	zone     string
+	revision string
+	readyAt  time.Time
+}
+
+// NewSimulator returns a Simulator without clusters
+func NewSimulator() *Simulator {
+	return &Simulator{
+		clusters: map[string]*simulatedCluster{},
+		now:      time.Now,
+	}
+}
+
+// For returns the provisioner of the Cluster, the operations listed in its
+// SimulateFailureAnnotation fail
+func (s *Simulator) For(instance *clusteroperatorv1alpha1.Cluster) Provisioner {
+	p := &simulated{
+		simulator:   s,
+		convergence: viper.GetDuration("simulated.convergence"),
+		version:     instance.Spec.KopsVersion,
+		failures:    map[string]bool{},
+	}
+	for _, op := range strings.Split(instance.GetAnnotations()[clusteroperatorv1alpha1.SimulateFailureAnnotation], ",") {
+		if op = strings.TrimSpace(op); op != "" {
+			p.failures[op] = true
+		}
+	}
+	if p.version == "" {
+		p.version = SimulatedKopsVersion
+	}
+	return p
+}
+
+// simulated is the Provisioner of a Cluster backed by the Simulator
+type simulated struct {
+	simulator   *Simulator
+	convergence time.Duration
+	version     string
+	failures    map[string]bool
+}
+
+// Operations of the simulated provider failures can be injected into
+const (
+	opReplace       = "replace"
+	opGet           = "get"
+	opUpdate        = "update"
+	opRollingUpdate = "rolling-update"
+	opValidate      = "validate"
+	opKubeConfig    = "kubeconfig"
+	opDelete        = "delete"
+)
+
+func (p *simulated) inject(op string) error {
+	if p.failures[op] {
+		return fmt.Errorf("simulated failure of %s", op)
+	}
+	return nil
+}
+
+func clusterKey(stateStore, name string) string {
+	return stateStore + "/" + name
+}
+
+// cluster returns the cluster, the caller holds the lock
+func (p *simulated) cluster(kc clusteroperatorv1alpha1.KopsConfig) (*simulatedCluster, error) {
+	c, ok := p.simulator.clusters[clusterKey(kc.StateStore, kc.Name)]
+	if !ok {
+		return nil, fmt.Errorf("cluster %q not found", kc.Name)
+	}
+	return c, nil
+}
+
+// launch returns a new instance running the applied revision
+func (p *simulated) launch(c *simulatedCluster, group, role, zone string) simulatedInstance {
+	c.serial++
+	return simulatedInstance{
+		name:     simulation.InstanceName(c.serial),
+		group:    group,
+		role:     role,
+		zone:     zone,
+		revision: c.applied[group],
+		readyAt:  p.simulator.now().Add(p.convergence),
+	}
+}
+
+func (p *simulated) ReplaceCluster(cluster clusteroperatorv1alpha1.ClusterSpec) error {
+	if err := p.inject(opReplace); err != nil {
+		return err
+	}
+	m, err := kops.ParseManifest(cluster.Config)
+	if err != nil {
+		return err
+	}
+	doc, err := m.Cluster()
+	if err != nil {
+		return err
+	}
+	name := kops.Name(doc)
+	if name == "" {
+		name = cluster.KopsConfig.Name
+	}
+
+	p.simulator.mu.Lock()
+	defer p.simulator.mu.Unlock()
+	key := clusterKey(cluster.KopsConfig.StateStore, name)
+	c, ok := p.simulator.clusters[key]
+	if ok {
+		stored, err := kops.ParseManifest(c.manifest)
+		if err != nil {
+			return err
+		}
+		m.KeepInstanceGroups(stored)
+	} else {
+		c = &simulatedCluster{}
+	}
+	if err := c.store(m); err != nil {
+		return err
+	}
+	p.simulator.clusters[key] = c
+	return nil
+}
+
+// store keeps the manifest and the revisions of its instance groups
+func (c *simulatedCluster) store(m *kops.Manifest) error {
+	revisions, err := m.GroupRevisions()
+	if err != nil {
+		return err
+	}
+	manifest, err := m.String()
+	if err != nil {
+		return err
+	}
+	c.manifest, c.revisions = manifest, revisions
+	return nil
+}
+
+// ReplaceInstanceGroup stores the instance groups in the manifest of the
+// cluster, only their revisions change
+func (p *simulated) ReplaceInstanceGroup(kc clusteroperatorv1alpha1.KopsConfig, manifest string) error {
+	if err := p.inject(opReplace); err != nil {
+		return err
+	}
+	m, err := kops.ParseManifest(manifest)
+	if err != nil {
+		return err
+	}
+	igs := m.InstanceGroups()
+	if len(igs) == 0 {
+		return fmt.Errorf("manifest has no InstanceGroup document")
+	}
+
+	p.simulator.mu.Lock()
+	defer p.simulator.mu.Unlock()
+	c, err := p.cluster(kc)
+	if err != nil {
+		return err
+	}
+	stored, err := kops.ParseManifest(c.manifest)
+	if err != nil {
+		return err
+	}
+	for _, ig := range igs {
+		stored.SetInstanceGroup(ig)
+	}
+	return c.store(stored)
+}
+
+// UpdateCluster launches and terminates instances so every instance group
+// has its minSize, running instances keep their revision until rolled
+func (p *simulated) UpdateCluster(kc clusteroperatorv1alpha1.KopsConfig) error {
+	if err := p.inject(opUpdate); err != nil {
+		return err
+	}
+	p.simulator.mu.Lock()
+	defer p.simulator.mu.Unlock()
+	c, err := p.cluster(kc)
+	if err != nil {
+		return err
+	}
+	groups, err := kops.ManifestGroups(c.manifest)
+	if err != nil {
+		return err
+	}
+
+	c.applied = c.revisions
+	sizes := map[string]int{}
+	for _, g := range groups {
+		sizes[g.Name] = g.Size
+	}
+	var instances []simulatedInstance
+	running := map[string]int{}
+	for _, i := range c.instances {
+		if running[i.group] < sizes[i.group] {
+			instances = append(instances, i)
+			running[i.group]++
+		}
+	}
+	for _, g := range groups {
+		for ; running[g.Name] < g.Size; running[g.Name]++ {
+			instances = append(instances, p.launch(c, g.Name, g.Role, g.Zone))
+		}
+	}
+	c.instances = instances
+	return nil
+}
+
+func (p *simulated) GetCluster(kc clusteroperatorv1alpha1.KopsConfig) (bool, error) {
+	if err := p.inject(opGet); err != nil {
+		return false, err
+	}
+	p.simulator.mu.Lock()
+	defer p.simulator.mu.Unlock()
+	_, err := p.cluster(kc)
+	return err == nil, nil
+}
+
+// rollingUpdate replaces the selected instances that run an older revision,
+// every one of them when forced
+func (p *simulated) rollingUpdate(kc clusteroperatorv1alpha1.KopsConfig, selected func(simulatedInstance) bool, force bool) error {
+	if err := p.inject(opRollingUpdate); err != nil {
+		return err
+	}
+	p.simulator.mu.Lock()
+	defer p.simulator.mu.Unlock()
+	c, err := p.cluster(kc)
+	if err != nil {
+		return err
+	}
+	for n, i := range c.instances {
+		if !selected(i) {
+			continue
+		}
+		if force || i.revision != c.applied[i.group] {
+			c.instances[n] = p.launch(c, i.group, i.role, i.zone)
+		}
+	}
+	return nil
+}
+
+func allInstances(simulatedInstance) bool {
+	return true
+}
+
+func (p *simulated) RollingUpdateCluster(kc clusteroperatorv1alpha1.KopsConfig) error {
+	return p.rollingUpdate(kc, allInstances, false)
+}
+
+func (p *simulated) ForceRollingUpdateCluster(kc clusteroperatorv1alpha1.KopsConfig) error {
+	return p.rollingUpdate(kc, allInstances, true)
+}
+
+func (p *simulated) RollingUpdateRole(kc clusteroperatorv1alpha1.KopsConfig, role string) error {
+	return p.rollingUpdate(kc, func(i simulatedInstance) bool {
+		return strings.EqualFold(i.role, role)
+	}, false)
+}
+
+func (p *simulated) RollingUpdateInstanceGroup(kc clusteroperatorv1alpha1.KopsConfig, name string) error {
+	return p.rollingUpdate(kc, func(i simulatedInstance) bool {
+		return i.group == name
+	}, false)
+}
+
+// DeleteInstanceGroup removes the group from the manifest and terminates
+// its instances, a missing group is already deleted
+func (p *simulated) DeleteInstanceGroup(kc clusteroperatorv1alpha1.KopsConfig, name string) error {
+	if err := p.inject(opDelete); err != nil {
+		return err
+	}
+	p.simulator.mu.Lock()
+	defer p.simulator.mu.Unlock()
+	c, err := p.cluster(kc)
+	if err != nil {
+		return err
+	}
+	stored, err := kops.ParseManifest(c.manifest)
+	if err != nil {
+		return err
+	}
+	if !stored.RemoveInstanceGroup(name) {
+		return nil
+	}
+	if err := c.store(stored); err != nil {
+		return err
+	}
+	var instances []simulatedInstance
+	for _, i := range c.instances {
+		if i.group != name {
+			instances = append(instances, i)
+		}
+	}
+	c.instances = instances
+	return nil
+}
+
+// GetInstanceGroups reports the instance groups of the stored manifest
+func (p *simulated) GetInstanceGroups(kc clusteroperatorv1alpha1.KopsConfig) ([]clusteroperatorv1alpha1.InstanceGroupStatus, error) {
+	p.simulator.mu.Lock()
+	defer p.simulator.mu.Unlock()
+	c, err := p.cluster(kc)
+	if err != nil {
+		return nil, err
+	}
+	return kops.InstanceGroupStatuses(c.manifest)
+}
+
+// ValidateCluster reports the ready instances as nodes, the ones still
+// converging and the instance groups short of ready nodes as failures
+func (p *simulated) ValidateCluster(kc clusteroperatorv1alpha1.KopsConfig) (clusteroperatorv1alpha1.KopsStatus, error) {
+	status := clusteroperatorv1alpha1.KopsStatus{}
+	if err := p.inject(opValidate); err != nil {
+		return status, err
+	}
+	p.simulator.mu.Lock()
+	defer p.simulator.mu.Unlock()
+	c, err := p.cluster(kc)
+	if err != nil {
+		return status, err
+	}
+
+	now := p.simulator.now()
+	ready := map[string]int{}
+	for _, i := range c.instances {
+		if now.Before(i.readyAt) {
+			status.Failures = append(status.Failures, clusteroperatorv1alpha1.KopsFailure{
+				Type:    "Machine",
+				Name:    i.name,
+				Message: fmt.Sprintf("machine %q has not yet joined cluster", i.name),
+			})
+			continue
+		}
+		ready[i.group]++
+		status.Nodes = append(status.Nodes, clusteroperatorv1alpha1.KopsNode{
+			Name:     i.name,
+			Zone:     i.zone,
+			Role:     strings.ToLower(i.role),
+			Hostname: i.name,
+			Status:   "True",
+		})
+	}
+	groups, err := kops.ManifestGroups(c.manifest)
+	if err != nil {
+		return status, err
+	}
+	for _, g := range groups {
+		if len(c.instances) > 0 && ready[g.Name] < g.Size {
+			status.Failures = append(status.Failures, kops.NotEnoughNodesFailure(g.Name, ready[g.Name], g.Size))
+		}
+	}
+	if len(c.instances) == 0 {
+		status.Failures = append(status.Failures, clusteroperatorv1alpha1.KopsFailure{
+			Type:    "dns",
+			Name:    "apiserver",
+			Message: "Validation Failed: the cluster has not been updated",
+		})
+	}
+	if len(status.Failures) > 0 {
+		return status, fmt.Errorf("Validation Failed: %d failures", len(status.Failures))
+	}
+	return status, nil
+}
+
+// GetKubeConfig returns a kubeconfig for the API server kops would create
+func (p *simulated) GetKubeConfig(kc clusteroperatorv1alpha1.KopsConfig) (clusteroperatorv1alpha1.KubeConfig, error) {
+	if err := p.inject(opKubeConfig); err != nil {
+		return clusteroperatorv1alpha1.KubeConfig{}, err
+	}
+	p.simulator.mu.Lock()
+	defer p.simulator.mu.Unlock()
+	if _, err := p.cluster(kc); err != nil {
+		return clusteroperatorv1alpha1.KubeConfig{}, err
+	}
+
+	fake := base64.StdEncoding.EncodeToString([]byte("simulated " + kc.Name))
+	return clusteroperatorv1alpha1.KubeConfig{
+		APIVersion:     "v1",
+		Kind:           "Config",
+		CurrentContext: kc.Name,
+		Clusters: []clusteroperatorv1alpha1.ClusterConfigs{{
+			Name: kc.Name,
+			ClusterConfigs: clusteroperatorv1alpha1.ClusterConfig{
+				CertificateAuthorityData: fake,
+				Server:                   simulation.APIServer(kc.Name),
+			},
+		}},
+		ContextConfigs: []clusteroperatorv1alpha1.ContextConfigs{{
+			Name:           kc.Name,
+			ContextConfigs: clusteroperatorv1alpha1.ContextConfig{Cluster: kc.Name, User: kc.Name},
+		}},
+		Users: []clusteroperatorv1alpha1.Users{{
+			Name: kc.Name,
+			Users: clusteroperatorv1alpha1.User{
+				ClientCertificateData: fake,
+				ClientKeyData:         fake,
+			},
+		}},
+	}, nil
+}
+
+func (p *simulated) DeleteCluster(kc clusteroperatorv1alpha1.KopsConfig) error {
+	if err := p.inject(opDelete); err != nil {
+		return err
+	}
+	p.simulator.mu.Lock()
+	defer p.simulator.mu.Unlock()
+	if _, err := p.cluster(kc); err != nil {
+		return err
+	}
+	delete(p.simulator.clusters, clusterKey(kc.StateStore, kc.Name))
+	return nil
+}
+
+// DeleteClusterPreview lists the instances in the format of kops delete
+func (p *simulated) DeleteClusterPreview(kc clusteroperatorv1alpha1.KopsConfig) (string, error) {
+	p.simulator.mu.Lock()
+	defer p.simulator.mu.Unlock()
+	c, err := p.cluster(kc)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString("TYPE\tNAME\tID\n")
+	for _, i := range c.instances {
+		fmt.Fprintf(&b, "instance\t%s.%s\t%s\n", i.group, kc.Name, i.name)
+	}
+	return b.String(), nil
+}
+
+func (p *simulated) ListClusters(stateStore string) ([]string, error) {
+	p.simulator.mu.Lock()
+	defer p.simulator.mu.Unlock()
+	var names []string
+	for key := range p.simulator.clusters {
+		if name := strings.TrimPrefix(key, stateStore+"/"); name != key {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (p *simulated) Version() (string, error) {
+	return p.version, nil
+}
+
+func (p *simulated) CheckCompatibility(kubernetesVersion string) (string, error) {
+	if kubernetesVersion == "" {
+		return p.version, nil
+	}
+	return p.version, kops.CheckKopsSupports(p.version, kubernetesVersion)
+}
+
+// nodeCounts returns the master and worker counts of the KopsConfig, they
+// default to the minSize of the Master and Node instance groups of the
+// manifest
+func nodeCounts(cluster clusteroperatorv1alpha1.ClusterSpec) (int, int, error) {
+	masters, workers := cluster.KopsConfig.MasterCount, cluster.KopsConfig.WorkerCount
+	if masters > 0 && workers > 0 {
+		return masters, workers, nil
+	}
+	groups, err := kops.ManifestGroups(cluster.Config)
+	if err != nil {
+		return 0, 0, err
+	}
+	var groupMasters, groupWorkers int
+	for _, g := range groups {
+		switch {
+		case strings.EqualFold(g.Role, "Master"):
+			groupMasters += g.Size
+		case strings.EqualFold(g.Role, "Node"):
+			groupWorkers += g.Size
+		}
+	}
+	if masters == 0 {
+		masters = groupMasters
+	}
+	if workers == 0 {
+		workers = groupWorkers
+	}
+	return masters, workers, nil
+}
@@ -0,0 +1,245 @@
+package provisioner
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	clusteroperatorv1alpha1 "github.com/infobloxopen/cluster-operator/pkg/apis/clusteroperator/v1alpha1"
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testManifest = `apiVersion: kops.k8s.io/v1alpha2
+kind: Cluster
+metadata:
+  name: test.k8s.local
+spec:
+  kubernetesVersion: 1.16.7
+---
+apiVersion: kops.k8s.io/v1alpha2
+kind: InstanceGroup
+metadata:
+  name: master-us-east-2a
+spec:
+  role: Master
+  minSize: 1
+  subnets:
+  - us-east-2a
+---
+apiVersion: kops.k8s.io/v1alpha2
+kind: InstanceGroup
+metadata:
+  name: nodes
+spec:
+  role: Node
+  minSize: 2
+`
+
+func newTestSimulator(now *time.Time, annotations map[string]string) (*Simulator, Provisioner) {
+	viper.Set("simulated.convergence", time.Minute)
+	s := NewSimulator()
+	s.now = func() time.Time { return *now }
+	instance := &clusteroperatorv1alpha1.Cluster{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}
+	return s, s.For(instance)
+}
+
+func TestSimulated(t *testing.T) {
+	now := time.Now()
+	s, p := newTestSimulator(&now, nil)
+	kc := clusteroperatorv1alpha1.KopsConfig{Name: "test.k8s.local", StateStore: "s3://test"}
+	spec := clusteroperatorv1alpha1.ClusterSpec{Name: "test", Config: testManifest, KopsConfig: kc}
+
+	if _, err := p.ValidateCluster(kc); err == nil {
+		t.Error("Expected validation of a missing cluster to fail")
+	}
+	if err := p.ReplaceCluster(spec); err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	if exists, err := p.GetCluster(kc); !exists || err != nil {
+		t.Error("Expected the cluster to exist got", exists, err)
+	}
+	if err := p.UpdateCluster(kc); err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+
+	// Instances converge before the cluster validates, kops reports the
+	// machines and the instance groups short of nodes
+	status, err := p.ValidateCluster(kc)
+	if err == nil || len(status.Failures) != 5 {
+		t.Error("Expected 3 instances of 2 groups still converging got", status, err)
+	}
+	now = now.Add(time.Minute)
+	status, err = p.ValidateCluster(kc)
+	if err != nil || len(status.Nodes) != 3 {
+		t.Fatal("Expected 3 ready nodes got", status, err)
+	}
+	if status.Nodes[0].Role != "master" || status.Nodes[0].Zone != "us-east-2a" {
+		t.Error("Expected the master in us-east-2a got", status.Nodes[0])
+	}
+	if config, err := p.GetKubeConfig(kc); err != nil || config.CurrentContext != kc.Name {
+		t.Error("Expected kubeconfig of the cluster got", config, err)
+	}
+
+	// Changing the manifest only replaces instances once they are rolled
+	spec.Config = strings.Replace(testManifest, "1.16.7", "1.17.4", 1)
+	if err := p.ReplaceCluster(spec); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateCluster(kc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ValidateCluster(kc); err != nil {
+		t.Error("Expected the cluster to stay valid until rolled got", err)
+	}
+	if err := p.RollingUpdateRole(kc, "Master"); err != nil {
+		t.Fatal(err)
+	}
+	rolled, err := p.ValidateCluster(kc)
+	if err == nil || len(rolled.Failures) != 2 || rolled.Failures[0].Name == status.Nodes[0].Name {
+		t.Error("Expected only the new master to converge got", rolled, err)
+	}
+	now = now.Add(time.Minute)
+	if err := p.ForceRollingUpdateCluster(kc); err != nil {
+		t.Fatal(err)
+	}
+	if forced, _ := p.ValidateCluster(kc); len(forced.Failures) != 5 {
+		t.Error("Expected every instance to be replaced got", forced)
+	}
+
+	if clusters, err := p.ListClusters("s3://test"); err != nil || len(clusters) != 1 || clusters[0] != kc.Name {
+		t.Error("Expected the cluster to be listed got", clusters, err)
+	}
+	if clusters, _ := p.ListClusters("s3://other"); len(clusters) != 0 {
+		t.Error("Expected no clusters in another state store got", clusters)
+	}
+	preview, err := p.DeleteClusterPreview(kc)
+	if err != nil || strings.Count(preview, "instance\t") != 3 {
+		t.Error("Expected the preview to list the instances got", preview, err)
+	}
+	if err := p.DeleteCluster(kc); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := s.For(&clusteroperatorv1alpha1.Cluster{}).GetCluster(kc); exists {
+		t.Error("Expected the cluster to be deleted")
+	}
+}
+
+func TestSimulatedFailures(t *testing.T) {
+	now := time.Now()
+	_, p := newTestSimulator(&now, map[string]string{
+		clusteroperatorv1alpha1.SimulateFailureAnnotation: "update, validate",
+	})
+	kc := clusteroperatorv1alpha1.KopsConfig{Name: "test.k8s.local"}
+
+	if err := p.ReplaceCluster(clusteroperatorv1alpha1.ClusterSpec{Name: "test", Config: testManifest}); err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	if err := p.UpdateCluster(kc); err == nil {
+		t.Error("Expected injected update failure")
+	}
+	if _, err := p.ValidateCluster(kc); err == nil {
+		t.Error("Expected injected validate failure")
+	}
+	if _, err := p.GetKubeConfig(kc); err != nil {
+		t.Error("Expected only the listed operations to fail got", err)
+	}
+}
+
+func TestSimulatedCompatibility(t *testing.T) {
+	s := NewSimulator()
+	p := s.For(&clusteroperatorv1alpha1.Cluster{})
+	if version, err := p.CheckCompatibility("1.17.4"); err != nil || version != SimulatedKopsVersion {
+		t.Error("Expected", SimulatedKopsVersion, "to support 1.17.4 got", version, err)
+	}
+	if _, err := p.CheckCompatibility("1.15.12"); err == nil {
+		t.Error("Expected", SimulatedKopsVersion, "not to support 1.15")
+	}
+	p = s.For(&clusteroperatorv1alpha1.Cluster{Spec: clusteroperatorv1alpha1.ClusterSpec{KopsVersion: "1.16.0"}})
+	if _, err := p.CheckCompatibility("1.18.3"); err == nil {
+		t.Error("Expected kops 1.16 not to support 1.18")
+	}
+}
+
+const testInstanceGroup = `apiVersion: kops.k8s.io/v1alpha2
+kind: InstanceGroup
+metadata:
+  name: pool
+spec:
+  role: Node
+  minSize: 1
+`
+
+func TestSimulatedInstanceGroups(t *testing.T) {
+	now := time.Now()
+	_, p := newTestSimulator(&now, nil)
+	kc := clusteroperatorv1alpha1.KopsConfig{Name: "test.k8s.local"}
+	spec := clusteroperatorv1alpha1.ClusterSpec{Name: "test", Config: testManifest}
+
+	if err := p.ReplaceInstanceGroup(kc, testInstanceGroup); err == nil {
+		t.Error("Expected replacing a group of a missing cluster to fail")
+	}
+	if err := p.ReplaceCluster(spec); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ReplaceInstanceGroup(kc, testInstanceGroup); err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	// Replacing the manifest of the cluster keeps the group
+	if err := p.ReplaceCluster(spec); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateCluster(kc); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	status, err := p.ValidateCluster(kc)
+	if err != nil || len(status.Nodes) != 4 {
+		t.Fatal("Expected 4 ready nodes got", status, err)
+	}
+
+	// Only the changed group is rolled
+	large := strings.Replace(testInstanceGroup, "role: Node", "role: Node\n  machineType: t3.large", 1)
+	if err := p.ReplaceInstanceGroup(kc, large); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateCluster(kc); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RollingUpdateCluster(kc); err != nil {
+		t.Fatal(err)
+	}
+	status, err = p.ValidateCluster(kc)
+	if err == nil || len(status.Nodes) != 3 {
+		t.Error("Expected the 3 nodes of the other groups to stay ready got", status, err)
+	}
+	if failure := status.Failures[len(status.Failures)-1]; failure.Name != "pool" ||
+		failure.Message != `InstanceGroup "pool" did not have enough nodes 0 vs 1` {
+		t.Error("Expected the group to be short of nodes got", failure)
+	}
+
+	// Scaling launches instances without rolling the running ones
+	now = now.Add(time.Minute)
+	if err := p.ReplaceInstanceGroup(kc, strings.Replace(large, "minSize: 1", "minSize: 2", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateCluster(kc); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RollingUpdateInstanceGroup(kc, "pool"); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := p.ValidateCluster(kc); len(status.Nodes) != 4 || len(status.Failures) != 2 {
+		t.Error("Expected one more instance to converge got", status)
+	}
+
+	if err := p.DeleteInstanceGroup(kc, "pool"); err != nil {
+		t.Fatal("Expected no error got", err)
+	}
+	if status, err := p.ValidateCluster(kc); err != nil || len(status.Nodes) != 3 {
+		t.Error("Expected the instances of the group to be terminated got", status, err)
+	}
+	if err := p.DeleteInstanceGroup(kc, "pool"); err != nil {
+		t.Error("Expected deleting a missing group to succeed got", err)
+	}
+}
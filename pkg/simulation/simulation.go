@@ -0,0 +1,26 @@
+// Package simulation holds what fakekops and the simulated provider share to
+// look like a cluster kops created on AWS
+package simulation
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/infobloxopen/cluster-operator/kops"
+)
+
+// APIServer returns the URL of the API server fakekops and the simulated
+// provider export. Gossip clusters are reached through the load balancer of
+// the API server, named like the ELBs kops creates.
+func APIServer(clusterName string) string {
+	if kops.IsGossip(clusterName) {
+		return "https://api-" + strings.Replace(clusterName, ".", "-", -1) + ".elb.amazonaws.com"
+	}
+	return "https://api." + clusterName
+}
+
+// InstanceName returns the private DNS name AWS gives an instance, the
+// simulations number their instances with serial
+func InstanceName(serial int) string {
+	return fmt.Sprintf("ip-172-20-%d-%d.compute.internal", serial/256, serial%256)
+}
@@ -0,0 +1,18 @@
+package simulation
+
+import "testing"
+
+func TestAPIServer(t *testing.T) {
+	if server := APIServer("test.k8s.local"); server != "https://api-test-k8s-local.elb.amazonaws.com" {
+		t.Error("Expected the load balancer of the API server got", server)
+	}
+	if server := APIServer("test.example.com"); server != "https://api.test.example.com" {
+		t.Error("Expected the DNS name of the API server got", server)
+	}
+}
+
+func TestInstanceName(t *testing.T) {
+	if name := InstanceName(257); name != "ip-172-20-1-1.compute.internal" {
+		t.Error("Expected the AWS name of the instance got", name)
+	}
+}
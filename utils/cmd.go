@@ -59,29 +59,42 @@ func CopyBufferContentsToTempFile(srcBuff []byte, destFile string) (err error) {
 	return
 }
 
-func RunCmd(cmdString string) (*bytes.Buffer, error) {
-	var out bytes.Buffer
-
-	cmd := exec.Command("echo", cmdString)
-	cmd.Stdout = &out
-	err := cmd.Run()
-	if err != nil {
-		return nil, err
+// inheritedEnv are the variables of the operator environment a command
+// given its own variables keeps, the cloud credentials of the operator are
+// not among them
+var inheritedEnv = []string{"PATH", "HOME", "USER", "TMPDIR", "LANG", "HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"}
+
+// commandEnv returns the environment of a command with the variables, nil
+// keeps the environment of the operator. Only inheritedEnv is taken from the
+// operator otherwise.
+func commandEnv(env []string) []string {
+	if len(env) == 0 {
+		return nil
 	}
-
-	var mode os.FileMode = 509
-	err = os.MkdirAll("./tmp", mode)
-	if err != nil {
-		return nil, err
+	list := make([]string, 0, len(inheritedEnv)+len(env))
+	for _, name := range inheritedEnv {
+		if v, ok := os.LookupEnv(name); ok {
+			list = append(list, name+"="+v)
+		}
 	}
+	return append(list, env...)
+}
 
-	err = CopyBufferContentsToFile(out.Bytes(), "./tmp/cmd.sh")
+// RunCmd runs the command line in a shell and returns its output, env adds
+// KEY=value variables to the environment of the command. The command line is
+// passed to the shell as an argument, commands run concurrently by the
+// controllers do not share a script file.
+func RunCmd(cmdString string, env ...string) (*bytes.Buffer, error) {
+	var out bytes.Buffer
+
+	var mode os.FileMode = 509
+	err := os.MkdirAll("./tmp", mode)
 	if err != nil {
 		return nil, err
 	}
 
-	out.Reset()
-	cmd = exec.Command("/bin/sh", "./tmp/cmd.sh")
+	cmd := exec.Command("/bin/sh", "-c", cmdString)
+	cmd.Env = commandEnv(env)
 	cmd.Stdout = &out
 	var errout bytes.Buffer
 	cmd.Stderr = &errout
@@ -94,29 +107,17 @@ func RunCmd(cmdString string) (*bytes.Buffer, error) {
 	return &out, nil
 }
 
-func RunStreamingCmd(cmdString string) error {
-	var out bytes.Buffer
-
-	cmd := exec.Command("echo", cmdString)
-	cmd.Stdout = &out
-	err := cmd.Run()
-	if err != nil {
-		return err
-	}
-
+// RunStreamingCmd runs the command line in a shell streaming its output to
+// the log, env adds KEY=value variables to the environment of the command
+func RunStreamingCmd(cmdString string, env ...string) error {
 	var mode os.FileMode = 509
-	err = os.MkdirAll("./tmp", mode)
-	if err != nil {
-		return err
-	}
-
-	err = CopyBufferContentsToFile(out.Bytes(), "./tmp/cmd.sh")
+	err := os.MkdirAll("./tmp", mode)
 	if err != nil {
 		return err
 	}
 
-	out.Reset()
-	command := New(context.TODO(), nil, "/bin/sh", "./tmp/cmd.sh")
+	command := New(context.TODO(), nil, "/bin/sh", "-c", cmdString)
+	command.Env = commandEnv(env)
 
 	if err := command.Start(); err != nil {
 		return err
@@ -132,7 +133,7 @@ func RunDockerCmd(dockerArgs []string) (*bytes.Buffer, error) {
 	var out bytes.Buffer
 
 	//cmd := exec.Command(cmdString)
-	cmd := exec.Command("/usr/local/bin/docker", dockerArgs...)
+	cmd := exec.Command(viper.GetString("docker.bin.path"), dockerArgs...)
 	cmd.Stdout = &out
 	var errout bytes.Buffer
 	cmd.Stderr = &errout
@@ -145,6 +146,20 @@ func RunDockerCmd(dockerArgs []string) (*bytes.Buffer, error) {
 	return &out, nil
 }
 
+// RunStreamingDockerCmd runs docker with the arguments and streams the output to the log
+func RunStreamingDockerCmd(dockerArgs []string) error {
+	command := New(context.TODO(), nil, viper.GetString("docker.bin.path"), dockerArgs...)
+
+	if err := command.Start(); err != nil {
+		return err
+	}
+	if err := command.Wait(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 type Cmd struct {
 	*exec.Cmd
 	entry     *logrus.Entry
@@ -6,6 +6,10 @@ import (
 	"context"
 	"encoding/json"
 	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -91,3 +95,48 @@ func TestMultiCloser(t *testing.T) {
 		t.Errorf("got: %s wanted: %s", string(bs), e)
 	}
 }
+
+func TestCommandEnv(t *testing.T) {
+	os.Setenv("AWS_SESSION_TOKEN", "operator")
+	defer os.Unsetenv("AWS_SESSION_TOKEN")
+	if env := commandEnv(nil); env != nil {
+		t.Error("Expected the operator environment got", env)
+	}
+
+	env := strings.Join(commandEnv([]string{"AWS_ACCESS_KEY_ID=cluster"}), "\n")
+	if strings.Contains(env, "AWS_SESSION_TOKEN") || !strings.Contains(env, "AWS_ACCESS_KEY_ID=cluster") {
+		t.Error("Expected only the cluster credentials got", env)
+	}
+	if path := os.Getenv("PATH"); path != "" && !strings.Contains(env, "PATH="+path) {
+		t.Error("Expected the PATH of the operator got", env)
+	}
+}
+
+// State store probes run while the Cluster controller runs kops, each command
+// has to run its own command line with its own environment
+func TestRunCmdConcurrent(t *testing.T) {
+	defer os.RemoveAll("tmp")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			store := "s3://probe-" + strconv.Itoa(i)
+			cmd := "echo get cluster --state=$KOPS_STATE_STORE"
+			if i%2 == 1 {
+				store = "s3://cluster-" + strconv.Itoa(i)
+				cmd = "echo delete cluster --yes --state=$KOPS_STATE_STORE"
+			}
+			out, err := RunCmd(cmd, "KOPS_STATE_STORE="+store)
+			if err != nil {
+				t.Error("Expected no error got", err)
+				return
+			}
+			want := strings.Replace(strings.TrimPrefix(cmd, "echo "), "$KOPS_STATE_STORE", store, 1)
+			if got := strings.TrimSpace(out.String()); got != want {
+				t.Error("Expected", want, "got", got)
+			}
+		}(i)
+	}
+	wg.Wait()
+}